@@ -0,0 +1,284 @@
+package aac
+
+import (
+	"awCodec/utils"
+	"errors"
+	"fmt"
+)
+
+var (
+	errConfig      = errors.New("aac: invalid audio specific config")
+	errUnsupported = errors.New("aac: unsupported")
+)
+
+// Audio object types (ISO/IEC 14496-3 Table 1.17)
+const (
+	ObjectTypeNull        = 0
+	ObjectTypeAacMain     = 1
+	ObjectTypeAacLc       = 2
+	ObjectTypeAacSsr      = 3
+	ObjectTypeAacLtp      = 4
+	ObjectTypeSbr         = 5
+	ObjectTypeAacScalable = 6
+	ObjectTypeTwinVq      = 7
+	ObjectTypeErAacLc     = 17
+	ObjectTypeErAacLtp    = 19
+	ObjectTypeErAacScal   = 20
+	ObjectTypeErTwinVq    = 21
+	ObjectTypeErBsac      = 22
+	ObjectTypeErAacLd     = 23
+	ObjectTypePs          = 29
+	ObjectTypeEscape      = 31
+	ObjectTypeLayer1      = 32
+	ObjectTypeLayer2      = 33
+	ObjectTypeLayer3      = 34
+	ObjectTypeAls         = 36
+)
+
+// Sampling frequencies by sampling_frequency_index (ISO/IEC 14496-3 Table 1.18)
+var samplingFrequencies = [13]int{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350, // Hz
+}
+
+// Number of channels by channelConfiguration (ISO/IEC 14496-3 Table 1.19)
+var channelConfigurations = [8]int{
+	0, 1, 2, 3, 4, 5, 6, 8,
+}
+
+// AudioSpecificConfig describes an MPEG-4 audio stream (ISO/IEC 14496-3 1.6.2.1). It is carried in the
+// DecoderSpecificInfo of an 'esds' box or built from an ADTS header.
+type AudioSpecificConfig struct {
+	ObjectType             int // 5 bits, 6 more bits if it is ObjectTypeEscape
+	SamplingFrequencyIndex int // 4 bits
+	SamplingFrequency      int // 24 bits if SamplingFrequencyIndex is 0xF
+	ChannelConfiguration   int // 4 bits
+
+	// SBR and PS signalling, explicit by the audio object type or by a sync extension at the end of the config
+	ExtensionObjectType             int  // ObjectTypeSbr if SBR is present
+	ExtensionSamplingFrequencyIndex int  // 4 bits
+	ExtensionSamplingFrequency      int  // Output sampling frequency of SBR, 24 bits if the index is 0xF
+	SbrPresent                      bool // 1 bit sbrPresentFlag
+	PsPresent                       bool // 1 bit psPresentFlag
+
+	// GASpecificConfig
+	FrameLengthFlag    bool                  // 1 bit, 960 instead of 1024 samples per frame
+	DependsOnCoreCoder bool                  // 1 bit
+	CoreCoderDelay     int                   // 14 bits
+	ExtensionFlag      bool                  // 1 bit
+	Pce                *ProgramConfigElement // Channel layout if ChannelConfiguration is 0
+	LayerNr            int                   // 3 bits, scalable object types only
+	NumOfSubFrame      int                   // 5 bits, ER BSAC only
+	LayerLength        int                   // 11 bits, ER BSAC only
+
+	// Error resilience
+	SectionDataResilience     bool // 1 bit aacSectionDataResilienceFlag
+	ScalefactorDataResilience bool // 1 bit aacScalefactorDataResilienceFlag
+	SpectralDataResilience    bool // 1 bit aacSpectralDataResilienceFlag
+	EpConfig                  int  // 2 bits
+}
+
+// ParseAudioSpecificConfig reads an AudioSpecificConfig.
+func ParseAudioSpecificConfig(b []byte) (*AudioSpecificConfig, error) {
+	br := utils.NewBitReader(b)
+	config := &AudioSpecificConfig{}
+
+	config.ObjectType = readObjectType(br)
+	config.SamplingFrequencyIndex, config.SamplingFrequency = readSamplingFrequency(br)
+	config.ChannelConfiguration = br.ReadBits(4)
+
+	// Hierarchical signalling, the core object type follows the extension sampling frequency.
+	if config.ObjectType == ObjectTypeSbr || config.ObjectType == ObjectTypePs {
+		config.ExtensionObjectType = ObjectTypeSbr
+		config.SbrPresent = true
+		config.PsPresent = config.ObjectType == ObjectTypePs
+		config.ExtensionSamplingFrequencyIndex, config.ExtensionSamplingFrequency = readSamplingFrequency(br)
+		config.ObjectType = readObjectType(br)
+	}
+
+	// GASpecificConfig --------------------------------------------------
+	switch config.ObjectType {
+	case ObjectTypeAacMain, ObjectTypeAacLc, ObjectTypeAacSsr, ObjectTypeAacLtp, ObjectTypeAacScalable, ObjectTypeTwinVq,
+		ObjectTypeErAacLc, ObjectTypeErAacLtp, ObjectTypeErAacScal, ObjectTypeErTwinVq, ObjectTypeErBsac, ObjectTypeErAacLd:
+		config.FrameLengthFlag = br.ReadBool()
+		config.DependsOnCoreCoder = br.ReadBool()
+		if config.DependsOnCoreCoder {
+			config.CoreCoderDelay = br.ReadBits(14)
+		}
+		config.ExtensionFlag = br.ReadBool()
+		if config.ChannelConfiguration == 0 {
+			config.Pce = readProgramConfigElement(br)
+		}
+		if config.ObjectType == ObjectTypeAacScalable || config.ObjectType == ObjectTypeErAacScal {
+			config.LayerNr = br.ReadBits(3)
+		}
+		if config.ExtensionFlag {
+			if config.ObjectType == ObjectTypeErBsac {
+				config.NumOfSubFrame = br.ReadBits(5)
+				config.LayerLength = br.ReadBits(11)
+			}
+			switch config.ObjectType {
+			case ObjectTypeErAacLc, ObjectTypeErAacLtp, ObjectTypeErAacScal, ObjectTypeErAacLd:
+				config.SectionDataResilience = br.ReadBool()
+				config.ScalefactorDataResilience = br.ReadBool()
+				config.SpectralDataResilience = br.ReadBool()
+			}
+			br.Seek(1) // extensionFlag3
+		}
+	}
+
+	// Error protection of the error resilient object types
+	switch config.ObjectType {
+	case ObjectTypeErAacLc, ObjectTypeErAacLtp, ObjectTypeErAacScal, ObjectTypeErTwinVq, ObjectTypeErBsac, ObjectTypeErAacLd:
+		config.EpConfig = br.ReadBits(2)
+	}
+
+	// Backward compatible signalling --------------------------------------------------
+	if config.ExtensionObjectType != ObjectTypeSbr && br.Len() >= 16 && br.ReadBits(11) == 0x2B7 {
+		if readObjectType(br) == ObjectTypeSbr {
+			config.SbrPresent = br.ReadBool()
+			if config.SbrPresent {
+				config.ExtensionObjectType = ObjectTypeSbr
+				config.ExtensionSamplingFrequencyIndex, config.ExtensionSamplingFrequency = readSamplingFrequency(br)
+				if br.Len() >= 12 && br.ReadBits(11) == 0x548 {
+					config.PsPresent = br.ReadBool()
+				}
+			}
+		}
+	}
+
+	if br.Len() < 0 {
+		return config, fmt.Errorf("%w: %d bytes", errConfig, len(b))
+	}
+	if config.SamplingFrequency == 0 {
+		return config, fmt.Errorf("%w: sampling frequency index %d", errConfig, config.SamplingFrequencyIndex)
+	}
+	return config, nil
+}
+
+func readObjectType(br *utils.BitReader) int {
+	objectType := br.ReadBits(5)
+	if objectType == ObjectTypeEscape {
+		objectType = 32 + br.ReadBits(6)
+	}
+	return objectType
+}
+
+// SamplingFrequency returns the frequency of a sampling_frequency_index, 0 if it is reserved or escaped.
+func SamplingFrequency(index int) int {
+	if index >= 0 && index < len(samplingFrequencies) {
+		return samplingFrequencies[index]
+	}
+	return 0
+}
+
+func readSamplingFrequency(br *utils.BitReader) (index, frequency int) {
+	index = br.ReadBits(4)
+	if index == 0xF {
+		return index, br.ReadBits(24)
+	}
+	if index < len(samplingFrequencies) {
+		frequency = samplingFrequencies[index]
+	}
+	return index, frequency
+}
+
+// Channels returns the number of output channels of the channel configuration or of the program config element.
+func (config *AudioSpecificConfig) Channels() int {
+	if config.ChannelConfiguration == 0 && config.Pce != nil {
+		return config.Pce.Channels()
+	}
+	if config.ChannelConfiguration < len(channelConfigurations) {
+		return channelConfigurations[config.ChannelConfiguration]
+	}
+	return 0
+}
+
+// ProgramConfigElement describes the channel layout of a stream (ISO/IEC 14496-3 4.4.1.1).
+type ProgramConfigElement struct {
+	ElementInstanceTag     int // 4 bits
+	ObjectType             int // 2 bits
+	SamplingFrequencyIndex int // 4 bits
+	FrontChannelElements   []ChannelElement
+	SideChannelElements    []ChannelElement
+	BackChannelElements    []ChannelElement
+	LfeChannelElements     []int // 4 bits element_tag_select
+	AssocDataElements      []int // 4 bits
+	CcElements             []ChannelElement
+	MonoMixdown            int // 4 bits element number, -1 if not present
+	StereoMixdown          int // 4 bits element number, -1 if not present
+	MatrixMixdownIdx       int // 2 bits, -1 if not present
+	PseudoSurroundEnable   bool
+	Comment                []byte
+}
+
+// ChannelElement is a reference to a single (SCE) or channel pair (CPE) element of a ProgramConfigElement.
+type ChannelElement struct {
+	IsCpe     bool // 1 bit, is_ind_sw for coupling channel elements
+	TagSelect int  // 4 bits
+}
+
+func readProgramConfigElement(br *utils.BitReader) *ProgramConfigElement {
+	pce := &ProgramConfigElement{MonoMixdown: -1, StereoMixdown: -1, MatrixMixdownIdx: -1}
+
+	pce.ElementInstanceTag = br.ReadBits(4)
+	pce.ObjectType = br.ReadBits(2)
+	pce.SamplingFrequencyIndex = br.ReadBits(4)
+	numFront := br.ReadBits(4)
+	numSide := br.ReadBits(4)
+	numBack := br.ReadBits(4)
+	numLfe := br.ReadBits(2)
+	numAssocData := br.ReadBits(3)
+	numValidCc := br.ReadBits(4)
+	if br.ReadBool() {
+		pce.MonoMixdown = br.ReadBits(4)
+	}
+	if br.ReadBool() {
+		pce.StereoMixdown = br.ReadBits(4)
+	}
+	if br.ReadBool() {
+		pce.MatrixMixdownIdx = br.ReadBits(2)
+		pce.PseudoSurroundEnable = br.ReadBool()
+	}
+
+	readElements := func(n int) []ChannelElement {
+		elements := make([]ChannelElement, n)
+		for i := range elements {
+			elements[i].IsCpe = br.ReadBool()
+			elements[i].TagSelect = br.ReadBits(4)
+		}
+		return elements
+	}
+	pce.FrontChannelElements = readElements(numFront)
+	pce.SideChannelElements = readElements(numSide)
+	pce.BackChannelElements = readElements(numBack)
+	pce.LfeChannelElements = make([]int, numLfe)
+	for i := range pce.LfeChannelElements {
+		pce.LfeChannelElements[i] = br.ReadBits(4)
+	}
+	pce.AssocDataElements = make([]int, numAssocData)
+	for i := range pce.AssocDataElements {
+		pce.AssocDataElements[i] = br.ReadBits(4)
+	}
+	pce.CcElements = readElements(numValidCc)
+
+	br.ByteAlign()
+	pce.Comment = make([]byte, br.ReadBits(8))
+	for i := range pce.Comment {
+		pce.Comment[i] = byte(br.ReadBits(8))
+	}
+	return pce
+}
+
+// Channels returns the number of output channels of the program, coupling channels are not counted.
+func (pce *ProgramConfigElement) Channels() int {
+	n := len(pce.LfeChannelElements)
+	for _, elements := range [][]ChannelElement{pce.FrontChannelElements, pce.SideChannelElements, pce.BackChannelElements} {
+		for _, element := range elements {
+			n++
+			if element.IsCpe {
+				n++
+			}
+		}
+	}
+	return n
+}
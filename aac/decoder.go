@@ -0,0 +1,301 @@
+package aac
+
+import (
+	"awCodec/utils"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	errBitstream = errors.New("aac: invalid bitstream")
+	errHuffman   = errors.New("aac: invalid huffman codeword")
+	errTruncated = errors.New("aac: frame is truncated")
+)
+
+// Syntactic elements of raw_data_block (ISO/IEC 14496-3 Table 4.85)
+const (
+	idSce = 0x0 // single_channel_element
+	idCpe = 0x1 // channel_pair_element
+	idCce = 0x2 // coupling_channel_element
+	idLfe = 0x3 // lfe_channel_element
+	idDse = 0x4 // data_stream_element
+	idPce = 0x5 // program_config_element
+	idFil = 0x6 // fill_element
+	idEnd = 0x7 // end of raw_data_block
+)
+
+// Samples per channel of a frame.
+const frameLength = 1024
+
+// channelState is the state of an output channel kept between frames.
+type channelState struct {
+	overlap     [1024]float32 // Second half of the last window
+	windowShape int           // Window shape of the last frame
+}
+
+// Decoder decodes the raw data blocks of an AAC stream. Channels are output in the order of the syntactic
+// elements. Of HE-AAC streams, only the AAC core is decoded.
+type Decoder struct {
+	Config *AudioSpecificConfig
+	Pce    *ProgramConfigElement // The last program_config_element of the stream
+
+	samplingIndex int
+	channels      []*channelState
+	seed          uint32 // Random generator of perceptual noise substitution
+}
+
+// NewDecoder returns a decoder of the stream described by config.
+func NewDecoder(config *AudioSpecificConfig) (*Decoder, error) {
+	switch config.ObjectType {
+	case ObjectTypeAacLc:
+	default:
+		return nil, fmt.Errorf("%w: audio object type %d", errUnsupported, config.ObjectType)
+	}
+	if config.FrameLengthFlag {
+		return nil, fmt.Errorf("%w: 960 samples per frame", errUnsupported)
+	}
+
+	d := &Decoder{Config: config, Pce: config.Pce, seed: 0x1f2e3d4c}
+	d.samplingIndex = config.SamplingFrequencyIndex
+	if d.samplingIndex >= len(samplingFrequencies) {
+		d.samplingIndex = samplingIndex(config.SamplingFrequency)
+	}
+	return d, nil
+}
+
+// samplingIndex returns the sampling_frequency_index of the tables of an arbitrary frequency
+// (ISO/IEC 14496-3 Table 4.82).
+func samplingIndex(frequency int) int {
+	limits := [...]int{92017, 75132, 55426, 46009, 37566, 27713, 23004, 18783, 13856, 11502, 9391}
+	for i, limit := range limits {
+		if frequency >= limit {
+			return i
+		}
+	}
+	return 11
+}
+
+// SampleRate returns the output sampling frequency.
+func (d *Decoder) SampleRate() int {
+	return d.Config.SamplingFrequency
+}
+
+// Channels returns the number of output channels. It is 0 for a stream whose layout is set by a
+// program_config_element before the first frame is decoded.
+func (d *Decoder) Channels() int {
+	if n := d.Config.Channels(); n != 0 {
+		return n
+	}
+	if d.Pce != nil {
+		return d.Pce.Channels()
+	}
+	return len(d.channels)
+}
+
+func (d *Decoder) channel(ch int) *channelState {
+	for len(d.channels) <= ch {
+		d.channels = append(d.channels, &channelState{})
+	}
+	return d.channels[ch]
+}
+
+func (d *Decoder) random() float64 {
+	d.seed = d.seed*1664525 + 1013904223
+	return float64(int32(d.seed)) / (1 << 31)
+}
+
+// DecodeFrame decodes one raw_data_block into interleaved samples of all channels.
+func (d *Decoder) DecodeFrame(frame []byte) ([]float32, error) {
+	return d.DecodeRawDataBlock(utils.NewBitReader(frame))
+}
+
+// DecodeRawDataBlock decodes the raw_data_block at the position of br and leaves it after the ID_END element.
+// Transports carrying several blocks in a frame, as ADTS does, read them one after the other.
+func (d *Decoder) DecodeRawDataBlock(br *utils.BitReader) ([]float32, error) {
+	var outputs [][]float32
+
+	for {
+		id := br.ReadBits(3)
+		if id == idEnd {
+			break
+		}
+		if br.Len() < 0 {
+			return nil, errTruncated
+		}
+
+		switch id {
+		case idSce, idLfe:
+			br.Seek(4) // element_instance_tag
+			s, err := d.readICS(br, nil)
+			if err != nil {
+				return nil, err
+			}
+			d.reconstruct(s)
+			out := make([]float32, frameLength)
+			d.decodeChannel(len(outputs), s, out)
+			outputs = append(outputs, out)
+
+		case idCpe:
+			br.Seek(4) // element_instance_tag
+			left, right, err := d.readChannelPair(br)
+			if err != nil {
+				return nil, err
+			}
+			outLeft, outRight := make([]float32, frameLength), make([]float32, frameLength)
+			d.decodeChannel(len(outputs), left, outLeft)
+			d.decodeChannel(len(outputs)+1, right, outRight)
+			outputs = append(outputs, outLeft, outRight)
+
+		case idCce:
+			return nil, fmt.Errorf("%w: coupling channel element", errUnsupported)
+
+		case idDse:
+			br.Seek(4) // element_instance_tag
+			dataByteAlignFlag := br.ReadBool()
+			count := br.ReadBits(8)
+			if count == 255 {
+				count += br.ReadBits(8)
+			}
+			if dataByteAlignFlag {
+				br.ByteAlign()
+			}
+			br.Seek(count * 8)
+
+		case idPce:
+			d.Pce = readProgramConfigElement(br)
+
+		case idFil:
+			count := br.ReadBits(4)
+			if count == 15 {
+				count += br.ReadBits(8) - 1
+			}
+			br.Seek(count * 8) // extension_payload
+		}
+	}
+	if br.Len() < 0 {
+		return nil, errTruncated
+	}
+
+	// Interleave --------------------------------------------------
+	channels := d.Channels()
+	if channels == 0 {
+		channels = len(outputs)
+	}
+	samples := make([]float32, frameLength*channels)
+	for ch := 0; ch < channels && ch < len(outputs); ch++ {
+		for i, v := range outputs[ch] {
+			samples[i*channels+ch] = v
+		}
+	}
+	return samples, nil
+}
+
+// decodeChannel reconstructs the spectrum of the channel ch and transforms it into out.
+func (d *Decoder) decodeChannel(ch int, s *ics, out []float32) {
+	s.temporalNoiseShaping()
+	d.channel(ch).filterbank(s.info, s.spectrum[:], out)
+}
+
+// readChannelPair reads a channel_pair_element after the element_instance_tag and applies the stereo tools to the
+// spectra of both channels (ISO/IEC 14496-3 4.6.8.1 and 4.6.8.2).
+func (d *Decoder) readChannelPair(br *utils.BitReader) (*ics, *ics, error) {
+	var info *icsInfo
+	var msMaskPresent int
+	var msUsed [8][64]bool
+
+	commonWindow := br.ReadBool()
+	if commonWindow {
+		var err error
+		if info, err = d.readICSInfo(br); err != nil {
+			return nil, nil, err
+		}
+		msMaskPresent = br.ReadBits(2)
+		switch msMaskPresent {
+		case 1:
+			for g := 0; g < info.numWindowGroups; g++ {
+				for sfb := 0; sfb < info.maxSfb; sfb++ {
+					msUsed[g][sfb] = br.ReadBool()
+				}
+			}
+		case 2:
+			for g := range msUsed {
+				for sfb := range msUsed[g] {
+					msUsed[g][sfb] = true
+				}
+			}
+		case 3:
+			return nil, nil, fmt.Errorf("%w: reserved ms_mask_present", errBitstream)
+		}
+	}
+
+	left, err := d.readICS(br, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, err := d.readICS(br, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.reconstruct(left)
+	d.reconstruct(right)
+	if !commonWindow {
+		return left, right, nil
+	}
+
+	window := 0
+	for g := 0; g < info.numWindowGroups; g++ {
+		for sfb := 0; sfb < info.maxSfb; sfb++ {
+			leftCb, rightCb := left.sfbCb[g][sfb], right.sfbCb[g][sfb]
+
+			for w := window; w < window+info.windowGroupLength[g]; w++ {
+				l := left.spectrum[w*128+info.swbOffset[sfb] : w*128+info.swbOffset[sfb+1]]
+				r := right.spectrum[w*128+info.swbOffset[sfb] : w*128+info.swbOffset[sfb+1]]
+
+				switch {
+				// Intensity stereo --------------------------------------------------
+				case rightCb == intensityHcb || rightCb == intensityHcb2:
+					scale := pow2(-0.25 * float64(right.scaleFactors[g][sfb]))
+					if rightCb == intensityHcb2 {
+						scale = -scale
+					}
+					if msMaskPresent == 1 && msUsed[g][sfb] {
+						scale = -scale
+					}
+					for i := range r {
+						r[i] = l[i] * scale
+					}
+
+				// Correlated noise --------------------------------------------------
+				case leftCb == noiseHcb && rightCb == noiseHcb:
+					if msUsed[g][sfb] {
+						scale := pow2(0.25 * float64(right.scaleFactors[g][sfb]-left.scaleFactors[g][sfb]))
+						for i := range r {
+							r[i] = l[i] * scale
+						}
+					}
+
+				// M/S stereo --------------------------------------------------
+				case msUsed[g][sfb] && leftCb != noiseHcb && rightCb != noiseHcb:
+					for i := range l {
+						l[i], r[i] = l[i]+r[i], l[i]-r[i]
+					}
+				}
+			}
+		}
+		window += info.windowGroupLength[g]
+	}
+	return left, right, nil
+}
+
+// reconstruct computes the spectrum of an individual channel stream from the quantized values and the noise
+// substitution.
+func (d *Decoder) reconstruct(s *ics) {
+	s.dequantize()
+	s.noise(d)
+}
+
+func pow2(x float64) float32 {
+	return float32(math.Exp2(x))
+}
@@ -0,0 +1,187 @@
+package aac
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Rising halves of the sine (0) and Kaiser-Bessel derived (1) windows (ISO/IEC 14496-3 4.6.11.3.2).
+var (
+	windowLong  = [2][1024]float32{}
+	windowShort = [2][128]float32{}
+)
+
+func init() {
+	for n := 0; n < 1024; n++ {
+		windowLong[0][n] = float32(math.Sin(math.Pi / 2048 * (float64(n) + 0.5)))
+	}
+	for n := 0; n < 128; n++ {
+		windowShort[0][n] = float32(math.Sin(math.Pi / 256 * (float64(n) + 0.5)))
+	}
+	kbdWindow(windowLong[1][:], 4)
+	kbdWindow(windowShort[1][:], 6)
+}
+
+// kbdWindow computes the rising half of a Kaiser-Bessel derived window of length 2*len(w).
+func kbdWindow(w []float32, alpha float64) {
+	n := len(w) * 2
+	kaiser := make([]float64, n/2+1)
+	var sum float64
+	for j := range kaiser {
+		x := float64(j-n/4) / float64(n/4)
+		kaiser[j] = besselI0(math.Pi * alpha * math.Sqrt(1-x*x))
+		sum += kaiser[j]
+	}
+
+	var acc float64
+	for j := range w {
+		acc += kaiser[j]
+		w[j] = float32(math.Sqrt(acc / sum))
+	}
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= x / 2 / float64(k)
+		sum += term * term
+		if term*term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+// imdct computes the inverse MDCT of the len(in) coefficients into 2*len(in) samples:
+//
+//	out[n] = 2/N * sum(in[k] * cos(2*pi/N * (n + n0) * (k + 1/2))), N = 2*len(in), n0 = (N/2 + 1) / 2
+//
+// The DCT-IV in the middle is computed with a complex FFT of len(in)/2 points.
+func imdct(in []float32, out []float32) {
+	m := len(in) // N/2
+	u := dct4(in)
+
+	scale := 2 / float64(2*m)
+	for n := 0; n < m/2; n++ {
+		out[n] = float32(u[n+m/2] * scale)
+	}
+	for n := m / 2; n < 3*m/2; n++ {
+		out[n] = float32(-u[3*m/2-1-n] * scale)
+	}
+	for n := 3 * m / 2; n < 2*m; n++ {
+		out[n] = float32(-u[n-3*m/2] * scale)
+	}
+}
+
+// dct4 computes u[n] = sum(x[k] * cos(pi/M * (n + 1/2) * (k + 1/2))) of M = len(x) points.
+func dct4(x []float32) []float64 {
+	m := len(x)
+	z := make([]complex128, m/2)
+	for n := range z {
+		angle := -math.Pi * float64(n) / float64(m)
+		z[n] = complex(float64(x[2*n]), float64(x[m-1-2*n])) * cmplx.Rect(1, angle)
+	}
+
+	fft(z)
+
+	u := make([]float64, m)
+	for k := range z {
+		angle := -math.Pi * (float64(k) + 0.25) / float64(m)
+		w := z[k] * cmplx.Rect(1, angle)
+		u[2*k] = real(w)
+		u[m-1-2*k] = -imag(w)
+	}
+	return u
+}
+
+// fft is an in-place radix-2 forward FFT, len(x) is a power of two.
+func fft(x []complex128) {
+	n := len(x)
+
+	// Bit reversal permutation --------------------------------------------------
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	// Butterflies --------------------------------------------------
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, -2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// filterbank transforms the spectrum of a channel into 1024 samples and keeps the second half of the window for the
+// overlap-add of the next frame (ISO/IEC 14496-3 4.6.11).
+func (state *channelState) filterbank(info *icsInfo, spectrum []float32, out []float32) {
+	buf := make([]float32, 2048)
+	shape, prevShape := info.windowShape, state.windowShape
+
+	switch info.windowSequence {
+	case onlyLongSequence, longStartSequence, longStopSequence:
+		imdct(spectrum[:1024], buf)
+
+		// First half --------------------------------------------------
+		if info.windowSequence == longStopSequence {
+			for i := 0; i < 448; i++ {
+				buf[i] = 0
+			}
+			for i := 0; i < 128; i++ {
+				buf[448+i] *= windowShort[prevShape][i]
+			}
+		} else {
+			for i := 0; i < 1024; i++ {
+				buf[i] *= windowLong[prevShape][i]
+			}
+		}
+
+		// Second half --------------------------------------------------
+		if info.windowSequence == longStartSequence {
+			for i := 0; i < 128; i++ {
+				buf[1472+i] *= windowShort[shape][127-i]
+			}
+			for i := 1600; i < 2048; i++ {
+				buf[i] = 0
+			}
+		} else {
+			for i := 0; i < 1024; i++ {
+				buf[1024+i] *= windowLong[shape][1023-i]
+			}
+		}
+
+	case eightShortSequence:
+		short := make([]float32, 256)
+		for w := 0; w < 8; w++ {
+			imdct(spectrum[w*128:(w+1)*128], short)
+
+			riseShape := shape
+			if w == 0 {
+				riseShape = prevShape
+			}
+			for i := 0; i < 128; i++ {
+				buf[448+w*128+i] += short[i] * windowShort[riseShape][i]
+				buf[448+w*128+128+i] += short[128+i] * windowShort[shape][127-i]
+			}
+		}
+	}
+
+	for i := 0; i < 1024; i++ {
+		out[i] = (buf[i] + state.overlap[i]) / 32768
+	}
+	copy(state.overlap[:], buf[1024:])
+	state.windowShape = shape
+}
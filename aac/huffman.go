@@ -0,0 +1,140 @@
+package aac
+
+import (
+	"awCodec/utils"
+)
+
+// Codebook numbers of section_data (ISO/IEC 14496-3 Table 4.150)
+const (
+	zeroHcb       = 0
+	firstPairHcb  = 5
+	escHcb        = 11
+	noiseHcb      = 13
+	intensityHcb2 = 14
+	intensityHcb  = 15
+)
+
+// huffmanTree is a binary tree of a codebook. Every node has two children, a child >= 0 is the index of the next
+// node and a child < 0 is the leaf -(index+1) of the decoded entry.
+type huffmanTree [][2]int32
+
+func newHuffmanTree(codes [][2]uint32) huffmanTree {
+	tree := huffmanTree{{0, 0}}
+	for index, code := range codes {
+		node := 0
+		for i := int(code[1]) - 1; i >= 0; i-- {
+			bit := code[0] >> uint(i) & 0x1
+			if i == 0 {
+				tree[node][bit] = -int32(index) - 1
+				break
+			}
+			if tree[node][bit] == 0 {
+				tree = append(tree, [2]int32{0, 0})
+				tree[node][bit] = int32(len(tree) - 1)
+			}
+			node = int(tree[node][bit])
+		}
+	}
+	return tree
+}
+
+// decode reads one codeword and returns the index of its entry or -1 for an invalid codeword.
+func (tree huffmanTree) decode(br *utils.BitReader) int {
+	node := int32(0)
+	for {
+		node = tree[node][br.ReadBits(1)]
+		if node < 0 {
+			return int(-node - 1)
+		}
+		if node == 0 || br.Len() < 0 {
+			return -1
+		}
+	}
+}
+
+var (
+	spectrumTrees   [11]huffmanTree
+	scalefactorTree huffmanTree
+)
+
+func init() {
+	for i, codebook := range spectrumCodebooks {
+		codes := make([][2]uint32, len(codebook))
+		for j, code := range codebook {
+			codes[j] = [2]uint32{uint32(code[0]), uint32(code[1])}
+		}
+		spectrumTrees[i] = newHuffmanTree(codes)
+	}
+	scalefactorTree = newHuffmanTree(scalefactorCodebook[:])
+}
+
+// Layout of the spectrum codebooks (ISO/IEC 14496-3 Table 4.148)
+var spectrumCodebookInfo = [11]struct {
+	dimension int  // Values per codeword
+	unsigned  bool // Signs are sent after the codeword
+	lav       int  // Largest absolute value
+}{
+	{4, false, 1}, {4, false, 1}, {4, true, 2}, {4, true, 2}, {2, false, 4}, {2, false, 4},
+	{2, true, 7}, {2, true, 7}, {2, true, 12}, {2, true, 12}, {2, true, 16},
+}
+
+// decodeScalefactor reads a scalefactor difference, it returns false for an invalid codeword.
+func decodeScalefactor(br *utils.BitReader) (int, bool) {
+	index := scalefactorTree.decode(br)
+	return index - 60, index >= 0
+}
+
+// decodeSpectrum reads one codeword of the codebook cb (1-11) into the 2 or 4 values of q.
+func decodeSpectrum(br *utils.BitReader, cb int, q []int) bool {
+	info := spectrumCodebookInfo[cb-1]
+	index := spectrumTrees[cb-1].decode(br)
+	if index < 0 {
+		return false
+	}
+
+	// Unpack the index --------------------------------------------------
+	if info.unsigned {
+		mod := info.lav + 1
+		for i := info.dimension - 1; i >= 0; i-- {
+			q[i] = index % mod
+			index /= mod
+		}
+	} else {
+		mod := 2*info.lav + 1
+		for i := info.dimension - 1; i >= 0; i-- {
+			q[i] = index%mod - info.lav
+			index /= mod
+		}
+	}
+	if !info.unsigned {
+		return true
+	}
+
+	// Sign bits --------------------------------------------------
+	for i := 0; i < info.dimension; i++ {
+		if q[i] != 0 && br.ReadBool() {
+			q[i] = -q[i]
+		}
+	}
+
+	// Escape sequences --------------------------------------------------
+	if cb == escHcb {
+		for i := 0; i < info.dimension; i++ {
+			if q[i] == 16 || q[i] == -16 {
+				n := 4
+				for br.ReadBool() {
+					n++
+					if n > 12 {
+						return false
+					}
+				}
+				escape := 1<<uint(n) + br.ReadBits(n)
+				if q[i] < 0 {
+					escape = -escape
+				}
+				q[i] = escape
+			}
+		}
+	}
+	return true
+}
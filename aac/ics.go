@@ -0,0 +1,475 @@
+package aac
+
+import (
+	"awCodec/utils"
+	"fmt"
+	"math"
+)
+
+// ics_info.window_sequence
+const (
+	onlyLongSequence   = 0
+	longStartSequence  = 1
+	eightShortSequence = 2
+	longStopSequence   = 3
+)
+
+// icsInfo is the ics_info of an individual channel stream (ISO/IEC 14496-3 4.4.2.1). Channels of a channel pair
+// element with common_window share it.
+type icsInfo struct {
+	windowSequence      int // 2 bits
+	windowShape         int // 1 bit, 0 - sine window, 1 - KBD window
+	maxSfb              int // 4 bits for short windows, 6 bits for long windows
+	scaleFactorGrouping int // 7 bits
+
+	numWindows        int
+	numWindowGroups   int
+	windowGroupLength [8]int
+	swbOffset         []int // Scalefactor band offsets of one window
+	numSwb            int
+	tnsMaxBands       int
+	tnsMaxOrder       int
+}
+
+// tnsFilter is one filter of tns_data with the coefficients converted to an all-pole filter.
+type tnsFilter struct {
+	length    int // 6 bits for long windows, 4 bits for short windows
+	order     int // 5 bits for long windows, 3 bits for short windows
+	direction int // 1 bit, 1 - filter from the top band downwards
+	lpc       [tnsMaxOrderLong + 1]float32
+}
+
+type tnsData struct {
+	nFilt   [8]int // 2 bits for long windows, 1 bit for short windows
+	filters [8][4]tnsFilter
+}
+
+// ics is an individual_channel_stream (ISO/IEC 14496-3 4.4.2.7).
+type ics struct {
+	info         *icsInfo
+	globalGain   int           // 8 bits
+	sfbCb        [8][64]int    // Codebook of every group and scalefactor band
+	scaleFactors [8][64]int    // Scalefactor, noise energy or intensity position of every group and band
+	tnsPresent   bool          // 1 bit
+	tns          tnsData       //
+	quantized    [1024]int     // Quantized values, 128 per window for short windows
+	spectrum     [1024]float32 // Spectral coefficients, 128 per window for short windows
+}
+
+func (d *Decoder) readICSInfo(br *utils.BitReader) (*icsInfo, error) {
+	info := &icsInfo{}
+
+	br.Seek(1) // ics_reserved_bit
+	info.windowSequence = br.ReadBits(2)
+	info.windowShape = br.ReadBits(1)
+
+	if info.windowSequence == eightShortSequence {
+		info.maxSfb = br.ReadBits(4)
+		info.scaleFactorGrouping = br.ReadBits(7)
+
+		info.numWindows = 8
+		info.numWindowGroups = 1
+		info.windowGroupLength[0] = 1
+		for i := 0; i < 7; i++ {
+			if info.scaleFactorGrouping>>uint(6-i)&0x1 == 1 {
+				info.windowGroupLength[info.numWindowGroups-1]++
+			} else {
+				info.numWindowGroups++
+				info.windowGroupLength[info.numWindowGroups-1] = 1
+			}
+		}
+		info.swbOffset = swbOffsetShort[d.samplingIndex]
+		info.tnsMaxBands = tnsMaxBandsShort[d.samplingIndex]
+		info.tnsMaxOrder = tnsMaxOrderShort
+	} else {
+		info.maxSfb = br.ReadBits(6)
+		if br.ReadBool() {
+			return nil, fmt.Errorf("%w: prediction", errUnsupported)
+		}
+
+		info.numWindows = 1
+		info.numWindowGroups = 1
+		info.windowGroupLength[0] = 1
+		info.swbOffset = swbOffsetLong[d.samplingIndex]
+		info.tnsMaxBands = tnsMaxBandsLong[d.samplingIndex]
+		info.tnsMaxOrder = tnsMaxOrderLong
+	}
+
+	info.numSwb = len(info.swbOffset) - 1
+	if info.maxSfb > info.numSwb {
+		return nil, fmt.Errorf("%w: max_sfb %d of %d bands", errBitstream, info.maxSfb, info.numSwb)
+	}
+	return info, nil
+}
+
+// readICS reads an individual_channel_stream. info is the shared ics_info of a channel pair with common_window.
+func (d *Decoder) readICS(br *utils.BitReader, info *icsInfo) (*ics, error) {
+	var err error
+	s := &ics{info: info}
+
+	s.globalGain = br.ReadBits(8)
+	if s.info == nil {
+		if s.info, err = d.readICSInfo(br); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = s.readSectionData(br); err != nil {
+		return nil, err
+	}
+	if err = s.readScaleFactorData(br); err != nil {
+		return nil, err
+	}
+
+	pulseDataPresent := br.ReadBool()
+	var pulseStartSfb int
+	var pulseOffset, pulseAmp []int
+	if pulseDataPresent {
+		if s.info.windowSequence == eightShortSequence {
+			return nil, fmt.Errorf("%w: pulse data in short window", errBitstream)
+		}
+		numPulse := br.ReadBits(2) + 1
+		pulseStartSfb = br.ReadBits(6)
+		pulseOffset = make([]int, numPulse)
+		pulseAmp = make([]int, numPulse)
+		for i := range pulseOffset {
+			pulseOffset[i] = br.ReadBits(5)
+			pulseAmp[i] = br.ReadBits(4)
+		}
+	}
+
+	s.tnsPresent = br.ReadBool()
+	if s.tnsPresent {
+		s.readTnsData(br)
+	}
+
+	if br.ReadBool() {
+		return nil, fmt.Errorf("%w: gain control", errUnsupported)
+	}
+
+	if err = s.readSpectralData(br); err != nil {
+		return nil, err
+	}
+
+	// Pulse data --------------------------------------------------
+	if pulseDataPresent {
+		if pulseStartSfb >= s.info.numSwb {
+			return nil, fmt.Errorf("%w: pulse start band %d", errBitstream, pulseStartSfb)
+		}
+		k := s.info.swbOffset[pulseStartSfb]
+		for i := range pulseOffset {
+			k += pulseOffset[i]
+			if k >= 1024 {
+				return nil, fmt.Errorf("%w: pulse offset %d", errBitstream, k)
+			}
+			if s.quantized[k] > 0 {
+				s.quantized[k] += pulseAmp[i]
+			} else {
+				s.quantized[k] -= pulseAmp[i]
+			}
+		}
+	}
+
+	if br.Len() < 0 {
+		return nil, errTruncated
+	}
+	return s, nil
+}
+
+func (s *ics) readSectionData(br *utils.BitReader) error {
+	sectBits := 5
+	if s.info.windowSequence == eightShortSequence {
+		sectBits = 3
+	}
+	sectEscVal := 1<<uint(sectBits) - 1
+
+	for g := 0; g < s.info.numWindowGroups; g++ {
+		for k := 0; k < s.info.maxSfb; {
+			cb := br.ReadBits(4)
+			if cb == 12 {
+				return fmt.Errorf("%w: reserved codebook", errBitstream)
+			}
+
+			length := 0
+			for {
+				incr := br.ReadBits(sectBits)
+				length += incr
+				if incr != sectEscVal {
+					break
+				}
+				if br.Len() < 0 {
+					return errTruncated
+				}
+			}
+			if k+length > s.info.maxSfb {
+				return fmt.Errorf("%w: section ends at band %d of %d", errBitstream, k+length, s.info.maxSfb)
+			}
+
+			for sfb := k; sfb < k+length; sfb++ {
+				s.sfbCb[g][sfb] = cb
+			}
+			k += length
+		}
+	}
+	return nil
+}
+
+func (s *ics) readScaleFactorData(br *utils.BitReader) error {
+	scaleFactor := s.globalGain
+	noiseEnergy := s.globalGain - 90
+	isPosition := 0
+	noisePcmFlag := true
+
+	for g := 0; g < s.info.numWindowGroups; g++ {
+		for sfb := 0; sfb < s.info.maxSfb; sfb++ {
+			switch s.sfbCb[g][sfb] {
+			case zeroHcb:
+				s.scaleFactors[g][sfb] = 0
+
+			case intensityHcb, intensityHcb2:
+				delta, ok := decodeScalefactor(br)
+				if !ok {
+					return fmt.Errorf("%w: intensity position", errHuffman)
+				}
+				isPosition += delta
+				s.scaleFactors[g][sfb] = isPosition
+
+			case noiseHcb:
+				if noisePcmFlag {
+					noisePcmFlag = false
+					noiseEnergy += br.ReadBits(9) - 256
+				} else {
+					delta, ok := decodeScalefactor(br)
+					if !ok {
+						return fmt.Errorf("%w: noise energy", errHuffman)
+					}
+					noiseEnergy += delta
+				}
+				s.scaleFactors[g][sfb] = noiseEnergy
+
+			default:
+				delta, ok := decodeScalefactor(br)
+				if !ok {
+					return fmt.Errorf("%w: scalefactor", errHuffman)
+				}
+				scaleFactor += delta
+				if scaleFactor < 0 || scaleFactor > 255 {
+					return fmt.Errorf("%w: scalefactor %d", errBitstream, scaleFactor)
+				}
+				s.scaleFactors[g][sfb] = scaleFactor
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ics) readTnsData(br *utils.BitReader) {
+	nFiltBits, lengthBits, orderBits := 2, 6, 5
+	if s.info.windowSequence == eightShortSequence {
+		nFiltBits, lengthBits, orderBits = 1, 4, 3
+	}
+
+	for w := 0; w < s.info.numWindows; w++ {
+		s.tns.nFilt[w] = br.ReadBits(nFiltBits)
+		coefRes := 0
+		if s.tns.nFilt[w] != 0 {
+			coefRes = br.ReadBits(1)
+		}
+
+		for f := 0; f < s.tns.nFilt[w]; f++ {
+			filter := &s.tns.filters[w][f]
+			filter.length = br.ReadBits(lengthBits)
+			filter.order = br.ReadBits(orderBits)
+			if filter.order == 0 {
+				continue
+			}
+			filter.direction = br.ReadBits(1)
+			coefCompress := br.ReadBits(1)
+			coefBits := coefRes + 3 - coefCompress
+
+			// Dequantize the reflection coefficients --------------------------------------------------
+			iqfac := (float64(int(1)<<uint(coefRes+2)) - 0.5) / (math.Pi / 2)
+			iqfacM := (float64(int(1)<<uint(coefRes+2)) + 0.5) / (math.Pi / 2)
+			var parcor [1 << 5]float64
+			for i := 0; i < filter.order; i++ {
+				coef := br.ReadBits(coefBits)
+				if coef >= 1<<uint(coefBits-1) {
+					coef -= 1 << uint(coefBits)
+				}
+				if coef >= 0 {
+					parcor[i] = math.Sin(float64(coef) / iqfac)
+				} else {
+					parcor[i] = math.Sin(float64(coef) / iqfacM)
+				}
+			}
+
+			// Reflection coefficients to LPC coefficients --------------------------------------------------
+			order := filter.order
+			if order > s.info.tnsMaxOrder {
+				order = s.info.tnsMaxOrder
+			}
+			var a, b [tnsMaxOrderLong + 1]float64
+			a[0] = 1
+			for m := 1; m <= order; m++ {
+				b = a
+				for i := 1; i < m; i++ {
+					a[i] = b[i] + parcor[m-1]*b[m-i]
+				}
+				a[m] = parcor[m-1]
+			}
+			for i := range filter.lpc {
+				filter.lpc[i] = float32(a[i])
+			}
+		}
+	}
+}
+
+func (s *ics) readSpectralData(br *utils.BitReader) error {
+	q := make([]int, 4)
+
+	window := 0
+	for g := 0; g < s.info.numWindowGroups; g++ {
+		for sfb := 0; sfb < s.info.maxSfb; sfb++ {
+			cb := s.sfbCb[g][sfb]
+			if cb == zeroHcb || cb > escHcb {
+				continue
+			}
+			dimension := spectrumCodebookInfo[cb-1].dimension
+
+			for w := window; w < window+s.info.windowGroupLength[g]; w++ {
+				for k := s.info.swbOffset[sfb]; k < s.info.swbOffset[sfb+1]; k += dimension {
+					if !decodeSpectrum(br, cb, q) {
+						return fmt.Errorf("%w: spectral data of codebook %d", errHuffman, cb)
+					}
+					copy(s.quantized[w*128+k:], q[:dimension])
+				}
+			}
+		}
+		window += s.info.windowGroupLength[g]
+	}
+	return nil
+}
+
+// dequantize computes the spectral coefficients of all bands coded with a spectrum codebook.
+func (s *ics) dequantize() {
+	window := 0
+	for g := 0; g < s.info.numWindowGroups; g++ {
+		for sfb := 0; sfb < s.info.maxSfb; sfb++ {
+			cb := s.sfbCb[g][sfb]
+			if cb == zeroHcb || cb > escHcb {
+				continue
+			}
+			gain := float32(math.Pow(2, 0.25*float64(s.scaleFactors[g][sfb]-100)))
+
+			for w := window; w < window+s.info.windowGroupLength[g]; w++ {
+				for k := w*128 + s.info.swbOffset[sfb]; k < w*128+s.info.swbOffset[sfb+1]; k++ {
+					s.spectrum[k] = invQuant(s.quantized[k]) * gain
+				}
+			}
+		}
+		window += s.info.windowGroupLength[g]
+	}
+}
+
+// invQuant returns sign(q) * |q|^(4/3).
+func invQuant(q int) float32 {
+	sign := float32(1)
+	if q < 0 {
+		q, sign = -q, -1
+	}
+	if q <= maxQuantizedValue {
+		return sign * pow43[q]
+	}
+	return sign * float32(math.Pow(float64(q), 4.0/3.0))
+}
+
+// noise fills the bands coded with the noise codebook by perceptual noise substitution (ISO/IEC 14496-3 4.6.13).
+func (s *ics) noise(d *Decoder) {
+	window := 0
+	for g := 0; g < s.info.numWindowGroups; g++ {
+		for sfb := 0; sfb < s.info.maxSfb; sfb++ {
+			if s.sfbCb[g][sfb] != noiseHcb {
+				continue
+			}
+			gain := math.Pow(2, 0.25*float64(s.scaleFactors[g][sfb]))
+
+			for w := window; w < window+s.info.windowGroupLength[g]; w++ {
+				band := s.spectrum[w*128+s.info.swbOffset[sfb] : w*128+s.info.swbOffset[sfb+1]]
+				var energy float64
+				for i := range band {
+					band[i] = float32(d.random())
+					energy += float64(band[i]) * float64(band[i])
+				}
+				scale := float32(gain / math.Sqrt(energy))
+				for i := range band {
+					band[i] *= scale
+				}
+			}
+		}
+		window += s.info.windowGroupLength[g]
+	}
+}
+
+// temporalNoiseShaping applies the TNS filters to the spectrum (ISO/IEC 14496-3 4.6.9).
+func (s *ics) temporalNoiseShaping() {
+	if !s.tnsPresent {
+		return
+	}
+
+	maxBand := s.info.tnsMaxBands
+	if maxBand > s.info.maxSfb {
+		maxBand = s.info.maxSfb
+	}
+
+	for w := 0; w < s.info.numWindows; w++ {
+		spectrum := s.spectrum[w*128:]
+		bottom := s.info.numSwb
+
+		for f := 0; f < s.tns.nFilt[w]; f++ {
+			filter := &s.tns.filters[w][f]
+			top := bottom
+			bottom = top - filter.length
+			if bottom < 0 {
+				bottom = 0
+			}
+			order := filter.order
+			if order > s.info.tnsMaxOrder {
+				order = s.info.tnsMaxOrder
+			}
+			if order == 0 {
+				continue
+			}
+
+			start := s.info.swbOffset[minInt(bottom, maxBand)]
+			end := s.info.swbOffset[minInt(top, maxBand)]
+			size := end - start
+			if size <= 0 {
+				continue
+			}
+			inc := 1
+			if filter.direction == 1 {
+				inc = -1
+				start = end - 1
+			}
+
+			// All-pole filter y[n] = x[n] - lpc[1]*y[n-1] - ... - lpc[order]*y[n-order]
+			var state [tnsMaxOrderLong]float32
+			for n, k := 0, start; n < size; n, k = n+1, k+inc {
+				y := spectrum[k]
+				for i := 0; i < order; i++ {
+					y -= filter.lpc[i+1] * state[i]
+				}
+				copy(state[1:order], state[:order-1])
+				state[0] = y
+				spectrum[k] = y
+			}
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,85 @@
+package aac
+
+import "math"
+
+// Scalefactor band offsets of long windows (ISO/IEC 14496-3 Table 4.129 - 4.147)
+var (
+	swbOffsetLong96 = []int{
+		0, 4, 8, 12, 16, 20, 24, 28, 32, 36, 40, 44, 48, 52, 56, 64, 72, 80, 88, 96, 108, 120, 132, 144, 156, 172,
+		188, 212, 240, 276, 320, 384, 448, 512, 576, 640, 704, 768, 832, 896, 960, 1024,
+	}
+	swbOffsetLong64 = []int{
+		0, 4, 8, 12, 16, 20, 24, 28, 32, 36, 40, 44, 48, 52, 56, 64, 72, 80, 88, 100, 112, 124, 140, 156, 172, 192,
+		216, 240, 268, 304, 344, 384, 424, 464, 504, 544, 584, 624, 664, 704, 744, 784, 824, 864, 904, 944, 984,
+		1024,
+	}
+	swbOffsetLong48 = []int{
+		0, 4, 8, 12, 16, 20, 24, 28, 32, 36, 40, 48, 56, 64, 72, 80, 88, 96, 108, 120, 132, 144, 160, 176, 196, 216,
+		240, 264, 292, 320, 352, 384, 416, 448, 480, 512, 544, 576, 608, 640, 672, 704, 736, 768, 800, 832, 864,
+		896, 928, 1024,
+	}
+	swbOffsetLong32 = []int{
+		0, 4, 8, 12, 16, 20, 24, 28, 32, 36, 40, 48, 56, 64, 72, 80, 88, 96, 108, 120, 132, 144, 160, 176, 196, 216,
+		240, 264, 292, 320, 352, 384, 416, 448, 480, 512, 544, 576, 608, 640, 672, 704, 736, 768, 800, 832, 864,
+		896, 928, 960, 992, 1024,
+	}
+	swbOffsetLong24 = []int{
+		0, 4, 8, 12, 16, 20, 24, 28, 32, 36, 40, 44, 52, 60, 68, 76, 84, 92, 100, 108, 116, 124, 136, 148, 160, 172,
+		188, 204, 220, 240, 260, 284, 308, 336, 364, 396, 432, 468, 508, 552, 600, 652, 704, 768, 832, 896, 960,
+		1024,
+	}
+	swbOffsetLong16 = []int{
+		0, 8, 16, 24, 32, 40, 48, 56, 64, 72, 80, 88, 100, 112, 124, 136, 148, 160, 172, 184, 196, 212, 228, 244,
+		260, 280, 300, 320, 344, 368, 396, 424, 456, 492, 532, 572, 616, 664, 716, 772, 832, 896, 960, 1024,
+	}
+	swbOffsetLong8 = []int{
+		0, 12, 24, 36, 48, 60, 72, 84, 96, 108, 120, 132, 144, 156, 172, 188, 204, 220, 236, 252, 268, 288, 308, 328,
+		348, 372, 396, 420, 448, 476, 508, 544, 580, 620, 664, 712, 764, 820, 880, 944, 1024,
+	}
+)
+
+// Scalefactor band offsets of short windows (ISO/IEC 14496-3 Table 4.129 - 4.147)
+var (
+	swbOffsetShort96 = []int{0, 4, 8, 12, 16, 20, 24, 32, 40, 48, 64, 92, 128}
+	swbOffsetShort48 = []int{0, 4, 8, 12, 16, 20, 28, 36, 44, 56, 68, 80, 96, 112, 128}
+	swbOffsetShort24 = []int{0, 4, 8, 12, 16, 20, 24, 28, 36, 44, 52, 64, 76, 92, 108, 128}
+	swbOffsetShort16 = []int{0, 4, 8, 12, 16, 20, 24, 28, 32, 40, 48, 60, 72, 88, 108, 128}
+	swbOffsetShort8  = []int{0, 4, 8, 12, 16, 20, 24, 28, 36, 44, 52, 60, 72, 88, 108, 128}
+)
+
+// Scalefactor band offsets by sampling_frequency_index. The number of bands is one less than the number of offsets.
+var swbOffsetLong = [13][]int{
+	swbOffsetLong96, swbOffsetLong96, swbOffsetLong64, swbOffsetLong48, swbOffsetLong48, swbOffsetLong32,
+	swbOffsetLong24, swbOffsetLong24, swbOffsetLong16, swbOffsetLong16, swbOffsetLong16, swbOffsetLong8,
+	swbOffsetLong8,
+}
+
+var swbOffsetShort = [13][]int{
+	swbOffsetShort96, swbOffsetShort96, swbOffsetShort96, swbOffsetShort48, swbOffsetShort48, swbOffsetShort48,
+	swbOffsetShort24, swbOffsetShort24, swbOffsetShort16, swbOffsetShort16, swbOffsetShort16, swbOffsetShort8,
+	swbOffsetShort8,
+}
+
+// Highest scalefactor band of TNS for AAC LC by sampling_frequency_index (ISO/IEC 14496-3 Table 4.156)
+var (
+	tnsMaxBandsLong  = [13]int{31, 31, 34, 40, 42, 51, 46, 46, 42, 42, 42, 39, 39}
+	tnsMaxBandsShort = [13]int{9, 9, 10, 14, 14, 14, 14, 14, 14, 14, 14, 14, 14}
+)
+
+// Highest order of the TNS filter for AAC LC.
+const (
+	tnsMaxOrderLong  = 12
+	tnsMaxOrderShort = 7
+)
+
+// Largest quantized value, 8191 for the escape codebook.
+const maxQuantizedValue = 8191
+
+// pow43 contains x^(4/3) of the quantized values.
+var pow43 = [maxQuantizedValue + 1]float32{}
+
+func init() {
+	for i := range pow43 {
+		pow43[i] = float32(math.Pow(float64(i), 4.0/3.0))
+	}
+}
@@ -0,0 +1,59 @@
+package id3
+
+import "encoding/binary"
+
+// Frame is a frame of an ID3v2.4 tag written by Tag.
+type Frame struct {
+	ID   [4]byte
+	Data []byte // Content of the frame after its header
+}
+
+// frameID returns the identifier of a frame from its four characters.
+func frameID(id string) [4]byte {
+	var b [4]byte
+	copy(b[:], id)
+	return b
+}
+
+// TextFrame returns a text information frame, like TIT2 for the title, encoded in UTF-8.
+func TextFrame(id string, text string) Frame {
+	return Frame{ID: frameID(id), Data: append([]byte{encodingUTF8}, text...)}
+}
+
+// UserTextFrame returns a user defined text information frame TXXX encoded in UTF-8.
+func UserTextFrame(description, value string) Frame {
+	data := append([]byte{encodingUTF8}, description...)
+	data = append(data, 0x00)
+	return Frame{ID: frameID("TXXX"), Data: append(data, value...)}
+}
+
+// PrivateFrame returns a private frame PRIV of an owner, like com.apple.streaming.transportStreamTimestamp.
+func PrivateFrame(owner string, data []byte) Frame {
+	b := append([]byte(owner), 0x00)
+	return Frame{ID: frameID("PRIV"), Data: append(b, data...)}
+}
+
+// Tag returns an ID3v2.4 tag of the frames, without padding.
+func Tag(frames ...Frame) []byte {
+	size := 0
+	for _, f := range frames {
+		size += 10 + len(f.Data)
+	}
+	b := make([]byte, 10, 10+size)
+	copy(b, fileIdentifier)
+	b[3] = 4 // Version 2.4.0
+	putSyncsafe(b[6:], size)
+	for _, f := range frames {
+		header := make([]byte, 10)
+		copy(header, f.ID[:])
+		putSyncsafe(header[4:], len(f.Data))
+		b = append(b, header...)
+		b = append(b, f.Data...)
+	}
+	return b
+}
+
+// putSyncsafe writes a size of 28 bits as a synchsafe integer of 4 bytes, 7 bits per byte.
+func putSyncsafe(b []byte, size int) {
+	binary.BigEndian.PutUint32(b, uint32(size&0x7F|size<<1&0x7F00|size<<2&0x7F0000|size<<3&0x7F000000))
+}
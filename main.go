@@ -14,9 +14,11 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	file := bytes.NewBuffer(content)
+	file := bytes.NewReader(content)
 
-	mpeg.Mp4(file)
+	if _, err := mpeg.ReadMp4(file); err != nil {
+		log.Fatal(err)
+	}
 
 	//out, _ := mpeg.DecodeMp3(file)
 
@@ -6,7 +6,6 @@ import (
 	"awCodec/utils"
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"math"
 )
 
@@ -126,352 +125,430 @@ func decodeMpeg1(file []byte) (*pcm.F32LE, error) {
 
 	file, _ = id3.ReadID3(file)
 
-	fileBuf := bytes.NewBuffer(file)
-
-	var prevData []byte
-	prevSamples := [2][32][18]float32{}
-	vVec := [2][1024]float32{}
-
-	for fileBuf.Len() != 0 {
-		// Header =====================================================================================================
-		header := binary.BigEndian.Uint32(fileBuf.Next(4))
-		if header>>20&syncWord != syncWord {
-			continue
-		}
-
-		id := uint8(header >> 19 & 0x1)
-		if id != mpeg1 {
+	decoder := &audioDecoder{}
+	for offset := 0; offset+4 <= len(file); {
+		h, ok := parseAudioFrameHeader(binary.BigEndian.Uint32(file[offset:]))
+		if !ok || offset+h.frameLength > len(file) {
+			offset++
 			continue
 		}
-		layer := uint8(header >> 17 & 0x3)
-		protectionBit := uint8(header >> 16 & 0x1)
-		bitrateIndex := uint8(header >> 12 & 0xF)
-		samplingFrequency := uint8(header >> 10 & 0x3)
-		paddingBit := uint8(header >> 9 & 0x1)
-		//privateBit := uint8(h >> 8 & 0x1)
-		mode := uint8(header >> 6 & 0x3)
-		modeExtension := uint8(header >> 4 & 0x3)
-		//copyright := uint8(h >> 3 & 0x1)
-		//copy := uint8(h >> 2 & 0x1)
-		//emphasis := uint8(h >> 0 & 0x3)
-
-		// Frame length --------------------------------------------------
-		frameSize := 144 // byte for layer2 and layer3 1152/(1b*8bit) = 144; for layer1 384/(4b*8bit) = 12
-		if layer == layer1 {
-			frameSize = 48 // 384/8bit = 48, why not 12???
-		}
-		bitrate := bitrateSpecified[layer-1][bitrateIndex]
-		sampleRate := frequencySpecified[samplingFrequency]
-		frameLength := 0
-		if sampleRate != 0 {
-			frameLength = frameSize * bitrate / sampleRate
-			if paddingBit == padding {
-				frameLength += 1
-			}
-		}
-		fmt.Println(bitrate/1000, sampleRate, frameLength)
-		frame := bytes.NewBuffer(fileBuf.Next(frameLength - 4))
 
 		if out.Context().SampleRate == 0 {
-			out.Context().SampleRate = sampleRate
+			out.Context().SampleRate = h.sampleRate
+			out.Context().Channels = h.nch
 		}
+		out.Append(decoder.decodeFrame(h, file[offset:offset+h.frameLength]))
+		offset += h.frameLength
+	}
 
-		// Error check ================================================================================================
-		if protectionBit == protected {
-			_ = frame.Next(2) // crc
-		}
+	return out, nil
+}
 
-		// Audio data =================================================================================================
-		nch := 2 // number of channels, equals 1 for single_channel mode, equals 2 for other modes.
-		if mode == modeSingleChannel {
-			nch = 1
-		}
+// audioFrameHeader is the header of an MPEG audio frame (ISO/IEC 11172-3 2.4.1.3).
+type audioFrameHeader struct {
+	layer             uint8 // 2 bits
+	protectionBit     uint8 // 1 bit
+	bitrateIndex      uint8 // 4 bits
+	samplingFrequency uint8 // 2 bits
+	paddingBit        uint8 // 1 bit
+	mode              uint8 // 2 bits
+	modeExtension     uint8 // 2 bits
+
+	bitrate     int // Bit/s
+	sampleRate  int // Hz
+	frameLength int // Bytes including the header
+	nch         int // Number of channels, 1 for single_channel mode and 2 for other modes
+}
 
-		// TODO rework to standard
-		if out.Context().Channels == 0 {
-			out.Context().Channels = nch
-		}
+// parseAudioFrameHeader reads the 32 bits of a frame header. It reports false if it is not the header of an MPEG-1
+// frame which can be decoded.
+func parseAudioFrameHeader(header uint32) (*audioFrameHeader, bool) {
+	if header>>20&syncWord != syncWord {
+		return nil, false
+	}
 
-		if layer == layer1 {
-			br := utils.NewBitReader(frame.Bytes())
+	id := uint8(header >> 19 & 0x1)
+	if id != mpeg1 {
+		return nil, false
+	}
+	h := &audioFrameHeader{}
+	h.layer = uint8(header >> 17 & 0x3)
+	h.protectionBit = uint8(header >> 16 & 0x1)
+	h.bitrateIndex = uint8(header >> 12 & 0xF)
+	h.samplingFrequency = uint8(header >> 10 & 0x3)
+	h.paddingBit = uint8(header >> 9 & 0x1)
+	//privateBit := uint8(h >> 8 & 0x1)
+	h.mode = uint8(header >> 6 & 0x3)
+	h.modeExtension = uint8(header >> 4 & 0x3)
+	//copyright := uint8(h >> 3 & 0x1)
+	//copy := uint8(h >> 2 & 0x1)
+	//emphasis := uint8(h >> 0 & 0x3)
+
+	if h.layer == layerReserved {
+		return nil, false
+	}
 
-			bound := 32
-			if mode == modeJoinStereo {
-				bound = subbands[modeExtension]
-			}
+	// Frame length --------------------------------------------------
+	frameSize := 144 // byte for layer2 and layer3 1152/(1b*8bit) = 144; for layer1 384/(4b*8bit) = 12
+	if h.layer == layer1 {
+		frameSize = 48 // 384/8bit = 48, why not 12???
+	}
+	h.bitrate = bitrateSpecified[h.layer-1][h.bitrateIndex]
+	h.sampleRate = frequencySpecified[h.samplingFrequency]
+	if h.bitrate == 0 || h.sampleRate == 0 { // Free format and reserved values
+		return nil, false
+	}
+	h.frameLength = frameSize * h.bitrate / h.sampleRate
+	if h.paddingBit == padding {
+		h.frameLength += 1
+	}
 
-			_, samples := decodeLayer1(br, nch, bound)
-			pcm_ := make([]float32, 32*2*12)
+	h.nch = 2
+	if h.mode == modeSingleChannel {
+		h.nch = 1
+	}
+	return h, true
+}
 
-			for ch := 0; ch < nch; ch++ {
-				for s := 0; s < 12; s++ {
-					synthSubbandFilter(samples[ch][32*s:], ch, &vVec[ch], pcm_[32*2*s:])
-				}
-			}
+// isLsfFrameHeader reports whether the 32 bits are the header of an MPEG-2 audio frame of a low sampling frequency
+// (ISO/IEC 13818-3 2.4.2.3), which is not decoded.
+func isLsfFrameHeader(header uint32) bool {
+	bitrateIndex, samplingFrequency := header>>12&0xF, header>>10&0x3
+	return header>>20&syncWord == syncWord && header>>19&0x1 == mpeg2 && header>>17&0x3 != layerReserved &&
+		bitrateIndex != 0 && bitrateIndex != 0xF && samplingFrequency != 0x3
+}
+
+// audioDecoder keeps the state of an MPEG audio stream between frames: the bit reservoir of layer III, the overlap
+// of the IMDCT and the V vectors of the synthesis filterbank.
+type audioDecoder struct {
+	prevData    []byte
+	prevSamples [2][32][18]float32
+	vVec        [2][1024]float32
+}
 
-			out.Append(pcm_)
+// decodeFrame decodes the frame of the header h, frameBytes starts with the header. The samples of the channels are
+// interleaved.
+func (d *audioDecoder) decodeFrame(h *audioFrameHeader, frameBytes []byte) []float32 {
+	layer, protectionBit, samplingFrequency := h.layer, h.protectionBit, h.samplingFrequency
+	mode, modeExtension, frameLength := h.mode, h.modeExtension, h.frameLength
+	frame := bytes.NewBuffer(frameBytes[4:frameLength])
 
-		} else if layer == layer2 {
+	// Error check ================================================================================================
+	if protectionBit == protected {
+		_ = frame.Next(2) // crc
+	}
 
-		} else if layer == layer3 {
+	// Audio data =================================================================================================
+	nch := 2 // number of channels, equals 1 for single_channel mode, equals 2 for other modes.
+	if mode == modeSingleChannel {
+		nch = 1
+	}
 
-		}
+	if layer == layer1 {
+		br := utils.NewBitReader(frame.Bytes())
 
-		// Ancillary data =============================================================================================
-		if layer == layer1 || layer == layer2 {
-			//fmt.Println(float32(br.Counter)/8)
-			continue
+		bound := 32
+		if mode == modeJoinStereo {
+			bound = subbands[modeExtension]
 		}
 
-		// Side Information ===========================================================================================
-		sideInformationLength := 32
-		if mode == modeSingleChannel {
-			sideInformationLength = 17
+		_, samples := decodeLayer1(br, nch, bound)
+		pcm_ := make([]float32, 32*2*12)
+
+		for ch := 0; ch < nch; ch++ {
+			for s := 0; s < 12; s++ {
+				synthSubbandFilter(samples[ch][32*s:], ch, &d.vVec[ch], pcm_[32*2*s:])
+			}
 		}
 
-		sideInfoBitReader := utils.NewBitReader(frame.Next(sideInformationLength))
+		return mono(pcm_, nch)
 
-		sideInfo := sideInformation{}
-		sideInfo.MainDataBegin = uint16(sideInfoBitReader.ReadBits(9)) // main_data_begin
+	} else if layer == layer2 {
+		br := utils.NewBitReader(frame.Bytes())
 
-		if mode == modeSingleChannel {
-			sideInfo.PrivateBits = byte(sideInfoBitReader.ReadBits(5)) // private_bits
-		} else {
-			sideInfo.PrivateBits = byte(sideInfoBitReader.ReadBits(3)) // private_bits
+		bound := 32
+		if mode == modeJoinStereo {
+			bound = subbands[modeExtension]
 		}
 
+		samples := decodeLayer2(br, nch, bound, layer2Table(h.bitrate, h.sampleRate, nch))
+		pcm_ := make([]float32, 32*2*36)
+
 		for ch := 0; ch < nch; ch++ {
-			for band := 0; band < 4; band++ {
-				sideInfo.Scfsi[ch][band] = byte(sideInfoBitReader.ReadBits(1)) // scfsi[ch][scfsi_band]
+			for s := 0; s < 36; s++ {
+				synthSubbandFilter(samples[ch][32*s:], ch, &d.vVec[ch], pcm_[32*2*s:])
 			}
 		}
 
-		for gr := 0; gr < 2; gr++ { // 2 granules for MPEG1, 1 granules for MPEG2
-			for ch := 0; ch < nch; ch++ {
-				sideInfo.Part23Length[gr][ch] = uint16(sideInfoBitReader.ReadBits(12))      // part2_3_length[gr][ch]
-				sideInfo.BigValues[gr][ch] = uint16(sideInfoBitReader.ReadBits(9))          // big_values[gr][ch]
-				sideInfo.GlobalGain[gr][ch] = uint8(sideInfoBitReader.ReadBits(8))          // global_gain[gr][ch]
-				sideInfo.ScalefacCompress[gr][ch] = byte(sideInfoBitReader.ReadBits(4))     // scalefac_compress[gr][ch]
-				sideInfo.WindowsSwitchingFlag[gr][ch] = byte(sideInfoBitReader.ReadBits(1)) // window_switching_flag[gr][ch]
+		return mono(pcm_, nch)
 
-				if sideInfo.WindowsSwitchingFlag[gr][ch] == 1 {
-					sideInfo.BlockType[gr][ch] = byte(sideInfoBitReader.ReadBits(2))       // block_type[gr][ch]
-					sideInfo.MixedBlockFlag[gr][ch] = uint8(sideInfoBitReader.ReadBits(1)) // mixed_block_flag[gr][ch]
+	} else if layer == layer3 {
 
-					for region := 0; region < 2; region++ {
-						sideInfo.TableSelect[gr][ch][region] = byte(sideInfoBitReader.ReadBits(5)) // table_select[gr][ch][region]
-					}
+	}
 
-					for window := 0; window < 3; window++ {
-						sideInfo.SubblockGain[gr][ch][window] = uint8(sideInfoBitReader.ReadBits(3)) // subblock_gain[gr][ch][window]
-					}
+	// Side Information ===========================================================================================
+	sideInformationLength := 32
+	if mode == modeSingleChannel {
+		sideInformationLength = 17
+	}
 
-					// Set default if window switching set
-					blockType := sideInfo.BlockType[gr][ch]
-					mixedBlock := sideInfo.MixedBlockFlag[gr][ch]
-					if blockType == 1 || blockType == 3 || blockType == 2 && mixedBlock == 1 {
-						sideInfo.Region0Count[gr][ch] = 7
-					} else if blockType == 2 && mixedBlock != 1 {
-						sideInfo.Region0Count[gr][ch] = 8
-					}
-					sideInfo.Region1Count[gr][ch] = 20 - sideInfo.Region0Count[gr][ch]
+	sideInfoBitReader := utils.NewBitReader(frame.Next(sideInformationLength))
 
-				} else {
-					// Set default if window not switching
-					//sideInfo.BlockType[gr][ch] = 0
-					//sideInfo.MixedBlockFlag[gr][ch] = 0
+	sideInfo := sideInformation{}
+	sideInfo.MainDataBegin = uint16(sideInfoBitReader.ReadBits(9)) // main_data_begin
 
-					for region := 0; region < 3; region++ {
-						sideInfo.TableSelect[gr][ch][region] = byte(sideInfoBitReader.ReadBits(5)) // table_select[gr][ch][region]
-					}
+	if mode == modeSingleChannel {
+		sideInfo.PrivateBits = byte(sideInfoBitReader.ReadBits(5)) // private_bits
+	} else {
+		sideInfo.PrivateBits = byte(sideInfoBitReader.ReadBits(3)) // private_bits
+	}
 
-					sideInfo.Region0Count[gr][ch] = byte(sideInfoBitReader.ReadBits(4)) // region0_count[gr][ch]
-					sideInfo.Region1Count[gr][ch] = byte(sideInfoBitReader.ReadBits(3)) // region1_count[gr][ch]
+	for ch := 0; ch < nch; ch++ {
+		for band := 0; band < 4; band++ {
+			sideInfo.Scfsi[ch][band] = byte(sideInfoBitReader.ReadBits(1)) // scfsi[ch][scfsi_band]
+		}
+	}
+
+	for gr := 0; gr < 2; gr++ { // 2 granules for MPEG1, 1 granules for MPEG2
+		for ch := 0; ch < nch; ch++ {
+			sideInfo.Part23Length[gr][ch] = uint16(sideInfoBitReader.ReadBits(12))      // part2_3_length[gr][ch]
+			sideInfo.BigValues[gr][ch] = uint16(sideInfoBitReader.ReadBits(9))          // big_values[gr][ch]
+			sideInfo.GlobalGain[gr][ch] = uint8(sideInfoBitReader.ReadBits(8))          // global_gain[gr][ch]
+			sideInfo.ScalefacCompress[gr][ch] = byte(sideInfoBitReader.ReadBits(4))     // scalefac_compress[gr][ch]
+			sideInfo.WindowsSwitchingFlag[gr][ch] = byte(sideInfoBitReader.ReadBits(1)) // window_switching_flag[gr][ch]
+
+			if sideInfo.WindowsSwitchingFlag[gr][ch] == 1 {
+				sideInfo.BlockType[gr][ch] = byte(sideInfoBitReader.ReadBits(2))       // block_type[gr][ch]
+				sideInfo.MixedBlockFlag[gr][ch] = uint8(sideInfoBitReader.ReadBits(1)) // mixed_block_flag[gr][ch]
+
+				for region := 0; region < 2; region++ {
+					sideInfo.TableSelect[gr][ch][region] = byte(sideInfoBitReader.ReadBits(5)) // table_select[gr][ch][region]
 				}
 
-				sideInfo.Preflag[gr][ch] = byte(sideInfoBitReader.ReadBits(1))           // preflag[gr][ch]
-				sideInfo.ScalfacScale[gr][ch] = byte(sideInfoBitReader.ReadBits(1))      // scalefac_scale[gr][ch]
-				sideInfo.Count1tableSelect[gr][ch] = byte(sideInfoBitReader.ReadBits(1)) // count1table_select[gr][ch]
-			}
-		}
+				for window := 0; window < 3; window++ {
+					sideInfo.SubblockGain[gr][ch][window] = uint8(sideInfoBitReader.ReadBits(3)) // subblock_gain[gr][ch][window]
+				}
 
-		//fmt.Printf("%+v\n", sideInfo)
+				// Set default if window switching set
+				blockType := sideInfo.BlockType[gr][ch]
+				mixedBlock := sideInfo.MixedBlockFlag[gr][ch]
+				if blockType == 1 || blockType == 3 || blockType == 2 && mixedBlock == 1 {
+					sideInfo.Region0Count[gr][ch] = 7
+				} else if blockType == 2 && mixedBlock != 1 {
+					sideInfo.Region0Count[gr][ch] = 8
+				}
+				sideInfo.Region1Count[gr][ch] = 20 - sideInfo.Region0Count[gr][ch]
 
-		// Main Data ==================================================================================================
-		mainDataLength := frameLength - sideInformationLength - 4 // 4 bytes header
-		if protectionBit == protected {
-			mainDataLength -= 2
-		}
+			} else {
+				// Set default if window not switching
+				//sideInfo.BlockType[gr][ch] = 0
+				//sideInfo.MixedBlockFlag[gr][ch] = 0
 
-		mainData := frame.Next(mainDataLength)
+				for region := 0; region < 3; region++ {
+					sideInfo.TableSelect[gr][ch][region] = byte(sideInfoBitReader.ReadBits(5)) // table_select[gr][ch][region]
+				}
+
+				sideInfo.Region0Count[gr][ch] = byte(sideInfoBitReader.ReadBits(4)) // region0_count[gr][ch]
+				sideInfo.Region1Count[gr][ch] = byte(sideInfoBitReader.ReadBits(3)) // region1_count[gr][ch]
+			}
 
-		if sideInfo.MainDataBegin != 0 {
-			mainData = append(prevData[len(prevData)-int(sideInfo.MainDataBegin):], mainData...)
+			sideInfo.Preflag[gr][ch] = byte(sideInfoBitReader.ReadBits(1))           // preflag[gr][ch]
+			sideInfo.ScalfacScale[gr][ch] = byte(sideInfoBitReader.ReadBits(1))      // scalefac_scale[gr][ch]
+			sideInfo.Count1tableSelect[gr][ch] = byte(sideInfoBitReader.ReadBits(1)) // count1table_select[gr][ch]
 		}
-		//fmt.Printf("%d %x\n", len(mainData), mainData)
+	}
 
-		prevData = mainData
+	//fmt.Printf("%+v\n", sideInfo)
 
-		mainDataBitReader := utils.NewBitReader(mainData)
+	// Main Data ==================================================================================================
+	mainDataLength := frameLength - sideInformationLength - 4 // 4 bytes header
+	if protectionBit == protected {
+		mainDataLength -= 2
+	}
 
-		scalefac := Scalefac{}
-		is := [2][2][iblen]float32{}
-		countValues := [2][2]int{}
+	mainData := frame.Next(mainDataLength)
 
-		for gr := 0; gr < 2; gr++ {
-			for ch := 0; ch < nch; ch++ {
-				mainDataBitReader.Counter = 0
+	// The bit reservoir is missing at the start of a stream which is cut out of a longer one.
+	if int(sideInfo.MainDataBegin) > len(d.prevData) {
+		d.prevData = append(d.prevData, mainData...)
+		return make([]float32, iblen*2*nch)
+	}
+	if sideInfo.MainDataBegin != 0 {
+		mainData = append(d.prevData[len(d.prevData)-int(sideInfo.MainDataBegin):len(d.prevData):len(d.prevData)], mainData...)
+	}
+	//fmt.Printf("%d %x\n", len(mainData), mainData)
 
-				slen1 := scalefacCompress[sideInfo.ScalefacCompress[gr][ch]][0]
-				slen2 := scalefacCompress[sideInfo.ScalefacCompress[gr][ch]][1]
+	d.prevData = mainData
 
-				// Scalefactor ========================================================================================
-				//var part2Length int // Number of bits used for scalefactors
-				if sideInfo.WindowsSwitchingFlag[gr][ch] == 1 && sideInfo.BlockType[gr][ch] == blockShort {
-					if sideInfo.MixedBlockFlag[gr][ch] == 1 { // Mixed blocks
-						//part2Length = 17*slen1 + 18*slen2 // part2_length all bit length
+	mainDataBitReader := utils.NewBitReader(mainData)
 
-						for sfb := 0; sfb < 8; sfb++ { // scalefactors bands
-							scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen1))
-						}
-						for sfb := 3; sfb < 6; sfb++ {
-							for window := 0; window < 3; window++ {
-								scalefac.S[gr][ch][sfb][window] = byte(mainDataBitReader.ReadBits(slen1))
-							}
-						}
+	scalefac := Scalefac{}
+	is := [2][2][iblen]float32{}
+	countValues := [2][2]int{}
+
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < nch; ch++ {
+			mainDataBitReader.Counter = 0
 
-					} else { // Short blocks
-						//part2Length = 18*slen1 + 18*slen2 // part2_length all bit length
+			slen1 := scalefacCompress[sideInfo.ScalefacCompress[gr][ch]][0]
+			slen2 := scalefacCompress[sideInfo.ScalefacCompress[gr][ch]][1]
 
-						for sfb := 0; sfb < 6; sfb++ {
-							for window := 0; window < 3; window++ {
-								scalefac.S[gr][ch][sfb][window] = byte(mainDataBitReader.ReadBits(slen1))
-							}
+			// Scalefactor ========================================================================================
+			//var part2Length int // Number of bits used for scalefactors
+			if sideInfo.WindowsSwitchingFlag[gr][ch] == 1 && sideInfo.BlockType[gr][ch] == blockShort {
+				if sideInfo.MixedBlockFlag[gr][ch] == 1 { // Mixed blocks
+					//part2Length = 17*slen1 + 18*slen2 // part2_length all bit length
+
+					for sfb := 0; sfb < 8; sfb++ { // scalefactors bands
+						scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen1))
+					}
+					for sfb := 3; sfb < 6; sfb++ {
+						for window := 0; window < 3; window++ {
+							scalefac.S[gr][ch][sfb][window] = byte(mainDataBitReader.ReadBits(slen1))
 						}
 					}
 
-					for sfb := 6; sfb < 12; sfb++ {
+				} else { // Short blocks
+					//part2Length = 18*slen1 + 18*slen2 // part2_length all bit length
+
+					for sfb := 0; sfb < 6; sfb++ {
 						for window := 0; window < 3; window++ {
-							scalefac.S[gr][ch][sfb][window] = byte(mainDataBitReader.ReadBits(slen2))
+							scalefac.S[gr][ch][sfb][window] = byte(mainDataBitReader.ReadBits(slen1))
 						}
 					}
+				}
 
-				} else { // Long blocks
-					//part2Length = 11*slen1 + 10*slen2 // part2_length all bit length
+				for sfb := 6; sfb < 12; sfb++ {
+					for window := 0; window < 3; window++ {
+						scalefac.S[gr][ch][sfb][window] = byte(mainDataBitReader.ReadBits(slen2))
+					}
+				}
 
-					if gr == 0 {
-						for sfb := 0; sfb < 11; sfb++ {
-							scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen1))
-						}
-						for sfb := 11; sfb < 21; sfb++ {
-							scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen2))
-						}
+			} else { // Long blocks
+				//part2Length = 11*slen1 + 10*slen2 // part2_length all bit length
 
-					} else {
-						for sfb := 0; sfb < 6; sfb++ {
-							if sideInfo.Scfsi[ch][0] == 0 {
-								scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen1))
-							} else {
-								scalefac.L[gr][ch][sfb] = scalefac.L[0][ch][sfb]
-							}
+				if gr == 0 {
+					for sfb := 0; sfb < 11; sfb++ {
+						scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen1))
+					}
+					for sfb := 11; sfb < 21; sfb++ {
+						scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen2))
+					}
+
+				} else {
+					for sfb := 0; sfb < 6; sfb++ {
+						if sideInfo.Scfsi[ch][0] == 0 {
+							scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen1))
+						} else {
+							scalefac.L[gr][ch][sfb] = scalefac.L[0][ch][sfb]
 						}
-						for sfb := 6; sfb < 11; sfb++ {
-							if sideInfo.Scfsi[ch][1] == 0 {
-								scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen1))
-							} else {
-								scalefac.L[gr][ch][sfb] = scalefac.L[0][ch][sfb]
-							}
+					}
+					for sfb := 6; sfb < 11; sfb++ {
+						if sideInfo.Scfsi[ch][1] == 0 {
+							scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen1))
+						} else {
+							scalefac.L[gr][ch][sfb] = scalefac.L[0][ch][sfb]
 						}
-						for sfb := 11; sfb < 16; sfb++ {
-							if sideInfo.Scfsi[ch][2] == 0 {
-								scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen2))
-							} else {
-								scalefac.L[gr][ch][sfb] = scalefac.L[0][ch][sfb]
-							}
+					}
+					for sfb := 11; sfb < 16; sfb++ {
+						if sideInfo.Scfsi[ch][2] == 0 {
+							scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen2))
+						} else {
+							scalefac.L[gr][ch][sfb] = scalefac.L[0][ch][sfb]
 						}
-						for sfb := 16; sfb < 21; sfb++ {
-							if sideInfo.Scfsi[ch][3] == 0 {
-								scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen2))
-							} else {
-								scalefac.L[gr][ch][sfb] = scalefac.L[0][ch][sfb]
-							}
+					}
+					for sfb := 16; sfb < 21; sfb++ {
+						if sideInfo.Scfsi[ch][3] == 0 {
+							scalefac.L[gr][ch][sfb] = byte(mainDataBitReader.ReadBits(slen2))
+						} else {
+							scalefac.L[gr][ch][sfb] = scalefac.L[0][ch][sfb]
 						}
 					}
 				}
+			}
 
-				// Huffman code =======================================================================================
-				var region0 int
-				var region1 int
-				if sideInfo.WindowsSwitchingFlag[gr][ch] == 1 && sideInfo.BlockType[gr][ch] == blockShort {
-					region0 = 36
-					region1 = iblen
+			// Huffman code =======================================================================================
+			var region0 int
+			var region1 int
+			if sideInfo.WindowsSwitchingFlag[gr][ch] == 1 && sideInfo.BlockType[gr][ch] == blockShort {
+				region0 = 36
+				region1 = iblen
+			} else {
+				region0 = bandIndex[samplingFrequency][0][sideInfo.Region0Count[gr][ch]+1]
+				region1 = bandIndex[samplingFrequency][0][sideInfo.Region0Count[gr][ch]+1+sideInfo.Region1Count[gr][ch]+1]
+			}
+			//fmt.Printf("region0 %+v region1 %+v\n", region0, region1)
+
+			sample := 0
+			for ; sample < int(sideInfo.BigValues[gr][ch])*2; sample += 2 {
+				tableNum := 0
+				if sample < region0 {
+					tableNum = int(sideInfo.TableSelect[gr][ch][0])
+				} else if sample < region1 {
+					tableNum = int(sideInfo.TableSelect[gr][ch][1])
 				} else {
-					region0 = bandIndex[samplingFrequency][0][sideInfo.Region0Count[gr][ch]+1]
-					region1 = bandIndex[samplingFrequency][0][sideInfo.Region0Count[gr][ch]+1+sideInfo.Region1Count[gr][ch]+1]
+					tableNum = int(sideInfo.TableSelect[gr][ch][2])
 				}
-				//fmt.Printf("region0 %+v region1 %+v\n", region0, region1)
-
-				sample := 0
-				for ; sample < int(sideInfo.BigValues[gr][ch])*2; sample += 2 {
-					tableNum := 0
-					if sample < region0 {
-						tableNum = int(sideInfo.TableSelect[gr][ch][0])
-					} else if sample < region1 {
-						tableNum = int(sideInfo.TableSelect[gr][ch][1])
-					} else {
-						tableNum = int(sideInfo.TableSelect[gr][ch][2])
-					}
 
-					if tableNum == 0 {
-						continue
-					}
-
-					x, y := decodeHuffman(mainDataBitReader, tableNum)
-					is[gr][ch][sample] = float32(x)
-					is[gr][ch][sample+1] = float32(y)
+				if tableNum == 0 {
+					continue
 				}
 
-				count1 := 0
-				for ; sample+4 <= iblen && mainDataBitReader.Counter < int(sideInfo.Part23Length[gr][ch]); sample += 4 {
-					count1++
-					var v, w, x, y int
-					if sideInfo.Count1tableSelect[gr][ch] == 1 {
-						v, w, x, y = decodeHuffmanB(mainDataBitReader)
-					} else {
-						v, w, x, y = decodeHuffmanA(mainDataBitReader)
-					}
+				x, y := decodeHuffman(mainDataBitReader, tableNum)
+				is[gr][ch][sample] = float32(x)
+				is[gr][ch][sample+1] = float32(y)
+			}
 
-					is[gr][ch][sample] = float32(v)
-					is[gr][ch][sample+1] = float32(w)
-					is[gr][ch][sample+2] = float32(x)
-					is[gr][ch][sample+3] = float32(y)
+			count1 := 0
+			for ; sample+4 <= iblen && mainDataBitReader.Counter < int(sideInfo.Part23Length[gr][ch]); sample += 4 {
+				count1++
+				var v, w, x, y int
+				if sideInfo.Count1tableSelect[gr][ch] == 1 {
+					v, w, x, y = decodeHuffmanB(mainDataBitReader)
+				} else {
+					v, w, x, y = decodeHuffmanA(mainDataBitReader)
 				}
 
-				countValues[gr][ch] = int(sideInfo.BigValues[gr][ch])*2 + count1*4
-
-				//fmt.Println(sideInfo.BigValues[gr][ch]*2, count1*4, part23Length, iblen)
+				is[gr][ch][sample] = float32(v)
+				is[gr][ch][sample+1] = float32(w)
+				is[gr][ch][sample+2] = float32(x)
+				is[gr][ch][sample+3] = float32(y)
 			}
+
+			countValues[gr][ch] = int(sideInfo.BigValues[gr][ch])*2 + count1*4
+
+			//fmt.Println(sideInfo.BigValues[gr][ch]*2, count1*4, part23Length, iblen)
 		}
+	}
 
-		// Decoding ===================================================================================================
-		samples := make([]float32, iblen*2*2) // iblen * number granules * byte count per sample
-		for gr := 0; gr < 2; gr++ {
-			for ch := 0; ch < nch; ch++ {
-				requantize(gr, ch, samplingFrequency, sideInfo, scalefac, &is, countValues)
-				reorder(gr, ch, samplingFrequency, sideInfo, &is, countValues)
-			}
-			stereo(gr, mode, modeExtension, &is)
-			for ch := 0; ch < nch; ch++ {
-				aliasReduction(gr, ch, sideInfo, &is)
-				imdct(gr, ch, sideInfo.BlockType[gr][ch], &is, &prevSamples)
-				frequencyInversion(gr, ch, &is)
-				synthFilterbank(gr, ch, &is, &vVec, samples[iblen*gr*2:])
-			}
+	// Decoding ===================================================================================================
+	samples := make([]float32, iblen*2*2) // iblen * number granules * byte count per sample
+	for gr := 0; gr < 2; gr++ {
+		for ch := 0; ch < nch; ch++ {
+			requantize(gr, ch, samplingFrequency, sideInfo, scalefac, &is, countValues)
+			reorder(gr, ch, samplingFrequency, sideInfo, &is, countValues)
+		}
+		stereo(gr, mode, modeExtension, &is)
+		for ch := 0; ch < nch; ch++ {
+			aliasReduction(gr, ch, sideInfo, &is)
+			imdct(gr, ch, sideInfo.BlockType[gr][ch], &is, &d.prevSamples)
+			frequencyInversion(gr, ch, &is)
+			synthFilterbank(gr, ch, &is, &d.vVec, samples[iblen*gr*2:])
 		}
-		out.Append(samples)
 	}
+	return mono(samples, nch)
+}
 
-	return out, nil
+// mono keeps the first channel of samples interleaved as stereo if the frame has one channel.
+func mono(samples []float32, nch int) []float32 {
+	if nch == 2 {
+		return samples
+	}
+	out := make([]float32, len(samples)/2)
+	for i := range out {
+		out[i] = samples[2*i]
+	}
+	return out
 }
 
 func requantize(gr, ch int, samplingFrequency uint8, sideInfo sideInformation, scalefac Scalefac, is *[2][2][iblen]float32, countValues [2][2]int) {
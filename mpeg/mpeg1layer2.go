@@ -0,0 +1,181 @@
+package mpeg
+
+import (
+	"awCodec/utils"
+)
+
+// layer2Allocation is one of the tables of possible quantizations (ISO/IEC 11172-3 Table 3-B.2a-d).
+type layer2Allocation struct {
+	sblimit int       // Number of subbands with allocations
+	classes [30]uint8 // Index in layer2Classes of each subband
+}
+
+// layer2Class is the number of bits of an allocation and the quantization classes it selects, from 1.
+type layer2Class struct {
+	nbal      int
+	quantizer []uint8 // Index in layer2Quantizers
+}
+
+var layer2Allocations = [4]layer2Allocation{
+	// Table 3-B.2a, 48 kHz above 48 kbit/s or 56 to 80 kbit/s per channel
+	{27, [30]uint8{7, 7, 7, 6, 6, 6, 6, 6, 6, 6, 6, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 0, 0, 0, 0}},
+	// Table 3-B.2b, 44.1 or 32 kHz above 80 kbit/s per channel
+	{30, [30]uint8{7, 7, 7, 6, 6, 6, 6, 6, 6, 6, 6, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 0, 0, 0, 0, 0, 0, 0}},
+	// Table 3-B.2c, 48 or 44.1 kHz up to 48 kbit/s per channel
+	{8, [30]uint8{5, 5, 2, 2, 2, 2, 2, 2}},
+	// Table 3-B.2d, 32 kHz up to 48 kbit/s per channel
+	{12, [30]uint8{5, 5, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2}},
+}
+
+var layer2Classes = [8]layer2Class{
+	{2, []uint8{0, 1, 16}},
+	{2, []uint8{0, 1, 3}},
+	{3, []uint8{0, 1, 3, 4, 5, 6, 7}},
+	{3, []uint8{0, 1, 2, 3, 4, 5, 16}},
+	{4, []uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}},
+	{4, []uint8{0, 1, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}},
+	{4, []uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 16}},
+	{4, []uint8{0, 2, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}},
+}
+
+// layer2Quantizer is a quantization class (ISO/IEC 11172-3 Table 3-B.4). Three samples of a class with grouping are
+// coded in one codeword.
+type layer2Quantizer struct {
+	levels   int
+	grouping bool
+	bits     int // Bits of the codeword
+}
+
+var layer2Quantizers = [17]layer2Quantizer{
+	{3, true, 5}, {5, true, 7}, {7, false, 3}, {9, true, 10}, {15, false, 4}, {31, false, 5}, {63, false, 6},
+	{127, false, 7}, {255, false, 8}, {511, false, 9}, {1023, false, 10}, {2047, false, 11}, {4095, false, 12},
+	{8191, false, 13}, {16383, false, 14}, {32767, false, 15}, {65535, false, 16},
+}
+
+// layer2Table selects the allocation table from the bitrate per channel and the sampling frequency.
+func layer2Table(bitrate, sampleRate, nch int) *layer2Allocation {
+	perChannel := bitrate / nch
+	switch {
+	case perChannel <= 48000 && sampleRate == 32000:
+		return &layer2Allocations[3]
+	case perChannel <= 48000:
+		return &layer2Allocations[2]
+	case perChannel <= 80000 || sampleRate == 48000:
+		return &layer2Allocations[0]
+	default:
+		return &layer2Allocations[1]
+	}
+}
+
+// decodeLayer2 returns the 36 samples of the 32 subbands of the channels of a frame (ISO/IEC 11172-3 2.4.1.6).
+func decodeLayer2(br *utils.BitReader, nch int, bound int, table *layer2Allocation) [2][32 * 36]float32 {
+	if bound > table.sblimit {
+		bound = table.sblimit
+	}
+
+	// allocation --------------------------------------------------
+	allocation := [2][32]int{}
+	for sb := 0; sb < table.sblimit; sb++ {
+		nbal := layer2Classes[table.classes[sb]].nbal
+		if sb < bound {
+			for ch := 0; ch < nch; ch++ {
+				allocation[ch][sb] = br.ReadBits(nbal)
+			}
+		} else {
+			allocation[0][sb] = br.ReadBits(nbal)
+			allocation[1][sb] = allocation[0][sb]
+		}
+	}
+
+	// scalefactor selection information --------------------------------------------------
+	scfsi := [2][32]int{}
+	for sb := 0; sb < table.sblimit; sb++ {
+		for ch := 0; ch < nch; ch++ {
+			if allocation[ch][sb] != 0 {
+				scfsi[ch][sb] = br.ReadBits(2)
+			}
+		}
+	}
+
+	// scalefactor, one for each third of the frame --------------------------------------------------
+	scaleFactor := [2][32][3]float32{}
+	for sb := 0; sb < table.sblimit; sb++ {
+		for ch := 0; ch < nch; ch++ {
+			if allocation[ch][sb] == 0 {
+				continue
+			}
+			factor := &scaleFactor[ch][sb]
+			factor[0] = scalefactor(br.ReadBits(6))
+			switch scfsi[ch][sb] {
+			case 0:
+				factor[1] = scalefactor(br.ReadBits(6))
+				factor[2] = scalefactor(br.ReadBits(6))
+			case 1:
+				factor[1] = factor[0]
+				factor[2] = scalefactor(br.ReadBits(6))
+			case 2:
+				factor[1], factor[2] = factor[0], factor[0]
+			case 3:
+				factor[1] = scalefactor(br.ReadBits(6))
+				factor[2] = factor[1]
+			}
+		}
+	}
+
+	// samples, 12 granules of 3 samples --------------------------------------------------
+	samples := [2][32 * 36]float32{}
+	for gr := 0; gr < 12; gr++ {
+		for sb := 0; sb < table.sblimit; sb++ {
+			class := layer2Classes[table.classes[sb]]
+			for ch := 0; ch < nch; ch++ {
+				if allocation[ch][sb] == 0 {
+					continue
+				}
+				quantizer := layer2Quantizers[class.quantizer[allocation[ch][sb]-1]]
+				values := readLayer2Samples(br, quantizer)
+				for s, value := range values {
+					samples[ch][32*(3*gr+s)+sb] = value * scaleFactor[ch][sb][gr/4]
+				}
+				// The subbands from bound have the samples of the first channel with the scalefactors of each.
+				if sb >= bound {
+					for s, value := range values {
+						samples[1][32*(3*gr+s)+sb] = value * scaleFactor[1][sb][gr/4]
+					}
+					break
+				}
+			}
+		}
+	}
+	return samples
+}
+
+// readLayer2Samples reads and requantizes the 3 samples of a granule of a subband.
+func readLayer2Samples(br *utils.BitReader, quantizer layer2Quantizer) [3]float32 {
+	var values [3]int
+	if quantizer.grouping {
+		code := br.ReadBits(quantizer.bits)
+		for s := range values {
+			values[s] = code % quantizer.levels
+			code /= quantizer.levels
+		}
+	} else {
+		for s := range values {
+			values[s] = br.ReadBits(quantizer.bits)
+		}
+	}
+
+	// s'' = C * (s''' + D) is (2 * s - levels + 1) / levels for the levels of the class.
+	var requantized [3]float32
+	for s, value := range values {
+		requantized[s] = float32(2*value-quantizer.levels+1) / float32(quantizer.levels)
+	}
+	return requantized
+}
+
+// scalefactor returns the factor of a scalefactor index, 63 is invalid.
+func scalefactor(index int) float32 {
+	if index >= len(requantizeFactor) {
+		return 0
+	}
+	return requantizeFactor[index]
+}
@@ -0,0 +1,759 @@
+package mpeg
+
+import (
+	"awCodec/utils"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Decoding of MPEG-1 video (ISO/IEC 11172-2), the video of Video CDs
+
+var errMpeg1Video = errors.New("mpeg: invalid MPEG-1 video")
+
+// Start codes of MPEG-1 video, following the prefix 0x000001 (ISO/IEC 11172-2 2.4.2.1)
+const (
+	pictureStartCode    = 0x00
+	sliceStartCodeFirst = 0x01
+	sliceStartCodeLast  = 0xAF
+	userDataStartCode   = 0xB2
+	sequenceHeaderCode  = 0xB3
+	sequenceErrorCode   = 0xB4
+	extensionStartCode  = 0xB5
+	sequenceEndCode     = 0xB7
+	groupStartCode      = 0xB8
+)
+
+// picture_coding_type (ISO/IEC 11172-2 2.4.3.4)
+const (
+	PictureTypeI = 1
+	PictureTypeP = 2
+	PictureTypeB = 3
+	PictureTypeD = 4 // DC coded, intra pictures of the DC coefficients only
+)
+
+// pictureRates holds the frame rates of picture_rate.
+var pictureRates = [9]float64{0, 24000.0 / 1001, 24, 25, 30000.0 / 1001, 30, 50, 60000.0 / 1001, 60}
+
+// Mpeg1SequenceHeader is the sequence header of an MPEG-1 video stream (ISO/IEC 11172-2 2.4.2.3).
+type Mpeg1SequenceHeader struct {
+	HorizontalSize            int // 12 bits
+	VerticalSize              int // 12 bits
+	PelAspectRatio            int // 4 bits
+	PictureRate               int // 4 bits
+	BitRate                   int // 18 bits, in units of 400 bit/s
+	VbvBufferSize             int // 10 bits, in units of 16384 bits
+	ConstrainedParametersFlag bool
+	IntraQuantizerMatrix      [64]int // In raster order, the default one unless loaded
+	NonIntraQuantizerMatrix   [64]int
+}
+
+// FrameRate returns the number of pictures per second, 0 if picture_rate is reserved.
+func (h *Mpeg1SequenceHeader) FrameRate() float64 {
+	if h.PictureRate >= len(pictureRates) {
+		return 0
+	}
+	return pictureRates[h.PictureRate]
+}
+
+// Mpeg1VideoDecoder decodes an MPEG-1 video stream, given in parts of any size, to pictures in display order.
+type Mpeg1VideoDecoder struct {
+	buf      []byte
+	sequence *Mpeg1SequenceHeader
+
+	// The last two reference pictures, I or P, in decoding order, and whether future is still to be displayed
+	past, future *image.YCbCr
+	held         bool
+
+	picture *mpeg1Picture // Picture being decoded
+}
+
+// mpeg1Picture is a picture being decoded with the fields of its header (ISO/IEC 11172-2 2.4.2.5).
+type mpeg1Picture struct {
+	img                     *image.YCbCr // Allocated to whole macroblocks
+	mbWidth, mbHeight       int
+	TemporalReference       int // 10 bits
+	PictureCodingType       int // 3 bits
+	FullPelForwardVector    bool
+	ForwardFCode            int // 3 bits
+	FullPelBackwardVector   bool
+	BackwardFCode           int          // 3 bits
+	forwardRef, backwardRef *image.YCbCr // Reference pictures
+	skip                    bool         // A reference picture is missing
+}
+
+// mpeg1Slice is the state of the decoding of a slice (ISO/IEC 11172-2 2.4.2.6 and 2.4.2.7).
+type mpeg1Slice struct {
+	*mpeg1Picture
+	sequence         *Mpeg1SequenceHeader
+	br               *utils.BitReader
+	quantizerScale   int
+	address          int // macroblock_address of the previous macroblock
+	pastIntraAddress int
+	dcPast           [3]int // dct_dc_y_past, dct_dc_cb_past and dct_dc_cr_past
+
+	// Motion vectors of the previous macroblock in half samples and the predictors of the next ones in the units
+	// of the picture
+	flags                     int
+	forward, backward         [2]int
+	forwardPred, backwardPred [2]int
+
+	blocks [6][64]int
+}
+
+// NewMpeg1VideoDecoder returns a decoder for a stream starting with a sequence header.
+func NewMpeg1VideoDecoder() *Mpeg1VideoDecoder {
+	return &Mpeg1VideoDecoder{}
+}
+
+// SequenceHeader returns the last sequence header of the stream, nil before the first one.
+func (d *Mpeg1VideoDecoder) SequenceHeader() *Mpeg1SequenceHeader {
+	return d.sequence
+}
+
+// DecodeMpeg1Video decodes the pictures of an MPEG-1 video elementary stream in display order.
+func DecodeMpeg1Video(file []byte) ([]*image.YCbCr, error) {
+	d := NewMpeg1VideoDecoder()
+	pictures, err := d.Decode(file)
+	if err != nil {
+		return pictures, err
+	}
+	last, err := d.Flush()
+	return append(pictures, last...), err
+}
+
+// Decode decodes the pictures completed by a part of the stream and returns the pictures ready for display. The
+// pictures use the sample layout of the coded macroblocks and are cropped to the size of the sequence. On errors in
+// slices the decoding goes on with the next slice and the first error is returned with the pictures.
+func (d *Mpeg1VideoDecoder) Decode(data []byte) ([]*image.YCbCr, error) {
+	d.buf = append(d.buf, data...)
+
+	// Pictures are complete at the next picture, group of pictures or sequence start code
+	end := 0
+	for i := nextStartCode(d.buf, 0); i+3 < len(d.buf); i = nextStartCode(d.buf, i+3) {
+		switch d.buf[i+3] {
+		case pictureStartCode, groupStartCode, sequenceHeaderCode:
+			end = i
+		case sequenceEndCode:
+			end = i + 4
+		}
+	}
+	pictures, err := d.decodeUnits(d.buf[:end])
+	d.buf = append(d.buf[:0], d.buf[end:]...)
+	return pictures, err
+}
+
+// Flush decodes the rest of the stream as complete and returns the last pictures.
+func (d *Mpeg1VideoDecoder) Flush() ([]*image.YCbCr, error) {
+	pictures, err := d.decodeUnits(d.buf)
+	d.buf = d.buf[:0]
+	pictures = d.finishPicture(pictures)
+	if d.held {
+		pictures = append(pictures, d.crop(d.future))
+		d.held = false
+	}
+	return pictures, err
+}
+
+// nextStartCode returns the index of the next start code prefix in b from i, len(b) if there is none.
+func nextStartCode(b []byte, i int) int {
+	for ; i+2 < len(b); i++ {
+		if b[i+2] > 1 {
+			i += 2
+		} else if b[i] == 0 && b[i+1] == 0 && b[i+2] == 1 {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// decodeUnits decodes the headers and slices of b, made of complete pictures.
+func (d *Mpeg1VideoDecoder) decodeUnits(b []byte) ([]*image.YCbCr, error) {
+	var pictures []*image.YCbCr
+	var firstErr error
+	for i := nextStartCode(b, 0); i+3 < len(b); {
+		next := nextStartCode(b, i+3)
+		code, payload := b[i+3], b[i+4:next]
+		i = next
+
+		var err error
+		switch {
+		case code >= sliceStartCodeFirst && code <= sliceStartCodeLast:
+			if d.picture != nil && !d.picture.skip {
+				err = d.decodeSlice(int(code), payload)
+			}
+		case code == pictureStartCode:
+			pictures = d.finishPicture(pictures)
+			err = d.startPicture(payload)
+		case code == groupStartCode:
+			pictures = d.finishPicture(pictures)
+			br := utils.NewBitReader(payload)
+			br.Seek(25)        // time_code
+			br.Seek(1)         // closed_gop
+			if br.ReadBool() { // broken_link, the B pictures following the first I picture have no past reference
+				if d.held {
+					pictures = append(pictures, d.crop(d.future))
+				}
+				d.past, d.future, d.held = nil, nil, false
+			}
+		case code == sequenceHeaderCode:
+			pictures = d.finishPicture(pictures)
+			err = d.parseSequenceHeader(payload)
+		case code == sequenceEndCode:
+			pictures = d.finishPicture(pictures)
+			if d.held {
+				pictures = append(pictures, d.crop(d.future))
+			}
+			d.past, d.future, d.held = nil, nil, false
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return pictures, firstErr
+}
+
+// parseSequenceHeader parses the sequence header following its start code.
+func (d *Mpeg1VideoDecoder) parseSequenceHeader(payload []byte) error {
+	br := utils.NewBitReader(payload)
+	h := &Mpeg1SequenceHeader{}
+	h.HorizontalSize = br.ReadBits(12)
+	h.VerticalSize = br.ReadBits(12)
+	h.PelAspectRatio = br.ReadBits(4)
+	h.PictureRate = br.ReadBits(4)
+	h.BitRate = br.ReadBits(18)
+	br.Seek(1) // marker_bit
+	h.VbvBufferSize = br.ReadBits(10)
+	h.ConstrainedParametersFlag = br.ReadBool()
+	h.IntraQuantizerMatrix = defaultIntraQuantizerMatrix
+	if br.ReadBool() { // load_intra_quantizer_matrix
+		for i := 0; i < 64; i++ {
+			h.IntraQuantizerMatrix[mpeg1Zigzag[i]] = br.ReadBits(8)
+		}
+	}
+	for i := range h.NonIntraQuantizerMatrix {
+		h.NonIntraQuantizerMatrix[i] = 16
+	}
+	if br.ReadBool() { // load_non_intra_quantizer_matrix
+		for i := 0; i < 64; i++ {
+			h.NonIntraQuantizerMatrix[mpeg1Zigzag[i]] = br.ReadBits(8)
+		}
+	}
+
+	if br.Len() < 0 {
+		return fmt.Errorf("%w: sequence header of %d bytes", errMpeg1Video, len(payload))
+	}
+	if h.HorizontalSize == 0 || h.VerticalSize == 0 || h.HorizontalSize > 4095 || h.VerticalSize > 4095 {
+		return fmt.Errorf("%w: picture size %dx%d", errMpeg1Video, h.HorizontalSize, h.VerticalSize)
+	}
+	for _, q := range h.IntraQuantizerMatrix {
+		if q == 0 {
+			return fmt.Errorf("%w: quantizer matrix", errMpeg1Video)
+		}
+	}
+	d.sequence = h
+	return nil
+}
+
+// startPicture parses a picture header and allocates the picture.
+func (d *Mpeg1VideoDecoder) startPicture(payload []byte) error {
+	if d.sequence == nil {
+		return fmt.Errorf("%w: picture before the sequence header", errMpeg1Video)
+	}
+	br := utils.NewBitReader(payload)
+	p := &mpeg1Picture{}
+	p.TemporalReference = br.ReadBits(10)
+	p.PictureCodingType = br.ReadBits(3)
+	br.Seek(16) // vbv_delay
+	if p.PictureCodingType == PictureTypeP || p.PictureCodingType == PictureTypeB {
+		p.FullPelForwardVector = br.ReadBool()
+		p.ForwardFCode = br.ReadBits(3)
+		if p.ForwardFCode == 0 {
+			return fmt.Errorf("%w: forward_f_code 0", errMpeg1Video)
+		}
+	}
+	if p.PictureCodingType == PictureTypeB {
+		p.FullPelBackwardVector = br.ReadBool()
+		p.BackwardFCode = br.ReadBits(3)
+		if p.BackwardFCode == 0 {
+			return fmt.Errorf("%w: backward_f_code 0", errMpeg1Video)
+		}
+	}
+
+	switch p.PictureCodingType {
+	case PictureTypeI, PictureTypeD:
+	case PictureTypeP:
+		p.forwardRef = d.future
+		p.skip = p.forwardRef == nil
+	case PictureTypeB:
+		p.forwardRef, p.backwardRef = d.past, d.future
+		p.skip = p.forwardRef == nil || p.backwardRef == nil
+	default:
+		return fmt.Errorf("%w: picture_coding_type %d", errMpeg1Video, p.PictureCodingType)
+	}
+
+	p.mbWidth, p.mbHeight = (d.sequence.HorizontalSize+15)/16, (d.sequence.VerticalSize+15)/16
+	if !p.skip {
+		p.img = image.NewYCbCr(image.Rect(0, 0, p.mbWidth*16, p.mbHeight*16), image.YCbCrSubsampleRatio420)
+	}
+	d.picture = p
+	return nil
+}
+
+// finishPicture appends the pictures ready for display once the current picture is decoded.
+func (d *Mpeg1VideoDecoder) finishPicture(pictures []*image.YCbCr) []*image.YCbCr {
+	p := d.picture
+	d.picture = nil
+	if p == nil || p.skip {
+		return pictures
+	}
+	switch p.PictureCodingType {
+	case PictureTypeI, PictureTypeP:
+		if d.held {
+			pictures = append(pictures, d.crop(d.future))
+		}
+		d.past, d.future, d.held = d.future, p.img, true
+		return pictures
+	}
+	return append(pictures, d.crop(p.img))
+}
+
+// crop returns a picture cropped to the size of the sequence.
+func (d *Mpeg1VideoDecoder) crop(img *image.YCbCr) *image.YCbCr {
+	return img.SubImage(image.Rect(0, 0, d.sequence.HorizontalSize, d.sequence.VerticalSize)).(*image.YCbCr)
+}
+
+// decodeSlice decodes the macroblocks of a slice into the current picture.
+func (d *Mpeg1VideoDecoder) decodeSlice(code int, payload []byte) error {
+	p := d.picture
+	if code > p.mbHeight {
+		return fmt.Errorf("%w: slice_vertical_position %d", errMpeg1Video, code)
+	}
+	s := &mpeg1Slice{mpeg1Picture: p, sequence: d.sequence, br: utils.NewBitReader(payload)}
+	s.address = (code-1)*p.mbWidth - 1
+	s.pastIntraAddress = -2
+	s.quantizerScale = s.br.ReadBits(5)
+	for s.br.ReadBool() { // extra_bit_slice
+		s.br.Seek(8) // extra_information_slice
+	}
+	if s.quantizerScale == 0 {
+		return fmt.Errorf("%w: quantizer_scale 0", errMpeg1Video)
+	}
+
+	for first := true; ; first = false {
+		if err := s.decodeMacroblock(first); err != nil {
+			return fmt.Errorf("slice %d macroblock %d: %w", code, s.address, err)
+		}
+		// The slice ends at the next start code, or in the zero bits stuffed before it
+		if s.br.Len() <= 0 || s.br.ReadBits(23) == 0 {
+			return nil
+		}
+		s.br.Seek(-23)
+	}
+}
+
+// decodeMacroblock decodes a macroblock and the skipped macroblocks before it (ISO/IEC 11172-2 2.4.2.7).
+func (s *mpeg1Slice) decodeMacroblock(first bool) error {
+	br := s.br
+	increment := 0
+	for {
+		v := decodeVlc(br, macroblockAddressIncrementCodes)
+		if v < 0 {
+			return fmt.Errorf("%w: macroblock_address_increment", errMpeg1Video)
+		}
+		if v == macroblockEscape {
+			increment += 33
+		} else if v != macroblockStuffing {
+			increment += v
+			break
+		}
+	}
+	if s.address+increment >= s.mbWidth*s.mbHeight {
+		return fmt.Errorf("%w: macroblock_address %d", errMpeg1Video, s.address+increment)
+	}
+
+	// Skipped macroblocks are predicted without residual, from the forward reference with a zero vector in P
+	// pictures and like the previous macroblock in B pictures
+	if !first && increment > 1 {
+		switch s.PictureCodingType {
+		case PictureTypeP:
+			s.flags = macroblockMotionForward
+			s.forward, s.forwardPred = [2]int{}, [2]int{}
+		case PictureTypeB:
+			if s.flags&macroblockIntra != 0 {
+				return fmt.Errorf("%w: skipped macroblock after an intra macroblock", errMpeg1Video)
+			}
+		default:
+			return fmt.Errorf("%w: skipped macroblock in an intra picture", errMpeg1Video)
+		}
+		for a := s.address + 1; a < s.address+increment; a++ {
+			s.predict(a)
+		}
+		s.dcPast = [3]int{1024, 1024, 1024}
+	}
+	s.address += increment
+
+	flags := decodeVlc(br, macroblockTypeCodes[s.PictureCodingType])
+	if flags < 0 {
+		return fmt.Errorf("%w: macroblock_type", errMpeg1Video)
+	}
+	s.flags = flags
+	if flags&macroblockQuant != 0 {
+		if s.quantizerScale = br.ReadBits(5); s.quantizerScale == 0 {
+			return fmt.Errorf("%w: quantizer_scale 0", errMpeg1Video)
+		}
+	}
+	if flags&macroblockMotionForward != 0 {
+		if err := s.motionVector(&s.forward, &s.forwardPred, s.ForwardFCode, s.FullPelForwardVector); err != nil {
+			return err
+		}
+	} else if s.PictureCodingType == PictureTypeP {
+		s.flags |= macroblockMotionForward // Predicted with a zero vector
+		s.forward, s.forwardPred = [2]int{}, [2]int{}
+	}
+	if flags&macroblockMotionBackward != 0 {
+		if err := s.motionVector(&s.backward, &s.backwardPred, s.BackwardFCode, s.FullPelBackwardVector); err != nil {
+			return err
+		}
+	}
+
+	intra := flags&macroblockIntra != 0
+	cbp := 0
+	switch {
+	case flags&macroblockPattern != 0:
+		if cbp = decodeVlc(br, codedBlockPatternCodes); cbp < 0 {
+			return fmt.Errorf("%w: coded_block_pattern", errMpeg1Video)
+		}
+	case intra:
+		cbp = 63
+	}
+	if intra {
+		s.forwardPred, s.backwardPred = [2]int{}, [2]int{}
+		if s.address-s.pastIntraAddress > 1 {
+			s.dcPast = [3]int{1024, 1024, 1024}
+		}
+	} else {
+		s.dcPast = [3]int{1024, 1024, 1024}
+	}
+
+	for i := 0; i < 6; i++ {
+		s.blocks[i] = [64]int{}
+		if cbp>>(5-i)&1 == 0 {
+			continue
+		}
+		if err := s.decodeBlock(i, intra); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+	}
+	if s.PictureCodingType == PictureTypeD {
+		br.Seek(1) // end_of_macroblock
+	}
+	if br.Len() < 0 {
+		return fmt.Errorf("%w: macroblock past the end of the slice", errMpeg1Video)
+	}
+
+	if intra {
+		s.pastIntraAddress = s.address
+	} else {
+		s.predict(s.address)
+	}
+	s.addBlocks(s.address, cbp, intra)
+	return nil
+}
+
+// motionVector decodes the horizontal and vertical components of a motion vector from the predictor, which it
+// updates (ISO/IEC 11172-2 2.4.4.2).
+func (s *mpeg1Slice) motionVector(mv, pred *[2]int, fCode int, fullPel bool) error {
+	rSize := fCode - 1
+	f := 1 << rSize
+	for i := range pred {
+		code := decodeVlc(s.br, motionCodes)
+		if code < 0 {
+			return fmt.Errorf("%w: motion code", errMpeg1Video)
+		}
+		delta := 0
+		if code != 0 {
+			negative := s.br.ReadBool()
+			r := 0
+			if f > 1 {
+				r = s.br.ReadBits(rSize)
+			}
+			delta = (code-1)*f + r + 1
+			if negative {
+				delta = -delta
+			}
+		}
+		v := pred[i] + delta
+		if v < -16*f {
+			v += 32 * f
+		} else if v > 16*f-1 {
+			v -= 32 * f
+		}
+		pred[i] = v
+		if fullPel {
+			v <<= 1
+		}
+		mv[i] = v
+	}
+	return nil
+}
+
+// decodeBlock decodes the coefficients of a block to the reconstructed DCT coefficients in raster order (ISO/IEC
+// 11172-2 2.4.2.8 and 2.4.4).
+func (s *mpeg1Slice) decodeBlock(i int, intra bool) error {
+	br := s.br
+	block := &s.blocks[i]
+	quant := &s.sequence.NonIntraQuantizerMatrix
+	n := -1
+	if intra {
+		quant = &s.sequence.IntraQuantizerMatrix
+		c, codes := 0, dctDcSizeLuminanceCodes
+		if i >= 4 {
+			c, codes = i-3, dctDcSizeChrominanceCodes
+		}
+		size := decodeVlc(br, codes)
+		if size < 0 {
+			return fmt.Errorf("%w: dct_dc_size", errMpeg1Video)
+		}
+		diff := 0
+		if size > 0 {
+			if diff = br.ReadBits(size); diff < 1<<(size-1) {
+				diff -= 1<<size - 1
+			}
+		}
+		s.dcPast[c] += diff * 8
+		block[0] = s.dcPast[c]
+		if s.PictureCodingType == PictureTypeD {
+			return nil
+		}
+		n = 0
+	}
+
+	for first := !intra; ; first = false {
+		run, level, eob := dctCoeff(br, first)
+		if eob {
+			return nil
+		}
+		if level == 0 {
+			return fmt.Errorf("%w: dct_coeff", errMpeg1Video)
+		}
+		if n += run + 1; n > 63 {
+			return fmt.Errorf("%w: %d coefficients", errMpeg1Video, n+1)
+		}
+		pos := mpeg1Zigzag[n]
+		var v int
+		if intra {
+			v = 2 * level * s.quantizerScale * quant[pos] / 16
+		} else {
+			sign := 1
+			if level < 0 {
+				sign = -1
+			}
+			v = (2*level + sign) * s.quantizerScale * quant[pos] / 16
+		}
+		if v&1 == 0 { // Oddification
+			if v > 0 {
+				v--
+			} else if v < 0 {
+				v++
+			}
+		}
+		block[pos] = clip3(-2048, 2047, v)
+	}
+}
+
+// dctCoeff decodes dct_coeff_first or dct_coeff_next, the run of zero coefficients and the level of the next
+// coefficient, or end_of_block. A level of 0 marks an invalid code.
+func dctCoeff(br *utils.BitReader, first bool) (run, level int, eob bool) {
+	if br.ReadBool() {
+		if !first && !br.ReadBool() {
+			return 0, 0, true
+		}
+		return 0, 1 - 2*br.ReadBits(1), false
+	}
+	br.Seek(-1)
+
+	if br.ReadBits(6) == 0b000001 { // escape
+		run, level = br.ReadBits(6), br.ReadBits(8)
+		switch {
+		case level == 0:
+			level = br.ReadBits(8)
+		case level == 128:
+			level = br.ReadBits(8) - 256
+		case level > 128:
+			level -= 256
+		}
+		return run, level, false
+	}
+	br.Seek(-6)
+
+	bits := br.ReadBits(16)
+	for _, c := range dctCoeffCodes {
+		if bits>>(16-c[1]) == c[0] {
+			br.Seek(c[1] - 16)
+			return c[2], c[3] * (1 - 2*br.ReadBits(1)), false
+		}
+	}
+	br.Seek(-16)
+	return 0, 0, false
+}
+
+// decodeVlc returns the value of the code {code, length, value} of a table at the position of the reader, -1 if
+// none matches.
+func decodeVlc(br *utils.BitReader, table [][3]int) int {
+	bits := br.ReadBits(16)
+	for _, c := range table {
+		if bits>>(16-c[1]) == c[0] {
+			br.Seek(c[1] - 16)
+			return c[2]
+		}
+	}
+	br.Seek(-16)
+	return -1
+}
+
+// predict writes the motion compensated prediction of a macroblock of a P or B picture with the motion vectors of
+// the slice (ISO/IEC 11172-2 2.4.4.2 and 2.4.4.3).
+func (s *mpeg1Slice) predict(address int) {
+	mbX, mbY := address%s.mbWidth, address/s.mbWidth
+	img := s.img
+	var forward, backward [256]int
+	for c, plane := range [3][]byte{img.Y, img.Cb, img.Cr} {
+		size, stride := 16, img.YStride
+		if c > 0 {
+			size, stride = 8, img.CStride
+		}
+		pred := forward[:size*size]
+		switch s.flags & (macroblockMotionForward | macroblockMotionBackward) {
+		case macroblockMotionForward:
+			predictBlock(pred, s.forwardRef, c, mbX*size, mbY*size, size, s.forward)
+		case macroblockMotionBackward:
+			predictBlock(pred, s.backwardRef, c, mbX*size, mbY*size, size, s.backward)
+		default:
+			predictBlock(pred, s.forwardRef, c, mbX*size, mbY*size, size, s.forward)
+			predictBlock(backward[:size*size], s.backwardRef, c, mbX*size, mbY*size, size, s.backward)
+			for i := range pred {
+				pred[i] = (pred[i] + backward[i] + 1) >> 1
+			}
+		}
+		for y := 0; y < size; y++ {
+			row := plane[(mbY*size+y)*stride+mbX*size:]
+			for x := 0; x < size; x++ {
+				row[x] = byte(pred[y*size+x])
+			}
+		}
+	}
+}
+
+// predictBlock writes the prediction of a block of size by size samples at (x0, y0) of a component of a reference
+// picture displaced by a luma motion vector in half samples.
+func predictBlock(pred []int, ref *image.YCbCr, c, x0, y0, size int, mv [2]int) {
+	plane, stride := ref.Y, ref.YStride
+	w, h := ref.Rect.Dx(), ref.Rect.Dy()
+	dx, dy := mv[0], mv[1]
+	if c > 0 {
+		plane, stride = ref.Cb, ref.CStride
+		if c == 2 {
+			plane = ref.Cr
+		}
+		w, h = w/2, h/2
+		dx, dy = dx/2, dy/2 // Rounded toward zero
+	}
+	x0, y0 = x0+dx>>1, y0+dy>>1
+	halfX, halfY := dx&1, dy&1
+
+	at := func(x, y int) int {
+		return int(plane[clip3(0, h-1, y)*stride+clip3(0, w-1, x)])
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := at(x0+x, y0+y)
+			switch {
+			case halfX == 1 && halfY == 1:
+				v = (v + at(x0+x+1, y0+y) + at(x0+x, y0+y+1) + at(x0+x+1, y0+y+1) + 2) >> 2
+			case halfX == 1:
+				v = (v + at(x0+x+1, y0+y) + 1) >> 1
+			case halfY == 1:
+				v = (v + at(x0+x, y0+y+1) + 1) >> 1
+			}
+			pred[y*size+x] = v
+		}
+	}
+}
+
+// addBlocks transforms the coded blocks of a macroblock and writes them to the picture, added to the prediction
+// unless intra.
+func (s *mpeg1Slice) addBlocks(address, cbp int, intra bool) {
+	mbX, mbY := address%s.mbWidth, address/s.mbWidth
+	img := s.img
+	for i := 0; i < 6; i++ {
+		if cbp>>(5-i)&1 == 0 {
+			continue
+		}
+		var pix []byte
+		stride := img.YStride
+		switch i {
+		case 4:
+			pix, stride = img.Cb[mbY*8*img.CStride+mbX*8:], img.CStride
+		case 5:
+			pix, stride = img.Cr[mbY*8*img.CStride+mbX*8:], img.CStride
+		default:
+			pix = img.Y[(mbY*16+i/2*8)*stride+mbX*16+i%2*8:]
+		}
+
+		block := &s.blocks[i]
+		idct8x8(block)
+		for y := 0; y < 8; y++ {
+			row := pix[y*stride:]
+			for x := 0; x < 8; x++ {
+				if intra {
+					row[x] = clip1(block[y*8+x])
+				} else {
+					row[x] = clip1(int(row[x]) + block[y*8+x])
+				}
+			}
+		}
+	}
+}
+
+// idctCos holds c(u) / 2 * cos((2x + 1) * u * pi / 16) of the 8x8 inverse DCT by x and u.
+var idctCos = func() (t [8][8]float64) {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			c := 0.5
+			if u == 0 {
+				c = math.Sqrt2 / 4
+			}
+			t[x][u] = c * math.Cos(float64((2*x+1)*u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// idct8x8 transforms the DCT coefficients of a block in raster order to samples, with the precision of IEEE 1180
+// (ISO/IEC 11172-2 2.4.4.1).
+func idct8x8(block *[64]int) {
+	var tmp [64]float64
+	for y := 0; y < 8; y++ {
+		row := block[y*8 : y*8+8]
+		for x := 0; x < 8; x++ {
+			sum := 0.0
+			for u, v := range row {
+				if v != 0 {
+					sum += idctCos[x][u] * float64(v)
+				}
+			}
+			tmp[y*8+x] = sum
+		}
+	}
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			sum := 0.0
+			for v := 0; v < 8; v++ {
+				sum += idctCos[y][v] * tmp[v*8+x]
+			}
+			block[y*8+x] = clip3(-256, 255, int(math.Floor(sum+0.5)))
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package mpeg
+
+// Tables of MPEG-1 video (ISO/IEC 11172-2 Annex B and 2.4.3.2)
+
+// mpeg1Zigzag maps the zigzag scan order to the raster positions of an 8x8 block (ISO/IEC 11172-2 Figure 2-D.13).
+var mpeg1Zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5, 12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21,
+	28, 35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51, 58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61,
+	54, 47, 55, 62, 63,
+}
+
+// defaultIntraQuantizerMatrix in raster order (ISO/IEC 11172-2 2.4.3.2)
+var defaultIntraQuantizerMatrix = [64]int{
+	8, 16, 19, 22, 26, 27, 29, 34,
+	16, 16, 22, 24, 27, 29, 34, 37,
+	19, 22, 26, 27, 29, 34, 34, 38,
+	22, 22, 26, 27, 29, 34, 37, 40,
+	22, 26, 27, 29, 32, 35, 40, 48,
+	26, 27, 29, 32, 35, 40, 48, 58,
+	26, 27, 29, 34, 38, 46, 56, 69,
+	27, 29, 35, 38, 46, 56, 69, 83,
+}
+
+// Values of macroblock_address_increment that are not increments
+const (
+	macroblockStuffing = 34
+	macroblockEscape   = 35 // Adds 33 to the increment
+)
+
+// Codes {code, length, value} of macroblock_address_increment (ISO/IEC 11172-2 Table B.1)
+var macroblockAddressIncrementCodes = [][3]int{
+	{0b1, 1, 1}, {0b011, 3, 2}, {0b010, 3, 3}, {0b0011, 4, 4}, {0b0010, 4, 5}, {0b00011, 5, 6}, {0b00010, 5, 7},
+	{0b0000111, 7, 8}, {0b0000110, 7, 9}, {0b00001011, 8, 10}, {0b00001010, 8, 11}, {0b00001001, 8, 12},
+	{0b00001000, 8, 13}, {0b00000111, 8, 14}, {0b00000110, 8, 15}, {0b0000010111, 10, 16}, {0b0000010110, 10, 17},
+	{0b0000010101, 10, 18}, {0b0000010100, 10, 19}, {0b0000010011, 10, 20}, {0b0000010010, 10, 21},
+	{0b00000100011, 11, 22}, {0b00000100010, 11, 23}, {0b00000100001, 11, 24}, {0b00000100000, 11, 25},
+	{0b00000011111, 11, 26}, {0b00000011110, 11, 27}, {0b00000011101, 11, 28}, {0b00000011100, 11, 29},
+	{0b00000011011, 11, 30}, {0b00000011010, 11, 31}, {0b00000011001, 11, 32}, {0b00000011000, 11, 33},
+	{0b00000001111, 11, macroblockStuffing}, {0b00000001000, 11, macroblockEscape},
+}
+
+// Flags of macroblock_type
+const (
+	macroblockQuant = 1 << iota
+	macroblockMotionForward
+	macroblockMotionBackward
+	macroblockPattern
+	macroblockIntra
+)
+
+// Codes {code, length, flags} of macroblock_type by picture_coding_type (ISO/IEC 11172-2 Table B.2)
+var macroblockTypeCodes = [5][][3]int{
+	PictureTypeI: {{0b1, 1, macroblockIntra}, {0b01, 2, macroblockIntra | macroblockQuant}},
+	PictureTypeP: {
+		{0b1, 1, macroblockMotionForward | macroblockPattern},
+		{0b01, 2, macroblockPattern},
+		{0b001, 3, macroblockMotionForward},
+		{0b00011, 5, macroblockIntra},
+		{0b00010, 5, macroblockQuant | macroblockMotionForward | macroblockPattern},
+		{0b00001, 5, macroblockQuant | macroblockPattern},
+		{0b000001, 6, macroblockIntra | macroblockQuant},
+	},
+	PictureTypeB: {
+		{0b10, 2, macroblockMotionForward | macroblockMotionBackward},
+		{0b11, 2, macroblockMotionForward | macroblockMotionBackward | macroblockPattern},
+		{0b010, 3, macroblockMotionBackward},
+		{0b011, 3, macroblockMotionBackward | macroblockPattern},
+		{0b0010, 4, macroblockMotionForward},
+		{0b0011, 4, macroblockMotionForward | macroblockPattern},
+		{0b00011, 5, macroblockIntra},
+		{0b00010, 5, macroblockQuant | macroblockMotionForward | macroblockMotionBackward | macroblockPattern},
+		{0b000011, 6, macroblockQuant | macroblockMotionForward | macroblockPattern},
+		{0b000010, 6, macroblockQuant | macroblockMotionBackward | macroblockPattern},
+		{0b000001, 6, macroblockIntra | macroblockQuant},
+	},
+	PictureTypeD: {{0b1, 1, macroblockIntra}},
+}
+
+// Codes {code, length, value} of coded_block_pattern (ISO/IEC 11172-2 Table B.3)
+var codedBlockPatternCodes = [][3]int{
+	{0b111, 3, 60}, {0b1101, 4, 4}, {0b1100, 4, 8}, {0b1011, 4, 16}, {0b1010, 4, 32}, {0b10011, 5, 12},
+	{0b10010, 5, 48}, {0b10001, 5, 20}, {0b10000, 5, 40}, {0b01111, 5, 28}, {0b01110, 5, 44}, {0b01101, 5, 52},
+	{0b01100, 5, 56}, {0b01011, 5, 1}, {0b01010, 5, 61}, {0b01001, 5, 2}, {0b01000, 5, 62}, {0b001111, 6, 24},
+	{0b001110, 6, 36}, {0b001101, 6, 3}, {0b001100, 6, 63}, {0b0010111, 7, 5}, {0b0010110, 7, 9}, {0b0010101, 7, 17},
+	{0b0010100, 7, 33}, {0b0010011, 7, 6}, {0b0010010, 7, 10}, {0b0010001, 7, 18}, {0b0010000, 7, 34},
+	{0b00011111, 8, 7}, {0b00011110, 8, 11}, {0b00011101, 8, 19}, {0b00011100, 8, 35}, {0b00011011, 8, 13},
+	{0b00011010, 8, 49}, {0b00011001, 8, 21}, {0b00011000, 8, 41}, {0b00010111, 8, 14}, {0b00010110, 8, 50},
+	{0b00010101, 8, 22}, {0b00010100, 8, 42}, {0b00010011, 8, 15}, {0b00010010, 8, 51}, {0b00010001, 8, 23},
+	{0b00010000, 8, 43}, {0b00001111, 8, 25}, {0b00001110, 8, 37}, {0b00001101, 8, 26}, {0b00001100, 8, 38},
+	{0b00001011, 8, 29}, {0b00001010, 8, 45}, {0b00001001, 8, 53}, {0b00001000, 8, 57}, {0b00000111, 8, 30},
+	{0b00000110, 8, 46}, {0b00000101, 8, 54}, {0b00000100, 8, 58}, {0b000000111, 9, 31}, {0b000000110, 9, 47},
+	{0b000000101, 9, 55}, {0b000000100, 9, 59}, {0b000000011, 9, 27}, {0b000000010, 9, 39},
+}
+
+// Codes {code, length, value} of the magnitude of motion_horizontal_forward_code and the other motion codes, which
+// are followed by a sign bit if not 0 (ISO/IEC 11172-2 Table B.4)
+var motionCodes = [][3]int{
+	{0b1, 1, 0}, {0b01, 2, 1}, {0b001, 3, 2}, {0b0001, 4, 3}, {0b000011, 6, 4}, {0b0000101, 7, 5}, {0b0000100, 7, 6},
+	{0b0000011, 7, 7}, {0b000001011, 9, 8}, {0b000001010, 9, 9}, {0b000001001, 9, 10}, {0b0000010001, 10, 11},
+	{0b0000010000, 10, 12}, {0b0000001111, 10, 13}, {0b0000001110, 10, 14}, {0b0000001101, 10, 15},
+	{0b0000001100, 10, 16},
+}
+
+// Codes {code, length, value} of dct_dc_size_luminance and dct_dc_size_chrominance (ISO/IEC 11172-2 Table B.5a
+// and B.5b)
+var (
+	dctDcSizeLuminanceCodes = [][3]int{
+		{0b100, 3, 0}, {0b00, 2, 1}, {0b01, 2, 2}, {0b101, 3, 3}, {0b110, 3, 4}, {0b1110, 4, 5}, {0b11110, 5, 6},
+		{0b111110, 6, 7}, {0b1111110, 7, 8},
+	}
+	dctDcSizeChrominanceCodes = [][3]int{
+		{0b00, 2, 0}, {0b01, 2, 1}, {0b10, 2, 2}, {0b110, 3, 3}, {0b1110, 4, 4}, {0b11110, 5, 5}, {0b111110, 6, 6},
+		{0b1111110, 7, 7}, {0b11111110, 8, 8},
+	}
+)
+
+// Codes {code, length, run, level} of dct_coeff_first and dct_coeff_next, which are followed by a sign bit, except
+// end_of_block (10), run 0 level 1 (1 for dct_coeff_first, 11 for dct_coeff_next) and escape (000001) (ISO/IEC
+// 11172-2 Table B.5c)
+var dctCoeffCodes = [][4]int{
+	{0b011, 3, 1, 1}, {0b0100, 4, 0, 2}, {0b0101, 4, 2, 1}, {0b00101, 5, 0, 3}, {0b00111, 5, 3, 1}, {0b00110, 5, 4, 1},
+	{0b000110, 6, 1, 2}, {0b000111, 6, 5, 1}, {0b000101, 6, 6, 1}, {0b000100, 6, 7, 1}, {0b0000110, 7, 0, 4},
+	{0b0000100, 7, 2, 2}, {0b0000111, 7, 8, 1}, {0b0000101, 7, 9, 1}, {0b00100110, 8, 0, 5}, {0b00100001, 8, 0, 6},
+	{0b00100101, 8, 1, 3}, {0b00100100, 8, 3, 2}, {0b00100111, 8, 10, 1}, {0b00100011, 8, 11, 1},
+	{0b00100010, 8, 12, 1}, {0b00100000, 8, 13, 1}, {0b0000001010, 10, 0, 7}, {0b0000001100, 10, 1, 4},
+	{0b0000001011, 10, 2, 3}, {0b0000001111, 10, 4, 2}, {0b0000001001, 10, 5, 2}, {0b0000001110, 10, 14, 1},
+	{0b0000001101, 10, 15, 1}, {0b0000001000, 10, 16, 1}, {0b000000011101, 12, 0, 8}, {0b000000011000, 12, 0, 9},
+	{0b000000010011, 12, 0, 10}, {0b000000010000, 12, 0, 11}, {0b000000011011, 12, 1, 5}, {0b000000010100, 12, 2, 4},
+	{0b000000011100, 12, 3, 3}, {0b000000010010, 12, 4, 3}, {0b000000011110, 12, 6, 2}, {0b000000010101, 12, 7, 2},
+	{0b000000010001, 12, 8, 2}, {0b000000011111, 12, 17, 1}, {0b000000011010, 12, 18, 1}, {0b000000011001, 12, 19, 1},
+	{0b000000010111, 12, 20, 1}, {0b000000010110, 12, 21, 1}, {0b0000000011010, 13, 0, 12},
+	{0b0000000011001, 13, 0, 13}, {0b0000000011000, 13, 0, 14}, {0b0000000010111, 13, 0, 15},
+	{0b0000000010110, 13, 1, 6}, {0b0000000010101, 13, 1, 7}, {0b0000000010100, 13, 2, 5}, {0b0000000010011, 13, 3, 4},
+	{0b0000000010010, 13, 5, 3}, {0b0000000010001, 13, 9, 2}, {0b0000000010000, 13, 10, 2},
+	{0b0000000011111, 13, 22, 1}, {0b0000000011110, 13, 23, 1}, {0b0000000011101, 13, 24, 1},
+	{0b0000000011100, 13, 25, 1}, {0b0000000011011, 13, 26, 1}, {0b00000000011111, 14, 0, 16},
+	{0b00000000011110, 14, 0, 17}, {0b00000000011101, 14, 0, 18}, {0b00000000011100, 14, 0, 19},
+	{0b00000000011011, 14, 0, 20}, {0b00000000011010, 14, 0, 21}, {0b00000000011001, 14, 0, 22},
+	{0b00000000011000, 14, 0, 23}, {0b00000000010111, 14, 0, 24}, {0b00000000010110, 14, 0, 25},
+	{0b00000000010101, 14, 0, 26}, {0b00000000010100, 14, 0, 27}, {0b00000000010011, 14, 0, 28},
+	{0b00000000010010, 14, 0, 29}, {0b00000000010001, 14, 0, 30}, {0b00000000010000, 14, 0, 31},
+	{0b000000000011000, 15, 0, 32}, {0b000000000010111, 15, 0, 33}, {0b000000000010110, 15, 0, 34},
+	{0b000000000010101, 15, 0, 35}, {0b000000000010100, 15, 0, 36}, {0b000000000010011, 15, 0, 37},
+	{0b000000000010010, 15, 0, 38}, {0b000000000010001, 15, 0, 39}, {0b000000000010000, 15, 0, 40},
+	{0b000000000011111, 15, 1, 8}, {0b000000000011110, 15, 1, 9}, {0b000000000011101, 15, 1, 10},
+	{0b000000000011100, 15, 1, 11}, {0b000000000011011, 15, 1, 12}, {0b000000000011010, 15, 1, 13},
+	{0b000000000011001, 15, 1, 14}, {0b0000000000010011, 16, 1, 15}, {0b0000000000010010, 16, 1, 16},
+	{0b0000000000010001, 16, 1, 17}, {0b0000000000010000, 16, 1, 18}, {0b0000000000010100, 16, 6, 3},
+	{0b0000000000011010, 16, 11, 2}, {0b0000000000011001, 16, 12, 2}, {0b0000000000011000, 16, 13, 2},
+	{0b0000000000010111, 16, 14, 2}, {0b0000000000010110, 16, 15, 2}, {0b0000000000010101, 16, 16, 2},
+	{0b0000000000011111, 16, 27, 1}, {0b0000000000011110, 16, 28, 1}, {0b0000000000011101, 16, 29, 1},
+	{0b0000000000011100, 16, 30, 1}, {0b0000000000011011, 16, 31, 1},
+}
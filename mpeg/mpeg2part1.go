@@ -0,0 +1,236 @@
+package mpeg
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Packetized elementary streams (ISO/IEC 13818-1 2.4.3.6 and ISO/IEC 11172-1 2.4.3.3), the packets of program and
+// transport streams
+
+var errPes = errors.New("mpeg: invalid PES packet")
+
+// stream_id of PES packets (ISO/IEC 13818-1 Table 2-22)
+const (
+	StreamIDProgramStreamMap = 0xBC
+	StreamIDPrivateStream1   = 0xBD // Sub streams of DVD, like AC-3 and LPCM audio
+	StreamIDPadding          = 0xBE
+	StreamIDPrivateStream2   = 0xBF // Navigation packets of DVD
+	StreamIDAudioFirst       = 0xC0 // MPEG audio streams 0 to 31
+	StreamIDAudioLast        = 0xDF
+	StreamIDVideoFirst       = 0xE0 // MPEG video streams 0 to 15
+	StreamIDVideoLast        = 0xEF
+	streamIDEcm              = 0xF0
+	streamIDEmm              = 0xF1
+	streamIDDsmcc            = 0xF2
+	streamIDTypeE            = 0xF8
+	streamIDDirectory        = 0xFF
+)
+
+// stream_type of the program stream map and of the program map table (ISO/IEC 13818-1 Table 2-34)
+const (
+	StreamTypeMpeg1Video = 0x01
+	StreamTypeMpeg2Video = 0x02
+	StreamTypeMpeg1Audio = 0x03
+	StreamTypeMpeg2Audio = 0x04
+	StreamTypePrivate    = 0x06 // PES packets with private data, like AC-3 by a descriptor
+	StreamTypeAdtsAac    = 0x0F
+	StreamTypeMetadata   = 0x15 // Metadata carried in PES packets, like ID3 timed metadata
+	StreamTypeAvc        = 0x1B
+	StreamTypeHevc       = 0x24
+	StreamTypeAc3        = 0x81 // ATSC A/52
+)
+
+// Sub streams of private_stream_1 in DVD video, by their first byte
+const (
+	substreamSubpictureFirst = 0x20
+	substreamSubpictureLast  = 0x3F
+	substreamAc3First        = 0x80
+	substreamAc3Last         = 0x87
+	substreamDtsFirst        = 0x88
+	substreamDtsLast         = 0x8F
+	substreamLpcmFirst       = 0xA0
+	substreamLpcmLast        = 0xA7
+)
+
+// PesPacket is the payload of a PES packet of an elementary stream with its time stamps. The access units of the
+// stream may start and end anywhere in the packets.
+type PesPacket struct {
+	StreamID    byte
+	SubstreamID byte  // Sub stream of private_stream_1, 0 for other streams
+	Codec       Codec // 0 if unknown. The AAC frames of CodecAac have ADTS headers
+	Pts         int64 // Presentation time stamp in units of 90 kHz, -1 if absent
+	Dts         int64 // Decoding time stamp in units of 90 kHz, the PTS if absent
+	Data        []byte
+
+	PID          int  // PID of the transport stream packets, 0 in program streams
+	RandomAccess bool // random_access_indicator of the first transport stream packet
+
+	// Lpcm is the format of the samples of DVD LPCM sub streams, big-endian and interleaved
+	Lpcm *DvdLpcmHeader
+}
+
+// DvdLpcmHeader is the header of the LPCM audio packets of DVD video.
+type DvdLpcmHeader struct {
+	FrameNumber   int // 5 bits
+	BitsPerSample int // 16, 20 or 24
+	SampleRate    int // 48000 or 96000
+	Channels      int // 1 to 8
+}
+
+// Time returns the presentation time stamp of the packet.
+func (p *PesPacket) Time() time.Duration {
+	if p.Pts < 0 {
+		return 0
+	}
+	return scaleDuration(uint64(p.Pts), 90000)
+}
+
+// hasPesHeader reports whether the PES packets of a stream have the header with the time stamps.
+func hasPesHeader(streamID byte) bool {
+	switch streamID {
+	case StreamIDProgramStreamMap, StreamIDPadding, StreamIDPrivateStream2, streamIDEcm, streamIDEmm,
+		streamIDDsmcc, streamIDTypeE, streamIDDirectory:
+		return false
+	}
+	return true
+}
+
+// parsePes parses the header of a PES packet following PES_packet_length, in the syntax of ISO/IEC 13818-1 or of
+// ISO/IEC 11172-1.
+func parsePes(streamID byte, b []byte) (*PesPacket, error) {
+	p := &PesPacket{StreamID: streamID, Pts: -1, Dts: -1}
+	if !hasPesHeader(streamID) {
+		p.Data = b
+		return p, nil
+	}
+
+	var err error
+	i := 0
+	if len(b) > 0 && b[0]>>6 == 0b10 {
+		// ISO/IEC 13818-1
+		if len(b) < 3 || 3+int(b[2]) > len(b) {
+			return p, fmt.Errorf("%w: header of %d bytes", errPes, len(b))
+		}
+		header := b[3 : 3+int(b[2])]
+		switch b[1] >> 6 { // PTS_DTS_flags
+		case 0b10:
+			p.Pts, err = readTimestamp(header, 0b0010)
+		case 0b11:
+			if p.Pts, err = readTimestamp(header, 0b0011); err == nil && len(header) >= 10 {
+				p.Dts, err = readTimestamp(header[5:], 0b0001)
+			}
+		}
+		i = 3 + int(b[2])
+	} else {
+		// ISO/IEC 11172-1
+		for i < len(b) && b[i] == 0xFF { // stuffing_byte
+			i++
+		}
+		if i < len(b) && b[i]>>6 == 0b01 { // STD_buffer_scale and STD_buffer_size
+			i += 2
+		}
+		if i >= len(b) {
+			return p, fmt.Errorf("%w: header of %d bytes", errPes, len(b))
+		}
+		switch b[i] >> 4 {
+		case 0b0010:
+			p.Pts, err = readTimestamp(b[i:], 0b0010)
+			i += 5
+		case 0b0011:
+			if p.Pts, err = readTimestamp(b[i:], 0b0011); err == nil && len(b) >= i+10 {
+				p.Dts, err = readTimestamp(b[i+5:], 0b0001)
+			}
+			i += 10
+		default:
+			if b[i] != 0x0F {
+				return p, fmt.Errorf("%w: header byte %#x", errPes, b[i])
+			}
+			i++
+		}
+	}
+	if err != nil {
+		return p, err
+	}
+	if i > len(b) {
+		return p, fmt.Errorf("%w: header of %d bytes", errPes, len(b))
+	}
+	if p.Dts < 0 {
+		p.Dts = p.Pts
+	}
+	p.Data = b[i:]
+
+	switch {
+	case streamID >= StreamIDAudioFirst && streamID <= StreamIDAudioLast:
+		p.Codec = CodecMp3
+	case streamID >= StreamIDVideoFirst && streamID <= StreamIDVideoLast:
+		p.Codec = CodecMpegVideo
+	}
+	return p, nil
+}
+
+// parseSubstream removes the sub stream number and the header of the sub streams of DVD video from the data of a
+// private_stream_1 packet.
+func parseSubstream(p *PesPacket) error {
+	if len(p.Data) == 0 {
+		return fmt.Errorf("%w: empty private_stream_1 packet", errPes)
+	}
+	p.SubstreamID = p.Data[0]
+	headerLen := 1
+	switch {
+	case p.SubstreamID >= substreamAc3First && p.SubstreamID <= substreamAc3Last:
+		p.Codec = CodecAc3
+		headerLen = 4 // number_of_frame_headers and first_access_unit_pointer
+	case p.SubstreamID >= substreamDtsFirst && p.SubstreamID <= substreamDtsLast:
+		headerLen = 4
+	case p.SubstreamID >= substreamLpcmFirst && p.SubstreamID <= substreamLpcmLast:
+		p.Codec = CodecLpcm
+		headerLen = 7
+		if len(p.Data) < headerLen {
+			break
+		}
+		h := &DvdLpcmHeader{
+			FrameNumber:   int(p.Data[4] & 0x1F),
+			BitsPerSample: 16 + 4*int(p.Data[5]>>6),
+			SampleRate:    48000,
+			Channels:      int(p.Data[5]&0x07) + 1,
+		}
+		if p.Data[5]>>4&0x3 == 1 {
+			h.SampleRate = 96000
+		}
+		p.Lpcm = h
+	}
+	if len(p.Data) < headerLen {
+		return fmt.Errorf("%w: sub stream %#x header of %d bytes", errPes, p.SubstreamID, len(p.Data))
+	}
+	p.Data = p.Data[headerLen:]
+	return nil
+}
+
+// readTimestamp reads a PTS or DTS of 33 bits with its 4 bit prefix and marker bits.
+func readTimestamp(b []byte, prefix byte) (int64, error) {
+	if len(b) < 5 || b[0]>>4 != prefix || b[0]&b[2]&b[4]&1 != 1 {
+		return -1, fmt.Errorf("%w: time stamp", errPes)
+	}
+	return int64(b[0]>>1&0x7)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1), nil
+}
+
+// streamTypeCodec returns the codec of a stream_type, 0 if it is not an audio or video coding.
+func streamTypeCodec(streamType byte) Codec {
+	switch streamType {
+	case StreamTypeMpeg1Video, StreamTypeMpeg2Video:
+		return CodecMpegVideo
+	case StreamTypeMpeg1Audio, StreamTypeMpeg2Audio:
+		return CodecMp3
+	case StreamTypeAdtsAac:
+		return CodecAac
+	case StreamTypeAvc:
+		return CodecAvc
+	case StreamTypeHevc:
+		return CodecHevc
+	case StreamTypeAc3:
+		return CodecAc3
+	}
+	return 0
+}
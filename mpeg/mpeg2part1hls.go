@@ -0,0 +1,153 @@
+package mpeg
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Transport stream segments and media playlists of HTTP Live Streaming (RFC 8216)
+
+type hlsSegment struct {
+	sequence uint32
+	duration time.Duration
+}
+
+// HlsSegmenter writes audio, video and timed metadata packets into transport stream segments and lists them in a
+// media playlist. Every segment starts with the PAT and PMT.
+//
+// A segment ends when a random access point of the reference track, the first video track or else the first audio
+// track, is written after SegmentDuration. For audio only streams, segments are cut after a fixed duration.
+type HlsSegmenter struct {
+	SegmentDuration time.Duration
+	PlaylistType    string // EXT-X-PLAYLIST-TYPE, "VOD" or "EVENT", omitted if empty
+	WindowSize      int    // Number of the last segments listed by the playlist, all segments if 0
+
+	// SegmentURI returns the URI of a segment in the playlist, "segment<Sequence>.ts" if it is nil.
+	SegmentURI func(sequence uint32) string
+
+	muxer    *TsMuxer
+	buf      bytes.Buffer
+	started  bool   // A packet of the reference track was written to the current segment
+	start    uint64 // Decoding time of the first packet of the reference track in the segment
+	earliest uint64 // Earliest presentation time of the packets of the reference track in the segment
+	sequence uint32
+	segments []hlsSegment
+}
+
+// NewHlsSegmenter returns a segmenter cutting segments of at least duration.
+func NewHlsSegmenter(duration time.Duration) *HlsSegmenter {
+	s := &HlsSegmenter{SegmentDuration: duration}
+	s.muxer = NewTsMuxer(&s.buf)
+	return s
+}
+
+// AddTrack adds an AAC, MP3, AVC or HEVC track and returns its ID, as TsMuxer.AddTrack.
+func (s *HlsSegmenter) AddTrack(track MuxTrack) (uint32, error) {
+	return s.muxer.AddTrack(track)
+}
+
+// AddMetadataTrack adds a track of ID3 timed metadata and returns its ID, as TsMuxer.AddMetadataTrack.
+func (s *HlsSegmenter) AddMetadataTrack() (uint32, error) {
+	return s.muxer.AddMetadataTrack()
+}
+
+// WritePacket adds a packet to the current segment. It returns the previous segment if the packet starts a new one,
+// otherwise nil. The times of the packet are used as by the TsMuxer.
+func (s *HlsSegmenter) WritePacket(p *Packet) (*Segment, error) {
+	t, err := s.muxer.track(p.TrackID)
+	if err != nil {
+		return nil, err
+	}
+
+	var segment *Segment
+	if ref := s.muxer.pcr; t == ref {
+		decodeTime := t.decodeTime(p)
+		presentationTime := decodeTime
+		if t.isVideo() && p.CompositionTime > int64(decodeTime) {
+			presentationTime = uint64(p.CompositionTime)
+		}
+		switch {
+		case !s.started:
+			s.start, s.started = decodeTime, true
+			s.earliest = presentationTime
+		case (p.Keyframe || !t.isVideo()) && decodeTime > s.start &&
+			scaleDuration(decodeTime-s.start, t.Timescale) >= s.SegmentDuration:
+			segment = s.segment(decodeTime)
+			s.earliest = presentationTime
+		case presentationTime < s.earliest:
+			s.earliest = presentationTime
+		}
+	}
+	return segment, s.muxer.WritePacket(p)
+}
+
+// Flush returns the packets written since the last segment as a segment, nil if there are none.
+func (s *HlsSegmenter) Flush() *Segment {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	end := s.start
+	if ref := s.muxer.pcr; s.started && ref.next > end {
+		end = ref.next
+	}
+	return s.segment(end)
+}
+
+// segment returns the packets of the current segment, which ends at a decoding time of the reference track, and
+// adds it to the playlist.
+func (s *HlsSegmenter) segment(end uint64) *Segment {
+	s.sequence++
+	segment := &Segment{Sequence: s.sequence, Data: append([]byte{}, s.buf.Bytes()...)}
+	if ref := s.muxer.pcr; ref != nil && s.started {
+		segment.Time = scaleDuration(s.earliest, ref.Timescale)
+		segment.Duration = scaleDuration(end-s.start, ref.Timescale)
+	}
+	s.segments = append(s.segments, hlsSegment{sequence: segment.Sequence, duration: segment.Duration})
+
+	s.buf.Reset()
+	s.start = end
+	s.muxer.tables = -1
+	return segment
+}
+
+// Playlist returns the media playlist of the segments returned so far. The playlist of a stream which ended has
+// the EXT-X-ENDLIST tag.
+func (s *HlsSegmenter) Playlist(ended bool) []byte {
+	segments := s.segments
+	if s.WindowSize > 0 && len(segments) > s.WindowSize {
+		segments = segments[len(segments)-s.WindowSize:]
+	}
+
+	// The durations of the segments rounded to the nearest second must not exceed EXT-X-TARGETDURATION. It is
+	// taken from all segments so it does not change while the window moves.
+	target := int(math.Ceil(s.SegmentDuration.Seconds()))
+	for _, segment := range s.segments {
+		if d := int(math.Round(segment.duration.Seconds())); d > target {
+			target = d
+		}
+	}
+	sequence := s.sequence + 1
+	if len(segments) > 0 {
+		sequence = segments[0].sequence
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n", target, sequence)
+	if s.PlaylistType != "" {
+		fmt.Fprintf(b, "#EXT-X-PLAYLIST-TYPE:%s\n", s.PlaylistType)
+	}
+	for _, segment := range segments {
+		uri := fmt.Sprintf("segment%d.ts", segment.sequence)
+		if s.SegmentURI != nil {
+			uri = s.SegmentURI(segment.sequence)
+		}
+		fmt.Fprintf(b, "#EXTINF:%.3f,\n%s\n", segment.duration.Seconds(), uri)
+	}
+	if ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return []byte(b.String())
+}
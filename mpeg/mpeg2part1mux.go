@@ -0,0 +1,373 @@
+package mpeg
+
+import (
+	"awCodec/aac"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Multiplexing of MPEG-2 transport streams (ISO/IEC 13818-1 2.4), the .ts files of HLS
+
+const (
+	tsPidPmt         = 0x1000
+	tsPidFirstStream = 0x0100
+	tsProgramNumber  = 1
+
+	// Delay of the time stamps after the program clock reference, in units of 90 kHz, so that decoders receive
+	// the access units before their decoding time.
+	tsDelay = 63000
+
+	// Interval between repetitions of the PAT and PMT, in units of 90 kHz.
+	tsTablesInterval = 9000
+)
+
+// metadata_application_format_identifier and metadata_format_identifier of ID3 timed metadata
+var formatID3 = []byte{'I', 'D', '3', ' '}
+
+type tsTrack struct {
+	*muxTrack
+	pid        int
+	streamID   byte
+	streamType byte
+	cc         byte                     // continuity_counter of the next packet
+	config     *aac.AudioSpecificConfig // Configuration of AAC raw data blocks, nil for ADTS frames
+	written    bool
+	last       uint64 // Decoding time of the last packet
+	next       uint64 // Decoding time following the last packet
+}
+
+// TsMuxer writes audio, video and timed metadata packets into a transport stream with a single program. Every
+// packet is written as one PES packet as soon as it arrives, so packets are written in the order of their decoding
+// times.
+//
+// AAC raw data blocks get an ADTS header, unless the Config of the track is empty and the packets already are ADTS
+// frames. AVC and HEVC samples are converted to Annex B access units with an access unit delimiter and the
+// parameter sets before random access pictures.
+//
+// The program clock reference is carried by the first video track, or else the first audio track. PAT and PMT
+// are written before the first packet and then before random access points of that track.
+type TsMuxer struct {
+	w       io.Writer
+	tracks  []*tsTrack
+	pcr     *tsTrack
+	started bool
+	tables  int64 // Time of the last PAT and PMT in units of 90 kHz, -1 to write them before the next packet
+	patCC   byte
+	pmtCC   byte
+}
+
+// NewTsMuxer returns a muxer writing to w.
+func NewTsMuxer(w io.Writer) *TsMuxer {
+	return &TsMuxer{w: w, tables: -1}
+}
+
+// AddTrack adds an AAC, MP3, AVC or HEVC track and returns its ID. Tracks are added before the first packet is
+// written.
+func (m *TsMuxer) AddTrack(track MuxTrack) (uint32, error) {
+	if m.started {
+		return 0, errMuxerClosed
+	}
+	mt, err := newMuxTrack(track, uint32(len(m.tracks)+1))
+	if err != nil {
+		return 0, err
+	}
+	t := &tsTrack{muxTrack: mt}
+	switch t.Codec {
+	case CodecAac:
+		t.streamType = StreamTypeAdtsAac
+		if len(t.Config) > 0 {
+			if t.config, err = aac.ParseAudioSpecificConfig(t.Config); err != nil {
+				return 0, err
+			}
+			if _, err = NewAdtsHeader(t.config, 0); err != nil {
+				return 0, err
+			}
+		}
+	case CodecMp3:
+		t.streamType = StreamTypeMpeg1Audio
+		if t.SampleRate < 32000 {
+			t.streamType = StreamTypeMpeg2Audio
+		}
+	case CodecAvc:
+		t.streamType = StreamTypeAvc
+	case CodecHevc:
+		t.streamType = StreamTypeHevc
+	default:
+		return 0, fmt.Errorf("%w: %d in transport streams", errMuxerCodec, t.Codec)
+	}
+	t.streamID = StreamIDAudioFirst
+	if t.isVideo() {
+		t.streamID = StreamIDVideoFirst
+	}
+	for _, other := range m.tracks {
+		if other.streamID == t.streamID {
+			t.streamID++
+		}
+	}
+	m.add(t)
+	if m.pcr == nil || !m.pcr.isVideo() && t.isVideo() {
+		m.pcr = t
+	}
+	return t.id, nil
+}
+
+// AddMetadataTrack adds a track of ID3 timed metadata and returns its ID. The packets of the track are ID3 tags,
+// like those returned by id3.Tag, with times in units of 90 kHz.
+func (m *TsMuxer) AddMetadataTrack() (uint32, error) {
+	if m.started {
+		return 0, errMuxerClosed
+	}
+	t := &tsTrack{
+		muxTrack:   &muxTrack{MuxTrack: MuxTrack{Codec: codecID3, Timescale: 90000}, id: uint32(len(m.tracks) + 1)},
+		streamID:   StreamIDPrivateStream1,
+		streamType: StreamTypeMetadata,
+	}
+	m.add(t)
+	return t.id, nil
+}
+
+func (m *TsMuxer) add(t *tsTrack) {
+	t.pid = tsPidFirstStream + len(m.tracks)
+	m.tracks = append(m.tracks, t)
+}
+
+// track returns the track of a packet.
+func (m *TsMuxer) track(id uint32) (*tsTrack, error) {
+	if id == 0 || int(id) > len(m.tracks) {
+		return nil, fmt.Errorf("%w: %d", errMuxerTrack, id)
+	}
+	return m.tracks[id-1], nil
+}
+
+// decodeTime returns the decoding time of a packet, which follows the previous packet if its DecodeTime is 0.
+func (t *tsTrack) decodeTime(p *Packet) uint64 {
+	if p.DecodeTime == 0 && t.written {
+		return t.next
+	}
+	return p.DecodeTime
+}
+
+// advance records the decoding time and the duration of a packet. A Duration of 0 is taken to be the interval
+// from the previous packet.
+func (t *tsTrack) advance(decodeTime uint64, duration uint32) {
+	switch {
+	case duration > 0:
+		t.next = decodeTime + uint64(duration)
+	case t.written && decodeTime > t.last:
+		t.next = decodeTime + (decodeTime - t.last)
+	default:
+		t.next = decodeTime
+	}
+	t.last, t.written = decodeTime, true
+}
+
+// WritePacket writes a packet as a PES packet. DecodeTime and Duration are in the timescale of the track, as for
+// the Muxer. The CompositionTime is used for video tracks only, it gives the PTS if it is after the DTS.
+func (m *TsMuxer) WritePacket(p *Packet) error {
+	t, err := m.track(p.TrackID)
+	if err != nil {
+		return err
+	}
+	m.started = true
+
+	decodeTime := t.decodeTime(p)
+	dts := timestamp90kHz(decodeTime, t.Timescale)
+	pts := dts
+	if t.isVideo() && p.CompositionTime > int64(decodeTime) {
+		pts = timestamp90kHz(uint64(p.CompositionTime), t.Timescale)
+	}
+	t.advance(decodeTime, p.Duration)
+
+	data := p.Data
+	switch {
+	case t.Codec == CodecAac && t.config != nil:
+		h, err := NewAdtsHeader(t.config, len(data))
+		if err != nil {
+			return err
+		}
+		data = append(h.Append(make([]byte, 0, adtsHeaderLength+len(data))), data...)
+	case t.Codec == CodecAvc:
+		if data, err = t.avcC.AnnexB(data); err != nil {
+			return err
+		}
+	case t.Codec == CodecHevc:
+		if data, err = t.hvcC.AnnexB(data); err != nil {
+			return err
+		}
+	}
+
+	randomAccess := p.Keyframe || !t.isVideo()
+	if m.tables < 0 || t == m.pcr && randomAccess && dts-m.tables >= tsTablesInterval {
+		if err := m.writeTables(); err != nil {
+			return err
+		}
+		m.tables = dts
+	}
+
+	pes, err := t.pes(pts, dts, data)
+	if err != nil {
+		return err
+	}
+	pcr := int64(-1)
+	if t == m.pcr {
+		pcr = dts * 300
+	}
+	_, err = m.w.Write(t.packets(pes, pcr, randomAccess))
+	return err
+}
+
+// timestamp90kHz converts a time of a timescale into units of 90 kHz.
+func timestamp90kHz(t uint64, timescale uint32) int64 {
+	if timescale == 90000 {
+		return int64(t)
+	}
+	return int64(t/uint64(timescale)*90000 + t%uint64(timescale)*90000/uint64(timescale))
+}
+
+// pes returns a PES packet of an access unit (ISO/IEC 13818-1 2.4.3.6). The DTS is only written if it differs from
+// the PTS.
+func (t *tsTrack) pes(pts, dts int64, data []byte) ([]byte, error) {
+	b := make([]byte, 9, 19+len(data))
+	copy(b, []byte{0x00, 0x00, 0x01, t.streamID})
+	b[6] = 0x84 // data_alignment_indicator
+	if dts == pts {
+		b[7], b[8] = 0x80, 5 // PTS_DTS_flags and PES_header_data_length
+		b = appendTimestamp(b, 0b0010, pts+tsDelay)
+	} else {
+		b[7], b[8] = 0xC0, 10
+		b = appendTimestamp(b, 0b0011, pts+tsDelay)
+		b = appendTimestamp(b, 0b0001, dts+tsDelay)
+	}
+	length := len(b) - 6 + len(data)
+	if length > 0xFFFF {
+		if !t.isVideo() {
+			return nil, fmt.Errorf("%w: PES packet of %d bytes", errPes, length)
+		}
+		length = 0 // Unbounded, allowed for video elementary streams in transport streams
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(length))
+	return append(b, data...), nil
+}
+
+// appendTimestamp appends a PTS or DTS of 33 bits with its 4 bit prefix and marker bits.
+func appendTimestamp(b []byte, prefix byte, v int64) []byte {
+	v &= 1<<33 - 1
+	return append(b, prefix<<4|byte(v>>30)<<1|1, byte(v>>22), byte(v>>15)<<1|1, byte(v>>7), byte(v)<<1|1)
+}
+
+// packets splits a PES packet into transport stream packets. The adaptation field of the first packet carries
+// the random_access_indicator and a program clock reference in units of 27 MHz if pcr is not negative, the one of
+// the last packet the stuffing bytes.
+func (t *tsTrack) packets(pes []byte, pcr int64, randomAccess bool) []byte {
+	out := make([]byte, 0, (len(pes)/(tsPacketSize-4)+2)*tsPacketSize)
+	for first := true; first || len(pes) > 0; first = false {
+		var flags byte
+		var pcrField []byte
+		if first && randomAccess {
+			flags |= 0x40 // random_access_indicator
+		}
+		if first && pcr >= 0 {
+			flags |= 0x10 // PCR_flag
+			base, extension := pcr/300&(1<<33-1), pcr%300
+			pcrField = []byte{byte(base >> 25), byte(base >> 17), byte(base >> 9), byte(base >> 1),
+				byte(base)<<7 | 0x7E | byte(extension>>8), byte(extension)}
+		}
+
+		// Size of the adaptation field with adaptation_field_length, 0 without adaptation field
+		afSize := 0
+		if flags != 0 {
+			afSize = 2 + len(pcrField)
+		}
+		n := tsPacketSize - 4 - afSize
+		if len(pes) < n {
+			afSize += n - len(pes)
+			n = len(pes)
+		}
+
+		pkt := make([]byte, tsPacketSize)
+		pkt[0] = tsSyncByte
+		binary.BigEndian.PutUint16(pkt[1:], uint16(t.pid))
+		if first {
+			pkt[1] |= 0x40 // payload_unit_start_indicator
+		}
+		pkt[3] = 0x10 | t.cc // adaptation_field_control payload only
+		if afSize > 0 {
+			pkt[3] |= 0x20
+			pkt[4] = byte(afSize - 1)
+			if afSize > 1 {
+				pkt[5] = flags
+				for i := 6 + copy(pkt[6:], pcrField); i < 4+afSize; i++ {
+					pkt[i] = 0xFF // stuffing_byte
+				}
+			}
+		}
+		copy(pkt[4+afSize:], pes[:n])
+		pes = pes[n:]
+		t.cc = (t.cc + 1) & 0xF
+		out = append(out, pkt...)
+	}
+	return out
+}
+
+// writeTables writes the program association table and the program map table.
+func (m *TsMuxer) writeTables() error {
+	pat := []byte{tableIDPat, 0, 0, 0x00, 0x01, 0xC1, 0x00, 0x00} // transport_stream_id 1, version 0
+	pat = append(pat, tsProgramNumber>>8, tsProgramNumber&0xFF, 0xE0|tsPidPmt>>8, tsPidPmt&0xFF)
+
+	pcrPid := pidNull
+	if m.pcr != nil {
+		pcrPid = m.pcr.pid
+	}
+	var programInfo, streams []byte
+	for _, t := range m.tracks {
+		var esInfo []byte
+		if t.Codec == codecID3 {
+			if programInfo == nil {
+				programInfo = append([]byte{DescriptorMetadataPointer, 15, 0xFF, 0xFF}, formatID3...)
+				programInfo = append(programInfo, 0xFF)
+				programInfo = append(programInfo, formatID3...)
+				// metadata_service_id, metadata_locator_record_flag, MPEG_carriage_flags and program_number
+				programInfo = append(programInfo, 0x00, 0x1F, tsProgramNumber>>8, tsProgramNumber&0xFF)
+			}
+			esInfo = append([]byte{DescriptorMetadata, 13, 0xFF, 0xFF}, formatID3...)
+			esInfo = append(esInfo, 0xFF)
+			esInfo = append(esInfo, formatID3...)
+			esInfo = append(esInfo, 0x00, 0x0F) // metadata_service_id, decoder_config_flags and DSM-CC_flag
+		} else if !t.isVideo() && len(t.Language) == 3 && t.Language != "und" {
+			esInfo = append([]byte{DescriptorLanguage, 4}, t.Language...)
+			esInfo = append(esInfo, 0x00) // audio_type undefined
+		}
+		streams = append(streams, t.streamType, 0xE0|byte(t.pid>>8), byte(t.pid), 0xF0|byte(len(esInfo)>>8), byte(len(esInfo)))
+		streams = append(streams, esInfo...)
+	}
+	pmt := []byte{tableIDPmt, 0, 0, tsProgramNumber >> 8, tsProgramNumber & 0xFF, 0xC1, 0x00, 0x00,
+		0xE0 | byte(pcrPid>>8), byte(pcrPid), 0xF0 | byte(len(programInfo)>>8), byte(len(programInfo))}
+	pmt = append(pmt, programInfo...)
+	pmt = append(pmt, streams...)
+
+	b := make([]byte, 0, 2*tsPacketSize)
+	b = appendSection(b, pidPat, &m.patCC, pat)
+	b = appendSection(b, tsPidPmt, &m.pmtCC, pmt)
+	_, err := m.w.Write(b)
+	return err
+}
+
+// appendSection sets the section_length and the CRC_32 of a PSI section and appends it in a transport stream
+// packet.
+func appendSection(b []byte, pid int, cc *byte, section []byte) []byte {
+	binary.BigEndian.PutUint16(section[1:], uint16(0xB000|len(section)-3+4)) // section_syntax_indicator
+	crc := crc32Mpeg2(section)
+	section = append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	pkt := make([]byte, tsPacketSize)
+	pkt[0] = tsSyncByte
+	binary.BigEndian.PutUint16(pkt[1:], 0x4000|uint16(pid)) // payload_unit_start_indicator
+	pkt[3] = 0x10 | *cc
+	*cc = (*cc + 1) & 0xF
+	// pointer_field 0 and the section, followed by stuffing
+	for i := 5 + copy(pkt[5:], section); i < tsPacketSize; i++ {
+		pkt[i] = 0xFF
+	}
+	return append(b, pkt...)
+}
@@ -0,0 +1,334 @@
+package mpeg
+
+import (
+	"awCodec/pcm"
+	"awCodec/utils"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Demultiplexing of MPEG-1 system streams (ISO/IEC 11172-1) and MPEG-2 program streams (ISO/IEC 13818-1 2.5), the
+// streams of .mpg and .vob files
+
+var errProgramStream = errors.New("mpeg: invalid program stream")
+
+// Start codes of program streams besides the stream_id of PES packets
+const (
+	programEndCode        = 0xB9
+	packStartCode         = 0xBA
+	systemHeaderStartCode = 0xBB
+)
+
+// SystemHeader is the system header of a program stream (ISO/IEC 13818-1 2.5.3.5).
+type SystemHeader struct {
+	RateBound                 int // 22 bits, in units of 50 bytes/s
+	AudioBound                int // 6 bits
+	FixedFlag                 bool
+	CspsFlag                  bool
+	SystemAudioLockFlag       bool
+	SystemVideoLockFlag       bool
+	VideoBound                int // 5 bits
+	PacketRateRestrictionFlag bool
+	Streams                   []SystemHeaderStream
+}
+
+// SystemHeaderStream gives the buffer size bound of a stream of a system header.
+type SystemHeaderStream struct {
+	StreamID         byte
+	BufferBoundScale int // 1 bit, the bound is in units of 128 bytes if 0 and 1024 bytes if 1
+	BufferSizeBound  int // 13 bits
+}
+
+// ProgramStreamDemuxer reads the PES packets of the elementary streams of an MPEG-1 system stream or an MPEG-2
+// program stream in the order of the stream. The packets of MPEG audio streams can be decoded by
+// NewMpegAudioDecoder and the ones of MPEG-1 video by an Mpeg1VideoDecoder.
+type ProgramStreamDemuxer struct {
+	r *bufio.Reader
+
+	Mpeg1        bool          // The last pack header is in the syntax of ISO/IEC 11172-1
+	Scr          int64         // System clock reference of the last pack header in units of 27 MHz
+	MuxRate      int           // program_mux_rate of the last pack header in units of 50 bytes/s
+	SystemHeader *SystemHeader // Last system header, nil before the first one
+
+	// StreamTypes maps the elementary_stream_id of the last program stream map to its stream_type
+	StreamTypes map[byte]byte
+}
+
+// NewProgramStreamDemuxer returns a demuxer of the program stream read from r.
+func NewProgramStreamDemuxer(r io.Reader) *ProgramStreamDemuxer {
+	return &ProgramStreamDemuxer{r: bufio.NewReader(r)}
+}
+
+// ReadPacket returns the next PES packet. The pack headers, system headers and program stream maps before it update
+// the demuxer and padding packets are skipped. It returns io.EOF at the program end code or the end of the stream.
+// After an invalid packet the next call resumes at the next start code.
+func (d *ProgramStreamDemuxer) ReadPacket() (*PesPacket, error) {
+	for {
+		code, err := d.nextStartCode()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case code == programEndCode:
+			return nil, io.EOF
+		case code == packStartCode:
+			if err := d.readPackHeader(); err != nil {
+				return nil, err
+			}
+		case code >= systemHeaderStartCode:
+			var length [2]byte
+			if _, err := io.ReadFull(d.r, length[:]); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			b := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(d.r, b); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+
+			switch code {
+			case systemHeaderStartCode:
+				if err := d.parseSystemHeader(b); err != nil {
+					return nil, err
+				}
+			case StreamIDProgramStreamMap:
+				if err := d.parseProgramStreamMap(b); err != nil {
+					return nil, err
+				}
+			case StreamIDPadding:
+			default:
+				p, err := parsePes(code, b)
+				if err == nil && code == StreamIDPrivateStream1 {
+					err = parseSubstream(p)
+				}
+				if err != nil {
+					return nil, err
+				}
+				if codec := streamTypeCodec(d.StreamTypes[code]); codec != 0 && p.SubstreamID == 0 {
+					p.Codec = codec
+				}
+				return p, nil
+			}
+		}
+		// Other start codes are not at the level of the program stream, they are skipped until the next pack
+	}
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF for the end of the stream within a packet.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// nextStartCode reads up to the next start code and returns the byte following its prefix.
+func (d *ProgramStreamDemuxer) nextStartCode() (byte, error) {
+	prefix := uint32(0xFFFFFF)
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if prefix == 0x000001 {
+			return c, nil
+		}
+		prefix = (prefix<<8 | uint32(c)) & 0xFFFFFF
+	}
+}
+
+// readPackHeader reads a pack header following its start code, in the syntax of ISO/IEC 11172-1 2.4.3.2 or of
+// ISO/IEC 13818-1 2.5.3.3.
+func (d *ProgramStreamDemuxer) readPackHeader() error {
+	b := make([]byte, 10)
+	if _, err := io.ReadFull(d.r, b[:8]); err != nil {
+		return unexpectedEOF(err)
+	}
+	br := utils.NewBitReader(b)
+	d.Mpeg1 = b[0]>>4 == 0b0010
+	if d.Mpeg1 {
+		br.Seek(4)
+		d.Scr = int64(readMarkedBits(br, 3, 15, 15)) * 300
+		br.Seek(1) // marker_bit
+		d.MuxRate = br.ReadBits(22)
+		return nil
+	}
+	if b[0]>>6 != 0b01 {
+		return fmt.Errorf("%w: pack header %#x", errProgramStream, b[0])
+	}
+
+	if _, err := io.ReadFull(d.r, b[8:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	br.Seek(2)
+	base := readMarkedBits(br, 3, 15, 15)
+	extension := br.ReadBits(9)
+	br.Seek(1) // marker_bit
+	d.Scr = int64(base)*300 + int64(extension)
+	d.MuxRate = br.ReadBits(22)
+	br.Seek(2 + 5) // marker_bit, marker_bit and reserved
+	stuffingLength := br.ReadBits(3)
+	_, err := d.r.Discard(stuffingLength)
+	return unexpectedEOF(err)
+}
+
+// readMarkedBits reads the parts of a value each followed by a marker bit.
+func readMarkedBits(br *utils.BitReader, sizes ...int) uint64 {
+	var v uint64
+	for _, n := range sizes {
+		v = v<<n | uint64(br.ReadBits(n))
+		br.Seek(1) // marker_bit
+	}
+	return v
+}
+
+// parseSystemHeader parses a system header following header_length.
+func (d *ProgramStreamDemuxer) parseSystemHeader(b []byte) error {
+	br := utils.NewBitReader(b)
+	h := &SystemHeader{}
+	br.Seek(1) // marker_bit
+	h.RateBound = br.ReadBits(22)
+	br.Seek(1) // marker_bit
+	h.AudioBound = br.ReadBits(6)
+	h.FixedFlag = br.ReadBool()
+	h.CspsFlag = br.ReadBool()
+	h.SystemAudioLockFlag = br.ReadBool()
+	h.SystemVideoLockFlag = br.ReadBool()
+	br.Seek(1) // marker_bit
+	h.VideoBound = br.ReadBits(5)
+	h.PacketRateRestrictionFlag = br.ReadBool()
+	br.Seek(7) // reserved_bits
+	for br.Len() >= 24 && br.ReadBits(1) == 1 {
+		br.Seek(-1)
+		s := SystemHeaderStream{StreamID: byte(br.ReadBits(8))}
+		br.Seek(2) // '11'
+		s.BufferBoundScale = br.ReadBits(1)
+		s.BufferSizeBound = br.ReadBits(13)
+		h.Streams = append(h.Streams, s)
+	}
+	if br.Len() < 0 {
+		return fmt.Errorf("%w: system header of %d bytes", errProgramStream, len(b))
+	}
+	d.SystemHeader = h
+	return nil
+}
+
+// parseProgramStreamMap parses the stream types of a program stream map following PES_packet_length (ISO/IEC
+// 13818-1 2.5.4).
+func (d *ProgramStreamDemuxer) parseProgramStreamMap(b []byte) error {
+	if len(b) < 6 {
+		return fmt.Errorf("%w: program stream map of %d bytes", errProgramStream, len(b))
+	}
+	i := 4 + int(binary.BigEndian.Uint16(b[2:])) // program_stream_info_length
+	if i+2 > len(b) {
+		return fmt.Errorf("%w: program stream map of %d bytes", errProgramStream, len(b))
+	}
+	end := i + 2 + int(binary.BigEndian.Uint16(b[i:])) // elementary_stream_map_length
+	if end > len(b) {
+		return fmt.Errorf("%w: program stream map of %d bytes", errProgramStream, len(b))
+	}
+	types := map[byte]byte{}
+	for i += 2; i+4 <= end; i += 4 + int(binary.BigEndian.Uint16(b[i+2:])) {
+		types[b[i+1]] = b[i]
+	}
+	d.StreamTypes = types
+	return nil
+}
+
+// MpegAudioDecoder decodes the MPEG-1 audio frames of the packets of a stream, the frames may span packets.
+type MpegAudioDecoder struct {
+	audioDecoder
+	buf    []byte
+	header *audioFrameHeader // Of the last frame
+	lsf    bool              // Frames of MPEG-2 low sampling frequencies were skipped
+}
+
+// NewMpegAudioDecoder returns a decoder of an MPEG audio elementary stream.
+func NewMpegAudioDecoder() *MpegAudioDecoder {
+	return &MpegAudioDecoder{}
+}
+
+// Decode decodes the frames completed by data to interleaved samples. Bytes which are not part of a frame are
+// skipped.
+func (d *MpegAudioDecoder) Decode(data []byte) []float32 {
+	d.buf = append(d.buf, data...)
+	var samples []float32
+	offset := 0
+	for ; offset+4 <= len(d.buf); offset++ {
+		header := binary.BigEndian.Uint32(d.buf[offset:])
+		h, ok := parseAudioFrameHeader(header)
+		if !ok {
+			d.lsf = d.lsf || isLsfFrameHeader(header)
+			continue
+		}
+		if offset+h.frameLength > len(d.buf) {
+			break
+		}
+		d.header = h
+		samples = append(samples, d.decodeFrame(h, d.buf[offset:offset+h.frameLength])...)
+		offset += h.frameLength - 1
+	}
+	d.buf = append(d.buf[:0], d.buf[offset:]...)
+	return samples
+}
+
+// SampleRate returns the sampling rate of the last frame, 0 before the first one.
+func (d *MpegAudioDecoder) SampleRate() int {
+	if d.header == nil {
+		return 0
+	}
+	return d.header.sampleRate
+}
+
+// Channels returns the number of channels of the last frame, 0 before the first one.
+func (d *MpegAudioDecoder) Channels() int {
+	if d.header == nil {
+		return 0
+	}
+	return d.header.nch
+}
+
+// DecodeMpg decodes the first MPEG audio stream of an MPEG-1 system stream or an MPEG-2 program stream. MPEG-2 audio
+// of low sampling frequencies is not supported. Invalid packets are skipped.
+func DecodeMpg(file []byte) (*pcm.F32LE, error) {
+	out := &pcm.F32LE{}
+	d := NewProgramStreamDemuxer(bytes.NewReader(file))
+	decoder := NewMpegAudioDecoder()
+	streamID := -1
+	decoded := false
+	for {
+		p, err := d.ReadPacket()
+		if err == io.EOF {
+			break
+		} else if errors.Is(err, errPes) || errors.Is(err, errProgramStream) {
+			continue
+		} else if err != nil {
+			return out, err
+		}
+		if p.Codec != CodecMp3 || (streamID >= 0 && int(p.StreamID) != streamID) {
+			continue
+		}
+		streamID = int(p.StreamID)
+
+		samples := decoder.Decode(p.Data)
+		if out.Context().SampleRate == 0 && decoder.SampleRate() != 0 {
+			out.Context().SampleRate = decoder.SampleRate()
+			out.Context().Channels = decoder.Channels()
+		}
+		out.Append(samples)
+		decoded = decoded || len(samples) > 0
+	}
+	switch {
+	case streamID < 0:
+		return out, errNoAudio
+	case !decoded && decoder.lsf:
+		return out, fmt.Errorf("%w: stream %#x", errLsfAudio, streamID)
+	case !decoded:
+		return out, fmt.Errorf("%w: no MPEG audio frames in stream %#x", errNoAudio, streamID)
+	}
+	return out, nil
+}
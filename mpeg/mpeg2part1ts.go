@@ -0,0 +1,564 @@
+package mpeg
+
+import (
+	"awCodec/pcm"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Demultiplexing of MPEG-2 transport streams (ISO/IEC 13818-1 2.4), the streams of broadcasts and of .ts and .m2ts
+// files
+
+var (
+	errTransportStream = errors.New("mpeg: invalid transport stream")
+	errContinuity      = errors.New("mpeg: transport stream packets lost")
+	errSection         = errors.New("mpeg: invalid PSI section")
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+
+	pidPat  = 0x0000
+	pidNull = 0x1FFF
+
+	tableIDPat = 0x00
+	tableIDPmt = 0x02
+)
+
+// descriptor_tag of the descriptors of the program map table
+const (
+	DescriptorRegistration    = 0x05 // ISO/IEC 13818-1 registration_descriptor, format_identifier "AC-3" for AC-3
+	DescriptorLanguage        = 0x0A // ISO/IEC 13818-1 ISO_639_language_descriptor
+	DescriptorMetadataPointer = 0x25 // ISO/IEC 13818-1 metadata_pointer_descriptor
+	DescriptorMetadata        = 0x26 // ISO/IEC 13818-1 metadata_descriptor
+	DescriptorAc3             = 0x6A // ETSI EN 300 468 AC-3_descriptor of DVB
+)
+
+// Descriptor is a descriptor of a program or of an elementary stream (ISO/IEC 13818-1 2.6).
+type Descriptor struct {
+	Tag  byte
+	Data []byte
+}
+
+// Program is a program of a transport stream with the elementary streams of its program map table (ISO/IEC 13818-1
+// 2.4.4.8).
+type Program struct {
+	Number      int // program_number
+	PmtPID      int
+	PcrPID      int
+	Pcr         int64 // Last program clock reference in units of 27 MHz, -1 before the first one
+	Version     int   // version_number of the program map table, -1 before the first one
+	Descriptors []Descriptor
+	Streams     []*ElementaryStream
+}
+
+// ElementaryStream is an elementary stream of a program.
+type ElementaryStream struct {
+	PID         int
+	StreamType  byte
+	Codec       Codec  // 0 if unknown
+	Language    string // ISO 639-2 code of the language descriptor, empty if absent
+	Descriptors []Descriptor
+}
+
+// tsPid is the state of the packets of a PID.
+type tsPid struct {
+	cc           int      // continuity_counter of the last packet with a payload, -1 before the first one
+	pmt          *Program // Program of a program map table PID
+	stream       *ElementaryStream
+	section      []byte // Sections being reassembled, nil until the start of a section
+	pes          []byte // PES packet being reassembled, nil until the start of a packet
+	randomAccess bool
+}
+
+// TransportStreamDemuxer reads the PES packets of the elementary streams of the programs of a transport stream in
+// the order in which they are completed. The packets of MPEG audio streams can be decoded by NewMpegAudioDecoder.
+type TransportStreamDemuxer struct {
+	r   *bufio.Reader
+	eof bool
+
+	// PacketSize is the size of the packets, detected from the first ones: 188, 192 with the time code prefix of
+	// M2TS or 204 with the Reed-Solomon parity of DVB
+	PacketSize        int
+	TransportStreamID int
+	Programs          []*Program // Programs of the program association table, in its order
+
+	pids    map[int]*tsPid
+	pending []*PesPacket // Packets completed and not yet returned
+}
+
+// NewTransportStreamDemuxer returns a demuxer of the transport stream read from r.
+func NewTransportStreamDemuxer(r io.Reader) *TransportStreamDemuxer {
+	return &TransportStreamDemuxer{r: bufio.NewReader(r), pids: map[int]*tsPid{}}
+}
+
+// ReadPacket returns the next complete PES packet of an elementary stream of a program. The packets of PIDs which
+// are not in a program map table are discarded. It returns io.EOF after the last packet. Errors, like
+// errContinuity when packets of a PID are lost, drop the PES packet being reassembled and the next call goes on with
+// the stream.
+func (d *TransportStreamDemuxer) ReadPacket() (*PesPacket, error) {
+	for len(d.pending) == 0 {
+		if d.eof {
+			return nil, io.EOF
+		}
+		pkt, err := d.readTsPacket()
+		if err == io.EOF {
+			d.eof = true
+			d.flush()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if err := d.handlePacket(pkt); err != nil {
+			return nil, err
+		}
+	}
+	p := d.pending[0]
+	d.pending = d.pending[1:]
+	return p, nil
+}
+
+// Stream returns the elementary stream of a PID, nil if it is not in a program.
+func (d *TransportStreamDemuxer) Stream(pid int) *ElementaryStream {
+	if p := d.pids[pid]; p != nil {
+		return p.stream
+	}
+	return nil
+}
+
+// readTsPacket returns the 188 bytes of the next packet starting with the sync byte, after the detection of the
+// packet size and the resynchronisation on lost sync bytes.
+func (d *TransportStreamDemuxer) readTsPacket() ([]byte, error) {
+	if d.PacketSize == 0 {
+		// Four sync bytes spaced by the packet size, or the start of a stream of fewer packets
+		b, _ := d.r.Peek(4 * 204)
+		for start := 0; start < len(b) && d.PacketSize == 0; start++ {
+			for _, size := range [3]int{188, 192, 204} {
+				n := 0
+				for i := start; i < len(b) && b[i] == tsSyncByte; i += size {
+					n++
+				}
+				if n >= 4 || (n >= 1 && start+n*size >= len(b)) {
+					d.PacketSize = size
+					_, _ = d.r.Discard(start)
+					break
+				}
+			}
+		}
+		if d.PacketSize == 0 {
+			if len(b) < tsPacketSize {
+				return nil, io.EOF
+			}
+			// The next call goes on with the bytes following the ones without packets.
+			_, _ = d.r.Discard(len(b))
+			return nil, fmt.Errorf("%w: no sync byte", errTransportStream)
+		}
+	}
+
+	for {
+		b, err := d.r.Peek(tsPacketSize)
+		if err != nil {
+			if err == io.EOF {
+				_, _ = d.r.Discard(len(b))
+			}
+			return nil, err
+		}
+		if b[0] == tsSyncByte {
+			pkt := make([]byte, tsPacketSize)
+			copy(pkt, b)
+			_, err := d.r.Discard(d.PacketSize)
+			if err == io.EOF {
+				err = nil // The end of the stream in the suffix of the last packet
+			}
+			return pkt, err
+		}
+		// Skip to the next sync byte followed by another one at the packet size
+		_, _ = d.r.Discard(1)
+		for {
+			b, _ := d.r.Peek(d.PacketSize + 1)
+			if len(b) == 0 || b[0] == tsSyncByte && (len(b) <= d.PacketSize || b[d.PacketSize] == tsSyncByte) {
+				break
+			}
+			_, _ = d.r.Discard(1)
+		}
+	}
+}
+
+// handlePacket handles a transport stream packet (ISO/IEC 13818-1 2.4.3.2).
+func (d *TransportStreamDemuxer) handlePacket(pkt []byte) error {
+	if pkt[1]&0x80 != 0 { // transport_error_indicator
+		return nil
+	}
+	pid := int(binary.BigEndian.Uint16(pkt[1:]) & 0x1FFF)
+	if pid == pidNull {
+		return nil
+	}
+	start := pkt[1]&0x40 != 0    // payload_unit_start_indicator
+	control := pkt[3] >> 4 & 0x3 // adaptation_field_control
+	cc := int(pkt[3] & 0xF)
+
+	p := d.pids[pid]
+	if p == nil {
+		p = &tsPid{cc: -1}
+		d.pids[pid] = p
+	}
+
+	payload := pkt[4:]
+	discontinuity, randomAccess := false, false
+	if control&0b10 != 0 {
+		n := int(pkt[4]) // adaptation_field_length
+		if 5+n > tsPacketSize {
+			return fmt.Errorf("%w: adaptation field of %d bytes", errTransportStream, n)
+		}
+		if n > 0 {
+			af := pkt[5 : 5+n]
+			discontinuity = af[0]&0x80 != 0
+			randomAccess = af[0]&0x40 != 0
+			if af[0]&0x10 != 0 && n >= 7 { // PCR_flag
+				base := int64(binary.BigEndian.Uint32(af[1:]))<<1 | int64(af[5]>>7)
+				extension := int64(af[5]&0x1)<<8 | int64(af[6])
+				for _, program := range d.Programs {
+					if program.PcrPID == pid {
+						program.Pcr = base*300 + extension
+					}
+				}
+			}
+		}
+		payload = pkt[5+n:]
+	}
+	if control&0b01 == 0 {
+		return nil
+	}
+
+	var err error
+	if p.cc >= 0 && !discontinuity {
+		if cc == p.cc {
+			return nil // Duplicate packet
+		}
+		if cc != (p.cc+1)&0xF {
+			err = fmt.Errorf("%w: PID %d continuity_counter %d after %d", errContinuity, pid, cc, p.cc)
+			p.section, p.pes = nil, nil
+		}
+	}
+	p.cc = cc
+
+	switch {
+	case pid == pidPat || p.pmt != nil:
+		if e := d.handleSections(p, payload, start); err == nil {
+			err = e
+		}
+	case p.stream != nil:
+		if start {
+			if e := d.completePes(pid, p); err == nil {
+				err = e
+			}
+			p.pes, p.randomAccess = append([]byte{}, payload...), randomAccess
+		} else if p.pes != nil {
+			p.pes = append(p.pes, payload...)
+		}
+		if len(p.pes) >= 6 {
+			if length := int(binary.BigEndian.Uint16(p.pes[4:])); length > 0 && len(p.pes) >= 6+length {
+				p.pes = p.pes[:6+length]
+				if e := d.completePes(pid, p); err == nil {
+					err = e
+				}
+			}
+		}
+	}
+	return err
+}
+
+// completePes parses the PES packet reassembled on a PID, if any, and queues it.
+func (d *TransportStreamDemuxer) completePes(pid int, p *tsPid) error {
+	b := p.pes
+	p.pes = nil
+	if b == nil {
+		return nil
+	}
+	if len(b) < 6 || b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return fmt.Errorf("%w: PID %d no start code", errPes, pid)
+	}
+	body := b[6:]
+	if length := int(binary.BigEndian.Uint16(b[4:])); length > 0 && length < len(body) {
+		body = body[:length]
+	}
+	packet, err := parsePes(b[3], body)
+	if err != nil {
+		return fmt.Errorf("PID %d: %w", pid, err)
+	}
+	packet.PID, packet.RandomAccess = pid, p.randomAccess
+	packet.Codec = p.stream.Codec
+	d.pending = append(d.pending, packet)
+	return nil
+}
+
+// flush queues the PES packets of unbounded length at the end of the stream, in the order of their PIDs.
+func (d *TransportStreamDemuxer) flush() {
+	pids := make([]int, 0, len(d.pids))
+	for pid, p := range d.pids {
+		if p.stream != nil && p.pes != nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		_ = d.completePes(pid, d.pids[pid])
+	}
+}
+
+// handleSections reassembles the sections of the program association table or of a program map table carried by
+// the payload of a packet and parses the complete ones.
+func (d *TransportStreamDemuxer) handleSections(p *tsPid, payload []byte, start bool) error {
+	var err error
+	if start {
+		if len(payload) == 0 || 1+int(payload[0]) > len(payload) {
+			p.section = nil
+			return fmt.Errorf("%w: pointer_field", errSection)
+		}
+		pointer := int(payload[0])
+		if p.section != nil {
+			p.section = append(p.section, payload[1:1+pointer]...)
+			err = d.parseSections(p)
+		}
+		p.section = append([]byte{}, payload[1+pointer:]...)
+	} else if p.section != nil {
+		p.section = append(p.section, payload...)
+	} else {
+		return nil
+	}
+	if e := d.parseSections(p); err == nil {
+		err = e
+	}
+	return err
+}
+
+// parseSections parses the complete sections at the start of the reassembled ones of a PID.
+func (d *TransportStreamDemuxer) parseSections(p *tsPid) error {
+	var err error
+	for len(p.section) >= 3 && p.section[0] != 0xFF {
+		n := 3 + int(binary.BigEndian.Uint16(p.section[1:])&0xFFF)
+		if len(p.section) < n {
+			return err
+		}
+		if e := d.parseSection(p, p.section[:n]); err == nil {
+			err = e
+		}
+		p.section = p.section[n:]
+	}
+	if len(p.section) > 0 && p.section[0] == 0xFF { // stuffing_byte
+		p.section = nil
+	}
+	return err
+}
+
+// parseSection parses a section of the program association table or of a program map table (ISO/IEC 13818-1
+// 2.4.4.3 and 2.4.4.8).
+func (d *TransportStreamDemuxer) parseSection(p *tsPid, b []byte) error {
+	if len(b) < 12 || b[1]&0x80 == 0 { // section_syntax_indicator
+		return fmt.Errorf("%w: section of %d bytes", errSection, len(b))
+	}
+	if crc32Mpeg2(b) != 0 {
+		return fmt.Errorf("%w: CRC of table %d", errSection, b[0])
+	}
+	if b[5]&0x1 == 0 { // current_next_indicator, the table is not yet applicable
+		return nil
+	}
+	tableIDExtension := int(binary.BigEndian.Uint16(b[3:]))
+	version := int(b[5] >> 1 & 0x1F)
+	body := b[8 : len(b)-4]
+
+	switch {
+	case b[0] == tableIDPat && p.pmt == nil:
+		d.TransportStreamID = tableIDExtension
+		for i := 0; i+4 <= len(body); i += 4 {
+			number := int(binary.BigEndian.Uint16(body[i:]))
+			pid := int(binary.BigEndian.Uint16(body[i+2:]) & 0x1FFF)
+			if number == 0 { // network_PID
+				continue
+			}
+			program := d.program(number)
+			if program == nil {
+				program = &Program{Number: number, PcrPID: pidNull, Pcr: -1, Version: -1}
+				d.Programs = append(d.Programs, program)
+			}
+			if program.PmtPID != pid {
+				program.PmtPID, program.Version = pid, -1
+			}
+			if d.pids[pid] == nil {
+				d.pids[pid] = &tsPid{cc: -1}
+			}
+			d.pids[pid].pmt = program
+		}
+	case b[0] == tableIDPmt && p.pmt != nil && p.pmt.Number == tableIDExtension:
+		program := p.pmt
+		if program.Version == version {
+			return nil
+		}
+		if len(body) < 4 {
+			return fmt.Errorf("%w: program map table of %d bytes", errSection, len(b))
+		}
+		pcrPID := int(binary.BigEndian.Uint16(body) & 0x1FFF)
+		infoLength := int(binary.BigEndian.Uint16(body[2:]) & 0xFFF)
+		if 4+infoLength > len(body) {
+			return fmt.Errorf("%w: program_info_length %d", errSection, infoLength)
+		}
+		descriptors, err := parseDescriptors(body[4 : 4+infoLength])
+		if err != nil {
+			return err
+		}
+
+		var streams []*ElementaryStream
+		for i := 4 + infoLength; i+5 <= len(body); {
+			s := &ElementaryStream{StreamType: body[i], PID: int(binary.BigEndian.Uint16(body[i+1:]) & 0x1FFF)}
+			length := int(binary.BigEndian.Uint16(body[i+3:]) & 0xFFF) // ES_info_length
+			if i+5+length > len(body) {
+				return fmt.Errorf("%w: ES_info_length %d", errSection, length)
+			}
+			if s.Descriptors, err = parseDescriptors(body[i+5 : i+5+length]); err != nil {
+				return err
+			}
+			s.identify()
+			streams = append(streams, s)
+			i += 5 + length
+		}
+
+		for _, s := range program.Streams {
+			if q := d.pids[s.PID]; q != nil {
+				q.stream, q.pes = nil, nil
+			}
+		}
+		for _, s := range streams {
+			if d.pids[s.PID] == nil {
+				d.pids[s.PID] = &tsPid{cc: -1}
+			}
+			d.pids[s.PID].stream = s
+		}
+		program.PcrPID, program.Version, program.Descriptors, program.Streams = pcrPID, version, descriptors, streams
+	}
+	return nil
+}
+
+// program returns the program of a program_number, nil if it is not in the program association table.
+func (d *TransportStreamDemuxer) program(number int) *Program {
+	for _, program := range d.Programs {
+		if program.Number == number {
+			return program
+		}
+	}
+	return nil
+}
+
+// parseDescriptors parses a loop of descriptors.
+func parseDescriptors(b []byte) ([]Descriptor, error) {
+	var descriptors []Descriptor
+	for i := 0; i < len(b); {
+		if i+2 > len(b) || i+2+int(b[i+1]) > len(b) {
+			return descriptors, fmt.Errorf("%w: descriptor of %d bytes", errSection, len(b)-i)
+		}
+		descriptors = append(descriptors, Descriptor{Tag: b[i], Data: b[i+2 : i+2+int(b[i+1])]})
+		i += 2 + int(b[i+1])
+	}
+	return descriptors, nil
+}
+
+// identify sets the codec and the language of an elementary stream from its stream_type and its descriptors.
+func (s *ElementaryStream) identify() {
+	s.Codec = streamTypeCodec(s.StreamType)
+	for _, desc := range s.Descriptors {
+		switch desc.Tag {
+		case DescriptorLanguage:
+			if len(desc.Data) >= 3 {
+				s.Language = string(desc.Data[:3])
+			}
+		case DescriptorAc3:
+			if s.StreamType == StreamTypePrivate {
+				s.Codec = CodecAc3
+			}
+		case DescriptorRegistration:
+			if s.StreamType == StreamTypePrivate && bytes.HasPrefix(desc.Data, []byte("AC-3")) {
+				s.Codec = CodecAc3
+			}
+		}
+	}
+}
+
+// crc32Table is the table of the CRC of PSI sections, of the polynomial 0x04C11DB7 without reflection.
+var crc32Table = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// crc32Mpeg2 returns the CRC_32 of b (ISO/IEC 13818-1 Annex A), 0 for a section followed by its CRC.
+func crc32Mpeg2(b []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, v := range b {
+		crc = crc<<8 ^ crc32Table[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+// DecodeTs decodes the first MPEG audio or AAC stream of the programs of a transport stream. Lost and invalid
+// packets, PES packets and sections are skipped. MPEG-2 audio of low sampling frequencies is not supported.
+func DecodeTs(file []byte) (*pcm.F32LE, error) {
+	out := &pcm.F32LE{}
+	d := NewTransportStreamDemuxer(bytes.NewReader(file))
+	decoder := NewMpegAudioDecoder()
+	var adts []byte
+	pid := -1
+	decoded := false
+	for {
+		p, err := d.ReadPacket()
+		if err == io.EOF {
+			break
+		} else if errors.Is(err, errContinuity) || errors.Is(err, errPes) || errors.Is(err, errSection) ||
+			errors.Is(err, errTransportStream) {
+			continue
+		} else if err != nil {
+			return out, err
+		}
+		if (p.Codec != CodecMp3 && p.Codec != CodecAac) || (pid >= 0 && p.PID != pid) {
+			continue
+		}
+		pid = p.PID
+
+		if p.Codec == CodecAac {
+			adts = append(adts, p.Data...)
+			continue
+		}
+		samples := decoder.Decode(p.Data)
+		if out.Context().SampleRate == 0 && decoder.SampleRate() != 0 {
+			out.Context().SampleRate = decoder.SampleRate()
+			out.Context().Channels = decoder.Channels()
+		}
+		out.Append(samples)
+		decoded = decoded || len(samples) > 0
+	}
+
+	switch {
+	case pid < 0:
+		return out, errNoAudio
+	case adts != nil:
+		return DecodeAac(adts)
+	case !decoded && decoder.lsf:
+		return out, fmt.Errorf("%w: PID %d", errLsfAudio, pid)
+	case !decoded:
+		return out, fmt.Errorf("%w: no MPEG audio frames in PID %d", errNoAudio, pid)
+	}
+	return out, nil
+}
@@ -0,0 +1,249 @@
+package mpeg
+
+import (
+	"awCodec/aac"
+	"awCodec/id3"
+	"awCodec/pcm"
+	"awCodec/utils"
+	"errors"
+	"fmt"
+)
+
+var (
+	errAdtsHeader = errors.New("mpeg: invalid ADTS header")
+	errNoAdts     = errors.New("mpeg: no ADTS frame found")
+)
+
+// Length of the adts_fixed_header and adts_variable_header.
+const adtsHeaderLength = 7
+
+// AdtsHeader is the header of an ADTS frame (ISO/IEC 13818-7 6.2.1).
+type AdtsHeader struct {
+	// adts_fixed_header
+	ID                     int  // 1 bit, 0 for MPEG-4 and 1 for MPEG-2
+	Layer                  int  // 2 bits, always 0
+	ProtectionAbsent       bool // 1 bit
+	Profile                int  // 2 bits, the audio object type minus one
+	SamplingFrequencyIndex int  // 4 bits
+	PrivateBit             bool // 1 bit
+	ChannelConfiguration   int  // 3 bits
+	OriginalCopy           bool // 1 bit
+	Home                   bool // 1 bit
+
+	// adts_variable_header
+	CopyrightIdentificationBit   bool // 1 bit
+	CopyrightIdentificationStart bool // 1 bit
+	FrameLength                  int  // 13 bits, the length of the frame including the header
+	BufferFullness               int  // 11 bits, 0x7FF for variable bitrate
+	RawDataBlocks                int  // 2 bits number_of_raw_data_blocks_in_frame plus one
+
+	// adts_error_check or adts_header_error_check if ProtectionAbsent is false
+	RawDataBlockPositions []int  // 16 bits, the start of the blocks after the first one
+	Crc                   uint16 // 16 bits crc_check, it is not verified
+
+	Offset int64 // Position of the frame in the stream
+}
+
+// ParseAdtsHeader reads the header of the ADTS frame at the start of b.
+func ParseAdtsHeader(b []byte) (*AdtsHeader, error) {
+	if len(b) < adtsHeaderLength {
+		return nil, fmt.Errorf("%w: %d bytes", errAdtsHeader, len(b))
+	}
+	br := utils.NewBitReader(b)
+	h := &AdtsHeader{}
+
+	if br.ReadBits(12) != syncWord {
+		return nil, fmt.Errorf("%w: no syncword", errAdtsHeader)
+	}
+	h.ID = br.ReadBits(1)
+	h.Layer = br.ReadBits(2)
+	h.ProtectionAbsent = br.ReadBool()
+	h.Profile = br.ReadBits(2)
+	h.SamplingFrequencyIndex = br.ReadBits(4)
+	h.PrivateBit = br.ReadBool()
+	h.ChannelConfiguration = br.ReadBits(3)
+	h.OriginalCopy = br.ReadBool()
+	h.Home = br.ReadBool()
+
+	h.CopyrightIdentificationBit = br.ReadBool()
+	h.CopyrightIdentificationStart = br.ReadBool()
+	h.FrameLength = br.ReadBits(13)
+	h.BufferFullness = br.ReadBits(11)
+	h.RawDataBlocks = br.ReadBits(2) + 1
+
+	if !h.ProtectionAbsent {
+		if h.RawDataBlocks > 1 {
+			h.RawDataBlockPositions = make([]int, h.RawDataBlocks-1)
+			for i := range h.RawDataBlockPositions {
+				h.RawDataBlockPositions[i] = br.ReadBits(16)
+			}
+		}
+		h.Crc = uint16(br.ReadBits(16))
+	}
+
+	switch {
+	case br.Len() < 0:
+		return nil, fmt.Errorf("%w: %d bytes", errAdtsHeader, len(b))
+	case h.Layer != 0:
+		return nil, fmt.Errorf("%w: layer %d", errAdtsHeader, h.Layer)
+	case h.SamplingFrequencyIndex > 12:
+		return nil, fmt.Errorf("%w: sampling frequency index %d", errAdtsHeader, h.SamplingFrequencyIndex)
+	case h.FrameLength < h.Len():
+		return nil, fmt.Errorf("%w: frame length %d", errAdtsHeader, h.FrameLength)
+	}
+	return h, nil
+}
+
+// Len returns the length of the header with the error check.
+func (h *AdtsHeader) Len() int {
+	if h.ProtectionAbsent {
+		return adtsHeaderLength
+	}
+	return adtsHeaderLength + 2*len(h.RawDataBlockPositions) + 2
+}
+
+// NewAdtsHeader returns the header without error check of an ADTS frame holding one raw data block of payloadLength
+// bytes coded with a configuration.
+func NewAdtsHeader(config *aac.AudioSpecificConfig, payloadLength int) (*AdtsHeader, error) {
+	switch {
+	case config.ObjectType < 1 || config.ObjectType > 4:
+		return nil, fmt.Errorf("%w: audio object type %d", errAdtsHeader, config.ObjectType)
+	case config.SamplingFrequencyIndex > 12:
+		return nil, fmt.Errorf("%w: sampling frequency index %d", errAdtsHeader, config.SamplingFrequencyIndex)
+	case config.ChannelConfiguration > 7:
+		return nil, fmt.Errorf("%w: channel configuration %d", errAdtsHeader, config.ChannelConfiguration)
+	case adtsHeaderLength+payloadLength >= 1<<13:
+		return nil, fmt.Errorf("%w: frame length %d", errAdtsHeader, adtsHeaderLength+payloadLength)
+	}
+	return &AdtsHeader{
+		ProtectionAbsent:       true,
+		Profile:                config.ObjectType - 1,
+		SamplingFrequencyIndex: config.SamplingFrequencyIndex,
+		ChannelConfiguration:   config.ChannelConfiguration,
+		FrameLength:            adtsHeaderLength + payloadLength,
+		BufferFullness:         0x7FF,
+		RawDataBlocks:          1,
+	}, nil
+}
+
+// Append appends the adts_fixed_header and adts_variable_header to b, the error check is not written.
+func (h *AdtsHeader) Append(b []byte) []byte {
+	var v uint64 // 56 bits
+	put := func(n int, x int) { v = v<<n | uint64(x)&(1<<n-1) }
+	flag := func(f bool) {
+		if f {
+			put(1, 1)
+		} else {
+			put(1, 0)
+		}
+	}
+	put(12, syncWord)
+	put(1, h.ID)
+	put(2, h.Layer)
+	flag(h.ProtectionAbsent)
+	put(2, h.Profile)
+	put(4, h.SamplingFrequencyIndex)
+	flag(h.PrivateBit)
+	put(3, h.ChannelConfiguration)
+	flag(h.OriginalCopy)
+	flag(h.Home)
+	flag(h.CopyrightIdentificationBit)
+	flag(h.CopyrightIdentificationStart)
+	put(13, h.FrameLength)
+	put(11, h.BufferFullness)
+	put(2, h.RawDataBlocks-1)
+	return append(b, byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// AudioSpecificConfig returns the configuration of the decoder of the frame.
+func (h *AdtsHeader) AudioSpecificConfig() *aac.AudioSpecificConfig {
+	return &aac.AudioSpecificConfig{
+		ObjectType:             h.Profile + 1,
+		SamplingFrequencyIndex: h.SamplingFrequencyIndex,
+		SamplingFrequency:      aac.SamplingFrequency(h.SamplingFrequencyIndex),
+		ChannelConfiguration:   h.ChannelConfiguration,
+	}
+}
+
+// adtsFrames calls fn with the header and the payload of every frame of an ADTS stream. Bytes which are not part of a
+// frame, a leading ID3 tag or garbage between frames, are skipped.
+func adtsFrames(file []byte, fn func(h *AdtsHeader, payload []byte) error) error {
+	if len(file) >= 128 {
+		file, _ = id3.ReadID3(file)
+	}
+
+	found := false
+	for offset := 0; offset+adtsHeaderLength <= len(file); {
+		if file[offset] != 0xFF || file[offset+1]&0xF6 != 0xF0 {
+			offset++
+			continue
+		}
+		h, err := ParseAdtsHeader(file[offset:])
+		if err != nil || offset+h.FrameLength > len(file) {
+			offset++
+			continue
+		}
+		h.Offset = int64(offset)
+		found = true
+
+		if err := fn(h, file[offset+h.Len():offset+h.FrameLength]); err != nil {
+			return err
+		}
+		offset += h.FrameLength
+	}
+
+	if !found {
+		return errNoAdts
+	}
+	return nil
+}
+
+// ProbeAac returns the headers of the frames of an ADTS stream without decoding them.
+func ProbeAac(file []byte) ([]*AdtsHeader, error) {
+	var headers []*AdtsHeader
+	err := adtsFrames(file, func(h *AdtsHeader, payload []byte) error {
+		headers = append(headers, h)
+		return nil
+	})
+	return headers, err
+}
+
+// DecodeAac decodes an AAC stream in ADTS frames.
+func DecodeAac(file []byte) (*pcm.F32LE, error) {
+	var out = &pcm.F32LE{}
+	var decoder *aac.Decoder
+	var fixedHeader AdtsHeader
+
+	err := adtsFrames(file, func(h *AdtsHeader, payload []byte) error {
+		// A new decoder is needed when the fixed header changes.
+		if decoder == nil || h.Profile != fixedHeader.Profile ||
+			h.SamplingFrequencyIndex != fixedHeader.SamplingFrequencyIndex ||
+			h.ChannelConfiguration != fixedHeader.ChannelConfiguration {
+			var err error
+			if decoder, err = aac.NewDecoder(h.AudioSpecificConfig()); err != nil {
+				return fmt.Errorf("frame at %d: %w", h.Offset, err)
+			}
+			fixedHeader = *h
+		}
+
+		br := utils.NewBitReader(payload)
+		for i := 0; i < h.RawDataBlocks; i++ {
+			samples, err := decoder.DecodeRawDataBlock(br)
+			if err != nil {
+				return fmt.Errorf("frame at %d: %w", h.Offset, err)
+			}
+			br.ByteAlign()
+			if !h.ProtectionAbsent && h.RawDataBlocks > 1 {
+				br.Seek(16) // adts_raw_data_block_error_check
+			}
+
+			out.Append(samples)
+			if out.Context().SampleRate == 0 {
+				out.Context().SampleRate = decoder.SampleRate()
+				out.Context().Channels = decoder.Channels()
+			}
+		}
+		return nil
+	})
+	return out, err
+}
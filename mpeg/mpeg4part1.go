@@ -0,0 +1,273 @@
+package mpeg
+
+import (
+	"awCodec/aac"
+	"errors"
+)
+
+// ISO/IEC 14496-1
+
+var errDescriptor = errors.New("mpeg: invalid descriptor")
+
+// Class tags of descriptors (ISO/IEC 14496-1 Table 1)
+const (
+	esDescrTag            = 0x03
+	decoderConfigDescrTag = 0x04
+	decSpecificInfoTag    = 0x05
+	slConfigDescrTag      = 0x06
+)
+
+// objectTypeIndication of the DecoderConfigDescriptor (ISO/IEC 14496-1 Table 5)
+const (
+	ObjectTypeMpeg4Visual   = 0x20 // ISO/IEC 14496-2
+	ObjectTypeMpeg4Avc      = 0x21 // ISO/IEC 14496-10
+	ObjectTypeMpeg4Audio    = 0x40 // ISO/IEC 14496-3, the DecoderSpecificInfo is an AudioSpecificConfig
+	ObjectTypeMpeg2Video    = 0x61 // ISO/IEC 13818-2 Main profile
+	ObjectTypeMpeg2AacMain  = 0x66 // ISO/IEC 13818-7 Main profile
+	ObjectTypeMpeg2AacLc    = 0x67 // ISO/IEC 13818-7 LowComplexity profile
+	ObjectTypeMpeg2AacSsr   = 0x68 // ISO/IEC 13818-7 Scalable Sampling Rate profile
+	ObjectTypeMpeg2Audio    = 0x69 // ISO/IEC 13818-3
+	ObjectTypeMpeg1Video    = 0x6A // ISO/IEC 11172-2
+	ObjectTypeMpeg1Audio    = 0x6B // ISO/IEC 11172-3
+	ObjectTypeJpeg          = 0x6C // ISO/IEC 10918-1
+	ObjectTypeNoneSpecified = 0xFF
+)
+
+// streamType of the DecoderConfigDescriptor (ISO/IEC 14496-1 Table 6)
+const (
+	StreamTypeVisual = 0x04
+	StreamTypeAudio  = 0x05
+)
+
+// Length of the fields of the DecoderConfigDescriptor before the sub descriptors.
+const decoderConfigFixedLength = 13
+
+// ESDescriptorBox is the 'esds' box of an MPEG-4 sample entry (ISO/IEC 14496-14 5.6).
+type ESDescriptorBox struct {
+	FullBox
+	ES *ESDescriptor
+}
+
+// ESDescriptor describes an elementary stream (ISO/IEC 14496-1 7.2.6.5).
+type ESDescriptor struct {
+	ESID                 uint16 // bit(16)
+	StreamDependenceFlag bool   // bit(1)
+	URLFlag              bool   // bit(1)
+	OCRStreamFlag        bool   // bit(1)
+	StreamPriority       uint8  // bit(5)
+	DependsOnESID        uint16 // bit(16) if StreamDependenceFlag
+	URL                  string // URLlength bytes if URLFlag
+	OCRESID              uint16 // bit(16) if OCRStreamFlag
+
+	DecoderConfig *DecoderConfigDescriptor
+	SLConfig      *SLConfigDescriptor
+}
+
+// DecoderConfigDescriptor describes the decoder of an elementary stream (ISO/IEC 14496-1 7.2.6.6).
+type DecoderConfigDescriptor struct {
+	ObjectTypeIndication uint8  // bit(8)
+	StreamType           uint8  // bit(6)
+	UpStream             bool   // bit(1)
+	BufferSizeDB         uint32 // bit(24)
+	MaxBitrate           uint32 // bit(32)
+	AvgBitrate           uint32 // bit(32)
+	DecoderSpecificInfo  []byte
+
+	// AudioSpecificConfig is the decoded DecoderSpecificInfo of MPEG-4 and MPEG-2 AAC streams. It is nil for other
+	// streams or if the DecoderSpecificInfo is invalid.
+	AudioSpecificConfig *aac.AudioSpecificConfig
+}
+
+// SLConfigDescriptor configures the sync layer of an elementary stream (ISO/IEC 14496-1 7.3.2.3). Only the predefined
+// configurations are decoded, MP4 files use 2.
+type SLConfigDescriptor struct {
+	Predefined uint8 // bit(8)
+	Data       []byte
+}
+
+// IsAac reports whether the stream is MPEG-4 or MPEG-2 AAC.
+func (dcd *DecoderConfigDescriptor) IsAac() bool {
+	switch dcd.ObjectTypeIndication {
+	case ObjectTypeMpeg4Audio, ObjectTypeMpeg2AacMain, ObjectTypeMpeg2AacLc, ObjectTypeMpeg2AacSsr:
+		return true
+	}
+	return false
+}
+
+// IsMpegAudio reports whether the stream is MPEG-1 audio (layer I, II or III). The low sampling frequencies of
+// MPEG-2 audio, ObjectTypeMpeg2Audio, are not decoded by the package.
+func (dcd *DecoderConfigDescriptor) IsMpegAudio() bool {
+	return dcd.ObjectTypeIndication == ObjectTypeMpeg1Audio
+}
+
+func parseEsds(h Box, payload []byte) (*ESDescriptorBox, error) {
+	r := newBoxReader(payload)
+	esds := &ESDescriptorBox{FullBox: r.fullBox(h)}
+
+	err := eachDescriptor(r, func(tag uint8, r *boxReader) (err error) {
+		if tag == esDescrTag {
+			esds.ES, err = parseESDescriptor(r)
+		}
+		return err
+	})
+	if err != nil {
+		return esds, err
+	}
+	if esds.ES == nil {
+		return esds, errDescriptor
+	}
+	return esds, nil
+}
+
+// eachDescriptor calls fn with the tag and the payload of every descriptor in r. The size of a descriptor is coded
+// in up to four bytes of seven bits (ISO/IEC 14496-1 8.3.3).
+func eachDescriptor(r *boxReader, fn func(tag uint8, r *boxReader) error) error {
+	for r.Len() > 0 && r.err == nil {
+		tag := r.u8()
+		var size int
+		for i := 0; i < 4; i++ {
+			b := r.u8()
+			size = size<<7 | int(b&0x7F)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		if size > r.Len() {
+			return errDescriptor
+		}
+		if err := fn(tag, newBoxReader(r.next(size))); err != nil {
+			return err
+		}
+	}
+	return r.err
+}
+
+func parseESDescriptor(r *boxReader) (*ESDescriptor, error) {
+	es := &ESDescriptor{}
+	es.ESID = r.u16()
+	flags := r.u8()
+	es.StreamDependenceFlag = flags&0x80 != 0
+	es.URLFlag = flags&0x40 != 0
+	es.OCRStreamFlag = flags&0x20 != 0
+	es.StreamPriority = flags & 0x1F
+	if es.StreamDependenceFlag {
+		es.DependsOnESID = r.u16()
+	}
+	if es.URLFlag {
+		es.URL = string(r.next(int(r.u8())))
+	}
+	if es.OCRStreamFlag {
+		es.OCRESID = r.u16()
+	}
+
+	err := eachDescriptor(r, func(tag uint8, r *boxReader) (err error) {
+		switch tag {
+		case decoderConfigDescrTag:
+			es.DecoderConfig, err = parseDecoderConfigDescriptor(r)
+		case slConfigDescrTag:
+			es.SLConfig = &SLConfigDescriptor{Predefined: r.u8(), Data: r.next(r.Len())}
+		}
+		return err
+	})
+	return es, err
+}
+
+func parseDecoderConfigDescriptor(r *boxReader) (*DecoderConfigDescriptor, error) {
+	if r.Len() < decoderConfigFixedLength {
+		return nil, errDescriptor
+	}
+	dcd := &DecoderConfigDescriptor{}
+	dcd.ObjectTypeIndication = r.u8()
+	b := r.u8()
+	dcd.StreamType = b >> 2
+	dcd.UpStream = b&0x02 != 0
+	dcd.BufferSizeDB = r.u24()
+	dcd.MaxBitrate = r.u32()
+	dcd.AvgBitrate = r.u32()
+
+	err := eachDescriptor(r, func(tag uint8, r *boxReader) error {
+		if tag == decSpecificInfoTag && dcd.DecoderSpecificInfo == nil {
+			dcd.DecoderSpecificInfo = r.next(r.Len())
+		}
+		return nil
+	})
+
+	if dcd.IsAac() && dcd.DecoderSpecificInfo != nil {
+		if config, err := aac.ParseAudioSpecificConfig(dcd.DecoderSpecificInfo); err == nil {
+			dcd.AudioSpecificConfig = config
+		}
+	}
+	return dcd, err
+}
+
+func (esds *ESDescriptorBox) write(w *boxWriter) {
+	w.box(esdsType, func() {
+		w.fullBox(esds.FullBox)
+		if esds.ES != nil {
+			esds.ES.write(w)
+		}
+	})
+}
+
+// descriptor writes a descriptor with the payload written by fn. The size is always coded in four bytes.
+func (w *boxWriter) descriptor(tag uint8, fn func()) {
+	w.u8(tag)
+	start := len(w.b)
+	w.u32(0)
+	fn()
+	size := len(w.b) - start - 4
+	w.b[start] = 0x80 | byte(size>>21&0x7F)
+	w.b[start+1] = 0x80 | byte(size>>14&0x7F)
+	w.b[start+2] = 0x80 | byte(size>>7&0x7F)
+	w.b[start+3] = byte(size & 0x7F)
+}
+
+func (es *ESDescriptor) write(w *boxWriter) {
+	w.descriptor(esDescrTag, func() {
+		w.u16(es.ESID)
+		var flags uint8
+		if es.StreamDependenceFlag {
+			flags |= 0x80
+		}
+		if es.URLFlag {
+			flags |= 0x40
+		}
+		if es.OCRStreamFlag {
+			flags |= 0x20
+		}
+		w.u8(flags | es.StreamPriority&0x1F)
+		if es.StreamDependenceFlag {
+			w.u16(es.DependsOnESID)
+		}
+		if es.URLFlag {
+			w.u8(uint8(len(es.URL)))
+			w.bytes([]byte(es.URL))
+		}
+		if es.OCRStreamFlag {
+			w.u16(es.OCRESID)
+		}
+
+		if dcd := es.DecoderConfig; dcd != nil {
+			w.descriptor(decoderConfigDescrTag, func() {
+				w.u8(dcd.ObjectTypeIndication)
+				b := dcd.StreamType<<2 | 0x01 // reserved
+				if dcd.UpStream {
+					b |= 0x02
+				}
+				w.u8(b)
+				w.u24(dcd.BufferSizeDB)
+				w.u32(dcd.MaxBitrate)
+				w.u32(dcd.AvgBitrate)
+				if dcd.DecoderSpecificInfo != nil {
+					w.descriptor(decSpecificInfoTag, func() { w.bytes(dcd.DecoderSpecificInfo) })
+				}
+			})
+		}
+		if sl := es.SLConfig; sl != nil {
+			w.descriptor(slConfigDescrTag, func() {
+				w.u8(sl.Predefined)
+				w.bytes(sl.Data)
+			})
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package mpeg
+
+// ISO/IEC 14496-10 (ITU-T H.264)
+
+// nal_unit_type (ISO/IEC 14496-10 Table 7-1)
+const (
+	NalSlice          = 1
+	NalSliceA         = 2
+	NalSliceB         = 3
+	NalSliceC         = 4
+	NalIdr            = 5
+	NalSei            = 6
+	NalSps            = 7
+	NalPps            = 8
+	NalAud            = 9
+	NalEndOfSequence  = 10
+	NalEndOfStream    = 11
+	NalFiller         = 12
+	NalSpsExt         = 13
+	NalPrefix         = 14
+	NalSubsetSps      = 15
+	NalSliceAux       = 19
+	NalSliceExtension = 20
+)
+
+// accessUnitDelimiter is an access unit delimiter NAL unit with primary_pic_type 7, any slice type, and the
+// rbsp_trailing_bits.
+var accessUnitDelimiter = []byte{NalAud, 0xF0}
+
+// NalUnitType returns the nal_unit_type of the header of a NAL unit, 0 if it is empty.
+func NalUnitType(nal []byte) uint8 {
+	if len(nal) == 0 {
+		return 0
+	}
+	return nal[0] & 0x1F
+}
+
+// isVcl reports whether the NAL unit holds a slice of the primary coded picture.
+func isVcl(nal []byte) bool {
+	t := NalUnitType(nal)
+	return t >= NalSlice && t <= NalIdr
+}
+
+// HasIdr reports whether the NAL units of an access unit hold an IDR picture.
+func HasIdr(nals [][]byte) bool {
+	return hasNalUnit(nals, NalIdr)
+}
+
+func hasNalUnit(nals [][]byte, nalUnitType uint8) bool {
+	for _, nal := range nals {
+		if NalUnitType(nal) == nalUnitType {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitAnnexB returns the NAL units of an Annex B byte stream, which are prefixed by the start code 0x000001.
+// Leading and trailing zero bytes are not part of the NAL units.
+func SplitAnnexB(b []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			nals = appendNal(nals, b[start:i])
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 {
+		nals = appendNal(nals, b[start:])
+	}
+	return nals
+}
+
+// appendNal appends a NAL unit found between two start codes without its trailing_zero_8bits.
+func appendNal(nals [][]byte, nal []byte) [][]byte {
+	for len(nal) > 0 && nal[len(nal)-1] == 0 {
+		nal = nal[:len(nal)-1]
+	}
+	if len(nal) == 0 {
+		return nals
+	}
+	return append(nals, nal)
+}
+
+// AnnexB writes NAL units as an Annex B byte stream. Every NAL unit is prefixed by a zero byte and the start code,
+// as required for the first NAL unit of an access unit and for parameter sets.
+func AnnexB(nals [][]byte) []byte {
+	n := 0
+	for _, nal := range nals {
+		n += 4 + len(nal)
+	}
+	b := make([]byte, 0, n)
+	for _, nal := range nals {
+		b = append(b, 0, 0, 0, 1)
+		b = append(b, nal...)
+	}
+	return b
+}
+
+// SplitAccessUnits groups the NAL units of a byte stream into access units (ISO/IEC 14496-10 7.4.1.2.3). An access
+// unit starts with an access unit delimiter, with an SEI, a parameter set or a prefix NAL unit following the slices
+// of the previous picture, or with the first slice of a picture, first_mb_in_slice 0.
+func SplitAccessUnits(nals [][]byte) [][][]byte {
+	var units [][][]byte
+	var unit [][]byte
+	hasVcl := false
+	for _, nal := range nals {
+		newUnit := false
+		switch t := NalUnitType(nal); {
+		case t == NalAud:
+			newUnit = true
+		case t == NalSei || t == NalSps || t == NalPps || t >= NalPrefix && t <= 18:
+			newUnit = hasVcl
+		case t >= NalSlice && t <= NalIdr:
+			// first_mb_in_slice is ue(v), 0 is coded as a single one bit.
+			newUnit = hasVcl && len(nal) > 1 && nal[1]&0x80 != 0
+		}
+		if newUnit && len(unit) > 0 {
+			units = append(units, unit)
+			unit, hasVcl = nil, false
+		}
+		unit = append(unit, nal)
+		if isVcl(nal) {
+			hasVcl = true
+		}
+	}
+	if len(unit) > 0 {
+		units = append(units, unit)
+	}
+	return units
+}
@@ -0,0 +1,423 @@
+package mpeg
+
+import (
+	"awCodec/utils"
+	"fmt"
+)
+
+// CABAC parsing of the macroblock layer of I slices (ISO/IEC 14496-10 9.3)
+
+// Context indices (ctxIdxOffset) of the syntax elements of I slices (ISO/IEC 14496-10 Table 9-34)
+const (
+	ctxMbTypeI              = 3
+	ctxMbQpDelta            = 60
+	ctxIntraChromaPredMode  = 64
+	ctxPrevIntra4x4PredMode = 68
+	ctxRemIntra4x4PredMode  = 69
+	ctxCodedBlockPattern    = 73
+	ctxCodedBlockFlag       = 85
+	ctxSignificantCoeffFlag = 105
+	ctxLastSignificantCoeff = 166
+	ctxCoeffAbsLevelMinus1  = 227
+	ctxTransformSize8x8Flag = 399
+)
+
+// ctxIdxBlockCatOffset of significant_coeff_flag, last_significant_coeff_flag and coeff_abs_level_minus1 by
+// ctxBlockCat (ISO/IEC 14496-10 Table 9-40)
+var (
+	significantCoeffCatOffset = [5]int{0, 15, 29, 44, 47}
+	coeffAbsLevelCatOffset    = [5]int{0, 10, 20, 30, 39}
+)
+
+// cabacInitI holds the values {m, n} initialising the contexts of I slices (ISO/IEC 14496-10 Tables 9-12 to 9-33).
+var cabacInitI = [402][2]int8{
+	// mb_type of SI and I slices
+	0: {20, -15}, {2, 54}, {3, 74}, {20, -15}, {2, 54}, {3, 74}, {-28, 127}, {-23, 104}, {-6, 53}, {-1, 54}, {7, 51},
+
+	// mb_qp_delta, intra_chroma_pred_mode, prev_intra4x4_pred_mode_flag and rem_intra4x4_pred_mode
+	60: {0, 41}, {0, 63}, {0, 63}, {0, 63}, {-9, 83}, {4, 86}, {0, 97}, {-7, 72}, {13, 41}, {3, 62},
+
+	// mb_field_decoding_flag, coded_block_pattern and coded_block_flag
+	70: {0, 11}, {1, 55}, {0, 69}, {-17, 127}, {-13, 102}, {0, 82}, {-7, 74}, {-21, 107}, {-27, 127}, {-31, 127},
+	{-24, 127}, {-18, 95}, {-27, 127}, {-21, 114}, {-30, 127}, {-17, 123}, {-12, 115}, {-16, 122}, {-11, 115},
+	{-12, 63}, {-2, 68}, {-15, 84}, {-13, 104}, {-3, 70}, {-8, 93}, {-10, 90}, {-30, 127}, {-1, 74}, {-6, 97},
+	{-7, 91}, {-20, 127}, {-4, 56}, {-5, 82}, {-7, 76}, {-22, 125},
+
+	// significant_coeff_flag of frame macroblocks
+	105: {-7, 93}, {-11, 87}, {-3, 77}, {-5, 71}, {-4, 63}, {-4, 68}, {-12, 84}, {-7, 62}, {-7, 65}, {8, 61}, {5, 56},
+	{-2, 66}, {1, 64}, {0, 61}, {-2, 78}, {1, 50}, {7, 52}, {10, 35}, {0, 44}, {11, 38}, {1, 45}, {0, 46}, {5, 44},
+	{31, 17}, {1, 51}, {7, 50}, {28, 19}, {16, 33}, {14, 62}, {-13, 108}, {-15, 100}, {-13, 101}, {-13, 91},
+	{-12, 94}, {-10, 88}, {-16, 84}, {-10, 86}, {-7, 83}, {-13, 87}, {-19, 94}, {1, 70}, {0, 72}, {-5, 74}, {18, 59},
+	{-8, 102}, {-15, 100}, {0, 95}, {-4, 75}, {2, 72}, {-11, 75}, {-3, 71}, {15, 46}, {-13, 69}, {0, 62}, {0, 65},
+	{21, 37}, {-15, 72}, {9, 57}, {16, 54}, {0, 62}, {12, 72},
+
+	// last_significant_coeff_flag of frame macroblocks
+	166: {24, 0}, {15, 9}, {8, 25}, {13, 18}, {15, 9}, {13, 19}, {10, 37}, {12, 18}, {6, 29}, {20, 33}, {15, 30},
+	{4, 45}, {1, 58}, {0, 62}, {7, 61}, {12, 38}, {11, 45}, {15, 39}, {11, 42}, {13, 44}, {16, 45}, {12, 41},
+	{10, 49}, {30, 34}, {18, 42}, {10, 55}, {17, 51}, {17, 46}, {0, 89}, {26, -19}, {22, -17}, {26, -17}, {30, -25},
+	{28, -20}, {33, -23}, {37, -27}, {33, -23}, {40, -28}, {38, -17}, {33, -11}, {40, -15}, {41, -6}, {38, 1},
+	{41, 17}, {30, -6}, {27, 3}, {26, 22}, {37, -16}, {35, -4}, {38, -8}, {38, -3}, {37, 3}, {38, 5}, {42, 0},
+	{35, 16}, {39, 22}, {14, 48}, {27, 37}, {21, 60}, {12, 68}, {2, 97},
+
+	// coeff_abs_level_minus1
+	227: {-3, 71}, {-6, 42}, {-5, 50}, {-3, 54}, {-2, 62}, {0, 58}, {1, 63}, {-2, 72}, {-1, 74}, {-9, 91}, {-5, 67},
+	{-5, 27}, {-3, 39}, {-2, 44}, {0, 46}, {-16, 64}, {-8, 68}, {-10, 78}, {-6, 77}, {-10, 86}, {-12, 92}, {-15, 55},
+	{-10, 60}, {-6, 62}, {-4, 65}, {-12, 73}, {-8, 76}, {-7, 80}, {-9, 88}, {-17, 110}, {-11, 97}, {-20, 84},
+	{-11, 79}, {-6, 73}, {-4, 74}, {-13, 86}, {-13, 96}, {-11, 97}, {-19, 117}, {-8, 78}, {-5, 33}, {-4, 48},
+	{-2, 53}, {-3, 62}, {-13, 71}, {-10, 79}, {-12, 86}, {-13, 90}, {-14, 97},
+
+	// transform_size_8x8_flag
+	399: {31, 21}, {31, 31}, {25, 50},
+}
+
+// rangeTabLPS gives codIRangeLPS by pStateIdx and qCodIRangeIdx (ISO/IEC 14496-10 Table 9-44).
+var rangeTabLPS = [64][4]uint16{
+	{128, 176, 208, 240}, {128, 167, 197, 227}, {128, 158, 187, 216}, {123, 150, 178, 205}, {116, 142, 169, 195},
+	{111, 135, 160, 185}, {105, 128, 152, 175}, {100, 122, 144, 166}, {95, 116, 137, 158}, {90, 110, 130, 150},
+	{85, 104, 123, 142}, {81, 99, 117, 135}, {77, 94, 111, 128}, {73, 89, 105, 122}, {69, 85, 100, 116},
+	{66, 80, 95, 110}, {62, 76, 90, 104}, {59, 72, 86, 99}, {56, 69, 81, 94}, {53, 65, 77, 89}, {51, 62, 73, 85},
+	{48, 59, 69, 80}, {46, 56, 66, 76}, {43, 53, 63, 72}, {41, 50, 59, 69}, {39, 48, 56, 65}, {37, 45, 54, 62},
+	{35, 43, 51, 59}, {33, 41, 48, 56}, {32, 39, 46, 53}, {30, 37, 43, 50}, {29, 35, 41, 48}, {27, 33, 39, 45},
+	{26, 31, 37, 43}, {24, 30, 35, 41}, {23, 28, 33, 39}, {22, 27, 32, 37}, {21, 26, 30, 35}, {20, 24, 29, 33},
+	{19, 23, 27, 31}, {18, 22, 26, 30}, {17, 21, 25, 28}, {16, 20, 23, 27}, {15, 19, 22, 25}, {14, 18, 21, 24},
+	{14, 17, 20, 23}, {13, 16, 19, 22}, {12, 15, 18, 21}, {12, 14, 17, 20}, {11, 14, 16, 19}, {11, 13, 15, 18},
+	{10, 12, 15, 17}, {10, 12, 14, 16}, {9, 11, 13, 15}, {9, 11, 12, 14}, {8, 10, 12, 14}, {8, 9, 11, 13},
+	{7, 9, 11, 12}, {7, 9, 10, 12}, {7, 8, 10, 11}, {6, 8, 9, 11}, {6, 7, 9, 10}, {6, 7, 8, 9}, {2, 2, 2, 2},
+}
+
+// transIdxLPS gives the next pStateIdx after a least probable symbol (ISO/IEC 14496-10 Table 9-45).
+var transIdxLPS = [64]uint8{
+	0, 0, 1, 2, 2, 4, 4, 5, 6, 7, 8, 9, 9, 11, 11, 12, 13, 13, 15, 15, 16, 16, 18, 18, 19, 19, 21, 21, 22, 22, 23, 24,
+	24, 25, 26, 26, 27, 27, 28, 29, 29, 30, 30, 30, 31, 32, 32, 33, 33, 33, 34, 34, 35, 35, 35, 36, 36, 36, 37, 37, 37,
+	38, 38, 63,
+}
+
+// cabacContext is the probability model of a context variable.
+type cabacContext struct {
+	pStateIdx uint8
+	valMPS    uint8
+}
+
+// cabacDecoder is the arithmetic decoding engine of a slice with its context variables (ISO/IEC 14496-10 9.3.1).
+type cabacDecoder struct {
+	br         *utils.BitReader
+	ctx        [len(cabacInitI)]cabacContext
+	codIRange  int
+	codIOffset int
+}
+
+// newCabacDecoder initialises the context variables of an I slice and the decoding engine at the first bit of
+// its slice_data.
+func newCabacDecoder(br *utils.BitReader, sliceQp int) *cabacDecoder {
+	c := &cabacDecoder{br: br}
+	for i, mn := range cabacInitI {
+		preCtxState := (int(mn[0])*sliceQp)>>4 + int(mn[1])
+		switch {
+		case preCtxState < 1:
+			preCtxState = 1
+		case preCtxState > 126:
+			preCtxState = 126
+		}
+		if preCtxState <= 63 {
+			c.ctx[i] = cabacContext{pStateIdx: uint8(63 - preCtxState)}
+		} else {
+			c.ctx[i] = cabacContext{pStateIdx: uint8(preCtxState - 64), valMPS: 1}
+		}
+	}
+	c.initEngine()
+	return c
+}
+
+// initEngine initialises the decoding engine at the position of the reader (ISO/IEC 14496-10 9.3.1.2).
+func (c *cabacDecoder) initEngine() {
+	c.codIRange = 510
+	c.codIOffset = c.br.ReadBits(9)
+}
+
+// decodeDecision decodes a bin with a context variable (ISO/IEC 14496-10 9.3.3.2.1).
+func (c *cabacDecoder) decodeDecision(ctxIdx int) int {
+	ctx := &c.ctx[ctxIdx]
+	codIRangeLPS := int(rangeTabLPS[ctx.pStateIdx][c.codIRange>>6&3])
+	c.codIRange -= codIRangeLPS
+
+	binVal := int(ctx.valMPS)
+	if c.codIOffset >= c.codIRange {
+		binVal = 1 - binVal
+		c.codIOffset -= c.codIRange
+		c.codIRange = codIRangeLPS
+		if ctx.pStateIdx == 0 {
+			ctx.valMPS = 1 - ctx.valMPS
+		}
+		ctx.pStateIdx = transIdxLPS[ctx.pStateIdx]
+	} else if ctx.pStateIdx < 62 {
+		ctx.pStateIdx++
+	}
+
+	for c.codIRange < 256 {
+		c.codIRange <<= 1
+		c.codIOffset = c.codIOffset<<1 | c.br.ReadBits(1)
+	}
+	return binVal
+}
+
+// decodeBypass decodes a bin of equal probabilities (ISO/IEC 14496-10 9.3.3.2.3).
+func (c *cabacDecoder) decodeBypass() int {
+	c.codIOffset = c.codIOffset<<1 | c.br.ReadBits(1)
+	if c.codIOffset >= c.codIRange {
+		c.codIOffset -= c.codIRange
+		return 1
+	}
+	return 0
+}
+
+// decodeTerminate decodes end_of_slice_flag or the bin of mb_type marking I_PCM (ISO/IEC 14496-10 9.3.3.2.2).
+// After a bin of 1 the reader is right after the last bit of the arithmetic code.
+func (c *cabacDecoder) decodeTerminate() int {
+	c.codIRange -= 2
+	if c.codIOffset >= c.codIRange {
+		return 1
+	}
+	for c.codIRange < 256 {
+		c.codIRange <<= 1
+		c.codIOffset = c.codIOffset<<1 | c.br.ReadBits(1)
+	}
+	return 0
+}
+
+// mbTypeCabac decodes the mb_type of a macroblock of an I slice (ISO/IEC 14496-10 9.3.2.5 and 9.3.3.1.1.3).
+func (s *avcSlice) mbTypeCabac() int {
+	c := s.cabac
+	ctxIdxInc := 0
+	for _, mb := range [2]*avcMacroblock{s.neighbour(-1, 0), s.neighbour(0, -1)} {
+		if mb != nil && mb.mbType != mbTypeINxN {
+			ctxIdxInc++
+		}
+	}
+	if c.decodeDecision(ctxMbTypeI+ctxIdxInc) == 0 {
+		return mbTypeINxN
+	}
+	if c.decodeTerminate() == 1 {
+		return mbTypeIPcm
+	}
+
+	mbType := 1 + 12*c.decodeDecision(ctxMbTypeI+3) // CodedBlockPatternLuma
+	if c.decodeDecision(ctxMbTypeI+4) == 1 {        // CodedBlockPatternChroma
+		mbType += 4 + 4*c.decodeDecision(ctxMbTypeI+5)
+		mbType += 2 * c.decodeDecision(ctxMbTypeI+6) // Intra16x16PredMode
+		mbType += c.decodeDecision(ctxMbTypeI + 7)
+	} else {
+		mbType += 2 * c.decodeDecision(ctxMbTypeI+6)
+		mbType += c.decodeDecision(ctxMbTypeI + 7)
+	}
+	return mbType
+}
+
+// intra4x4PredModeCabac decodes prev_intra4x4_pred_mode_flag and rem_intra4x4_pred_mode, see
+// readIntra4x4PredMode.
+func (s *avcSlice) intra4x4PredModeCabac() int {
+	c := s.cabac
+	if c.decodeDecision(ctxPrevIntra4x4PredMode) == 1 {
+		return -1
+	}
+	rem := c.decodeDecision(ctxRemIntra4x4PredMode)
+	rem |= c.decodeDecision(ctxRemIntra4x4PredMode) << 1
+	return rem | c.decodeDecision(ctxRemIntra4x4PredMode)<<2
+}
+
+// intraChromaPredModeCabac decodes intra_chroma_pred_mode (ISO/IEC 14496-10 9.3.3.1.1.8).
+func (s *avcSlice) intraChromaPredModeCabac() int {
+	c := s.cabac
+	ctxIdxInc := 0
+	for _, mb := range [2]*avcMacroblock{s.neighbour(-1, 0), s.neighbour(0, -1)} {
+		if mb != nil && mb.mbType != mbTypeIPcm && mb.chromaPredMode != 0 {
+			ctxIdxInc++
+		}
+	}
+	mode := 0
+	for ctxIdx := ctxIntraChromaPredMode + ctxIdxInc; mode < 3 && c.decodeDecision(ctxIdx) == 1; mode++ {
+		ctxIdx = ctxIntraChromaPredMode + 3
+	}
+	return mode
+}
+
+// codedBlockPatternCabac decodes coded_block_pattern, the four bits of the 8x8 luma blocks followed by the
+// chroma pattern (ISO/IEC 14496-10 9.3.3.1.1.4).
+func (s *avcSlice) codedBlockPatternCabac() int {
+	c := s.cabac
+	cbp := 0
+	for b8 := 0; b8 < 4; b8++ {
+		x, y := b8%2*8, b8/2*8
+		ctxIdxInc := 0
+		for i, d := range [2][2]int{{-1, 0}, {0, -1}} {
+			mb, xW, yW := s.neighbourLocation(x+d[0], y+d[1], 16, 16)
+			if mb == nil {
+				continue
+			}
+			pattern := mb.cbp
+			if mb == s.mb {
+				pattern = cbp
+			}
+			if pattern>>(yW/8*2+xW/8)&1 == 0 {
+				ctxIdxInc += 1 << i
+			}
+		}
+		cbp |= c.decodeDecision(ctxCodedBlockPattern+ctxIdxInc) << b8
+	}
+
+	mbA, mbB := s.neighbour(-1, 0), s.neighbour(0, -1)
+	ctxIdxInc := 0
+	if mbA != nil && mbA.cbp>>4 != 0 {
+		ctxIdxInc++
+	}
+	if mbB != nil && mbB.cbp>>4 != 0 {
+		ctxIdxInc += 2
+	}
+	if c.decodeDecision(ctxCodedBlockPattern+4+ctxIdxInc) == 0 {
+		return cbp
+	}
+	ctxIdxInc = 0
+	if mbA != nil && mbA.cbp>>4 == 2 {
+		ctxIdxInc++
+	}
+	if mbB != nil && mbB.cbp>>4 == 2 {
+		ctxIdxInc += 2
+	}
+	return cbp | (1+c.decodeDecision(ctxCodedBlockPattern+8+ctxIdxInc))<<4
+}
+
+// mbQpDeltaCabac decodes mb_qp_delta (ISO/IEC 14496-10 9.3.2.7 and 9.3.3.1.1.5).
+func (s *avcSlice) mbQpDeltaCabac() int {
+	c := s.cabac
+	ctxIdx := ctxMbQpDelta
+	if s.qpDelta != 0 {
+		ctxIdx++
+	}
+	k := 0
+	for k < 53 && c.decodeDecision(ctxIdx) == 1 {
+		k++
+		ctxIdx = ctxMbQpDelta + 2
+		if k > 1 {
+			ctxIdx = ctxMbQpDelta + 3
+		}
+	}
+	if k%2 == 1 {
+		return (k + 1) / 2
+	}
+	return -(k / 2)
+}
+
+// codedBlockFlagCtxIdxInc returns the ctxIdxInc of the coded_block_flag of a block from the flags of its left and
+// upper neighbours (ISO/IEC 14496-10 9.3.3.1.1.9).
+func (s *avcSlice) codedBlockFlagCtxIdxInc(cat, comp, blk int) int {
+	ctxIdxInc := 0
+	for i, d := range [2][2]int{{-1, 0}, {0, -1}} {
+		var mb *avcMacroblock
+		bit := codedBlockBit(cat, comp, blk)
+		switch cat {
+		case blockLumaDc, blockChromaDc:
+			mb = s.neighbour(d[0], d[1])
+		case blockChromaAc:
+			var xW, yW int
+			mb, xW, yW = s.neighbourLocation(blk%2*4+d[0], blk/2*4+d[1], 8, 8)
+			bit = codedBlockBit(cat, comp, yW/4*2+xW/4)
+		default:
+			x, y := lumaBlockPosition(blk)
+			var xW, yW int
+			mb, xW, yW = s.neighbourLocation(x+d[0], y+d[1], 16, 16)
+			bit = lumaBlock(xW, yW)
+		}
+		// Unavailable neighbours of intra macroblocks count as coded, so do the blocks of I_PCM macroblocks
+		if mb == nil || mb.codedBlocks>>bit&1 == 1 {
+			ctxIdxInc += 1 << i
+		}
+	}
+	return ctxIdxInc
+}
+
+// residualBlockCabac decodes the coefficient levels of a block in scan order and reports whether the block is
+// coded (ISO/IEC 14496-10 7.3.5.3.3).
+func (s *avcSlice) residualBlockCabac(coeffLevel []int, cat, comp, blk int) (bool, error) {
+	c := s.cabac
+	if c.decodeDecision(ctxCodedBlockFlag+cat*4+s.codedBlockFlagCtxIdxInc(cat, comp, blk)) == 0 {
+		return false, nil
+	}
+
+	// Significance map
+	numCoeff := len(coeffLevel)
+	var significant [16]int
+	n := 0
+	i := 0
+	for ; i < numCoeff-1; i++ {
+		ctxIdxInc := i
+		if cat == blockChromaDc && i > 2 {
+			ctxIdxInc = 2
+		}
+		if c.decodeDecision(ctxSignificantCoeffFlag+significantCoeffCatOffset[cat]+ctxIdxInc) == 0 {
+			continue
+		}
+		significant[n] = i
+		n++
+		if c.decodeDecision(ctxLastSignificantCoeff+significantCoeffCatOffset[cat]+ctxIdxInc) == 1 {
+			break
+		}
+	}
+	if i == numCoeff-1 {
+		significant[n] = i
+		n++
+	}
+
+	// Levels in reverse scan order
+	ctxIdx := ctxCoeffAbsLevelMinus1 + coeffAbsLevelCatOffset[cat]
+	maxGt1 := 4
+	if cat == blockChromaDc {
+		maxGt1 = 3
+	}
+	numDecodAbsLevelEq1, numDecodAbsLevelGt1 := 0, 0
+	for j := n - 1; j >= 0; j-- {
+		ctxIdxInc := 0
+		if numDecodAbsLevelGt1 == 0 {
+			ctxIdxInc = 1 + numDecodAbsLevelEq1
+			if ctxIdxInc > 4 {
+				ctxIdxInc = 4
+			}
+		}
+		level := 0
+		if c.decodeDecision(ctxIdx+ctxIdxInc) == 1 {
+			ctxIdxInc = 5 + numDecodAbsLevelGt1
+			if numDecodAbsLevelGt1 > maxGt1 {
+				ctxIdxInc = 5 + maxGt1
+			}
+			level = 1
+			for level < 14 && c.decodeDecision(ctxIdx+ctxIdxInc) == 1 {
+				level++
+			}
+			if level == 14 { // Exp-Golomb suffix of order 0
+				k := 0
+				for c.decodeBypass() == 1 {
+					level += 1 << k
+					if k++; k > 20 {
+						return true, fmt.Errorf("%w: coeff_abs_level_minus1", errSlice)
+					}
+				}
+				for k > 0 {
+					k--
+					level += c.decodeBypass() << k
+				}
+			}
+		}
+		if level == 0 {
+			numDecodAbsLevelEq1++
+		} else {
+			numDecodAbsLevelGt1++
+		}
+		level++
+		if c.decodeBypass() == 1 { // coeff_sign_flag
+			level = -level
+		}
+		coeffLevel[significant[j]] = level
+	}
+	if s.br.Len() < 0 {
+		return true, fmt.Errorf("%w: residual past the end of the slice", errSlice)
+	}
+	return true, nil
+}
@@ -0,0 +1,300 @@
+package mpeg
+
+import (
+	"awCodec/utils"
+	"fmt"
+)
+
+// CAVLC parsing of residual blocks (ISO/IEC 14496-10 7.3.5.3.2 and 9.2)
+
+// Codes {code, length} of coeff_token by TotalCoeff*4+TrailingOnes for 0 <= nC < 2, 2 <= nC < 4, 4 <= nC < 8 and
+// 8 <= nC (ISO/IEC 14496-10 Table 9-5). A length of 0 marks a combination without code.
+var coeffTokenCodes = [4][68][2]int{
+	{
+		{0b1, 1}, {}, {}, {},
+		{0b000101, 6}, {0b01, 2}, {}, {},
+		{0b00000111, 8}, {0b000100, 6}, {0b001, 3}, {},
+		{0b000000111, 9}, {0b00000110, 8}, {0b0000101, 7}, {0b00011, 5},
+		{0b0000000111, 10}, {0b000000110, 9}, {0b00000101, 8}, {0b000011, 6},
+		{0b00000000111, 11}, {0b0000000110, 10}, {0b000000101, 9}, {0b0000100, 7},
+		{0b0000000001111, 13}, {0b00000000110, 11}, {0b0000000101, 10}, {0b00000100, 8},
+		{0b0000000001011, 13}, {0b0000000001110, 13}, {0b00000000101, 11}, {0b000000100, 9},
+		{0b0000000001000, 13}, {0b0000000001010, 13}, {0b0000000001101, 13}, {0b0000000100, 10},
+		{0b00000000001111, 14}, {0b00000000001110, 14}, {0b0000000001001, 13}, {0b00000000100, 11},
+		{0b00000000001011, 14}, {0b00000000001010, 14}, {0b00000000001101, 14}, {0b0000000001100, 13},
+		{0b000000000001111, 15}, {0b000000000001110, 15}, {0b00000000001001, 14}, {0b00000000001100, 14},
+		{0b000000000001011, 15}, {0b000000000001010, 15}, {0b000000000001101, 15}, {0b00000000001000, 14},
+		{0b0000000000001111, 16}, {0b000000000000001, 15}, {0b000000000001001, 15}, {0b000000000001100, 15},
+		{0b0000000000001011, 16}, {0b0000000000001110, 16}, {0b0000000000001101, 16}, {0b000000000001000, 15},
+		{0b0000000000000111, 16}, {0b0000000000001010, 16}, {0b0000000000001001, 16}, {0b0000000000001100, 16},
+		{0b0000000000000100, 16}, {0b0000000000000110, 16}, {0b0000000000000101, 16}, {0b0000000000001000, 16},
+	},
+	{
+		{0b11, 2}, {}, {}, {},
+		{0b001011, 6}, {0b10, 2}, {}, {},
+		{0b000111, 6}, {0b00111, 5}, {0b011, 3}, {},
+		{0b0000111, 7}, {0b001010, 6}, {0b001001, 6}, {0b0101, 4},
+		{0b00000111, 8}, {0b000110, 6}, {0b000101, 6}, {0b0100, 4},
+		{0b00000100, 8}, {0b0000110, 7}, {0b0000101, 7}, {0b00110, 5},
+		{0b000000111, 9}, {0b00000110, 8}, {0b00000101, 8}, {0b001000, 6},
+		{0b00000001111, 11}, {0b000000110, 9}, {0b000000101, 9}, {0b000100, 6},
+		{0b00000001011, 11}, {0b00000001110, 11}, {0b00000001101, 11}, {0b0000100, 7},
+		{0b000000001111, 12}, {0b00000001010, 11}, {0b00000001001, 11}, {0b000000100, 9},
+		{0b000000001011, 12}, {0b000000001110, 12}, {0b000000001101, 12}, {0b00000001100, 11},
+		{0b000000001000, 12}, {0b000000001010, 12}, {0b000000001001, 12}, {0b00000001000, 11},
+		{0b0000000001111, 13}, {0b0000000001110, 13}, {0b0000000001101, 13}, {0b000000001100, 12},
+		{0b0000000001011, 13}, {0b0000000001010, 13}, {0b0000000001001, 13}, {0b0000000001100, 13},
+		{0b0000000000111, 13}, {0b00000000001011, 14}, {0b0000000000110, 13}, {0b0000000001000, 13},
+		{0b00000000001001, 14}, {0b00000000001000, 14}, {0b00000000001010, 14}, {0b0000000000001, 13},
+		{0b00000000000111, 14}, {0b00000000000110, 14}, {0b00000000000101, 14}, {0b00000000000100, 14},
+	},
+	{
+		{0b1111, 4}, {}, {}, {},
+		{0b001111, 6}, {0b1110, 4}, {}, {},
+		{0b001011, 6}, {0b01111, 5}, {0b1101, 4}, {},
+		{0b001000, 6}, {0b01100, 5}, {0b01110, 5}, {0b1100, 4},
+		{0b0001111, 7}, {0b01010, 5}, {0b01011, 5}, {0b1011, 4},
+		{0b0001011, 7}, {0b01000, 5}, {0b01001, 5}, {0b1010, 4},
+		{0b0001001, 7}, {0b001110, 6}, {0b001101, 6}, {0b1001, 4},
+		{0b0001000, 7}, {0b001010, 6}, {0b001001, 6}, {0b1000, 4},
+		{0b00001111, 8}, {0b0001110, 7}, {0b0001101, 7}, {0b01101, 5},
+		{0b00001011, 8}, {0b00001110, 8}, {0b0001010, 7}, {0b001100, 6},
+		{0b000001111, 9}, {0b00001010, 8}, {0b00001101, 8}, {0b0001100, 7},
+		{0b000001011, 9}, {0b000001110, 9}, {0b00001001, 8}, {0b00001100, 8},
+		{0b000001000, 9}, {0b000001010, 9}, {0b000001101, 9}, {0b00001000, 8},
+		{0b0000001101, 10}, {0b000000111, 9}, {0b000001001, 9}, {0b000001100, 9},
+		{0b0000001001, 10}, {0b0000001100, 10}, {0b0000001011, 10}, {0b0000001010, 10},
+		{0b0000000101, 10}, {0b0000001000, 10}, {0b0000000111, 10}, {0b0000000110, 10},
+		{0b0000000001, 10}, {0b0000000100, 10}, {0b0000000011, 10}, {0b0000000010, 10},
+	},
+	{
+		{0b000011, 6}, {}, {}, {},
+		{0b000000, 6}, {0b000001, 6}, {}, {},
+		{0b000100, 6}, {0b000101, 6}, {0b000110, 6}, {},
+		{0b001000, 6}, {0b001001, 6}, {0b001010, 6}, {0b001011, 6},
+		{0b001100, 6}, {0b001101, 6}, {0b001110, 6}, {0b001111, 6},
+		{0b010000, 6}, {0b010001, 6}, {0b010010, 6}, {0b010011, 6},
+		{0b010100, 6}, {0b010101, 6}, {0b010110, 6}, {0b010111, 6},
+		{0b011000, 6}, {0b011001, 6}, {0b011010, 6}, {0b011011, 6},
+		{0b011100, 6}, {0b011101, 6}, {0b011110, 6}, {0b011111, 6},
+		{0b100000, 6}, {0b100001, 6}, {0b100010, 6}, {0b100011, 6},
+		{0b100100, 6}, {0b100101, 6}, {0b100110, 6}, {0b100111, 6},
+		{0b101000, 6}, {0b101001, 6}, {0b101010, 6}, {0b101011, 6},
+		{0b101100, 6}, {0b101101, 6}, {0b101110, 6}, {0b101111, 6},
+		{0b110000, 6}, {0b110001, 6}, {0b110010, 6}, {0b110011, 6},
+		{0b110100, 6}, {0b110101, 6}, {0b110110, 6}, {0b110111, 6},
+		{0b111000, 6}, {0b111001, 6}, {0b111010, 6}, {0b111011, 6},
+		{0b111100, 6}, {0b111101, 6}, {0b111110, 6}, {0b111111, 6},
+	},
+}
+
+// Codes of coeff_token of chroma DC blocks of 4:2:0, nC = -1.
+var chromaDcCoeffTokenCodes = [20][2]int{
+	{0b01, 2}, {}, {}, {},
+	{0b000111, 6}, {0b1, 1}, {}, {},
+	{0b000100, 6}, {0b000110, 6}, {0b001, 3}, {},
+	{0b000011, 6}, {0b0000011, 7}, {0b0000010, 7}, {0b000101, 6},
+	{0b000010, 6}, {0b00000011, 8}, {0b00000010, 8}, {0b0000000, 7},
+}
+
+// Codes of total_zeros of 4x4 blocks by TotalCoeff-1 (ISO/IEC 14496-10 Table 9-7 and 9-8).
+var totalZerosCodes = [15][][2]int{
+	{{0b1, 1}, {0b011, 3}, {0b010, 3}, {0b0011, 4}, {0b0010, 4}, {0b00011, 5}, {0b00010, 5}, {0b000011, 6}, {0b000010, 6}, {0b0000011, 7}, {0b0000010, 7}, {0b00000011, 8}, {0b00000010, 8}, {0b000000011, 9}, {0b000000010, 9}, {0b000000001, 9}},
+	{{0b111, 3}, {0b110, 3}, {0b101, 3}, {0b100, 3}, {0b011, 3}, {0b0101, 4}, {0b0100, 4}, {0b0011, 4}, {0b0010, 4}, {0b00011, 5}, {0b00010, 5}, {0b000011, 6}, {0b000010, 6}, {0b000001, 6}, {0b000000, 6}},
+	{{0b0101, 4}, {0b111, 3}, {0b110, 3}, {0b101, 3}, {0b0100, 4}, {0b0011, 4}, {0b100, 3}, {0b011, 3}, {0b0010, 4}, {0b00011, 5}, {0b00010, 5}, {0b000001, 6}, {0b00001, 5}, {0b000000, 6}},
+	{{0b00011, 5}, {0b111, 3}, {0b0101, 4}, {0b0100, 4}, {0b110, 3}, {0b101, 3}, {0b100, 3}, {0b0011, 4}, {0b011, 3}, {0b0010, 4}, {0b00010, 5}, {0b00001, 5}, {0b00000, 5}},
+	{{0b0101, 4}, {0b0100, 4}, {0b0011, 4}, {0b111, 3}, {0b110, 3}, {0b101, 3}, {0b100, 3}, {0b011, 3}, {0b0010, 4}, {0b00001, 5}, {0b0001, 4}, {0b00000, 5}},
+	{{0b000001, 6}, {0b00001, 5}, {0b111, 3}, {0b110, 3}, {0b101, 3}, {0b100, 3}, {0b011, 3}, {0b010, 3}, {0b0001, 4}, {0b001, 3}, {0b000000, 6}},
+	{{0b000001, 6}, {0b00001, 5}, {0b101, 3}, {0b100, 3}, {0b011, 3}, {0b11, 2}, {0b010, 3}, {0b0001, 4}, {0b001, 3}, {0b000000, 6}},
+	{{0b000001, 6}, {0b0001, 4}, {0b00001, 5}, {0b011, 3}, {0b11, 2}, {0b10, 2}, {0b010, 3}, {0b001, 3}, {0b000000, 6}},
+	{{0b000001, 6}, {0b000000, 6}, {0b0001, 4}, {0b11, 2}, {0b10, 2}, {0b001, 3}, {0b01, 2}, {0b00001, 5}},
+	{{0b00001, 5}, {0b00000, 5}, {0b001, 3}, {0b11, 2}, {0b10, 2}, {0b01, 2}, {0b0001, 4}},
+	{{0b0000, 4}, {0b0001, 4}, {0b001, 3}, {0b010, 3}, {0b1, 1}, {0b011, 3}},
+	{{0b0000, 4}, {0b0001, 4}, {0b01, 2}, {0b1, 1}, {0b001, 3}},
+	{{0b000, 3}, {0b001, 3}, {0b1, 1}, {0b01, 2}},
+	{{0b00, 2}, {0b01, 2}, {0b1, 1}},
+	{{0b0, 1}, {0b1, 1}},
+}
+
+// Codes of total_zeros of chroma DC blocks of 4:2:0 by TotalCoeff-1 (ISO/IEC 14496-10 Table 9-9).
+var chromaDcTotalZerosCodes = [3][][2]int{
+	{{0b1, 1}, {0b01, 2}, {0b001, 3}, {0b000, 3}},
+	{{0b1, 1}, {0b01, 2}, {0b00, 2}},
+	{{0b1, 1}, {0b0, 1}},
+}
+
+// Codes of run_before by zerosLeft-1, the last row for zerosLeft > 6 (ISO/IEC 14496-10 Table 9-10).
+var runBeforeCodes = [7][][2]int{
+	{{0b1, 1}, {0b0, 1}},
+	{{0b1, 1}, {0b01, 2}, {0b00, 2}},
+	{{0b11, 2}, {0b10, 2}, {0b01, 2}, {0b00, 2}},
+	{{0b11, 2}, {0b10, 2}, {0b01, 2}, {0b001, 3}, {0b000, 3}},
+	{{0b11, 2}, {0b10, 2}, {0b011, 3}, {0b010, 3}, {0b001, 3}, {0b000, 3}},
+	{{0b11, 2}, {0b000, 3}, {0b001, 3}, {0b011, 3}, {0b010, 3}, {0b101, 3}, {0b100, 3}},
+	{{0b111, 3}, {0b110, 3}, {0b101, 3}, {0b100, 3}, {0b011, 3}, {0b010, 3}, {0b001, 3}, {0b0001, 4}, {0b00001, 5}, {0b000001, 6}, {0b0000001, 7}, {0b00000001, 8}, {0b000000001, 9}, {0b0000000001, 10}, {0b00000000001, 11}},
+}
+
+// readVlc returns the index of the code of a table at the position of the reader, -1 if none matches.
+func readVlc(br *utils.BitReader, codes [][2]int) int {
+	bits := br.ReadBits(16)
+	for i, c := range codes {
+		if c[1] > 0 && bits>>(16-c[1]) == c[0] {
+			br.Seek(c[1] - 16)
+			return i
+		}
+	}
+	br.Seek(-16)
+	return -1
+}
+
+// coeffTokenNc returns nC, the number of coefficients predicted for a block from the TotalCoeff of its left and
+// upper neighbours (ISO/IEC 14496-10 9.2.1).
+func (s *avcSlice) coeffTokenNc(cat, comp, blk int) int {
+	if cat == blockChromaDc {
+		return -1
+	}
+	x, y, size := blk%2*4, blk/2*4, 8
+	if comp == 0 {
+		x, y = lumaBlockPosition(blk)
+		size = 16
+	}
+
+	n, count := 0, 0
+	for _, d := range [2][2]int{{-1, 0}, {0, -1}} {
+		mb, xW, yW := s.neighbourLocation(x+d[0], y+d[1], size, size)
+		if mb == nil {
+			continue
+		}
+		count++
+		switch {
+		case mb.mbType == mbTypeIPcm:
+			n += 16
+		case comp == 0:
+			n += int(mb.totalCoeff[0][lumaBlock(xW, yW)])
+		default:
+			n += int(mb.totalCoeff[comp][yW/4*2+xW/4])
+		}
+	}
+	if count == 2 {
+		return (n + 1) >> 1
+	}
+	return n
+}
+
+// residualBlockCavlc reads the coefficient levels of a block in scan order and returns TotalCoeff (ISO/IEC
+// 14496-10 7.3.5.3.2).
+func (s *avcSlice) residualBlockCavlc(coeffLevel []int, cat, comp, blk int) (int, error) {
+	br := s.br
+	maxNumCoeff := len(coeffLevel)
+
+	var token int
+	switch nC := s.coeffTokenNc(cat, comp, blk); {
+	case nC < 0:
+		token = readVlc(br, chromaDcCoeffTokenCodes[:])
+	case nC < 2:
+		token = readVlc(br, coeffTokenCodes[0][:])
+	case nC < 4:
+		token = readVlc(br, coeffTokenCodes[1][:])
+	case nC < 8:
+		token = readVlc(br, coeffTokenCodes[2][:])
+	default:
+		token = readVlc(br, coeffTokenCodes[3][:])
+	}
+	totalCoeff, trailingOnes := token/4, token%4
+	if token < 0 || totalCoeff > maxNumCoeff {
+		return 0, fmt.Errorf("%w: coeff_token", errSlice)
+	}
+	if totalCoeff == 0 {
+		return 0, nil
+	}
+
+	var levelVal [16]int
+	suffixLength := 0
+	if totalCoeff > 10 && trailingOnes < 3 {
+		suffixLength = 1
+	}
+	for i := 0; i < totalCoeff; i++ {
+		if i < trailingOnes {
+			levelVal[i] = 1 - 2*br.ReadBits(1) // trailing_ones_sign_flag
+			continue
+		}
+		levelPrefix := 0
+		for br.ReadBits(1) == 0 {
+			if levelPrefix++; levelPrefix > 25 {
+				return 0, fmt.Errorf("%w: level_prefix", errSlice)
+			}
+		}
+		levelCode := levelPrefix << suffixLength
+		if levelPrefix > 15 {
+			levelCode = 15 << suffixLength
+		}
+		if suffixLength > 0 || levelPrefix >= 14 {
+			levelSuffixSize := suffixLength
+			switch {
+			case levelPrefix == 14 && suffixLength == 0:
+				levelSuffixSize = 4
+			case levelPrefix >= 15:
+				levelSuffixSize = levelPrefix - 3
+			}
+			if levelSuffixSize > 0 {
+				levelCode += br.ReadBits(levelSuffixSize) // level_suffix
+			}
+		}
+		if levelPrefix >= 15 && suffixLength == 0 {
+			levelCode += 15
+		}
+		if levelPrefix >= 16 {
+			levelCode += 1<<(levelPrefix-3) - 4096
+		}
+		if i == trailingOnes && trailingOnes < 3 {
+			levelCode += 2
+		}
+		if levelCode%2 == 0 {
+			levelVal[i] = (levelCode + 2) >> 1
+		} else {
+			levelVal[i] = (-levelCode - 1) >> 1
+		}
+
+		if suffixLength == 0 {
+			suffixLength = 1
+		}
+		if (levelVal[i] > 3<<(suffixLength-1) || levelVal[i] < -3<<(suffixLength-1)) && suffixLength < 6 {
+			suffixLength++
+		}
+	}
+
+	totalZeros := 0
+	if totalCoeff < maxNumCoeff {
+		if cat == blockChromaDc {
+			totalZeros = readVlc(br, chromaDcTotalZerosCodes[totalCoeff-1])
+		} else {
+			totalZeros = readVlc(br, totalZerosCodes[totalCoeff-1])
+		}
+		if totalZeros < 0 || totalZeros > maxNumCoeff-totalCoeff {
+			return 0, fmt.Errorf("%w: total_zeros", errSlice)
+		}
+	}
+
+	var runVal [16]int
+	zerosLeft := totalZeros
+	for i := 0; i < totalCoeff-1 && zerosLeft > 0; i++ {
+		row := zerosLeft
+		if row > 7 {
+			row = 7
+		}
+		run := readVlc(br, runBeforeCodes[row-1])
+		if run < 0 || run > zerosLeft {
+			return 0, fmt.Errorf("%w: run_before", errSlice)
+		}
+		runVal[i] = run
+		zerosLeft -= run
+	}
+	runVal[totalCoeff-1] = zerosLeft
+
+	coeffNum := -1
+	for i := totalCoeff - 1; i >= 0; i-- {
+		coeffNum += runVal[i] + 1
+		coeffLevel[coeffNum] = levelVal[i]
+	}
+	return totalCoeff, nil
+}
@@ -0,0 +1,157 @@
+package mpeg
+
+// Deblocking filter of intra pictures (ISO/IEC 14496-10 8.7)
+
+// Thresholds alpha' and beta' by indexA and indexB (ISO/IEC 14496-10 Table 8-16)
+var (
+	deblockAlpha = [52]int{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 4, 4, 5, 6, 7, 8, 9, 10, 12, 13, 15, 17, 20, 22, 25, 28, 32, 36,
+		40, 45, 50, 56, 63, 71, 80, 90, 101, 113, 127, 144, 162, 182, 203, 226, 255, 255,
+	}
+	deblockBeta = [52]int{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10,
+		11, 11, 12, 12, 13, 13, 14, 14, 15, 15, 16, 16, 17, 17, 18, 18,
+	}
+)
+
+// deblockTc0 gives tC0' by indexA and bS from 1 to 3 (ISO/IEC 14496-10 Table 8-17).
+var deblockTc0 = [52][3]int{
+	{0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0},
+	{0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 1}, {0, 0, 1}, {0, 0, 1},
+	{0, 0, 1}, {0, 1, 1}, {0, 1, 1}, {1, 1, 1}, {1, 1, 1}, {1, 1, 1}, {1, 1, 1}, {1, 1, 2}, {1, 1, 2}, {1, 1, 2},
+	{1, 1, 2}, {1, 2, 3}, {1, 2, 3}, {2, 2, 3}, {2, 2, 4}, {2, 3, 4}, {2, 3, 4}, {3, 3, 5}, {3, 4, 6}, {3, 4, 6},
+	{4, 5, 7}, {4, 5, 8}, {4, 6, 9}, {5, 7, 10}, {6, 8, 11}, {6, 8, 13}, {7, 10, 14}, {8, 11, 16}, {9, 12, 18},
+	{10, 13, 20}, {11, 15, 23}, {13, 17, 25},
+}
+
+// deblock filters the edges of the 4x4 blocks of the decoded macroblocks in the order of their addresses. All the
+// macroblocks are intra coded, so the boundary strength is 4 on the edges of macroblocks and 3 inside them.
+func (pic *avcPicture) deblock() {
+	img := pic.img
+	for mbAddr := range pic.mbs {
+		q := &pic.mbs[mbAddr]
+		if q.slice == 0 {
+			continue
+		}
+		h := pic.slices[q.slice-1]
+		if h.DisableDeblockingFilterIdc == 1 {
+			continue
+		}
+		mbX, mbY := mbAddr%pic.widthInMbs, mbAddr/pic.widthInMbs
+		offsetA, offsetB := h.SliceAlphaC0OffsetDiv2*2, h.SliceBetaOffsetDiv2*2
+
+		// Vertical edges then horizontal edges, the first one shared with the left or upper macroblock
+		for dir := 0; dir < 2; dir++ {
+			p := q
+			lumaStep, lumaAlong, chromaStep, chromaAlong := 1, img.YStride, 1, img.CStride
+			if dir == 1 {
+				lumaStep, lumaAlong, chromaStep, chromaAlong = img.YStride, 1, img.CStride, 1
+			}
+			if dir == 0 && mbX > 0 {
+				p = &pic.mbs[mbAddr-1]
+			} else if dir == 1 && mbY > 0 {
+				p = &pic.mbs[mbAddr-pic.widthInMbs]
+			}
+			filterMbEdge := p != q && p.slice != 0 && (h.DisableDeblockingFilterIdc != 2 || p.slice == q.slice)
+
+			for edge := 0; edge < 4; edge++ {
+				bS := 3
+				if edge == 0 {
+					if !filterMbEdge {
+						continue
+					}
+					bS = 4
+				} else {
+					p = q
+				}
+
+				i := (mbY*16)*img.YStride + mbX*16 + edge*4*lumaStep
+				indexA, indexB := deblockIndices((p.qp+q.qp+1)>>1, offsetA, offsetB)
+				filterEdge(img.Y, i, lumaStep, lumaAlong, 16, bS, indexA, indexB, false)
+
+				if edge%2 == 1 { // Chroma edges 0 and 4 follow the luma edges 0 and 8
+					continue
+				}
+				i = (mbY*8)*img.CStride + mbX*8 + edge*2*chromaStep
+				for c, plane := range [2][]byte{img.Cb, img.Cr} {
+					offset := pic.pps.ChromaQpIndexOffset
+					if c == 1 {
+						offset = pic.pps.SecondChromaQpIndexOffset
+					}
+					qpav := (chromaQp(p.qp, offset) + chromaQp(q.qp, offset) + 1) >> 1
+					indexA, indexB := deblockIndices(qpav, offsetA, offsetB)
+					filterEdge(plane, i, chromaStep, chromaAlong, 8, bS, indexA, indexB, true)
+				}
+			}
+		}
+	}
+}
+
+// deblockIndices returns indexA and indexB of an average quantisation parameter.
+func deblockIndices(qpav, offsetA, offsetB int) (indexA, indexB int) {
+	return clip3(0, 51, qpav+offsetA), clip3(0, 51, qpav+offsetB)
+}
+
+// filterEdge filters n lines of samples across an edge, with q0 of the first line at pix[i], step the offset of
+// the samples across the edge and along the offset of the lines (ISO/IEC 14496-10 8.7.2).
+func filterEdge(pix []byte, i, step, along, n, bS, indexA, indexB int, chroma bool) {
+	alpha, beta := deblockAlpha[indexA], deblockBeta[indexB]
+	for k := 0; k < n; k, i = k+1, i+along {
+		p0, p1 := int(pix[i-step]), int(pix[i-2*step])
+		q0, q1 := int(pix[i]), int(pix[i+step])
+		if abs(p0-q0) >= alpha || abs(p1-p0) >= beta || abs(q1-q0) >= beta {
+			continue
+		}
+
+		if chroma {
+			if bS < 4 {
+				tc := deblockTc0[indexA][bS-1] + 1
+				delta := clip3(-tc, tc, ((q0-p0)<<2+(p1-q1)+4)>>3)
+				pix[i-step], pix[i] = clip1(p0+delta), clip1(q0-delta)
+			} else {
+				pix[i-step], pix[i] = byte((2*p1+p0+q1+2)>>2), byte((2*q1+q0+p1+2)>>2)
+			}
+			continue
+		}
+
+		p2, q2 := int(pix[i-3*step]), int(pix[i+2*step])
+		ap, aq := abs(p2-p0) < beta, abs(q2-q0) < beta
+		if bS < 4 {
+			tc0 := deblockTc0[indexA][bS-1]
+			tc := tc0
+			if ap {
+				tc++
+			}
+			if aq {
+				tc++
+			}
+			delta := clip3(-tc, tc, ((q0-p0)<<2+(p1-q1)+4)>>3)
+			pix[i-step], pix[i] = clip1(p0+delta), clip1(q0-delta)
+			if ap {
+				pix[i-2*step] = byte(p1 + clip3(-tc0, tc0, (p2+(p0+q0+1)>>1-p1<<1)>>1))
+			}
+			if aq {
+				pix[i+step] = byte(q1 + clip3(-tc0, tc0, (q2+(p0+q0+1)>>1-q1<<1)>>1))
+			}
+			continue
+		}
+
+		strong := abs(p0-q0) < alpha>>2+2
+		if ap && strong {
+			p3 := int(pix[i-4*step])
+			pix[i-step] = byte((p2 + 2*p1 + 2*p0 + 2*q0 + q1 + 4) >> 3)
+			pix[i-2*step] = byte((p2 + p1 + p0 + q0 + 2) >> 2)
+			pix[i-3*step] = byte((2*p3 + 3*p2 + p1 + p0 + q0 + 4) >> 3)
+		} else {
+			pix[i-step] = byte((2*p1 + p0 + q1 + 2) >> 2)
+		}
+		if aq && strong {
+			q3 := int(pix[i+3*step])
+			pix[i] = byte((p1 + 2*p0 + 2*q0 + 2*q1 + q2 + 4) >> 3)
+			pix[i+step] = byte((p0 + q0 + q1 + q2 + 2) >> 2)
+			pix[i+2*step] = byte((2*q3 + 3*q2 + q1 + q0 + p0 + 4) >> 3)
+		} else {
+			pix[i] = byte((2*q1 + q0 + p1 + 2) >> 2)
+		}
+	}
+}
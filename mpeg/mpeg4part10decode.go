@@ -0,0 +1,714 @@
+package mpeg
+
+import (
+	"awCodec/utils"
+	"errors"
+	"fmt"
+	"image"
+	"time"
+)
+
+// Decoding of H.264 pictures made of I slices (ISO/IEC 14496-10 7.3.3, 7.3.4 and 8.3), the keyframes of a video
+
+var (
+	errAvcUnsupported = errors.New("mpeg: unsupported H.264 coding")
+	errSlice          = errors.New("mpeg: invalid slice")
+)
+
+// mb_type of I slices (ISO/IEC 14496-10 Table 7-11), 1 to 24 are the Intra_16x16 types
+const (
+	mbTypeINxN = 0
+	mbTypeIPcm = 25
+)
+
+// Intra_4x4 and Intra_16x16 prediction modes (ISO/IEC 14496-10 Table 8-2 and 8-4)
+const (
+	predVertical   = 0
+	predHorizontal = 1
+	predDc         = 2
+	predPlane      = 3 // Intra_16x16 only
+)
+
+// zigzag4x4 maps the zigzag scan of frame macroblocks to the raster positions of a 4x4 block (ISO/IEC 14496-10
+// Table 8-13).
+var zigzag4x4 = [16]int{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+
+// codedBlockPatternIntra maps the codeNum of coded_block_pattern to the pattern of Intra_4x4 macroblocks for
+// ChromaArrayType 1 and 2 (ISO/IEC 14496-10 Table 9-4).
+var codedBlockPatternIntra = [48]int{
+	47, 31, 15, 0, 23, 27, 29, 30, 7, 11, 13, 14, 39, 43, 45, 46, 16, 3, 5, 10, 12, 19, 21, 26, 28, 35, 37, 42, 44, 1,
+	2, 4, 8, 17, 18, 20, 24, 6, 9, 22, 25, 32, 33, 34, 36, 40, 38, 41,
+}
+
+// AvcDecoder decodes H.264 pictures made of I slices, like the IDR pictures of the sync samples of a track, to
+// thumbnails. It supports the CAVLC and CABAC entropy coding of 8 bit 4:2:0 frames with the 4x4 transform, which
+// covers the intra pictures of the Baseline and Main profiles. Other pictures fail with errAvcUnsupported.
+type AvcDecoder struct {
+	sps [32]*SequenceParameterSet
+	pps [256][]byte // Parsed for each picture with the sequence parameter set it refers to
+}
+
+// avcSliceHeader holds the fields of the slice header the decoding of I slices depends on (ISO/IEC 14496-10
+// 7.3.3).
+type avcSliceHeader struct {
+	FirstMbInSlice             int // ue(v)
+	SliceType                  int // ue(v)
+	PicParameterSetID          int // ue(v)
+	FrameNum                   int // u(v)
+	FieldPicFlag               bool
+	RedundantPicCnt            int // ue(v)
+	SliceQpDelta               int // se(v)
+	DisableDeblockingFilterIdc int // ue(v)
+	SliceAlphaC0OffsetDiv2     int // se(v)
+	SliceBetaOffsetDiv2        int // se(v)
+}
+
+// avcMacroblock is the state of a decoded macroblock its neighbours depend on.
+type avcMacroblock struct {
+	slice          int // Number of the slice starting with 1, 0 if the macroblock is not decoded
+	mbType         int
+	qp             int // QPY, 0 for I_PCM
+	cbp            int // CodedBlockPatternLuma in bits 0 to 3 and CodedBlockPatternChroma in bits 4 and 5
+	chromaPredMode int
+	predModes      [16]int8     // Intra4x4PredMode of the luma blocks of I_NxN macroblocks
+	totalCoeff     [3][16]uint8 // TotalCoeff of the 4x4 blocks of luma, Cb and Cr, for CAVLC
+	codedBlocks    uint32       // coded_block_flag of the 4x4 blocks, see codedBlockBit, for CABAC
+}
+
+// Bits of avcMacroblock.codedBlocks: the 16 luma blocks, the 4 AC blocks of Cb and of Cr, and the DC blocks.
+const (
+	codedLumaDc = 24
+	codedCbDc   = 25
+)
+
+// Categories of residual blocks, ctxBlockCat of ISO/IEC 14496-10 Table 9-42
+const (
+	blockLumaDc   = 0 // Intra16x16DCLevel
+	blockLumaAc   = 1 // Intra16x16ACLevel
+	blockLuma4x4  = 2 // LumaLevel4x4
+	blockChromaDc = 3 // ChromaDCLevel
+	blockChromaAc = 4 // ChromaACLevel
+)
+
+// avcPicture is a picture being decoded.
+type avcPicture struct {
+	sps         *SequenceParameterSet
+	pps         *PictureParameterSet
+	img         *image.YCbCr
+	widthInMbs  int
+	heightInMbs int
+	mbs         []avcMacroblock
+	slices      []*avcSliceHeader
+	levelScale  [3][6][16]int // LevelScale4x4 of the intra lists of Y, Cb and Cr in raster order
+}
+
+// avcSlice is the state of the decoding of a slice.
+type avcSlice struct {
+	*avcPicture
+	header  *avcSliceHeader
+	id      int
+	br      *utils.BitReader
+	cabac   *cabacDecoder // nil for CAVLC
+	mbAddr  int
+	mbX     int
+	mbY     int
+	mb      *avcMacroblock
+	qp      int
+	qpDelta int // mb_qp_delta of the previous macroblock, for CABAC
+
+	// Coefficient levels of the macroblock in zigzag order
+	lumaDc   [16]int
+	luma     [16][16]int // By luma4x4BlkIdx, with the DC of Intra_16x16 at 0
+	chromaDc [2][4]int
+	chroma   [2][4][16]int // By chroma4x4BlkIdx, with the DC at 0
+}
+
+// NewAvcDecoder returns a decoder with the parameter sets of the decoder configuration, which may be nil for
+// streams that carry their parameter sets in band.
+func NewAvcDecoder(avcC *AVCConfigurationBox) (*AvcDecoder, error) {
+	d := &AvcDecoder{}
+	if avcC == nil {
+		return d, nil
+	}
+	for _, nals := range [][][]byte{avcC.SequenceParameterSets, avcC.PictureParameterSets} {
+		for _, nal := range nals {
+			if err := d.parameterSet(nal); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return d, nil
+}
+
+// parameterSet keeps a sequence or picture parameter set NAL unit.
+func (d *AvcDecoder) parameterSet(nal []byte) error {
+	switch NalUnitType(nal) {
+	case NalSps:
+		sps, err := ParseSps(nal)
+		if err != nil {
+			return err
+		}
+		d.sps[sps.SeqParameterSetID] = sps
+	case NalPps:
+		pps, err := ParsePps(nal, nil)
+		if err != nil {
+			return err
+		}
+		d.pps[pps.PicParameterSetID] = nal
+	}
+	return nil
+}
+
+// Decode decodes the picture of an access unit, given as its NAL units, and keeps the parameter sets it carries.
+// The samples of the returned picture are cropped to the frame cropping rectangle and are not converted from the
+// video range of most streams. On errors in the slice data the picture decoded so far is returned with the error.
+func (d *AvcDecoder) Decode(nals [][]byte) (*image.YCbCr, error) {
+	var pic *avcPicture
+	for _, nal := range nals {
+		switch t := NalUnitType(nal); t {
+		case NalSps, NalPps:
+			if err := d.parameterSet(nal); err != nil {
+				return nil, err
+			}
+		case NalSlice, NalIdr:
+			var err error
+			if pic, err = d.decodeSlice(pic, nal); err != nil {
+				if pic == nil {
+					return nil, err
+				}
+				return pic.picture(), err
+			}
+		case NalSliceA, NalSliceB, NalSliceC:
+			return nil, fmt.Errorf("%w: data partitioning", errAvcUnsupported)
+		}
+	}
+	if pic == nil {
+		return nil, fmt.Errorf("%w: no slice", errSlice)
+	}
+	pic.deblock()
+	return pic.picture(), nil
+}
+
+// picture returns the decoded picture cropped to the frame cropping rectangle.
+func (pic *avcPicture) picture() *image.YCbCr {
+	sps := pic.sps
+	cropUnitX, cropUnitY := sps.cropUnits()
+	left, top := cropUnitX*sps.FrameCropLeftOffset, cropUnitY*sps.FrameCropTopOffset
+	return pic.img.SubImage(image.Rect(left, top, left+sps.Width(), top+sps.Height())).(*image.YCbCr)
+}
+
+// decodeSlice decodes a slice NAL unit into the picture, which is created for the first slice.
+func (d *AvcDecoder) decodeSlice(pic *avcPicture, nal []byte) (*avcPicture, error) {
+	b := rbsp(nal, 1)
+	br := utils.NewBitReader(b)
+
+	h := &avcSliceHeader{}
+	h.FirstMbInSlice = br.ReadUe()
+	h.SliceType = br.ReadUe()
+	h.PicParameterSetID = br.ReadUe()
+	if h.PicParameterSetID > 255 || d.pps[h.PicParameterSetID] == nil {
+		return pic, fmt.Errorf("%w: no picture parameter set %d", errSlice, h.PicParameterSetID)
+	}
+	switch h.SliceType % 5 {
+	case 2:
+	case 4:
+		return pic, fmt.Errorf("%w: SI slice", errAvcUnsupported)
+	default:
+		return pic, fmt.Errorf("%w: inter slice", errAvcUnsupported)
+	}
+
+	if pic == nil {
+		var err error
+		if pic, err = d.newPicture(h.PicParameterSetID); err != nil {
+			return nil, err
+		}
+	}
+	sps, pps := pic.sps, pic.pps
+	if h.PicParameterSetID != pps.PicParameterSetID {
+		return pic, fmt.Errorf("%w: picture parameter set %d changes within the picture", errSlice, h.PicParameterSetID)
+	}
+
+	h.FrameNum = br.ReadBits(sps.Log2MaxFrameNumMinus4 + 4)
+	if !sps.FrameMbsOnlyFlag {
+		h.FieldPicFlag = br.ReadBool()
+		if h.FieldPicFlag {
+			return pic, fmt.Errorf("%w: field picture", errAvcUnsupported)
+		}
+		if sps.MbAdaptiveFrameFieldFlag {
+			return pic, fmt.Errorf("%w: MBAFF frame", errAvcUnsupported)
+		}
+	}
+	if NalUnitType(nal) == NalIdr {
+		br.ReadUe() // idr_pic_id
+	}
+	if sps.PicOrderCntType == 0 {
+		br.Seek(sps.Log2MaxPicOrderCntLsbMinus4 + 4) // pic_order_cnt_lsb
+		if pps.BottomFieldPicOrderInFramePresentFlag {
+			br.ReadSe() // delta_pic_order_cnt_bottom
+		}
+	}
+	if sps.PicOrderCntType == 1 && !sps.DeltaPicOrderAlwaysZeroFlag {
+		br.ReadSe() // delta_pic_order_cnt[0]
+		if pps.BottomFieldPicOrderInFramePresentFlag {
+			br.ReadSe() // delta_pic_order_cnt[1]
+		}
+	}
+	if pps.RedundantPicCntPresentFlag {
+		h.RedundantPicCnt = br.ReadUe()
+		if h.RedundantPicCnt > 0 {
+			return pic, nil // The primary coded picture is decoded instead
+		}
+	}
+	if nal[0]>>5&3 != 0 {
+		skipDecRefPicMarking(br, NalUnitType(nal) == NalIdr)
+	}
+	h.SliceQpDelta = br.ReadSe()
+	if pps.DeblockingFilterControlPresentFlag {
+		h.DisableDeblockingFilterIdc = br.ReadUe()
+		if h.DisableDeblockingFilterIdc != 1 {
+			h.SliceAlphaC0OffsetDiv2 = br.ReadSe()
+			h.SliceBetaOffsetDiv2 = br.ReadSe()
+		}
+	}
+
+	sliceQp := 26 + pps.PicInitQpMinus26 + h.SliceQpDelta
+	if sliceQp < 0 || sliceQp > 51 || h.DisableDeblockingFilterIdc > 2 || br.Len() < 0 {
+		return pic, fmt.Errorf("%w: slice header", errSlice)
+	}
+	if h.FirstMbInSlice >= len(pic.mbs) {
+		return pic, fmt.Errorf("%w: first_mb_in_slice %d", errSlice, h.FirstMbInSlice)
+	}
+
+	pic.slices = append(pic.slices, h)
+	s := &avcSlice{avcPicture: pic, header: h, id: len(pic.slices), br: br, qp: sliceQp}
+	if pps.EntropyCodingModeFlag {
+		br.ByteAlign() // cabac_alignment_one_bit
+		s.cabac = newCabacDecoder(br, sliceQp)
+	}
+	return pic, s.decodeData(b)
+}
+
+// newPicture allocates a picture for the parameter sets the picture parameter set refers to.
+func (d *AvcDecoder) newPicture(ppsID int) (*avcPicture, error) {
+	pps, err := ParsePps(d.pps[ppsID], nil)
+	if err != nil {
+		return nil, err
+	}
+	sps := d.sps[pps.SeqParameterSetID]
+	if sps == nil {
+		return nil, fmt.Errorf("%w: no sequence parameter set %d", errSlice, pps.SeqParameterSetID)
+	}
+	if pps, err = ParsePps(d.pps[ppsID], sps); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case sps.Log2MaxFrameNumMinus4 > 12 || sps.Log2MaxPicOrderCntLsbMinus4 > 12:
+		return nil, fmt.Errorf("%w: frame_num or pic_order_cnt_lsb of more than 16 bits", errSps)
+	case sps.ChromaArrayType() != 1:
+		return nil, fmt.Errorf("%w: chroma format %d", errAvcUnsupported, sps.ChromaFormatIdc)
+	case sps.BitDepthLumaMinus8 != 0 || sps.BitDepthChromaMinus8 != 0:
+		return nil, fmt.Errorf("%w: bit depth %d", errAvcUnsupported, sps.BitDepthLumaMinus8+8)
+	case pps.NumSliceGroupsMinus1 > 0:
+		return nil, fmt.Errorf("%w: slice groups", errAvcUnsupported)
+	case sps.PicWidthInMbsMinus1 >= 1024 || sps.frameHeightInMbs() > 1024:
+		return nil, fmt.Errorf("%w: picture size %dx%d", errAvcUnsupported, sps.Width(), sps.Height())
+	}
+
+	pic := &avcPicture{sps: sps, pps: pps, widthInMbs: sps.PicWidthInMbsMinus1 + 1, heightInMbs: sps.frameHeightInMbs()}
+	pic.img = image.NewYCbCr(image.Rect(0, 0, pic.widthInMbs*16, pic.heightInMbs*16), image.YCbCrSubsampleRatio420)
+	pic.mbs = make([]avcMacroblock, pic.widthInMbs*pic.heightInMbs)
+	for i := 0; i < 3; i++ {
+		pic.levelScale[i] = levelScale4x4(&pps.ScalingMatrix.List4x4[i])
+	}
+	return pic, nil
+}
+
+// skipDecRefPicMarking reads over the dec_ref_pic_marking (ISO/IEC 14496-10 7.3.3.3).
+func skipDecRefPicMarking(br *utils.BitReader, idr bool) {
+	if idr {
+		br.Seek(2) // no_output_of_prior_pics_flag and long_term_reference_flag
+		return
+	}
+	if !br.ReadBool() { // adaptive_ref_pic_marking_mode_flag
+		return
+	}
+	for i := 0; i < 66 && br.Len() > 0; i++ {
+		switch br.ReadUe() { // memory_management_control_operation
+		case 0:
+			return
+		case 1, 2, 4, 6:
+			br.ReadUe()
+		case 3:
+			br.ReadUe()
+			br.ReadUe()
+		}
+	}
+}
+
+// decodeData decodes the macroblocks of the slice_data of the payload b (ISO/IEC 14496-10 7.3.4).
+func (s *avcSlice) decodeData(b []byte) error {
+	for s.mbAddr = s.header.FirstMbInSlice; s.mbAddr < len(s.mbs); s.mbAddr++ {
+		if s.mbs[s.mbAddr].slice != 0 {
+			return fmt.Errorf("%w: macroblock %d decoded twice", errSlice, s.mbAddr)
+		}
+		if err := s.decodeMacroblock(); err != nil {
+			return fmt.Errorf("macroblock %d: %w", s.mbAddr, err)
+		}
+		if s.cabac != nil {
+			if s.cabac.decodeTerminate() == 1 { // end_of_slice_flag
+				return nil
+			}
+		} else if !moreRbspData(s.br, b) {
+			return nil
+		}
+		if s.br.Len() < 0 {
+			return fmt.Errorf("%w: %d bytes", errSlice, len(b))
+		}
+	}
+	return nil
+}
+
+// neighbour returns the macroblock at an offset from the current one if it is available for prediction, in the
+// picture and in the same slice (ISO/IEC 14496-10 6.4.8).
+func (s *avcSlice) neighbour(dx, dy int) *avcMacroblock {
+	x, y := s.mbX+dx, s.mbY+dy
+	if x < 0 || y < 0 || x >= s.widthInMbs || y >= s.heightInMbs {
+		return nil
+	}
+	if mb := &s.mbs[y*s.widthInMbs+x]; mb.slice == s.id {
+		return mb
+	}
+	return nil
+}
+
+// neighbourLocation returns the macroblock covering the location (x, y) relative to the upper-left sample of the
+// current macroblock of maxW by maxH samples, with the location inside it, or nil if it is not available
+// (ISO/IEC 14496-10 6.4.12.1).
+func (s *avcSlice) neighbourLocation(x, y, maxW, maxH int) (mb *avcMacroblock, xW, yW int) {
+	switch {
+	case x < 0 && y < 0:
+		mb = s.neighbour(-1, -1)
+	case x < 0 && y < maxH:
+		mb = s.neighbour(-1, 0)
+	case x < maxW && y < 0:
+		mb = s.neighbour(0, -1)
+	case x < maxW && y < maxH:
+		mb = s.mb
+	case y < 0:
+		mb = s.neighbour(1, -1)
+	}
+	return mb, (x + maxW) % maxW, (y + maxH) % maxH
+}
+
+// lumaBlock returns luma4x4BlkIdx of the block covering the location (x, y) in a macroblock (ISO/IEC 14496-10
+// 6.4.13.1).
+func lumaBlock(x, y int) int {
+	return 8*(y/8) + 4*(x/8) + 2*(y%8/4) + x%8/4
+}
+
+// lumaBlockPosition returns the location of the upper-left sample of a luma block in a macroblock (ISO/IEC
+// 14496-10 6.4.3).
+func lumaBlockPosition(blk int) (x, y int) {
+	return blk/4%2*8 + blk%2*4, blk/8*8 + blk/2%2*4
+}
+
+// isIntra16x16 reports whether the mb_type is one of the Intra_16x16 types.
+func isIntra16x16(mbType int) bool {
+	return mbType > mbTypeINxN && mbType < mbTypeIPcm
+}
+
+// decodeMacroblock decodes the macroblock_layer of the current macroblock and reconstructs its samples (ISO/IEC
+// 14496-10 7.3.5).
+func (s *avcSlice) decodeMacroblock() error {
+	s.mbX, s.mbY = s.mbAddr%s.widthInMbs, s.mbAddr/s.widthInMbs
+	s.mb = &s.mbs[s.mbAddr]
+	mb := s.mb
+	mb.slice = s.id
+
+	mbType := s.readMbType()
+	if mbType < 0 || mbType > mbTypeIPcm {
+		return fmt.Errorf("%w: mb_type %d", errSlice, mbType)
+	}
+	mb.mbType = mbType
+	if mbType == mbTypeIPcm {
+		return s.decodePcm()
+	}
+
+	if mbType == mbTypeINxN {
+		if s.pps.Transform8x8ModeFlag && s.readTransformSize8x8Flag() {
+			return fmt.Errorf("%w: 8x8 transform", errAvcUnsupported)
+		}
+		for blk := 0; blk < 16; blk++ {
+			mode := s.predIntra4x4PredMode(blk)
+			if rem := s.readIntra4x4PredMode(); rem >= 0 {
+				if rem >= mode {
+					rem++
+				}
+				mode = rem
+			}
+			mb.predModes[blk] = int8(mode)
+		}
+	}
+	mb.chromaPredMode = s.readIntraChromaPredMode()
+	if mb.chromaPredMode > 3 {
+		return fmt.Errorf("%w: intra_chroma_pred_mode %d", errSlice, mb.chromaPredMode)
+	}
+
+	if mbType == mbTypeINxN {
+		mb.cbp = s.readCodedBlockPattern()
+		if mb.cbp < 0 {
+			return fmt.Errorf("%w: coded_block_pattern", errSlice)
+		}
+	} else {
+		mb.cbp = (mbType - 1) / 4 % 3 << 4
+		if mbType >= 13 {
+			mb.cbp |= 15
+		}
+	}
+
+	delta := 0
+	if mb.cbp != 0 || mbType != mbTypeINxN {
+		delta = s.readMbQpDelta()
+		if delta < -26 || delta > 25 {
+			return fmt.Errorf("%w: mb_qp_delta %d", errSlice, delta)
+		}
+		s.qp = (s.qp + delta + 52) % 52
+	}
+	s.qpDelta = delta
+	mb.qp = s.qp
+
+	if err := s.decodeResidual(); err != nil {
+		return err
+	}
+	s.reconstruct()
+	return nil
+}
+
+// decodePcm reads the samples of an I_PCM macroblock.
+func (s *avcSlice) decodePcm() error {
+	mb := s.mb
+	mb.qp = 0
+	mb.cbp = 0x2F
+	mb.codedBlocks = 1<<27 - 1
+	for i := range mb.totalCoeff {
+		for j := range mb.totalCoeff[i] {
+			mb.totalCoeff[i][j] = 16
+		}
+	}
+	s.qpDelta = 0
+
+	s.br.ByteAlign() // pcm_alignment_zero_bit
+	img := s.img
+	for y := 0; y < 16; y++ {
+		row := img.Y[(s.mbY*16+y)*img.YStride+s.mbX*16:]
+		for x := 0; x < 16; x++ {
+			row[x] = byte(s.br.ReadBits(8))
+		}
+	}
+	for _, plane := range [][]byte{img.Cb, img.Cr} {
+		for y := 0; y < 8; y++ {
+			row := plane[(s.mbY*8+y)*img.CStride+s.mbX*8:]
+			for x := 0; x < 8; x++ {
+				row[x] = byte(s.br.ReadBits(8))
+			}
+		}
+	}
+	if s.br.Len() < 0 {
+		return fmt.Errorf("%w: I_PCM samples", errSlice)
+	}
+	if s.cabac != nil {
+		s.cabac.initEngine()
+	}
+	return nil
+}
+
+// predIntra4x4PredMode returns predIntra4x4PredMode of a luma block from its left and upper neighbours (ISO/IEC
+// 14496-10 8.3.1.1).
+func (s *avcSlice) predIntra4x4PredMode(blk int) int {
+	x, y := lumaBlockPosition(blk)
+	mbA, xA, yA := s.neighbourLocation(x-1, y, 16, 16)
+	mbB, xB, yB := s.neighbourLocation(x, y-1, 16, 16)
+	if mbA == nil || mbB == nil {
+		return predDc
+	}
+	modeA, modeB := predDc, predDc
+	if mbA.mbType == mbTypeINxN {
+		modeA = int(mbA.predModes[lumaBlock(xA, yA)])
+	}
+	if mbB.mbType == mbTypeINxN {
+		modeB = int(mbB.predModes[lumaBlock(xB, yB)])
+	}
+	if modeB < modeA {
+		return modeB
+	}
+	return modeA
+}
+
+// decodeResidual reads the coefficient levels of the residual of the macroblock (ISO/IEC 14496-10 7.3.5.3).
+func (s *avcSlice) decodeResidual() error {
+	mb := s.mb
+	s.lumaDc = [16]int{}
+	s.luma = [16][16]int{}
+	s.chromaDc = [2][4]int{}
+	s.chroma = [2][4][16]int{}
+
+	intra16x16 := isIntra16x16(mb.mbType)
+	if intra16x16 {
+		if err := s.residualBlock(s.lumaDc[:], blockLumaDc, 0, 0); err != nil {
+			return err
+		}
+	}
+	for blk := 0; blk < 16; blk++ {
+		if mb.cbp>>(blk/4)&1 == 0 {
+			continue
+		}
+		var err error
+		if intra16x16 {
+			err = s.residualBlock(s.luma[blk][1:], blockLumaAc, 0, blk)
+		} else {
+			err = s.residualBlock(s.luma[blk][:], blockLuma4x4, 0, blk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	cbpChroma := mb.cbp >> 4
+	if cbpChroma != 0 {
+		for c := 0; c < 2; c++ {
+			if err := s.residualBlock(s.chromaDc[c][:], blockChromaDc, c+1, 0); err != nil {
+				return err
+			}
+		}
+	}
+	if cbpChroma == 2 {
+		for c := 0; c < 2; c++ {
+			for blk := 0; blk < 4; blk++ {
+				if err := s.residualBlock(s.chroma[c][blk][1:], blockChromaAc, c+1, blk); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// residualBlock reads the coefficient levels of a block of a category, of the component 0 for luma, 1 for Cb or 2
+// for Cr, and records whether it is coded for the neighbouring blocks.
+func (s *avcSlice) residualBlock(coeffLevel []int, cat, comp, blk int) error {
+	if s.cabac != nil {
+		coded, err := s.residualBlockCabac(coeffLevel, cat, comp, blk)
+		if coded {
+			s.mb.codedBlocks |= 1 << codedBlockBit(cat, comp, blk)
+		}
+		return err
+	}
+	totalCoeff, err := s.residualBlockCavlc(coeffLevel, cat, comp, blk)
+	if cat != blockLumaDc && cat != blockChromaDc {
+		s.mb.totalCoeff[comp][blk] = uint8(totalCoeff)
+	}
+	return err
+}
+
+// codedBlockBit returns the bit of avcMacroblock.codedBlocks of a block.
+func codedBlockBit(cat, comp, blk int) int {
+	switch cat {
+	case blockLumaDc:
+		return codedLumaDc
+	case blockChromaDc:
+		return codedCbDc + comp - 1
+	case blockChromaAc:
+		return 16 + (comp-1)*4 + blk
+	}
+	return blk
+}
+
+// Readers of the syntax elements of the macroblock layer, with CABAC or Exp-Golomb codes
+
+func (s *avcSlice) readMbType() int {
+	if s.cabac != nil {
+		return s.mbTypeCabac()
+	}
+	return s.br.ReadUe()
+}
+
+func (s *avcSlice) readTransformSize8x8Flag() bool {
+	if s.cabac != nil {
+		return s.cabac.decodeDecision(ctxTransformSize8x8Flag) == 1
+	}
+	return s.br.ReadBool()
+}
+
+// readIntra4x4PredMode returns rem_intra4x4_pred_mode, or -1 if prev_intra4x4_pred_mode_flag is set.
+func (s *avcSlice) readIntra4x4PredMode() int {
+	if s.cabac != nil {
+		return s.intra4x4PredModeCabac()
+	}
+	if s.br.ReadBool() {
+		return -1
+	}
+	return s.br.ReadBits(3)
+}
+
+func (s *avcSlice) readIntraChromaPredMode() int {
+	if s.cabac != nil {
+		return s.intraChromaPredModeCabac()
+	}
+	return s.br.ReadUe()
+}
+
+// readCodedBlockPattern returns the coded_block_pattern of an I_NxN macroblock, -1 if it is invalid.
+func (s *avcSlice) readCodedBlockPattern() int {
+	if s.cabac != nil {
+		return s.codedBlockPatternCabac()
+	}
+	codeNum := s.br.ReadUe()
+	if codeNum >= len(codedBlockPatternIntra) {
+		return -1
+	}
+	return codedBlockPatternIntra[codeNum]
+}
+
+func (s *avcSlice) readMbQpDelta() int {
+	if s.cabac != nil {
+		return s.mbQpDeltaCabac()
+	}
+	return s.br.ReadSe()
+}
+
+// DecodeKeyframe decodes the picture of the last sync sample at or before the sample presented at t of an 'avc1'
+// or 'avc3' track, a thumbnail of the video at t.
+func (d *Demuxer) DecodeKeyframe(trackID uint32, t time.Duration) (*image.YCbCr, error) {
+	track := d.track(trackID)
+	if track == nil {
+		return nil, fmt.Errorf("mpeg: no track %d", trackID)
+	}
+	i := d.SampleAtTime(trackID, t)
+	if i < 0 {
+		return nil, fmt.Errorf("mpeg: track %d has no samples", trackID)
+	}
+	for i > 0 && !track.samples[i].Sync {
+		i--
+	}
+
+	entries := track.trak.SampleDescriptions()
+	index := int(track.samples[i].SampleDescriptionIndex)
+	if index == 0 || index > len(entries) || entries[index-1].AvcC == nil {
+		return nil, fmt.Errorf("track %d sample %d: %w", trackID, i, errNoAvcC)
+	}
+	avcC := entries[index-1].AvcC
+	decoder, err := NewAvcDecoder(avcC)
+	if err != nil {
+		return nil, err
+	}
+	p, err := d.readPacket(track, i)
+	if err != nil {
+		return nil, err
+	}
+	nals, err := avcC.NalUnits(p.Data)
+	if err != nil {
+		return nil, fmt.Errorf("track %d sample %d: %w", trackID, i, err)
+	}
+	return decoder.Decode(nals)
+}
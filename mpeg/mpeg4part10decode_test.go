@@ -0,0 +1,240 @@
+package mpeg
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// avcTestWriter writes the Exp-Golomb coded syntax elements of H.264 parameter sets and slices.
+type avcTestWriter struct {
+	b []byte
+	n int // Bits written
+}
+
+func (w *avcTestWriter) u(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte(v>>i&1) << (7 - w.n%8)
+		w.n++
+	}
+}
+
+func (w *avcTestWriter) ue(v int) {
+	n := 0
+	for (v+1)>>n > 1 {
+		n++
+	}
+	w.u(0, n)
+	w.u(v+1, n+1)
+}
+
+func (w *avcTestWriter) align() {
+	for w.n%8 != 0 {
+		w.u(0, 1)
+	}
+}
+
+// nal returns the NAL unit of the RBSP written so far, with its trailing bits and emulation prevention bytes.
+func (w *avcTestWriter) nal(header byte) []byte {
+	w.u(1, 1) // rbsp_stop_one_bit
+	w.align()
+	nal := []byte{header}
+	zeros := 0
+	for _, v := range w.b {
+		if zeros >= 2 && v <= 3 {
+			nal = append(nal, 3)
+			zeros = 0
+		}
+		nal = append(nal, v)
+		if v == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return nal
+}
+
+// testSps returns a Baseline profile sequence parameter set of a picture of macroblocks, cropped at the right and at
+// the bottom by pairs of samples.
+func testSps(widthInMbs, heightInMbs, cropRight, cropBottom, log2MaxFrameNumMinus4 int) []byte {
+	w := &avcTestWriter{}
+	w.u(66, 8) // profile_idc
+	w.u(0, 8)  // constraint_set_flags
+	w.u(30, 8) // level_idc
+	w.ue(0)    // seq_parameter_set_id
+	w.ue(log2MaxFrameNumMinus4)
+	w.ue(2)   // pic_order_cnt_type
+	w.ue(0)   // max_num_ref_frames
+	w.u(0, 1) // gaps_in_frame_num_value_allowed_flag
+	w.ue(widthInMbs - 1)
+	w.ue(heightInMbs - 1)
+	w.u(1, 1) // frame_mbs_only_flag
+	w.u(1, 1) // direct_8x8_inference_flag
+	if cropRight != 0 || cropBottom != 0 {
+		w.u(1, 1) // frame_cropping_flag
+		w.ue(0)
+		w.ue(cropRight)
+		w.ue(0)
+		w.ue(cropBottom)
+	} else {
+		w.u(0, 1)
+	}
+	w.u(0, 1) // vui_parameters_present_flag
+	return w.nal(0x67)
+}
+
+// testPps returns a CAVLC picture parameter set.
+func testPps() []byte {
+	w := &avcTestWriter{}
+	w.ue(0)   // pic_parameter_set_id
+	w.ue(0)   // seq_parameter_set_id
+	w.u(0, 1) // entropy_coding_mode_flag
+	w.u(0, 1) // bottom_field_pic_order_in_frame_present_flag
+	w.ue(0)   // num_slice_groups_minus1
+	w.ue(0)   // num_ref_idx_l0_default_active_minus1
+	w.ue(0)   // num_ref_idx_l1_default_active_minus1
+	w.u(0, 1) // weighted_pred_flag
+	w.u(0, 2) // weighted_bipred_idc
+	w.ue(0)   // pic_init_qp_minus26
+	w.ue(0)   // pic_init_qs_minus26
+	w.ue(0)   // chroma_qp_index_offset
+	w.u(0, 1) // deblocking_filter_control_present_flag
+	w.u(0, 1) // constrained_intra_pred_flag
+	w.u(0, 1) // redundant_pic_cnt_present_flag
+	return w.nal(0x68)
+}
+
+// testPcmSlice returns an IDR slice of the I_PCM macroblocks from firstMb up to endMb with the samples of the
+// picture.
+func testPcmSlice(img []byte, widthInMbs, heightInMbs, firstMb, endMb int) []byte {
+	width, height := widthInMbs*16, heightInMbs*16
+	w := &avcTestWriter{}
+	w.ue(firstMb)
+	w.ue(7)   // slice_type I
+	w.ue(0)   // pic_parameter_set_id
+	w.u(0, 4) // frame_num
+	w.ue(0)   // idr_pic_id
+	w.u(0, 2) // no_output_of_prior_pics_flag and long_term_reference_flag
+	w.ue(0)   // slice_qp_delta
+	for mb := firstMb; mb < endMb; mb++ {
+		w.ue(mbTypeIPcm)
+		w.align()
+		mbX, mbY := mb%widthInMbs, mb/widthInMbs
+		for y := 0; y < 16; y++ {
+			w.b = append(w.b, img[(mbY*16+y)*width+mbX*16:][:16]...)
+		}
+		for c := 0; c < 2; c++ {
+			plane := img[width*height+c*width*height/4:]
+			for y := 0; y < 8; y++ {
+				w.b = append(w.b, plane[(mbY*8+y)*width/2+mbX*8:][:8]...)
+			}
+		}
+		w.n = 8 * len(w.b)
+	}
+	return w.nal(0x65)
+}
+
+func TestAvcDecoderPcm(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, test := range []struct {
+		name                    string
+		widthInMbs, heightInMbs int
+		cropRight, cropBottom   int
+		slices                  []int // First macroblock of each slice
+	}{
+		{"one macroblock", 1, 1, 0, 0, []int{0}},
+		{"picture", 3, 2, 0, 0, []int{0}},
+		{"cropped", 2, 2, 3, 1, []int{0}},
+		{"slices", 2, 3, 0, 0, []int{0, 1, 4}},
+	} {
+		width, height := test.widthInMbs*16, test.heightInMbs*16
+		// The reference picture of 4:2:0 planes, with runs of zeros that need emulation prevention.
+		ref := make([]byte, width*height*3/2)
+		for i := range ref {
+			if rng.Intn(4) != 0 {
+				ref[i] = byte(rng.Intn(256))
+			}
+		}
+
+		nals := [][]byte{testSps(test.widthInMbs, test.heightInMbs, test.cropRight, test.cropBottom, 0), testPps()}
+		for i, first := range test.slices {
+			end := test.widthInMbs * test.heightInMbs
+			if i+1 < len(test.slices) {
+				end = test.slices[i+1]
+			}
+			nals = append(nals, testPcmSlice(ref, test.widthInMbs, test.heightInMbs, first, end))
+		}
+
+		d, err := NewAvcDecoder(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := d.Decode(nals)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		w, h := width-2*test.cropRight, height-2*test.cropBottom
+		if img.Rect.Dx() != w || img.Rect.Dy() != h {
+			t.Errorf("%s: picture of %v, want %dx%d", test.name, img.Rect, w, h)
+			continue
+		}
+		for y := 0; y < h; y++ {
+			if !bytes.Equal(img.Y[img.YOffset(0, y):][:w], ref[y*width:][:w]) {
+				t.Errorf("%s: luma row %d differs", test.name, y)
+			}
+		}
+		for c, plane := range [][]byte{img.Cb, img.Cr} {
+			refPlane := ref[width*height+c*width*height/4:]
+			for y := 0; y < h/2; y++ {
+				if !bytes.Equal(plane[img.COffset(0, 2*y):][:w/2], refPlane[y*width/2:][:w/2]) {
+					t.Errorf("%s: chroma %d row %d differs", test.name, c, y)
+				}
+			}
+		}
+	}
+}
+
+func TestAvcDecoderMalformed(t *testing.T) {
+	pcm := make([]byte, 16*16*3/2)
+	slice := testPcmSlice(pcm, 1, 1, 0, 1)
+	for _, test := range []struct {
+		name string
+		nals [][]byte
+		err  error
+	}{
+		{"frame_num of 26 bits", [][]byte{testSps(1, 1, 0, 0, 22), testPps(), slice}, errSps},
+		{"huge frame_num width", [][]byte{testSps(1, 1, 0, 0, 1<<20), testPps(), slice}, errSps},
+		{"no parameter sets", [][]byte{slice}, errSlice},
+		{"no sequence parameter set", [][]byte{testPps(), slice}, errSlice},
+		{"truncated slice", [][]byte{testSps(1, 1, 0, 0, 0), testPps(), slice[:len(slice)/2]}, errSlice},
+		{"too large", [][]byte{testSps(2000, 1, 0, 0, 0), testPps(), slice}, errAvcUnsupported},
+		{"no slice", [][]byte{testSps(1, 1, 0, 0, 0), testPps()}, errSlice},
+	} {
+		d, err := NewAvcDecoder(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Decode(test.nals); !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	// Random slice data must not panic.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		d, err := NewAvcDecoder(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, 1+rng.Intn(100))
+		rng.Read(data)
+		_, _ = d.Decode([][]byte{testSps(2, 2, 0, 0, 0), testPps(), append([]byte{0x65}, data...)})
+	}
+}
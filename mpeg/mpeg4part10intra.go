@@ -0,0 +1,466 @@
+package mpeg
+
+// Intra prediction, scaling and inverse transform of the macroblocks of I slices (ISO/IEC 14496-10 8.3 and 8.5)
+
+// normAdjust4x4 holds v of ISO/IEC 14496-10 8.5.9 by qP%6 for the positions with both coordinates even, both odd,
+// and the others.
+var normAdjust4x4 = [6][3]int{
+	{10, 16, 13}, {11, 18, 14}, {13, 20, 16}, {14, 23, 18}, {16, 25, 20}, {18, 29, 23},
+}
+
+// qpChroma maps qPI from 30 to 51 to QPC (ISO/IEC 14496-10 Table 8-15), QPC is qPI below 30.
+var qpChroma = [22]int{29, 30, 31, 32, 32, 33, 34, 34, 35, 35, 36, 36, 37, 37, 37, 38, 38, 38, 39, 39, 39, 39}
+
+// levelScale4x4 returns LevelScale4x4 of a scaling list in zigzag order, in raster order of the block (ISO/IEC
+// 14496-10 8.5.9).
+func levelScale4x4(list *[16]int) [6][16]int {
+	var ls [6][16]int
+	for k, pos := range zigzag4x4 {
+		i, j := pos/4, pos%4
+		v := 2
+		switch {
+		case i%2 == 0 && j%2 == 0:
+			v = 0
+		case i%2 == 1 && j%2 == 1:
+			v = 1
+		}
+		for m := range ls {
+			ls[m][pos] = list[k] * normAdjust4x4[m][v]
+		}
+	}
+	return ls
+}
+
+// chromaQp returns QPC of a luma QPY and the chroma_qp_index_offset of the component.
+func chromaQp(qp, offset int) int {
+	qpi := qp + offset
+	switch {
+	case qpi < 0:
+		return 0
+	case qpi > 51:
+		qpi = 51
+	}
+	if qpi < 30 {
+		return qpi
+	}
+	return qpChroma[qpi-30]
+}
+
+func clip1(v int) byte {
+	switch {
+	case v < 0:
+		return 0
+	case v > 255:
+		return 255
+	}
+	return byte(v)
+}
+
+func clip3(lo, hi, v int) int {
+	switch {
+	case v < lo:
+		return lo
+	case v > hi:
+		return hi
+	}
+	return v
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// reconstruct predicts the samples of the current macroblock and adds the residual.
+func (s *avcSlice) reconstruct() {
+	mb := s.mb
+	img := s.img
+	px, py := s.mbX*16, s.mbY*16
+
+	if isIntra16x16(mb.mbType) {
+		s.predIntra16x16((mb.mbType - 1) % 4)
+		dc := lumaDc(&s.lumaDc, mb.qp, s.levelScale[0][mb.qp%6][0])
+		for blk := 0; blk < 16; blk++ {
+			x, y := lumaBlockPosition(blk)
+			addResidual4x4(img.Y[(py+y)*img.YStride+px+x:], img.YStride, &s.luma[blk], dc[y+x/4], true, mb.qp,
+				&s.levelScale[0])
+		}
+	} else {
+		for blk := 0; blk < 16; blk++ {
+			x, y := lumaBlockPosition(blk)
+			s.predIntra4x4(blk, int(mb.predModes[blk]))
+			addResidual4x4(img.Y[(py+y)*img.YStride+px+x:], img.YStride, &s.luma[blk], 0, false, mb.qp,
+				&s.levelScale[0])
+		}
+	}
+
+	offsets := [2]int{s.pps.ChromaQpIndexOffset, s.pps.SecondChromaQpIndexOffset}
+	for c, plane := range [2][]byte{img.Cb, img.Cr} {
+		qpc := chromaQp(mb.qp, offsets[c])
+		s.predIntraChroma(plane, mb.chromaPredMode)
+		dc := chromaDc(&s.chromaDc[c], qpc, s.levelScale[c+1][qpc%6][0])
+		for blk := 0; blk < 4; blk++ {
+			x, y := blk%2*4, blk/2*4
+			addResidual4x4(plane[(s.mbY*8+y)*img.CStride+s.mbX*8+x:], img.CStride, &s.chroma[c][blk], dc[blk], true,
+				qpc, &s.levelScale[c+1])
+		}
+	}
+}
+
+// lumaDc returns the scaled DC coefficients of the Intra_16x16 luma blocks in raster order of the blocks from
+// their levels in zigzag order (ISO/IEC 14496-10 8.5.10).
+func lumaDc(levels *[16]int, qp, levelScale int) [16]int {
+	var c [16]int
+	nonzero := false
+	for k, pos := range zigzag4x4 {
+		c[pos] = levels[k]
+		nonzero = nonzero || levels[k] != 0
+	}
+	if !nonzero {
+		return c
+	}
+
+	// f = A c A with the 4x4 Hadamard matrix A
+	for i := 0; i < 16; i += 4 {
+		c0, c1, c2, c3 := c[i], c[i+1], c[i+2], c[i+3]
+		c[i], c[i+1], c[i+2], c[i+3] = c0+c1+c2+c3, c0+c1-c2-c3, c0-c1-c2+c3, c0-c1+c2-c3
+	}
+	for j := 0; j < 4; j++ {
+		c0, c1, c2, c3 := c[j], c[4+j], c[8+j], c[12+j]
+		c[j], c[4+j], c[8+j], c[12+j] = c0+c1+c2+c3, c0+c1-c2-c3, c0-c1-c2+c3, c0-c1+c2-c3
+	}
+
+	for i := range c {
+		if qp >= 36 {
+			c[i] = c[i] * levelScale << (qp/6 - 6)
+		} else {
+			c[i] = (c[i]*levelScale + 1<<(5-qp/6)) >> (6 - qp/6)
+		}
+	}
+	return c
+}
+
+// chromaDc returns the scaled DC coefficients of the 4 blocks of a chroma component of 4:2:0 (ISO/IEC 14496-10
+// 8.5.11).
+func chromaDc(c *[4]int, qp, levelScale int) [4]int {
+	f := [4]int{c[0] + c[1] + c[2] + c[3], c[0] - c[1] + c[2] - c[3], c[0] + c[1] - c[2] - c[3], c[0] - c[1] - c[2] + c[3]}
+	for i := range f {
+		f[i] = f[i] * levelScale << (qp / 6) >> 5
+	}
+	return f
+}
+
+// addResidual4x4 scales the levels of a 4x4 block in zigzag order, transforms them and adds the residual to the
+// predicted samples of pix. With hasDc the DC coefficient is the scaled dc instead (ISO/IEC 14496-10 8.5.12).
+func addResidual4x4(pix []byte, stride int, levels *[16]int, dc int, hasDc bool, qp int, levelScale *[6][16]int) {
+	var d [16]int
+	nonzero := false
+	for k, pos := range zigzag4x4 {
+		c := levels[k]
+		if k == 0 && hasDc {
+			c, d[0] = 0, dc
+			nonzero = dc != 0
+		}
+		if c == 0 {
+			continue
+		}
+		nonzero = true
+		if qp >= 24 {
+			d[pos] = c * levelScale[qp%6][pos] << (qp/6 - 4)
+		} else {
+			d[pos] = (c*levelScale[qp%6][pos] + 1<<(3-qp/6)) >> (4 - qp/6)
+		}
+	}
+	if !nonzero {
+		return
+	}
+
+	inverseTransform4x4(&d)
+	for y := 0; y < 4; y++ {
+		row := pix[y*stride:]
+		for x := 0; x < 4; x++ {
+			row[x] = clip1(int(row[x]) + d[y*4+x])
+		}
+	}
+}
+
+// inverseTransform4x4 transforms scaled coefficients in raster order to residual samples (ISO/IEC 14496-10
+// 8.5.12.2).
+func inverseTransform4x4(d *[16]int) {
+	for i := 0; i < 16; i += 4 {
+		e0, e1 := d[i]+d[i+2], d[i]-d[i+2]
+		e2, e3 := d[i+1]>>1-d[i+3], d[i+1]+d[i+3]>>1
+		d[i], d[i+1], d[i+2], d[i+3] = e0+e3, e1+e2, e1-e2, e0-e3
+	}
+	for j := 0; j < 4; j++ {
+		g0, g1 := d[j]+d[8+j], d[j]-d[8+j]
+		g2, g3 := d[4+j]>>1-d[12+j], d[4+j]+d[12+j]>>1
+		d[j], d[4+j], d[8+j], d[12+j] = (g0+g3+32)>>6, (g1+g2+32)>>6, (g1-g2+32)>>6, (g0-g3+32)>>6
+	}
+}
+
+// lumaAvailable reports whether the luma sample at (x, y) relative to the current macroblock is available for the
+// Intra_4x4 prediction of a block, decoded before it in the same slice.
+func (s *avcSlice) lumaAvailable(blk, x, y int) bool {
+	mb, xW, yW := s.neighbourLocation(x, y, 16, 16)
+	return mb != nil && (mb != s.mb || lumaBlock(xW, yW) < blk)
+}
+
+// predIntra4x4 writes the Intra_4x4 prediction of a luma block (ISO/IEC 14496-10 8.3.1.2).
+func (s *avcSlice) predIntra4x4(blk, mode int) {
+	x0, y0 := lumaBlockPosition(blk)
+	stride := s.img.YStride
+	pix := s.img.Y[(s.mbY*16+y0)*stride+s.mbX*16+x0:]
+
+	// p[-1, -1] at top[0] and left[0], p[x, -1] at top[x+1] and p[-1, y] at left[y+1]
+	var top [9]int
+	var left [5]int
+	hasTop, hasLeft := s.lumaAvailable(blk, x0, y0-1), s.lumaAvailable(blk, x0-1, y0)
+	if s.lumaAvailable(blk, x0-1, y0-1) {
+		top[0] = int(s.img.Y[(s.mbY*16+y0-1)*stride+s.mbX*16+x0-1])
+		left[0] = top[0]
+	}
+	if hasTop {
+		above := s.img.Y[(s.mbY*16+y0-1)*stride+s.mbX*16+x0:]
+		hasTopRight := s.lumaAvailable(blk, x0+4, y0-1)
+		for x := 0; x < 8; x++ {
+			switch {
+			case x < 4 || hasTopRight:
+				top[x+1] = int(above[x])
+			default:
+				top[x+1] = top[4]
+			}
+		}
+	}
+	if hasLeft {
+		for y := 0; y < 4; y++ {
+			left[y+1] = int(s.img.Y[(s.mbY*16+y0+y)*stride+s.mbX*16+x0-1])
+		}
+	}
+	t := func(x int) int { return top[x+1] }
+	l := func(y int) int { return left[y+1] }
+
+	var pred [4][4]int
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			var v int
+			switch mode {
+			case predVertical:
+				v = t(x)
+			case predHorizontal:
+				v = l(y)
+			case predDc:
+				switch {
+				case hasTop && hasLeft:
+					v = (t(0) + t(1) + t(2) + t(3) + l(0) + l(1) + l(2) + l(3) + 4) >> 3
+				case hasLeft:
+					v = (l(0) + l(1) + l(2) + l(3) + 2) >> 2
+				case hasTop:
+					v = (t(0) + t(1) + t(2) + t(3) + 2) >> 2
+				default:
+					v = 128
+				}
+			case 3: // Diagonal_Down_Left
+				if x == 3 && y == 3 {
+					v = (t(6) + 3*t(7) + 2) >> 2
+				} else {
+					v = (t(x+y) + 2*t(x+y+1) + t(x+y+2) + 2) >> 2
+				}
+			case 4: // Diagonal_Down_Right
+				switch {
+				case x > y:
+					v = (t(x-y-2) + 2*t(x-y-1) + t(x-y) + 2) >> 2
+				case x < y:
+					v = (l(y-x-2) + 2*l(y-x-1) + l(y-x) + 2) >> 2
+				default:
+					v = (t(0) + 2*t(-1) + l(0) + 2) >> 2
+				}
+			case 5: // Vertical_Right
+				switch z := 2*x - y; {
+				case z >= 0 && z%2 == 0:
+					v = (t(x-y>>1-1) + t(x-y>>1) + 1) >> 1
+				case z > 0:
+					v = (t(x-y>>1-2) + 2*t(x-y>>1-1) + t(x-y>>1) + 2) >> 2
+				case z == -1:
+					v = (l(0) + 2*l(-1) + t(0) + 2) >> 2
+				default:
+					v = (l(y-1) + 2*l(y-2) + l(y-3) + 2) >> 2
+				}
+			case 6: // Horizontal_Down
+				switch z := 2*y - x; {
+				case z >= 0 && z%2 == 0:
+					v = (l(y-x>>1-1) + l(y-x>>1) + 1) >> 1
+				case z > 0:
+					v = (l(y-x>>1-2) + 2*l(y-x>>1-1) + l(y-x>>1) + 2) >> 2
+				case z == -1:
+					v = (l(0) + 2*l(-1) + t(0) + 2) >> 2
+				default:
+					v = (t(x-1) + 2*t(x-2) + t(x-3) + 2) >> 2
+				}
+			case 7: // Vertical_Left
+				if y%2 == 0 {
+					v = (t(x+y>>1) + t(x+y>>1+1) + 1) >> 1
+				} else {
+					v = (t(x+y>>1) + 2*t(x+y>>1+1) + t(x+y>>1+2) + 2) >> 2
+				}
+			case 8: // Horizontal_Up
+				switch z := x + 2*y; {
+				case z < 5 && z%2 == 0:
+					v = (l(y+x>>1) + l(y+x>>1+1) + 1) >> 1
+				case z < 5:
+					v = (l(y+x>>1) + 2*l(y+x>>1+1) + l(y+x>>1+2) + 2) >> 2
+				case z == 5:
+					v = (l(2) + 3*l(3) + 2) >> 2
+				default:
+					v = l(3)
+				}
+			}
+			pred[y][x] = v
+		}
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			pix[y*stride+x] = byte(pred[y][x])
+		}
+	}
+}
+
+// mbEdges returns the samples of a plane above and left of the current macroblock of size by size samples, with
+// p[-1, -1] at index 0, and whether they are available.
+func (s *avcSlice) mbEdges(pix []byte, stride, size int) (top, left [17]int, hasTop, hasLeft bool) {
+	x0, y0 := s.mbX*size, s.mbY*size
+	if s.neighbour(-1, -1) != nil {
+		top[0] = int(pix[(y0-1)*stride+x0-1])
+		left[0] = top[0]
+	}
+	if hasTop = s.neighbour(0, -1) != nil; hasTop {
+		for x := 0; x < size; x++ {
+			top[x+1] = int(pix[(y0-1)*stride+x0+x])
+		}
+	}
+	if hasLeft = s.neighbour(-1, 0) != nil; hasLeft {
+		for y := 0; y < size; y++ {
+			left[y+1] = int(pix[(y0+y)*stride+x0-1])
+		}
+	}
+	return top, left, hasTop, hasLeft
+}
+
+// predIntra16x16 writes the Intra_16x16 prediction of the luma samples (ISO/IEC 14496-10 8.3.3).
+func (s *avcSlice) predIntra16x16(mode int) {
+	stride := s.img.YStride
+	pix := s.img.Y[s.mbY*16*stride+s.mbX*16:]
+	top, left, hasTop, hasLeft := s.mbEdges(s.img.Y, stride, 16)
+
+	dc := 128
+	sumTop, sumLeft := 0, 0
+	for i := 1; i <= 16; i++ {
+		sumTop += top[i]
+		sumLeft += left[i]
+	}
+	switch {
+	case hasTop && hasLeft:
+		dc = (sumTop + sumLeft + 16) >> 5
+	case hasLeft:
+		dc = (sumLeft + 8) >> 4
+	case hasTop:
+		dc = (sumTop + 8) >> 4
+	}
+
+	var a, b, c int
+	if mode == predPlane {
+		h, v := 0, 0
+		for i := 0; i < 8; i++ {
+			h += (i + 1) * (top[9+i] - top[7-i])
+			v += (i + 1) * (left[9+i] - left[7-i])
+		}
+		a = 16 * (left[16] + top[16])
+		b = (5*h + 32) >> 6
+		c = (5*v + 32) >> 6
+	}
+
+	for y := 0; y < 16; y++ {
+		row := pix[y*stride:]
+		for x := 0; x < 16; x++ {
+			switch mode {
+			case predVertical:
+				row[x] = byte(top[x+1])
+			case predHorizontal:
+				row[x] = byte(left[y+1])
+			case predDc:
+				row[x] = byte(dc)
+			case predPlane:
+				row[x] = clip1((a + b*(x-7) + c*(y-7) + 16) >> 5)
+			}
+		}
+	}
+}
+
+// predIntraChroma writes the prediction of the samples of a chroma component of 4:2:0 for intra_chroma_pred_mode
+// DC (0), horizontal (1), vertical (2) or plane (3) (ISO/IEC 14496-10 8.3.4).
+func (s *avcSlice) predIntraChroma(plane []byte, mode int) {
+	stride := s.img.CStride
+	pix := plane[s.mbY*8*stride+s.mbX*8:]
+	top, left, hasTop, hasLeft := s.mbEdges(plane, stride, 8)
+
+	switch mode {
+	case 0:
+		for blk := 0; blk < 4; blk++ {
+			xO, yO := blk%2*4, blk/2*4
+			sumTop, sumLeft := 0, 0
+			for i := 1; i <= 4; i++ {
+				sumTop += top[xO+i]
+				sumLeft += left[yO+i]
+			}
+			dc := 128
+			useTop, useLeft := hasTop, hasLeft
+			switch {
+			case xO > 0 && yO == 0 && hasTop:
+				useLeft = false
+			case xO == 0 && yO > 0 && hasLeft:
+				useTop = false
+			}
+			switch {
+			case useTop && useLeft:
+				dc = (sumTop + sumLeft + 4) >> 3
+			case useLeft:
+				dc = (sumLeft + 2) >> 2
+			case useTop:
+				dc = (sumTop + 2) >> 2
+			}
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 4; x++ {
+					pix[(yO+y)*stride+xO+x] = byte(dc)
+				}
+			}
+		}
+
+	case 1, 2:
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				if mode == 1 {
+					pix[y*stride+x] = byte(left[y+1])
+				} else {
+					pix[y*stride+x] = byte(top[x+1])
+				}
+			}
+		}
+
+	case 3:
+		h, v := 0, 0
+		for i := 0; i < 4; i++ {
+			h += (i + 1) * (top[5+i] - top[3-i])
+			v += (i + 1) * (left[5+i] - left[3-i])
+		}
+		a := 16 * (left[8] + top[8])
+		b := (34*h + 32) >> 6
+		c := (34*v + 32) >> 6
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				pix[y*stride+x] = clip1((a + b*(x-3) + c*(y-3) + 16) >> 5)
+			}
+		}
+	}
+}
@@ -0,0 +1,651 @@
+package mpeg
+
+import (
+	"awCodec/utils"
+	"errors"
+	"fmt"
+)
+
+// Parameter sets of ISO/IEC 14496-10 (ITU-T H.264 7.3.2.1 and 7.3.2.2)
+
+var (
+	errSps = errors.New("mpeg: invalid sequence parameter set")
+	errPps = errors.New("mpeg: invalid picture parameter set")
+)
+
+// Scaling lists in zigzag scan order (ISO/IEC 14496-10 Table 7-3 and 7-4).
+var (
+	defaultScaling4x4Intra = [16]int{6, 13, 13, 20, 20, 20, 28, 28, 28, 28, 32, 32, 32, 37, 37, 42}
+	defaultScaling4x4Inter = [16]int{10, 14, 14, 20, 20, 20, 24, 24, 24, 24, 27, 27, 27, 30, 30, 34}
+	defaultScaling8x8Intra = [64]int{
+		6, 10, 10, 13, 11, 13, 16, 16, 16, 16, 18, 18, 18, 18, 18, 23, 23, 23, 23, 23, 23, 25, 25, 25, 25, 25, 25, 25,
+		27, 27, 27, 27, 27, 27, 27, 27, 29, 29, 29, 29, 29, 29, 29, 31, 31, 31, 31, 31, 31, 33, 33, 33, 33, 33, 36, 36,
+		36, 36, 38, 38, 38, 40, 40, 42,
+	}
+	defaultScaling8x8Inter = [64]int{
+		9, 13, 13, 15, 13, 15, 17, 17, 17, 17, 19, 19, 19, 19, 19, 21, 21, 21, 21, 21, 21, 22, 22, 22, 22, 22, 22, 22,
+		24, 24, 24, 24, 24, 24, 24, 24, 25, 25, 25, 25, 25, 25, 25, 27, 27, 27, 27, 27, 27, 28, 28, 28, 28, 28, 30, 30,
+		30, 30, 32, 32, 32, 33, 33, 35,
+	}
+)
+
+// Sample aspect ratios of aspect_ratio_idc 1 to 16 (ISO/IEC 14496-10 Table E-1).
+var sampleAspectRatios = [...][2]int{
+	{1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11}, {32, 11},
+	{80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+// aspect_ratio_idc of a sample aspect ratio given by sar_width and sar_height.
+const extendedSar = 255
+
+// ScalingMatrix holds the six 4x4 lists, Intra Y, Cb, Cr and Inter Y, Cb, Cr, and the six 8x8 lists, Intra Y,
+// Inter Y, Intra Cb, Inter Cb, Intra Cr and Inter Cr, in zigzag scan order. The 8x8 lists of Cb and Cr are used for
+// 4:4:4 video only.
+type ScalingMatrix struct {
+	List4x4 [6][16]int
+	List8x8 [6][64]int
+}
+
+// flatScalingMatrix is Flat_4x4_16 and Flat_8x8_16, used when no scaling matrix is present.
+func flatScalingMatrix() ScalingMatrix {
+	var m ScalingMatrix
+	for i := range m.List4x4 {
+		for j := range m.List4x4[i] {
+			m.List4x4[i][j] = 16
+		}
+	}
+	for i := range m.List8x8 {
+		for j := range m.List8x8[i] {
+			m.List8x8[i][j] = 16
+		}
+	}
+	return m
+}
+
+// SequenceParameterSet is a decoded seq_parameter_set_rbsp.
+type SequenceParameterSet struct {
+	ProfileIdc                      int  // u(8)
+	ConstraintSetFlags              int  // u(8), constraint_set0_flag in the highest bit
+	LevelIdc                        int  // u(8)
+	SeqParameterSetID               int  // ue(v)
+	ChromaFormatIdc                 int  // ue(v), 1 (4:2:0) if not present
+	SeparateColourPlaneFlag         bool // u(1)
+	BitDepthLumaMinus8              int  // ue(v)
+	BitDepthChromaMinus8            int  // ue(v)
+	QpprimeYZeroTransformBypassFlag bool // u(1)
+	SeqScalingMatrixPresentFlag     bool // u(1)
+	ScalingMatrix                   ScalingMatrix
+	Log2MaxFrameNumMinus4           int   // ue(v)
+	PicOrderCntType                 int   // ue(v)
+	Log2MaxPicOrderCntLsbMinus4     int   // ue(v)
+	DeltaPicOrderAlwaysZeroFlag     bool  // u(1)
+	OffsetForNonRefPic              int   // se(v)
+	OffsetForTopToBottomField       int   // se(v)
+	OffsetForRefFrame               []int // se(v)[num_ref_frames_in_pic_order_cnt_cycle]
+	MaxNumRefFrames                 int   // ue(v)
+	GapsInFrameNumValueAllowedFlag  bool  // u(1)
+	PicWidthInMbsMinus1             int   // ue(v)
+	PicHeightInMapUnitsMinus1       int   // ue(v)
+	FrameMbsOnlyFlag                bool  // u(1)
+	MbAdaptiveFrameFieldFlag        bool  // u(1)
+	Direct8x8InferenceFlag          bool  // u(1)
+	FrameCroppingFlag               bool  // u(1)
+	FrameCropLeftOffset             int   // ue(v)
+	FrameCropRightOffset            int   // ue(v)
+	FrameCropTopOffset              int   // ue(v)
+	FrameCropBottomOffset           int   // ue(v)
+	Vui                             *VuiParameters
+}
+
+// VuiParameters are the video usability information of the sequence (ISO/IEC 14496-10 E.1.1).
+type VuiParameters struct {
+	AspectRatioIdc                     int  // u(8)
+	SarWidth                           int  // u(16)
+	SarHeight                          int  // u(16)
+	OverscanInfoPresentFlag            bool // u(1)
+	OverscanAppropriateFlag            bool // u(1)
+	VideoFormat                        int  // u(3), 5 (unspecified) if not present
+	VideoFullRangeFlag                 bool // u(1)
+	ColourDescriptionPresentFlag       bool // u(1)
+	ColourPrimaries                    int  // u(8), 2 (unspecified) if not present
+	TransferCharacteristics            int  // u(8), 2 (unspecified) if not present
+	MatrixCoefficients                 int  // u(8), 2 (unspecified) if not present
+	ChromaSampleLocTypeTopField        int  // ue(v)
+	ChromaSampleLocTypeBottomField     int  // ue(v)
+	TimingInfoPresentFlag              bool // u(1)
+	NumUnitsInTick                     uint32
+	TimeScale                          uint32
+	FixedFrameRateFlag                 bool // u(1)
+	NalHrdParametersPresentFlag        bool // u(1)
+	VclHrdParametersPresentFlag        bool // u(1)
+	LowDelayHrdFlag                    bool // u(1)
+	PicStructPresentFlag               bool // u(1)
+	BitstreamRestrictionFlag           bool // u(1)
+	MotionVectorsOverPicBoundariesFlag bool // u(1)
+	MaxBytesPerPicDenom                int  // ue(v)
+	MaxBitsPerMbDenom                  int  // ue(v)
+	Log2MaxMvLengthHorizontal          int  // ue(v)
+	Log2MaxMvLengthVertical            int  // ue(v)
+	MaxNumReorderFrames                int  // ue(v)
+	MaxDecFrameBuffering               int  // ue(v)
+}
+
+// PictureParameterSet is a decoded pic_parameter_set_rbsp.
+type PictureParameterSet struct {
+	PicParameterSetID                     int  // ue(v)
+	SeqParameterSetID                     int  // ue(v)
+	EntropyCodingModeFlag                 bool // u(1), CABAC if set, otherwise CAVLC
+	BottomFieldPicOrderInFramePresentFlag bool // u(1)
+	NumSliceGroupsMinus1                  int  // ue(v)
+	SliceGroupMapType                     int  // ue(v)
+	NumRefIdxL0DefaultActiveMinus1        int  // ue(v)
+	NumRefIdxL1DefaultActiveMinus1        int  // ue(v)
+	WeightedPredFlag                      bool // u(1)
+	WeightedBipredIdc                     int  // u(2)
+	PicInitQpMinus26                      int  // se(v)
+	PicInitQsMinus26                      int  // se(v)
+	ChromaQpIndexOffset                   int  // se(v)
+	DeblockingFilterControlPresentFlag    bool // u(1)
+	ConstrainedIntraPredFlag              bool // u(1)
+	RedundantPicCntPresentFlag            bool // u(1)
+	Transform8x8ModeFlag                  bool // u(1)
+	PicScalingMatrixPresentFlag           bool // u(1)
+	ScalingMatrix                         ScalingMatrix
+	SecondChromaQpIndexOffset             int // se(v), ChromaQpIndexOffset if not present
+}
+
+// rbsp returns the raw byte sequence payload of a NAL unit without its header of headerLen bytes. The
+// emulation_prevention_three_byte following two zero bytes is removed.
+func rbsp(nal []byte, headerLen int) []byte {
+	if len(nal) < headerLen {
+		return nil
+	}
+	nal = nal[headerLen:]
+	b := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, v := range nal {
+		if zeros >= 2 && v == 3 {
+			zeros = 0
+			continue
+		}
+		b = append(b, v)
+		if v == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return b
+}
+
+// moreRbspData reports whether there is more data before the rbsp_trailing_bits, the last one bit of the payload.
+func moreRbspData(br *utils.BitReader, b []byte) bool {
+	last := len(b) - 1
+	for last >= 0 && b[last] == 0 {
+		last--
+	}
+	if last < 0 {
+		return false
+	}
+	stopBit := last*8 + 7
+	for v := b[last]; v&1 == 0; v >>= 1 {
+		stopBit--
+	}
+	return br.Offset() < stopBit
+}
+
+// ParseSps decodes a sequence parameter set NAL unit.
+func ParseSps(nal []byte) (*SequenceParameterSet, error) {
+	if NalUnitType(nal) != NalSps && NalUnitType(nal) != NalSubsetSps {
+		return nil, fmt.Errorf("%w: NAL unit type %d", errSps, NalUnitType(nal))
+	}
+	b := rbsp(nal, 1)
+	br := utils.NewBitReader(b)
+
+	sps := &SequenceParameterSet{ChromaFormatIdc: 1}
+	sps.ProfileIdc = br.ReadBits(8)
+	sps.ConstraintSetFlags = br.ReadBits(8)
+	sps.LevelIdc = br.ReadBits(8)
+	sps.SeqParameterSetID = br.ReadUe()
+	if sps.SeqParameterSetID > 31 {
+		return nil, fmt.Errorf("%w: seq_parameter_set_id %d", errSps, sps.SeqParameterSetID)
+	}
+
+	sps.ScalingMatrix = flatScalingMatrix()
+	switch sps.ProfileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		sps.ChromaFormatIdc = br.ReadUe()
+		if sps.ChromaFormatIdc > 3 {
+			return nil, fmt.Errorf("%w: chroma_format_idc %d", errSps, sps.ChromaFormatIdc)
+		}
+		if sps.ChromaFormatIdc == 3 {
+			sps.SeparateColourPlaneFlag = br.ReadBool()
+		}
+		sps.BitDepthLumaMinus8 = br.ReadUe()
+		sps.BitDepthChromaMinus8 = br.ReadUe()
+		if sps.BitDepthLumaMinus8 > 6 || sps.BitDepthChromaMinus8 > 6 {
+			return nil, fmt.Errorf("%w: bit depth %d", errSps, sps.BitDepthLumaMinus8+8)
+		}
+		sps.QpprimeYZeroTransformBypassFlag = br.ReadBool()
+		sps.SeqScalingMatrixPresentFlag = br.ReadBool()
+		if sps.SeqScalingMatrixPresentFlag {
+			lists8x8 := 2
+			if sps.ChromaFormatIdc == 3 {
+				lists8x8 = 6
+			}
+			// Fall-back rule A
+			sps.ScalingMatrix.read(br, lists8x8, nil)
+		}
+	}
+
+	sps.Log2MaxFrameNumMinus4 = br.ReadUe()
+	if sps.Log2MaxFrameNumMinus4 > 12 {
+		return nil, fmt.Errorf("%w: log2_max_frame_num_minus4 %d", errSps, sps.Log2MaxFrameNumMinus4)
+	}
+	sps.PicOrderCntType = br.ReadUe()
+	switch sps.PicOrderCntType {
+	case 0:
+		sps.Log2MaxPicOrderCntLsbMinus4 = br.ReadUe()
+		if sps.Log2MaxPicOrderCntLsbMinus4 > 12 {
+			return nil, fmt.Errorf("%w: log2_max_pic_order_cnt_lsb_minus4 %d", errSps, sps.Log2MaxPicOrderCntLsbMinus4)
+		}
+	case 1:
+		sps.DeltaPicOrderAlwaysZeroFlag = br.ReadBool()
+		sps.OffsetForNonRefPic = br.ReadSe()
+		sps.OffsetForTopToBottomField = br.ReadSe()
+		n := br.ReadUe()
+		if n > 255 {
+			return nil, fmt.Errorf("%w: num_ref_frames_in_pic_order_cnt_cycle %d", errSps, n)
+		}
+		sps.OffsetForRefFrame = make([]int, n)
+		for i := range sps.OffsetForRefFrame {
+			sps.OffsetForRefFrame[i] = br.ReadSe()
+		}
+	case 2:
+	default:
+		return nil, fmt.Errorf("%w: pic_order_cnt_type %d", errSps, sps.PicOrderCntType)
+	}
+	sps.MaxNumRefFrames = br.ReadUe()
+	sps.GapsInFrameNumValueAllowedFlag = br.ReadBool()
+	sps.PicWidthInMbsMinus1 = br.ReadUe()
+	sps.PicHeightInMapUnitsMinus1 = br.ReadUe()
+	sps.FrameMbsOnlyFlag = br.ReadBool()
+	if !sps.FrameMbsOnlyFlag {
+		sps.MbAdaptiveFrameFieldFlag = br.ReadBool()
+	}
+	sps.Direct8x8InferenceFlag = br.ReadBool()
+	sps.FrameCroppingFlag = br.ReadBool()
+	if sps.FrameCroppingFlag {
+		sps.FrameCropLeftOffset = br.ReadUe()
+		sps.FrameCropRightOffset = br.ReadUe()
+		sps.FrameCropTopOffset = br.ReadUe()
+		sps.FrameCropBottomOffset = br.ReadUe()
+	}
+	if br.ReadBool() {
+		sps.Vui = readVuiParameters(br)
+	}
+
+	if br.Len() < 0 {
+		return sps, fmt.Errorf("%w: %d bytes", errSps, len(b))
+	}
+	if sps.Width() <= 0 || sps.Height() <= 0 {
+		return sps, fmt.Errorf("%w: picture size %dx%d", errSps, sps.Width(), sps.Height())
+	}
+	return sps, nil
+}
+
+// read decodes the scaling lists of a parameter set, of which 6 4x4 lists and lists8x8 8x8 lists may be present. A
+// list which is not present is inferred by fall-back rule A from the default lists, or by fall-back rule B from
+// the lists of the sequence parameter set fallback.
+func (m *ScalingMatrix) read(br *utils.BitReader, lists8x8 int, fallback *ScalingMatrix) {
+	for i := range m.List4x4 {
+		present := br.ReadBool()
+		useDefault := false
+		if present {
+			useDefault = readScalingList(br, m.List4x4[i][:])
+		}
+		switch {
+		case present && !useDefault:
+		case i == 0 && (useDefault || fallback == nil):
+			m.List4x4[i] = defaultScaling4x4Intra
+		case i == 3 && (useDefault || fallback == nil):
+			m.List4x4[i] = defaultScaling4x4Inter
+		case useDefault && i < 3:
+			m.List4x4[i] = defaultScaling4x4Intra
+		case useDefault:
+			m.List4x4[i] = defaultScaling4x4Inter
+		case i == 0 || i == 3:
+			m.List4x4[i] = fallback.List4x4[i]
+		default:
+			m.List4x4[i] = m.List4x4[i-1]
+		}
+	}
+
+	for i := range m.List8x8 {
+		present := false
+		useDefault := false
+		if i < lists8x8 {
+			present = br.ReadBool()
+		}
+		if present {
+			useDefault = readScalingList(br, m.List8x8[i][:])
+		}
+		switch {
+		case present && !useDefault:
+		case useDefault || i < 2 && fallback == nil:
+			m.List8x8[i] = defaultScaling8x8Intra
+			if i%2 == 1 {
+				m.List8x8[i] = defaultScaling8x8Inter
+			}
+		case i < 2:
+			m.List8x8[i] = fallback.List8x8[i]
+		default:
+			m.List8x8[i] = m.List8x8[i-2]
+		}
+	}
+}
+
+// readScalingList reads a scaling list in zigzag order and reports whether the default list is used instead.
+func readScalingList(br *utils.BitReader, list []int) (useDefault bool) {
+	lastScale, nextScale := 8, 8
+	for j := range list {
+		if nextScale != 0 {
+			deltaScale := br.ReadSe()
+			nextScale = (lastScale + deltaScale + 256) % 256
+			useDefault = j == 0 && nextScale == 0
+		}
+		if nextScale != 0 {
+			list[j] = nextScale
+		} else {
+			list[j] = lastScale
+		}
+		lastScale = list[j]
+	}
+	return useDefault
+}
+
+func readVuiParameters(br *utils.BitReader) *VuiParameters {
+	vui := &VuiParameters{VideoFormat: 5, ColourPrimaries: 2, TransferCharacteristics: 2, MatrixCoefficients: 2}
+	if br.ReadBool() {
+		vui.AspectRatioIdc = br.ReadBits(8)
+		if vui.AspectRatioIdc == extendedSar {
+			vui.SarWidth = br.ReadBits(16)
+			vui.SarHeight = br.ReadBits(16)
+		}
+	}
+	vui.OverscanInfoPresentFlag = br.ReadBool()
+	if vui.OverscanInfoPresentFlag {
+		vui.OverscanAppropriateFlag = br.ReadBool()
+	}
+	if br.ReadBool() {
+		vui.VideoFormat = br.ReadBits(3)
+		vui.VideoFullRangeFlag = br.ReadBool()
+		vui.ColourDescriptionPresentFlag = br.ReadBool()
+		if vui.ColourDescriptionPresentFlag {
+			vui.ColourPrimaries = br.ReadBits(8)
+			vui.TransferCharacteristics = br.ReadBits(8)
+			vui.MatrixCoefficients = br.ReadBits(8)
+		}
+	}
+	if br.ReadBool() {
+		vui.ChromaSampleLocTypeTopField = br.ReadUe()
+		vui.ChromaSampleLocTypeBottomField = br.ReadUe()
+	}
+	vui.TimingInfoPresentFlag = br.ReadBool()
+	if vui.TimingInfoPresentFlag {
+		vui.NumUnitsInTick = uint32(br.ReadBits(16))<<16 | uint32(br.ReadBits(16))
+		vui.TimeScale = uint32(br.ReadBits(16))<<16 | uint32(br.ReadBits(16))
+		vui.FixedFrameRateFlag = br.ReadBool()
+	}
+	vui.NalHrdParametersPresentFlag = br.ReadBool()
+	if vui.NalHrdParametersPresentFlag {
+		skipHrdParameters(br)
+	}
+	vui.VclHrdParametersPresentFlag = br.ReadBool()
+	if vui.VclHrdParametersPresentFlag {
+		skipHrdParameters(br)
+	}
+	if vui.NalHrdParametersPresentFlag || vui.VclHrdParametersPresentFlag {
+		vui.LowDelayHrdFlag = br.ReadBool()
+	}
+	vui.PicStructPresentFlag = br.ReadBool()
+	vui.BitstreamRestrictionFlag = br.ReadBool()
+	if vui.BitstreamRestrictionFlag {
+		vui.MotionVectorsOverPicBoundariesFlag = br.ReadBool()
+		vui.MaxBytesPerPicDenom = br.ReadUe()
+		vui.MaxBitsPerMbDenom = br.ReadUe()
+		vui.Log2MaxMvLengthHorizontal = br.ReadUe()
+		vui.Log2MaxMvLengthVertical = br.ReadUe()
+		vui.MaxNumReorderFrames = br.ReadUe()
+		vui.MaxDecFrameBuffering = br.ReadUe()
+	}
+	return vui
+}
+
+// skipHrdParameters reads over the hrd_parameters (ISO/IEC 14496-10 E.1.2).
+func skipHrdParameters(br *utils.BitReader) {
+	cpbCnt := br.ReadUe() + 1
+	br.Seek(8) // bit_rate_scale and cpb_size_scale
+	for i := 0; i < cpbCnt && i < 32 && br.Len() > 0; i++ {
+		br.ReadUe() // bit_rate_value_minus1
+		br.ReadUe() // cpb_size_value_minus1
+		br.Seek(1)  // cbr_flag
+	}
+	br.Seek(20) // the lengths of the delays and time_offset_length
+}
+
+// ChromaArrayType is ChromaFormatIdc, or 0 if the colour planes are coded separately.
+func (sps *SequenceParameterSet) ChromaArrayType() int {
+	if sps.SeparateColourPlaneFlag {
+		return 0
+	}
+	return sps.ChromaFormatIdc
+}
+
+// subsampling returns SubWidthC and SubHeightC of the chroma format (ISO/IEC 14496-10 Table 6-1).
+func (sps *SequenceParameterSet) subsampling() (subWidthC, subHeightC int) {
+	switch sps.ChromaArrayType() {
+	case 1:
+		return 2, 2
+	case 2:
+		return 2, 1
+	}
+	return 1, 1
+}
+
+// frameHeightInMbs is FrameHeightInMbs, twice the height of the map units of field coding.
+func (sps *SequenceParameterSet) frameHeightInMbs() int {
+	if sps.FrameMbsOnlyFlag {
+		return sps.PicHeightInMapUnitsMinus1 + 1
+	}
+	return 2 * (sps.PicHeightInMapUnitsMinus1 + 1)
+}
+
+// Width returns the width of the decoded pictures after cropping.
+func (sps *SequenceParameterSet) Width() int {
+	cropUnitX, _ := sps.cropUnits()
+	return (sps.PicWidthInMbsMinus1+1)*16 - cropUnitX*(sps.FrameCropLeftOffset+sps.FrameCropRightOffset)
+}
+
+// Height returns the height of the decoded frames after cropping.
+func (sps *SequenceParameterSet) Height() int {
+	_, cropUnitY := sps.cropUnits()
+	return sps.frameHeightInMbs()*16 - cropUnitY*(sps.FrameCropTopOffset+sps.FrameCropBottomOffset)
+}
+
+// cropUnits returns CropUnitX and CropUnitY, the luma samples of a unit of the frame cropping offsets.
+func (sps *SequenceParameterSet) cropUnits() (cropUnitX, cropUnitY int) {
+	cropUnitX, cropUnitY = sps.subsampling()
+	if !sps.FrameMbsOnlyFlag {
+		cropUnitY *= 2
+	}
+	return cropUnitX, cropUnitY
+}
+
+// BitDepth returns the bit depth of the luma and chroma samples.
+func (sps *SequenceParameterSet) BitDepth() (luma, chroma int) {
+	return sps.BitDepthLumaMinus8 + 8, sps.BitDepthChromaMinus8 + 8
+}
+
+// FrameRate returns the frame rate of the VUI timing information, 0 if it is not present. A frame lasts two ticks.
+func (sps *SequenceParameterSet) FrameRate() float64 {
+	if sps.Vui == nil || !sps.Vui.TimingInfoPresentFlag || sps.Vui.NumUnitsInTick == 0 {
+		return 0
+	}
+	return float64(sps.Vui.TimeScale) / float64(2*sps.Vui.NumUnitsInTick)
+}
+
+// SampleAspectRatio returns the shape of the samples, 1:1 if it is not given.
+func (sps *SequenceParameterSet) SampleAspectRatio() (width, height int) {
+	if sps.Vui == nil {
+		return 1, 1
+	}
+	switch idc := sps.Vui.AspectRatioIdc; {
+	case idc == extendedSar && sps.Vui.SarWidth != 0 && sps.Vui.SarHeight != 0:
+		return sps.Vui.SarWidth, sps.Vui.SarHeight
+	case idc >= 1 && idc <= len(sampleAspectRatios):
+		return sampleAspectRatios[idc-1][0], sampleAspectRatios[idc-1][1]
+	}
+	return 1, 1
+}
+
+// ReorderDepth returns the number of frames which may precede a frame in decoding order and follow it in output
+// order, max_num_reorder_frames or else its inferred value.
+func (sps *SequenceParameterSet) ReorderDepth() int {
+	if sps.Vui != nil && sps.Vui.BitstreamRestrictionFlag {
+		return sps.Vui.MaxNumReorderFrames
+	}
+	// Intra profiles
+	switch sps.ProfileIdc {
+	case 44, 86, 100, 110, 122, 244:
+		if sps.ConstraintSetFlags&0x10 != 0 {
+			return 0
+		}
+	}
+	return sps.maxDpbFrames()
+}
+
+// maxDpbFrames returns MaxDpbFrames from the MaxDpbMbs of the level (ISO/IEC 14496-10 Table A-1).
+func (sps *SequenceParameterSet) maxDpbFrames() int {
+	var maxDpbMbs int
+	switch level := sps.LevelIdc; {
+	case level <= 10 || level == 11 && sps.ConstraintSetFlags&0x10 != 0 && sps.ProfileIdc < 100: // 1, 1b
+		maxDpbMbs = 396
+	case level == 11:
+		maxDpbMbs = 900
+	case level <= 20:
+		maxDpbMbs = 2376
+	case level == 21:
+		maxDpbMbs = 4752
+	case level <= 30:
+		maxDpbMbs = 8100
+	case level == 31:
+		maxDpbMbs = 18000
+	case level == 32:
+		maxDpbMbs = 20480
+	case level <= 41:
+		maxDpbMbs = 32768
+	case level == 42:
+		maxDpbMbs = 34816
+	case level == 50:
+		maxDpbMbs = 110400
+	case level <= 52:
+		maxDpbMbs = 184320
+	default:
+		maxDpbMbs = 696320
+	}
+	frames := maxDpbMbs / ((sps.PicWidthInMbsMinus1 + 1) * sps.frameHeightInMbs())
+	if frames > 16 {
+		frames = 16
+	}
+	return frames
+}
+
+// ParsePps decodes a picture parameter set NAL unit. The sequence parameter set it refers to gives the number of
+// 8x8 scaling lists and the lists they fall back to; without it 4:2:0 video is assumed.
+func ParsePps(nal []byte, sps *SequenceParameterSet) (*PictureParameterSet, error) {
+	if NalUnitType(nal) != NalPps {
+		return nil, fmt.Errorf("%w: NAL unit type %d", errPps, NalUnitType(nal))
+	}
+	b := rbsp(nal, 1)
+	br := utils.NewBitReader(b)
+
+	pps := &PictureParameterSet{}
+	pps.PicParameterSetID = br.ReadUe()
+	pps.SeqParameterSetID = br.ReadUe()
+	if pps.PicParameterSetID > 255 || pps.SeqParameterSetID > 31 {
+		return nil, fmt.Errorf("%w: parameter set ID %d %d", errPps, pps.PicParameterSetID, pps.SeqParameterSetID)
+	}
+	pps.EntropyCodingModeFlag = br.ReadBool()
+	pps.BottomFieldPicOrderInFramePresentFlag = br.ReadBool()
+	pps.NumSliceGroupsMinus1 = br.ReadUe()
+	if pps.NumSliceGroupsMinus1 > 7 {
+		return nil, fmt.Errorf("%w: num_slice_groups_minus1 %d", errPps, pps.NumSliceGroupsMinus1)
+	}
+	if pps.NumSliceGroupsMinus1 > 0 {
+		pps.SliceGroupMapType = br.ReadUe()
+		switch pps.SliceGroupMapType {
+		case 0:
+			for i := 0; i <= pps.NumSliceGroupsMinus1; i++ {
+				br.ReadUe() // run_length_minus1
+			}
+		case 2:
+			for i := 0; i < pps.NumSliceGroupsMinus1; i++ {
+				br.ReadUe() // top_left
+				br.ReadUe() // bottom_right
+			}
+		case 3, 4, 5:
+			br.Seek(1)  // slice_group_change_direction_flag
+			br.ReadUe() // slice_group_change_rate_minus1
+		case 6:
+			n := br.ReadUe() + 1 // pic_size_in_map_units_minus1
+			bits := 0
+			for 1<<bits < pps.NumSliceGroupsMinus1+1 {
+				bits++
+			}
+			br.Seek(n * bits) // slice_group_id
+		}
+	}
+	pps.NumRefIdxL0DefaultActiveMinus1 = br.ReadUe()
+	pps.NumRefIdxL1DefaultActiveMinus1 = br.ReadUe()
+	pps.WeightedPredFlag = br.ReadBool()
+	pps.WeightedBipredIdc = br.ReadBits(2)
+	pps.PicInitQpMinus26 = br.ReadSe()
+	pps.PicInitQsMinus26 = br.ReadSe()
+	pps.ChromaQpIndexOffset = br.ReadSe()
+	pps.DeblockingFilterControlPresentFlag = br.ReadBool()
+	pps.ConstrainedIntraPredFlag = br.ReadBool()
+	pps.RedundantPicCntPresentFlag = br.ReadBool()
+	pps.SecondChromaQpIndexOffset = pps.ChromaQpIndexOffset
+
+	if sps != nil {
+		pps.ScalingMatrix = sps.ScalingMatrix
+	} else {
+		pps.ScalingMatrix = flatScalingMatrix()
+	}
+	if moreRbspData(br, b) {
+		pps.Transform8x8ModeFlag = br.ReadBool()
+		pps.PicScalingMatrixPresentFlag = br.ReadBool()
+		if pps.PicScalingMatrixPresentFlag {
+			lists8x8 := 0
+			if pps.Transform8x8ModeFlag {
+				lists8x8 = 2
+				if sps != nil && sps.ChromaFormatIdc == 3 {
+					lists8x8 = 6
+				}
+			}
+			// Fall-back rule B, or rule A if the sequence has no scaling matrix
+			var fallback *ScalingMatrix
+			if sps != nil && sps.SeqScalingMatrixPresentFlag {
+				fallback = &sps.ScalingMatrix
+			}
+			pps.ScalingMatrix.read(br, lists8x8, fallback)
+		}
+		pps.SecondChromaQpIndexOffset = br.ReadSe()
+	}
+
+	if br.Len() < 0 {
+		return pps, fmt.Errorf("%w: %d bytes", errPps, len(b))
+	}
+	return pps, nil
+}
@@ -1,11 +1,8 @@
 package mpeg
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"time"
-	"unsafe"
 )
 
 const (
@@ -25,23 +22,31 @@ type FullBox struct {
 	Flags   [3]byte
 }
 
+// RawBox is a box which is not decoded into a typed struct.
+type RawBox struct {
+	Type   [4]byte
+	Offset int64  // Position of the box header in the file
+	Size   uint64 // Size of the whole box including the header
+	Data   []byte // Payload following the header
+}
+
 type FileTypeBox struct {
 	Box
-	MajorBrand   [4]byte
-	MinorVersion [4]byte
-	//CompatibleBrands [][4]byte
+	MajorBrand       [4]byte
+	MinorVersion     [4]byte
+	CompatibleBrands [][4]byte
 }
 
 type MovieHeaderBox struct {
 	FullBox
-	CreationTime     uint32    // unsigned int(32) / unsigned int(64)
-	ModificationTime uint32    // unsigned int(32) / unsigned int(64)
+	CreationTime     uint64    // unsigned int(32) / unsigned int(64)
+	ModificationTime uint64    // unsigned int(32) / unsigned int(64)
 	Timescale        uint32    // unsigned int(32)
-	Duration         uint32    // unsigned int(32) / unsigned int(64)
+	Duration         uint64    // unsigned int(32) / unsigned int(64)
 	Rate             int32     // template int(32)
 	Volume           int16     // template int(16)
 	Reserved         uint16    // const bit(16)
-	Reserved_        [2]uint32 // const unsigned int(32)[2]
+	Reserved_        [2]uint32 // const unsigned int(32)
 	Matrix           [9]int32  // template int(32)[9]
 	PreDefined       [6]int32  // bit(32)[6]
 	NextTrackID      uint32    // unsigned int(32)
@@ -49,27 +54,27 @@ type MovieHeaderBox struct {
 
 type TrackHeaderBox struct {
 	FullBox
-	CreationTime     uint32    // unsigned int(32) / unsigned int(64)
-	ModificationTime uint32    // unsigned int(32) / unsigned int(64)
+	CreationTime     uint64    // unsigned int(32) / unsigned int(64)
+	ModificationTime uint64    // unsigned int(32) / unsigned int(64)
 	TrackID          uint32    // unsigned int(32)
 	Reserved         uint32    // unsigned int(32)
-	Duration         uint32    // unsigned int(32) / unsigned int(64)
+	Duration         uint64    // unsigned int(32) / unsigned int(64)
 	Reserved_        [2]uint32 // unsigned int(32)[2]
 	Layer            int16     // template int(16)
 	AlternateGroup   int16     // template int(16)
 	Volume           int16     // template int(16)
 	Reserved__       uint16    // unsigned int(16)
-	Matrix           [9]uint32 // template int(32)[9]
+	Matrix           [9]int32  // template int(32)[9]
 	Width            uint32    // unsigned int(32)
 	Height           uint32    // unsigned int(32)
 }
 
 type MediaHeaderBox struct {
 	FullBox
-	CreationTime     uint32 // unsigned int(32) / unsigned int(64)
-	ModificationTime uint32 // unsigned int(32) / unsigned int(64)
+	CreationTime     uint64 // unsigned int(32) / unsigned int(64)
+	ModificationTime uint64 // unsigned int(32) / unsigned int(64)
 	Timescale        uint32 // unsigned int(32)
-	Duration         uint32 // unsigned int(32) / unsigned int(64)
+	Duration         uint64 // unsigned int(32) / unsigned int(64)
 	Language         uint16 // 1 bit pad and unsigned int(5)[3]
 	PreDefined       uint16 // unsigned int(16)
 }
@@ -79,20 +84,23 @@ type HandlerBox struct {
 	PreDefined  uint32    // unsigned int(32)
 	HandlerType [4]byte   // unsigned int(32)
 	Reserved    [3]uint32 // unsigned int(32)[3]
-	//Name        string
+	Name        string    // string
 }
 
 type VideoMediaHeaderBox struct {
+	FullBox
 	GraphicsMode uint16    // unsigned int(16)
 	OpColor      [3]uint16 // unsigned int(16)[3]
 }
 
 type SoundMediaHeaderBox struct {
+	FullBox
 	Balance  int16  // int(16)
 	Reserved uint16 // unsigned int(16)
 }
 
 type HintMediaHeaderBox struct {
+	FullBox
 	MaxPDUSize uint16 // unsigned int(16)
 	AvgPDUSize uint16 // unsigned int(16)
 	MaxBitRate uint32 // unsigned int(32)
@@ -101,21 +109,27 @@ type HintMediaHeaderBox struct {
 }
 
 type NullMediaHeaderBox struct {
+	FullBox
 }
 
 type DataReferenceBox struct {
 	FullBox
 	EntryCount uint32 // unsigned int(32)
+	Entries    []DataEntryBox
 }
 
-type SampleTableBox struct {
-	Box
+// DataEntryBox is a 'url ' or 'urn ' entry of the DataReferenceBox. The flag 0x000001 means the media data is in
+// the same file.
+type DataEntryBox struct {
+	FullBox
+	Name     string // Only for 'urn '
+	Location string
 }
 
 type SampleDescriptionBox struct {
 	FullBox
-	//I          int32  // int
 	EntryCount uint32 // unsigned int(32)
+	Entries    []*SampleDescription
 }
 
 type SampleEntry struct {
@@ -151,7 +165,22 @@ type AudioSampleEntry struct {
 	SampleSize   uint16
 	PreDefined   uint16
 	Reserved_    uint16
-	SampleRate   uint32
+	SampleRate   uint32 // 16.16 fixed point
+}
+
+// SampleDescription is an entry of the SampleDescriptionBox with its child boxes. Audio is set for 'soun' tracks
+// and Visual for 'vide' tracks, the payload of other entries is kept in Data. For QuickTime sound descriptions of
+// version 1 and 2 Data holds the fields following the AudioSampleEntry.
+type SampleDescription struct {
+	SampleEntry
+	Audio  *AudioSampleEntry
+	Visual *VisualSampleEntry
+	AvcC   *AVCConfigurationBox
+	HvcC   *HEVCConfigurationBox
+	Esds   *ESDescriptorBox // Also found in the 'wave' box of QuickTime sound descriptions
+	Sinf   *ProtectionSchemeInfoBox
+	Data   []byte
+	Boxes  []*RawBox // Child boxes which are not decoded
 }
 
 // ISO/IEC 14496-15
@@ -168,9 +197,13 @@ type AVCDecoderConfigurationRecord struct {
 type AVCConfigurationBox struct {
 	Box
 	AVCDecoderConfigurationRecord // AVCConfig
+	SequenceParameterSets         [][]byte
+	PictureParameterSets          [][]byte
+	Ext                           *AVCConfigurationBoxExt // High profiles 100, 110, 122 and 144 only
+	SequenceParameterSetExts      [][]byte
 }
 
-// AVCConfigurationBoxExt non standard struct
+// AVCConfigurationBoxExt follows the parameter sets for the high profiles, it may be missing in older files.
 type AVCConfigurationBoxExt struct {
 	ChromaFormat                 uint8 // bit(6) reserved and unsigned int(2) chroma_format
 	BitDepthLumaMinus8           uint8 // bit(5) reserved and unsigned int(3) bit_depth_luma_minus8
@@ -178,383 +211,1066 @@ type AVCConfigurationBoxExt struct {
 	NumOfSequenceParameterSetExt uint8 // unsigned int(8)
 }
 
+// ISO/IEC 14496-15 8.3.3
+
+type HEVCDecoderConfigurationRecord struct {
+	ConfigurationVersion             uint8  // unsigned int(8)
+	GeneralProfileSpace              uint8  // unsigned int(2)
+	GeneralTierFlag                  bool   // unsigned int(1)
+	GeneralProfileIdc                uint8  // unsigned int(5)
+	GeneralProfileCompatibilityFlags uint32 // unsigned int(32)
+	GeneralConstraintIndicatorFlags  uint64 // unsigned int(48)
+	GeneralLevelIdc                  uint8  // unsigned int(8)
+	MinSpatialSegmentationIdc        uint16 // bit(4) reserved and unsigned int(12) min_spatial_segmentation_idc
+	ParallelismType                  uint8  // bit(6) reserved and unsigned int(2) parallelismType
+	ChromaFormat                     uint8  // bit(6) reserved and unsigned int(2) chroma_format_idc
+	BitDepthLumaMinus8               uint8  // bit(5) reserved and unsigned int(3) bit_depth_luma_minus8
+	BitDepthChromaMinus8             uint8  // bit(5) reserved and unsigned int(3) bit_depth_chroma_minus8
+	AvgFrameRate                     uint16 // bit(16) avgFrameRate, in frames per 256 seconds
+	ConstantFrameRate                uint8  // bit(2) constantFrameRate
+	NumTemporalLayers                uint8  // bit(3) numTemporalLayers
+	TemporalIDNested                 bool   // bit(1) temporalIdNested
+	LengthSizeMinusOne               uint8  // unsigned int(2) lengthSizeMinusOne
+}
+
+type HEVCConfigurationBox struct {
+	Box
+	HEVCDecoderConfigurationRecord // HEVCConfig
+	Arrays                         []HEVCNalUnitArray
+}
+
+// HEVCNalUnitArray holds the NAL units of one type, the VPS, SPS, PPS and SEI of the decoder configuration.
+type HEVCNalUnitArray struct {
+	ArrayCompleteness bool  // bit(1) array_completeness, all NAL units of the type are in the array
+	NalUnitType       uint8 // bit(1) reserved and unsigned int(6) NAL_unit_type
+	NalUnits          [][]byte
+}
+
+type TimeToSampleBox struct {
+	FullBox
+	EntryCount uint32 // unsigned int(32)
+	Entries    []TimeToSampleEntry
+}
+
+type TimeToSampleEntry struct {
+	SampleCount uint32 // unsigned int(32)
+	SampleDelta uint32 // unsigned int(32)
+}
+
+type SampleToChunkBox struct {
+	FullBox
+	EntryCount uint32 // unsigned int(32)
+	Entries    []SampleToChunkEntry
+}
+
+type SampleToChunkEntry struct {
+	FirstChunk             uint32 // unsigned int(32)
+	SamplesPerChunk        uint32 // unsigned int(32)
+	SampleDescriptionIndex uint32 // unsigned int(32)
+}
+
 type SampleSizeBox struct {
-	SampleSize  uint32
-	SampleCount uint32
+	FullBox
+	SampleSize  uint32   // unsigned int(32)
+	SampleCount uint32   // unsigned int(32)
+	EntrySize   []uint32 // unsigned int(32), only if SampleSize is 0
 }
 
 type CompactSampleSizeBox struct {
-	Reserved    uint16 // unsigned int(24)
-	FieldSize   uint16 // unisgned int(8)
-	SampleCount uint32 // unsigned int(32)
+	FullBox
+	Reserved    [3]uint8 // unsigned int(24)
+	FieldSize   uint8    // unisgned int(8)
+	SampleCount uint32   // unsigned int(32)
+	EntrySize   []uint32 // unsigned int(FieldSize)
+}
+
+// ChunkOffsetBox holds both 'stco' and 'co64' boxes.
+type ChunkOffsetBox struct {
+	FullBox
+	EntryCount  uint32   // unsigned int(32)
+	ChunkOffset []uint64 // unsigned int(32) / unsigned int(64)
+}
+
+type SampleTableBox struct {
+	Box
+	Stsd  *SampleDescriptionBox
+	Stts  *TimeToSampleBox
+	Ctts  *CompositionOffsetBox
+	Stss  *SyncSampleBox
+	Stps  *SyncSampleBox // Partial sync samples of QuickTime files
+	Sdtp  *SampleDependencyTypeBox
+	Stsc  *SampleToChunkBox
+	Stsz  *SampleSizeBox
+	Stz2  *CompactSampleSizeBox
+	Stco  *ChunkOffsetBox
+	Saiz  []*SampleAuxiliaryInformationSizesBox
+	Saio  []*SampleAuxiliaryInformationOffsetsBox
+	Sbgp  []*SampleToGroupBox
+	Sgpd  []*SampleGroupDescriptionBox
+	Boxes []*RawBox
+}
+
+type DataInformationBox struct {
+	Box
+	Dref  *DataReferenceBox
+	Boxes []*RawBox
+}
+
+type MediaInformationBox struct {
+	Box
+	Vmhd  *VideoMediaHeaderBox
+	Smhd  *SoundMediaHeaderBox
+	Hmhd  *HintMediaHeaderBox
+	Nmhd  *NullMediaHeaderBox
+	Dinf  *DataInformationBox
+	Stbl  *SampleTableBox
+	Boxes []*RawBox
+}
+
+type MediaBox struct {
+	Box
+	Mdhd  *MediaHeaderBox
+	Hdlr  *HandlerBox
+	Minf  *MediaInformationBox
+	Boxes []*RawBox
+}
+
+type UserDataBox struct {
+	Box
+	Meta  *MetaBox
+	Chpl  *ChapterListBox
+	Boxes []*RawBox
+}
+
+// EditListBox maps the media timeline of a track onto the presentation timeline.
+type EditListBox struct {
+	FullBox
+	EntryCount uint32 // unsigned int(32)
+	Entries    []EditListEntry
+}
+
+type EditListEntry struct {
+	SegmentDuration   uint64 // unsigned int(32) / unsigned int(64), in the timescale of the movie
+	MediaTime         int64  // int(32) / int(64), in the timescale of the media, -1 for an empty edit
+	MediaRateInteger  int16  // int(16)
+	MediaRateFraction int16  // int(16)
+}
+
+type EditBox struct {
+	Box
+	Elst  *EditListBox
+	Boxes []*RawBox
+}
+
+type TrackBox struct {
+	Box
+	Tkhd  *TrackHeaderBox
+	Tref  *TrackReferenceBox
+	Edts  *EditBox
+	Mdia  *MediaBox
+	Udta  *UserDataBox
+	Boxes []*RawBox
+}
+
+type MovieBox struct {
+	Box
+	Offset int64 // Position of the box header in the file
+	Mvhd   *MovieHeaderBox
+	Trak   []*TrackBox
+	Mvex   *MovieExtendsBox
+	Udta   *UserDataBox
+	Pssh   []*ProtectionSystemSpecificHeaderBox
+	Boxes  []*RawBox
+}
+
+// File is an ISO base media file (MP4, M4A, MOV, 3GP) parsed into its box tree. Boxes which are not known are kept
+// as RawBox in the Boxes field of their parent.
+type File struct {
+	Ftyp  *FileTypeBox
+	Moov  *MovieBox
+	Moof  []*MovieFragmentBox
+	Mdat  []*RawBox
+	Sidx  []*SegmentIndexBox
+	Mfra  *MovieFragmentRandomAccessBox
+	Boxes []*RawBox
 }
 
 var (
-	//ftypType = [4]byte{'f', 't', 'y', 'p'}
+	ftypType = [4]byte{'f', 't', 'y', 'p'}
 	moovType = [4]byte{'m', 'o', 'o', 'v'}
 	mdatType = [4]byte{'m', 'd', 'a', 't'}
-	//mvhdType = [4]byte{'m', 'v', 'h', 'd'}
+	mvhdType = [4]byte{'m', 'v', 'h', 'd'}
 	trakType = [4]byte{'t', 'r', 'a', 'k'}
-	//tkhdType = [4]byte{'t', 'k', 'h', 'd'}
+	tkhdType = [4]byte{'t', 'k', 'h', 'd'}
+	edtsType = [4]byte{'e', 'd', 't', 's'}
+	elstType = [4]byte{'e', 'l', 's', 't'}
 	mdiaType = [4]byte{'m', 'd', 'i', 'a'}
-	//mdhdType = [4]byte{'m', 'd', 'h', 'd'}
-	//hdlrType = [4]byte{'h', 'd', 'l', 'r'}
-	handlerVide = [4]byte{'v', 'i', 'd', 'e'}
-	handlerSoun = [4]byte{'s', 'o', 'u', 'n'}
-	handlerHint = [4]byte{'h', 'i', 'n', 't'}
-	//minfType = [4]byte{'m', 'i', 'n', 'f'}
+	mdhdType = [4]byte{'m', 'd', 'h', 'd'}
+	hdlrType = [4]byte{'h', 'd', 'l', 'r'}
+	minfType = [4]byte{'m', 'i', 'n', 'f'}
+	udtaType = [4]byte{'u', 'd', 't', 'a'}
+
+	HandlerVide = [4]byte{'v', 'i', 'd', 'e'}
+	HandlerSoun = [4]byte{'s', 'o', 'u', 'n'}
+	HandlerHint = [4]byte{'h', 'i', 'n', 't'}
 
 	vmhdType = [4]byte{'v', 'm', 'h', 'd'}
 	smhdType = [4]byte{'s', 'm', 'h', 'd'}
 	hmhdType = [4]byte{'h', 'm', 'h', 'd'}
 	nmhdType = [4]byte{'n', 'm', 'h', 'd'}
 
-	//urlType = [4]byte{'u', 'r', 'l', ' '}
-	urnType = [4]byte{'u', 'r', 'n', ' '}
+	dinfType = [4]byte{'d', 'i', 'n', 'f'}
+	drefType = [4]byte{'d', 'r', 'e', 'f'}
+	urlType  = [4]byte{'u', 'r', 'l', ' '}
+	urnType  = [4]byte{'u', 'r', 'n', ' '}
 
+	stblType = [4]byte{'s', 't', 'b', 'l'}
+	stsdType = [4]byte{'s', 't', 's', 'd'}
 	sttsType = [4]byte{'s', 't', 't', 's'}
 	stscType = [4]byte{'s', 't', 's', 'c'}
 	stcoType = [4]byte{'s', 't', 'c', 'o'}
-	co64Type = [4]byte{'c', '0', '6', '4'}
+	co64Type = [4]byte{'c', 'o', '6', '4'}
 	stszType = [4]byte{'s', 't', 's', 'z'}
 	stz2Type = [4]byte{'s', 't', 'z', '2'}
+
+	avc1Type = [4]byte{'a', 'v', 'c', '1'}
+	avcCType = [4]byte{'a', 'v', 'c', 'C'}
+	hvc1Type = [4]byte{'h', 'v', 'c', '1'}
+	hev1Type = [4]byte{'h', 'e', 'v', '1'}
+	hvcCType = [4]byte{'h', 'v', 'c', 'C'}
 )
 
-//const (
-//	ftypType uint32 = 0x66747970
-//	moovType uint32 = 0x6D6F6F76
-//)
+// ParseMp4 parses the box tree of an ISO base media file. The Data of the returned boxes references b.
+func ParseMp4(b []byte) (*File, error) {
+	f := &File{}
+	if err := eachBox(b, 0, f.addBox); err != nil {
+		return f, err
+	}
 
-func mpeg12(file *bytes.Reader) {
-	var moov *bytes.Buffer
-	var trakList []*bytes.Buffer
-	var mdat []*bytes.Buffer
+	if f.Moov == nil {
+		return f, fmt.Errorf("mpeg: no %s box", moovType)
+	}
+	return f, nil
+}
 
-	fileTypeBox := FileTypeBox{}
-	if err := binary.Read(file, binary.BigEndian, &fileTypeBox); err != nil {
-		fmt.Println("binary.Read failed:", err)
+// addBox adds a top level box to the file.
+func (f *File) addBox(h Box, payload []byte, offset int64) (err error) {
+	switch h.Type {
+	case ftypType:
+		f.Ftyp, err = parseFtyp(h, payload)
+	case moovType:
+		f.Moov, err = parseMoov(h, payload, offset+boxHeaderLen(h))
+		f.Moov.Offset = offset
+	case moofType:
+		var moof *MovieFragmentBox
+		moof, err = parseMoof(h, payload, offset)
+		f.Moof = append(f.Moof, moof)
+	case mdatType:
+		f.Mdat = append(f.Mdat, newRawBox(h, payload, offset))
+	case sidxType:
+		var sidx *SegmentIndexBox
+		sidx, err = parseSidx(h, payload)
+		f.Sidx = append(f.Sidx, sidx)
+	case mfraType:
+		f.Mfra, err = parseMfra(h, payload, offset+boxHeaderLen(h))
+	default:
+		f.Boxes = append(f.Boxes, newRawBox(h, payload, offset))
 	}
-	compatibleBrands := make([][4]byte, (fileTypeBox.Size-uint32(unsafe.Sizeof(FileTypeBox{})))/4)
-	if err := binary.Read(file, binary.BigEndian, &compatibleBrands); err != nil {
-		fmt.Println("binary.Read failed:", err)
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.Type, err)
 	}
+	return nil
+}
 
-	fmt.Printf("{Box:{Size:%d Type:%s} MajorBrand:%s MinorVersion:%s} CompatibleBrands:%s\n",
-		fileTypeBox.Size, fileTypeBox.Type, fileTypeBox.MajorBrand, fileTypeBox.MinorVersion, compatibleBrands)
+func parseFtyp(h Box, payload []byte) (*FileTypeBox, error) {
+	r := newBoxReader(payload)
 
-	for file.Len() != 0 {
-		box := Box{}
-		if err := binary.Read(file, binary.BigEndian, &box); err != nil {
-			fmt.Println("binary.Read failed:", err)
+	ftyp := &FileTypeBox{Box: h}
+	ftyp.MajorBrand = r.fourCC()
+	ftyp.MinorVersion = r.fourCC()
+	for r.Len() >= 4 {
+		ftyp.CompatibleBrands = append(ftyp.CompatibleBrands, r.fourCC())
+	}
+	return ftyp, r.err
+}
+
+func parseMoov(h Box, payload []byte, offset int64) (*MovieBox, error) {
+	moov := &MovieBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case mvhdType:
+			moov.Mvhd, err = parseMvhd(h, payload)
+		case trakType:
+			var trak *TrackBox
+			trak, err = parseTrak(h, payload, offset+boxHeaderLen(h))
+			moov.Trak = append(moov.Trak, trak)
+		case mvexType:
+			moov.Mvex, err = parseMvex(h, payload, offset+boxHeaderLen(h))
+		case udtaType:
+			moov.Udta, err = parseUdta(h, payload, offset+boxHeaderLen(h))
+		case psshType:
+			var pssh *ProtectionSystemSpecificHeaderBox
+			pssh, err = parsePssh(h, payload)
+			moov.Pssh = append(moov.Pssh, pssh)
+		default:
+			moov.Boxes = append(moov.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
 		}
+		return nil
+	})
+	return moov, err
+}
 
-		fmt.Printf("{Size:%d Type:%s}\n", box.Size, box.Type)
+func parseMvhd(h Box, payload []byte) (*MovieHeaderBox, error) {
+	r := newBoxReader(payload)
+
+	mvhd := &MovieHeaderBox{FullBox: r.fullBox(h)}
+	mvhd.CreationTime = r.uint(mvhd.Version)
+	mvhd.ModificationTime = r.uint(mvhd.Version)
+	mvhd.Timescale = r.u32()
+	mvhd.Duration = r.uint(mvhd.Version)
+	mvhd.Rate = int32(r.u32())
+	mvhd.Volume = int16(r.u16())
+	mvhd.Reserved = r.u16()
+	for i := range mvhd.Reserved_ {
+		mvhd.Reserved_[i] = r.u32()
+	}
+	for i := range mvhd.Matrix {
+		mvhd.Matrix[i] = int32(r.u32())
+	}
+	for i := range mvhd.PreDefined {
+		mvhd.PreDefined[i] = int32(r.u32())
+	}
+	mvhd.NextTrackID = r.u32()
+	return mvhd, r.err
+}
 
-		size := uint64(box.Size)
-		if size == 1 {
-			if err := binary.Read(file, binary.BigEndian, &size); err != nil {
-				fmt.Println("binary.Read failed:", err)
-			}
-			size -= 8 // self size
-		} else if size == 0 {
-			size = uint64(file.Len())
+func parseTrak(h Box, payload []byte, offset int64) (*TrackBox, error) {
+	trak := &TrackBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case tkhdType:
+			trak.Tkhd, err = parseTkhd(h, payload)
+		case trefType:
+			trak.Tref, err = parseTref(h, payload)
+		case edtsType:
+			trak.Edts, err = parseEdts(h, payload, offset+boxHeaderLen(h))
+		case mdiaType:
+			trak.Mdia, err = parseMdia(h, payload, offset+boxHeaderLen(h))
+		case udtaType:
+			trak.Udta, err = parseUdta(h, payload, offset+boxHeaderLen(h))
+		default:
+			trak.Boxes = append(trak.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
 		}
+		return nil
+	})
+	return trak, err
+}
+
+func parseTkhd(h Box, payload []byte) (*TrackHeaderBox, error) {
+	r := newBoxReader(payload)
+
+	tkhd := &TrackHeaderBox{FullBox: r.fullBox(h)}
+	tkhd.CreationTime = r.uint(tkhd.Version)
+	tkhd.ModificationTime = r.uint(tkhd.Version)
+	tkhd.TrackID = r.u32()
+	tkhd.Reserved = r.u32()
+	tkhd.Duration = r.uint(tkhd.Version)
+	for i := range tkhd.Reserved_ {
+		tkhd.Reserved_[i] = r.u32()
+	}
+	tkhd.Layer = int16(r.u16())
+	tkhd.AlternateGroup = int16(r.u16())
+	tkhd.Volume = int16(r.u16())
+	tkhd.Reserved__ = r.u16()
+	for i := range tkhd.Matrix {
+		tkhd.Matrix[i] = int32(r.u32())
+	}
+	tkhd.Width = r.u32()
+	tkhd.Height = r.u32()
+	return tkhd, r.err
+}
 
-		buf := make([]byte, int(size-uint64(unsafe.Sizeof(Box{}))))
-		file.Read(buf)
-		boxData := bytes.NewBuffer(buf)
+func parseEdts(h Box, payload []byte, offset int64) (*EditBox, error) {
+	edts := &EditBox{Box: h}
 
-		//boxData := bytes.NewBuffer(file.Next(int(size - uint64(unsafe.Sizeof(Box{})))))
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		if h.Type == elstType {
+			edts.Elst, err = parseElst(h, payload)
+		} else {
+			edts.Boxes = append(edts.Boxes, newRawBox(h, payload, offset))
+		}
+		return err
+	})
+	return edts, err
+}
 
-		if box.Type == moovType {
-			moov = boxData
+func parseElst(h Box, payload []byte) (*EditListBox, error) {
+	r := newBoxReader(payload)
 
-		} else if box.Type == mdatType {
-			mdat = append(mdat, boxData)
+	elst := &EditListBox{FullBox: r.fullBox(h)}
+	elst.EntryCount = r.u32()
+	size := 12
+	if elst.Version == 1 {
+		size = 20
+	}
+	elst.Entries = make([]EditListEntry, r.entries(elst.EntryCount, size))
+	for i := range elst.Entries {
+		entry := &elst.Entries[i]
+		entry.SegmentDuration = r.uint(elst.Version)
+		if elst.Version == 1 {
+			entry.MediaTime = int64(r.u64())
+		} else {
+			entry.MediaTime = int64(int32(r.u32()))
 		}
+		entry.MediaRateInteger = int16(r.u16())
+		entry.MediaRateFraction = int16(r.u16())
 	}
+	return elst, r.err
+}
 
-	fmt.Println()
+func parseMdia(h Box, payload []byte, offset int64) (*MediaBox, error) {
+	mdia := &MediaBox{Box: h}
 
-	movieHeaderBox := MovieHeaderBox{}
-	if err := binary.Read(moov, binary.BigEndian, &movieHeaderBox); err != nil {
-		fmt.Println("binary.Read failed:", err)
+	// The sample entries depend on the handler type, so 'hdlr' is read before the other boxes.
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		if h.Type == hdlrType {
+			mdia.Hdlr, err = parseHdlr(h, payload)
+		}
+		return err
+	})
+	if err != nil {
+		return mdia, fmt.Errorf("%s: %w", hdlrType, err)
 	}
 
-	fmt.Printf("MovieHeaderBox %+v\n", movieHeaderBox)
+	var handlerType [4]byte
+	if mdia.Hdlr != nil {
+		handlerType = mdia.Hdlr.HandlerType
+	}
 
-	for (moov).Len() != 0 {
-		box := Box{}
-		if err := binary.Read(moov, binary.BigEndian, &box); err != nil {
-			fmt.Println("binary.Read failed:", err)
+	err = eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case hdlrType:
+		case mdhdType:
+			mdia.Mdhd, err = parseMdhd(h, payload)
+		case minfType:
+			mdia.Minf, err = parseMinf(h, payload, offset+boxHeaderLen(h), handlerType)
+		default:
+			mdia.Boxes = append(mdia.Boxes, newRawBox(h, payload, offset))
 		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return mdia, err
+}
+
+func parseMdhd(h Box, payload []byte) (*MediaHeaderBox, error) {
+	r := newBoxReader(payload)
+
+	mdhd := &MediaHeaderBox{FullBox: r.fullBox(h)}
+	mdhd.CreationTime = r.uint(mdhd.Version)
+	mdhd.ModificationTime = r.uint(mdhd.Version)
+	mdhd.Timescale = r.u32()
+	mdhd.Duration = r.uint(mdhd.Version)
+	mdhd.Language = r.u16()
+	mdhd.PreDefined = r.u16()
+	return mdhd, r.err
+}
 
-		fmt.Printf("{Size:%d Type:%s}\n", box.Size, box.Type)
+func parseHdlr(h Box, payload []byte) (*HandlerBox, error) {
+	r := newBoxReader(payload)
 
-		size := uint64(box.Size)
-		if size == 1 {
-			if err := binary.Read(moov, binary.BigEndian, &box); err != nil {
-				fmt.Println("binary.Read failed:", err)
-			}
-			size -= 8
-		} else if size == 0 {
-			size = uint64((moov).Len())
+	hdlr := &HandlerBox{FullBox: r.fullBox(h)}
+	hdlr.PreDefined = r.u32()
+	hdlr.HandlerType = r.fourCC()
+	for i := range hdlr.Reserved {
+		hdlr.Reserved[i] = r.u32()
+	}
+	hdlr.Name = r.cstring()
+	return hdlr, r.err
+}
+
+func parseMinf(h Box, payload []byte, offset int64, handlerType [4]byte) (*MediaInformationBox, error) {
+	minf := &MediaInformationBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case vmhdType:
+			minf.Vmhd = &VideoMediaHeaderBox{}
+			_, err = readFixed(h, payload, minf.Vmhd)
+		case smhdType:
+			minf.Smhd = &SoundMediaHeaderBox{}
+			_, err = readFixed(h, payload, minf.Smhd)
+		case hmhdType:
+			minf.Hmhd = &HintMediaHeaderBox{}
+			_, err = readFixed(h, payload, minf.Hmhd)
+		case nmhdType:
+			minf.Nmhd = &NullMediaHeaderBox{}
+			_, err = readFixed(h, payload, minf.Nmhd)
+		case dinfType:
+			minf.Dinf, err = parseDinf(h, payload, offset+boxHeaderLen(h))
+		case stblType:
+			minf.Stbl, err = parseStbl(h, payload, offset+boxHeaderLen(h), handlerType)
+		default:
+			minf.Boxes = append(minf.Boxes, newRawBox(h, payload, offset))
 		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return minf, err
+}
 
-		boxData := bytes.NewBuffer((moov).Next(int(size - uint64(unsafe.Sizeof(Box{})))))
+func parseDinf(h Box, payload []byte, offset int64) (*DataInformationBox, error) {
+	dinf := &DataInformationBox{Box: h}
 
-		if box.Type == trakType {
-			trakList = append(trakList, boxData)
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		if h.Type == drefType {
+			dinf.Dref, err = parseDref(h, payload)
+		} else {
+			dinf.Boxes = append(dinf.Boxes, newRawBox(h, payload, offset))
 		}
+		return err
+	})
+	return dinf, err
+}
+
+func parseDref(h Box, payload []byte) (*DataReferenceBox, error) {
+	r := newBoxReader(payload)
+
+	dref := &DataReferenceBox{FullBox: r.fullBox(h)}
+	dref.EntryCount = r.u32()
+	if r.err != nil {
+		return dref, r.err
 	}
 
-	fmt.Println()
-	for _, trak := range trakList {
-		trackHeaderBox := TrackHeaderBox{}
-		binary.Read(trak, binary.BigEndian, &trackHeaderBox)
+	err := eachBox(payload[r.off:], 0, func(h Box, payload []byte, offset int64) error {
+		r := newBoxReader(payload)
 
-		fmt.Printf("TrackHeaderBox %+v\n", trackHeaderBox)
+		entry := DataEntryBox{FullBox: r.fullBox(h)}
+		if h.Type == urnType && r.Len() != 0 {
+			entry.Name = r.cstring()
+		}
+		if (h.Type == urlType || h.Type == urnType) && r.Len() != 0 {
+			entry.Location = r.cstring()
+		}
+		dref.Entries = append(dref.Entries, entry)
+		return r.err
+	})
+	return dref, err
+}
 
-		var mdia *bytes.Buffer
+func parseStbl(h Box, payload []byte, offset int64, handlerType [4]byte) (*SampleTableBox, error) {
+	stbl := &SampleTableBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case stsdType:
+			stbl.Stsd, err = parseStsd(h, payload, offset+boxHeaderLen(h), handlerType)
+		case sttsType:
+			stbl.Stts, err = parseStts(h, payload)
+		case cttsType:
+			stbl.Ctts, err = parseCtts(h, payload)
+		case stssType:
+			stbl.Stss, err = parseStss(h, payload)
+		case stpsType:
+			stbl.Stps, err = parseStss(h, payload)
+		case sdtpType:
+			stbl.Sdtp, err = parseSdtp(h, payload)
+		case stscType:
+			stbl.Stsc, err = parseStsc(h, payload)
+
+		// Exactly one variant 'stsz' or 'stz2' must be present
+		case stszType:
+			stbl.Stsz, err = parseStsz(h, payload)
+		case stz2Type:
+			stbl.Stz2, err = parseStz2(h, payload)
+
+		// Exactly one variant 'stco' or 'co64' must be present
+		case stcoType, co64Type:
+			stbl.Stco, err = parseStco(h, payload)
+
+		case saizType:
+			var saiz *SampleAuxiliaryInformationSizesBox
+			saiz, err = parseSaiz(h, payload)
+			stbl.Saiz = append(stbl.Saiz, saiz)
+		case saioType:
+			var saio *SampleAuxiliaryInformationOffsetsBox
+			saio, err = parseSaio(h, payload)
+			stbl.Saio = append(stbl.Saio, saio)
+		case sbgpType:
+			var sbgp *SampleToGroupBox
+			sbgp, err = parseSbgp(h, payload)
+			stbl.Sbgp = append(stbl.Sbgp, sbgp)
+		case sgpdType:
+			var sgpd *SampleGroupDescriptionBox
+			sgpd, err = parseSgpd(h, payload)
+			stbl.Sgpd = append(stbl.Sgpd, sgpd)
+
+		default:
+			stbl.Boxes = append(stbl.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return stbl, err
+}
 
-		for trak.Len() != 0 {
-			box := Box{}
-			if err := binary.Read(trak, binary.BigEndian, &box); err != nil {
-				fmt.Println("binary.Read failed:", err)
-			}
+func parseStsd(h Box, payload []byte, offset int64, handlerType [4]byte) (*SampleDescriptionBox, error) {
+	r := newBoxReader(payload)
 
-			fmt.Printf("{Size:%d Type:%s}\n", box.Size, box.Type)
+	stsd := &SampleDescriptionBox{FullBox: r.fullBox(h)}
+	stsd.EntryCount = r.u32()
+	if r.err != nil {
+		return stsd, r.err
+	}
 
-			if box.Type == mdiaType {
-				mdia = bytes.NewBuffer(trak.Next(int(box.Size - uint32(unsafe.Sizeof(Box{})))))
-			} else {
-				trak.Next(int(box.Size - uint32(unsafe.Sizeof(Box{}))))
-			}
+	err := eachBox(payload[r.off:], offset+int64(r.off), func(h Box, payload []byte, offset int64) error {
+		entry, err := parseSampleEntry(h, payload, offset+boxHeaderLen(h), handlerType)
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
 		}
+		stsd.Entries = append(stsd.Entries, entry)
+		return nil
+	})
+	return stsd, err
+}
 
-		mediaHeaderBox := MediaHeaderBox{}
-		binary.Read(mdia, binary.BigEndian, &mediaHeaderBox)
+func parseSampleEntry(h Box, payload []byte, offset int64, handlerType [4]byte) (*SampleDescription, error) {
+	entry := &SampleDescription{}
+	n, err := readFixed(h, payload, &entry.SampleEntry)
+	if err != nil {
+		return entry, err
+	}
 
-		fmt.Printf("\tMediaHeaderBox {FullBox:{Box:{Size:%d Type:%s} Version:%d Flags:%d} CreationTime:%d ModificationTime:%d Timescale:%d Duration:%d Language:%d PreDefined:%d}\n",
-			mediaHeaderBox.Size, mediaHeaderBox.Type, mediaHeaderBox.Version, mediaHeaderBox.Flags, mediaHeaderBox.CreationTime, mediaHeaderBox.ModificationTime, mediaHeaderBox.Timescale, mediaHeaderBox.Duration, mediaHeaderBox.Language, mediaHeaderBox.PreDefined)
-		//fmt.Printf("%016b\n", mediaHeaderBox.Language)
-		//fmt.Printf("%x, %x, %x\n", 0b10101+0x60, 0b01110+0x60, 0b00100+0x60) // und 0 10101 01110 00100
+	switch handlerType {
+	case HandlerSoun:
+		entry.Audio = &AudioSampleEntry{}
+		if n, err = readFixed(h, payload, entry.Audio); err != nil {
+			return entry, err
+		}
 
-		handlerBox := HandlerBox{}
-		binary.Read(mdia, binary.BigEndian, &handlerBox)
-		name, _ := (mdia).ReadString('\x00')
+		// QuickTime sound descriptions keep a version in the first reserved field and append extra fields.
+		extra := 0
+		switch entry.Audio.Reserved[0] >> 16 {
+		case 1:
+			extra = 16
+		case 2:
+			extra = 36
+		}
+		if n+extra > len(payload) {
+			return entry, errShortBox
+		}
+		entry.Data = payload[n : n+extra]
+		n += extra
 
-		fmt.Printf("\tHandlerBox {FullBox:{Box:{Size:%d Type:%s} Version:%d Flags:%d} PreDefined:%d HandlerType:%s Reserved:%d} Name:%s\n",
-			handlerBox.Size, handlerBox.Type, handlerBox.Version, handlerBox.Flags, handlerBox.PreDefined, handlerBox.HandlerType, handlerBox.Reserved, name)
+	case HandlerVide:
+		entry.Visual = &VisualSampleEntry{}
+		if n, err = readFixed(h, payload, entry.Visual); err != nil {
+			return entry, err
+		}
 
-		box := Box{}
-		binary.Read(mdia, binary.BigEndian, &box)
+	default:
+		entry.Data = payload[n:]
+		return entry, nil
+	}
 
-		fmt.Printf("\t{Size:%d Type:%s}\n", box.Size, box.Type)
+	if n > len(payload) {
+		return entry, errShortBox
+	}
 
-		minf := bytes.NewBuffer((mdia).Next(int(box.Size - uint32(unsafe.Sizeof(Box{})))))
+	err = eachBox(payload[n:], offset+int64(n), func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case avcCType:
+			entry.AvcC, err = parseAvcC(h, payload)
+		case hvcCType:
+			entry.HvcC, err = parseHvcC(h, payload)
+		case esdsType:
+			entry.Esds, err = parseEsds(h, payload)
+		case sinfType:
+			entry.Sinf, err = parseSinf(h, payload, offset+boxHeaderLen(h))
+		case waveType:
+			entry.Boxes = append(entry.Boxes, newRawBox(h, payload, offset))
+			err = eachBox(payload, offset+int64(boxHeaderLen(h)), func(h Box, payload []byte, offset int64) (err error) {
+				if h.Type == esdsType {
+					entry.Esds, err = parseEsds(h, payload)
+				}
+				return err
+			})
+		default:
+			entry.Boxes = append(entry.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return entry, err
+}
+
+func parseAvcC(h Box, payload []byte) (*AVCConfigurationBox, error) {
+	r := newBoxReader(payload)
 
-		fullBox := FullBox{}
-		binary.Read(minf, binary.BigEndian, &fullBox)
+	avcC := &AVCConfigurationBox{Box: h}
+	avcC.ConfigurationVersion = r.u8()
+	avcC.AVCProfileIndication = r.u8()
+	avcC.ProfileCompatibility = r.u8()
+	avcC.AVCLevelIndication = r.u8()
+	avcC.LengthSizeMinusOne = r.u8() & 0b00000011
+	avcC.NumOfSequenceParameterSets = r.u8() & 0b00011111
 
-		fmt.Printf("\t\ttypeMediaHeaderBox {Box:{Size:%d Type:%s} Version:%d Flags:%d} ", fullBox.Size, fullBox.Type, fullBox.Version, fullBox.Flags)
+	for i := 0; i < int(avcC.NumOfSequenceParameterSets); i++ {
+		sequenceParameterSetLength := r.u16()
+		avcC.SequenceParameterSets = append(avcC.SequenceParameterSets, r.next(int(sequenceParameterSetLength)))
+	}
 
-		var typeMediaHeaderBox interface{}
-		if fullBox.Type == vmhdType {
-			header := VideoMediaHeaderBox{}
-			binary.Read(minf, binary.BigEndian, &header)
-			typeMediaHeaderBox = header
-		} else if fullBox.Type == smhdType {
-			header := SoundMediaHeaderBox{}
-			binary.Read(minf, binary.BigEndian, &header)
-			typeMediaHeaderBox = header
-		} else if fullBox.Type == hmhdType {
-			header := HintMediaHeaderBox{}
-			binary.Read(minf, binary.BigEndian, &header)
-			typeMediaHeaderBox = header
-		} else if fullBox.Type == nmhdType {
-			typeMediaHeaderBox = NullMediaHeaderBox{}
+	numOfPictureParameterSets := r.u8()
+	for i := 0; i < int(numOfPictureParameterSets); i++ {
+		pictureParameterSetLength := r.u16()
+		avcC.PictureParameterSets = append(avcC.PictureParameterSets, r.next(int(pictureParameterSetLength)))
+	}
+
+	if hasAvcCExt(avcC.AVCProfileIndication) && r.err == nil && r.Len() >= 4 {
+		avcC.Ext = &AVCConfigurationBoxExt{}
+		avcC.Ext.ChromaFormat = r.u8() & 0b00000011
+		avcC.Ext.BitDepthLumaMinus8 = r.u8() & 0b00000111
+		avcC.Ext.BitDepthChromaMinus8 = r.u8() & 0b00000111
+		avcC.Ext.NumOfSequenceParameterSetExt = r.u8()
+		for i := 0; i < int(avcC.Ext.NumOfSequenceParameterSetExt); i++ {
+			sequenceParameterSetExtLength := r.u16()
+			avcC.SequenceParameterSetExts = append(avcC.SequenceParameterSetExts, r.next(int(sequenceParameterSetExtLength)))
 		}
+	}
+	return avcC, r.err
+}
 
-		fmt.Printf("%+v\n", typeMediaHeaderBox)
+func parseHvcC(h Box, payload []byte) (*HEVCConfigurationBox, error) {
+	r := newBoxReader(payload)
+
+	hvcC := &HEVCConfigurationBox{Box: h}
+	hvcC.ConfigurationVersion = r.u8()
+	v := r.u8()
+	hvcC.GeneralProfileSpace = v >> 6
+	hvcC.GeneralTierFlag = v&0x20 != 0
+	hvcC.GeneralProfileIdc = v & 0x1F
+	hvcC.GeneralProfileCompatibilityFlags = r.u32()
+	hvcC.GeneralConstraintIndicatorFlags = uint64(r.u32())<<16 | uint64(r.u16())
+	hvcC.GeneralLevelIdc = r.u8()
+	hvcC.MinSpatialSegmentationIdc = r.u16() & 0x0FFF
+	hvcC.ParallelismType = r.u8() & 0b00000011
+	hvcC.ChromaFormat = r.u8() & 0b00000011
+	hvcC.BitDepthLumaMinus8 = r.u8() & 0b00000111
+	hvcC.BitDepthChromaMinus8 = r.u8() & 0b00000111
+	hvcC.AvgFrameRate = r.u16()
+	v = r.u8()
+	hvcC.ConstantFrameRate = v >> 6
+	hvcC.NumTemporalLayers = v >> 3 & 0b111
+	hvcC.TemporalIDNested = v&0b100 != 0
+	hvcC.LengthSizeMinusOne = v & 0b11
+
+	numOfArrays := r.u8()
+	for i := 0; i < int(numOfArrays) && r.err == nil; i++ {
+		v := r.u8()
+		array := HEVCNalUnitArray{ArrayCompleteness: v&0x80 != 0, NalUnitType: v & 0x3F}
+		numNalus := r.u16()
+		for j := 0; j < int(numNalus) && r.err == nil; j++ {
+			nalUnitLength := r.u16()
+			array.NalUnits = append(array.NalUnits, r.next(int(nalUnitLength)))
+		}
+		hvcC.Arrays = append(hvcC.Arrays, array)
+	}
+	return hvcC, r.err
+}
 
-		binary.Read(minf, binary.BigEndian, &box)
+// hasAvcCExt reports whether the decoder configuration of the profile ends with the AVCConfigurationBoxExt.
+func hasAvcCExt(profile uint8) bool {
+	return profile == 100 || profile == 110 || profile == 122 || profile == 144
+}
 
-		fmt.Printf("\t\t{Size:%d Type:%s}\n", box.Size, box.Type)
+func parseStts(h Box, payload []byte) (*TimeToSampleBox, error) {
+	r := newBoxReader(payload)
 
-		dataReferenceBox := DataReferenceBox{}
-		binary.Read(minf, binary.BigEndian, &dataReferenceBox)
+	stts := &TimeToSampleBox{FullBox: r.fullBox(h)}
+	stts.EntryCount = r.u32()
+	stts.Entries = make([]TimeToSampleEntry, r.entries(stts.EntryCount, 8))
+	for i := range stts.Entries {
+		stts.Entries[i].SampleCount = r.u32()
+		stts.Entries[i].SampleDelta = r.u32()
+	}
+	return stts, r.err
+}
 
-		fmt.Printf("\t\t\tDataReferenceBox {FullBox:{Box:{Size:%d Type:%s} Version:%d Flags:%d} EntryCount:%d}\n",
-			dataReferenceBox.Size, dataReferenceBox.Type, dataReferenceBox.Version, dataReferenceBox.Flags, dataReferenceBox.EntryCount)
+func parseStsc(h Box, payload []byte) (*SampleToChunkBox, error) {
+	r := newBoxReader(payload)
 
-		for i := 1; i <= int(dataReferenceBox.EntryCount); i++ {
-			binary.Read(minf, binary.BigEndian, &fullBox)
+	stsc := &SampleToChunkBox{FullBox: r.fullBox(h)}
+	stsc.EntryCount = r.u32()
+	stsc.Entries = make([]SampleToChunkEntry, r.entries(stsc.EntryCount, 12))
+	for i := range stsc.Entries {
+		stsc.Entries[i].FirstChunk = r.u32()
+		stsc.Entries[i].SamplesPerChunk = r.u32()
+		stsc.Entries[i].SampleDescriptionIndex = r.u32()
+	}
+	return stsc, r.err
+}
 
-			fmt.Printf("\t\t\t{Box:{Size:%d Type:%s} Version:%d Flags:%d} ", fullBox.Size, fullBox.Type, fullBox.Version, fullBox.Flags)
+func parseStsz(h Box, payload []byte) (*SampleSizeBox, error) {
+	r := newBoxReader(payload)
 
-			if fullBox.Size > uint32(unsafe.Sizeof(FullBox{})) {
-				var name string
-				if fullBox.Type == urnType {
-					name, _ = minf.ReadString('\x00')
-				}
-				location, _ := minf.ReadString('\x00')
+	stsz := &SampleSizeBox{FullBox: r.fullBox(h)}
+	stsz.SampleSize = r.u32()
+	stsz.SampleCount = r.u32()
+	if stsz.SampleSize == 0 {
+		stsz.EntrySize = make([]uint32, r.entries(stsz.SampleCount, 4))
+		for i := range stsz.EntrySize {
+			stsz.EntrySize[i] = r.u32()
+		}
+	}
+	return stsz, r.err
+}
 
-				fmt.Printf("Name:%s Location:%s\n", name, location)
-			} else {
-				fmt.Println()
+func parseStz2(h Box, payload []byte) (*CompactSampleSizeBox, error) {
+	r := newBoxReader(payload)
+
+	stz2 := &CompactSampleSizeBox{FullBox: r.fullBox(h)}
+	copy(stz2.Reserved[:], r.next(3))
+	stz2.FieldSize = r.u8()
+	stz2.SampleCount = r.u32()
+
+	switch stz2.FieldSize {
+	case 4:
+		stz2.EntrySize = make([]uint32, r.entries((stz2.SampleCount+1)/2, 1)*2)[:stz2.SampleCount]
+		for i := 0; i < len(stz2.EntrySize); i += 2 {
+			b := r.u8()
+			stz2.EntrySize[i] = uint32(b >> 4)
+			if i+1 < len(stz2.EntrySize) {
+				stz2.EntrySize[i+1] = uint32(b & 0x0F)
 			}
 		}
+	case 8:
+		stz2.EntrySize = make([]uint32, r.entries(stz2.SampleCount, 1))
+		for i := range stz2.EntrySize {
+			stz2.EntrySize[i] = uint32(r.u8())
+		}
+	case 16:
+		stz2.EntrySize = make([]uint32, r.entries(stz2.SampleCount, 2))
+		for i := range stz2.EntrySize {
+			stz2.EntrySize[i] = uint32(r.u16())
+		}
+	default:
+		return stz2, fmt.Errorf("mpeg: invalid field size %d", stz2.FieldSize)
+	}
+	return stz2, r.err
+}
 
-		sampleTableBox := SampleTableBox{}
-		binary.Read(minf, binary.BigEndian, &sampleTableBox)
-
-		fmt.Printf("\t\t{Size:%d Type:%s}\n", sampleTableBox.Size, sampleTableBox.Type)
-
-		sampleDescriptionBox := SampleDescriptionBox{}
-		binary.Read(minf, binary.BigEndian, &sampleDescriptionBox)
-
-		fmt.Printf("\t\t\tSampleDescriptionBox {FullBox:{Box:{Size:%d Type:%s} Version:%d Flags:%d} EntryCount:%d}\n",
-			sampleDescriptionBox.Size, sampleDescriptionBox.Type, sampleDescriptionBox.Version, sampleDescriptionBox.Flags, sampleDescriptionBox.EntryCount)
-
-		for i := 1; i <= int(sampleDescriptionBox.EntryCount); i++ {
-			switch handlerBox.HandlerType {
-			case handlerSoun:
-				audioSampleEntry := AudioSampleEntry{}
-				binary.Read(minf, binary.BigEndian, &audioSampleEntry)
-				fmt.Printf("\t\t\tAudioSampleEntry {SampleEntry:{Box:{Size:%d Type:%s} Reserved:%d DataReferenceIndex:%d} Reserved:%d ChannelCount:%d SampleSize:%d PreDefined:%d Reserved_:%d SampleRate:%d}\n",
-					audioSampleEntry.Size, audioSampleEntry.Type, audioSampleEntry.Reserved, audioSampleEntry.DataReferenceIndex, audioSampleEntry.Reserved, audioSampleEntry.ChannelCount, audioSampleEntry.SampleSize, audioSampleEntry.PreDefined, audioSampleEntry.Reserved_, audioSampleEntry.SampleRate)
-
-			case handlerVide:
-				visualSampleEntry := VisualSampleEntry{}
-				binary.Read(minf, binary.BigEndian, &visualSampleEntry)
-
-				fmt.Printf("\t\t\tVisualSampleEntry {SampleEntry:{Box:{Size:%d Type:%s} Reserved:%d DataReferenceIndex:%d} PreDefined:%d Reserved:%d PreDefined_:%d Width:%d Height:%d HorizResolution:%d VertResolution:%d Reserved_:%d FrameCount:%d CompressorName:%s Depth:%d PreDefined__:%d}\n",
-					visualSampleEntry.Size, visualSampleEntry.Type, visualSampleEntry.Reserved, visualSampleEntry.DataReferenceIndex, visualSampleEntry.PreDefined, visualSampleEntry.Reserved, visualSampleEntry.PreDefined_, visualSampleEntry.Width, visualSampleEntry.Height, visualSampleEntry.HorizResolution, visualSampleEntry.VertResolution, visualSampleEntry.Reserved_, visualSampleEntry.FrameCount, visualSampleEntry.CompressorName, visualSampleEntry.Depth, visualSampleEntry.PreDefined__)
-
-				if visualSampleEntry.Type == [4]byte{'a', 'v', 'c', '1'} {
-					avcConfigurationBox := AVCConfigurationBox{}
-					binary.Read(minf, binary.BigEndian, &avcConfigurationBox)
-
-					fmt.Printf("\t\t\tAVCConfigurationBox {Box:{Size:%d Type:%s} AVCConfig:{ConfigurationVersion:%d AVCProfileIndication:%d ProfileCompatibility:%d AVCLevelIndication:%d LengthSizeMinusOne:%d NumOfSequenceParameterSets:%d}}\n",
-						avcConfigurationBox.Size, avcConfigurationBox.Type, avcConfigurationBox.ConfigurationVersion, avcConfigurationBox.AVCProfileIndication, avcConfigurationBox.AVCProfileIndication, avcConfigurationBox.ProfileCompatibility, avcConfigurationBox.LengthSizeMinusOne&0b00000011, avcConfigurationBox.NumOfSequenceParameterSets&0b00011111)
-
-					for i = 0; i < int(avcConfigurationBox.NumOfSequenceParameterSets&0b00011111); i++ {
-						var sequenceParameterSetLength uint16
-						binary.Read(minf, binary.BigEndian, &sequenceParameterSetLength)
-						sequenceParameterSetNALUnit := minf.Next(int(sequenceParameterSetLength)) // sequenceParameterSetNALUnit
-						fmt.Printf("\t\t\tsequenceParameterSetNALUnit %v\n", sequenceParameterSetNALUnit)
-					}
-
-					var numOfPictureParameterSets uint8
-					binary.Read(minf, binary.BigEndian, &numOfPictureParameterSets)
-					for i = 0; i < int(numOfPictureParameterSets); i++ {
-						var pictureParameterSetLength uint16
-						binary.Read(minf, binary.BigEndian, &pictureParameterSetLength)
-						pictureParameterSetNALUnit := minf.Next(int(pictureParameterSetLength)) // pictureParameterSetNALUnit
-						fmt.Printf("\t\t\tpictureParameterSetNALUnit %v\n", pictureParameterSetNALUnit)
-					}
-					//if avcConfigurationBox.AVCProfileIndication == 100 || avcConfigurationBox.AVCProfileIndication == 110 ||
-					//	avcConfigurationBox.AVCProfileIndication == 122 || avcConfigurationBox.AVCProfileIndication == 144 {
-					//	avcConfigurationBoxExt := AVCConfigurationBoxExt{}
-					//	binary.Read(minf, binary.BigEndian, &avcConfigurationBoxExt)
-					//
-					//	fmt.Printf("\t\t\t{ChromaFormat:%d BitDepthLumaMinus8:%d BitDepthChromaMinus8:%d NumOfSequenceParameterSetExt:%d}\n",
-					//		avcConfigurationBoxExt.ChromaFormat&0b00000011, avcConfigurationBoxExt.BitDepthLumaMinus8&0b00000111, avcConfigurationBoxExt.BitDepthChromaMinus8&0b00000111, avcConfigurationBoxExt.NumOfSequenceParameterSetExt)
-					//
-					//	for i = 0; i < 1; i++ {
-					//		var sequenceParameterSetExtLength uint16
-					//		binary.Read(minf, binary.BigEndian, &sequenceParameterSetExtLength)
-					//		fmt.Println("sequenceParameterSetExtLength", sequenceParameterSetExtLength)
-					//		if sequenceParameterSetExtLength != 0 {
-					//			sequenceParameterSetExtNALUnit := minf.Next(int(sequenceParameterSetExtLength))
-					//			fmt.Printf("%s\n", sequenceParameterSetExtNALUnit)
-					//		}
-					//	}
-					//}
-				}
-			case handlerHint:
+func parseStco(h Box, payload []byte) (*ChunkOffsetBox, error) {
+	r := newBoxReader(payload)
 
-			}
+	size := 4
+	if h.Type == co64Type {
+		size = 8
+	}
+
+	stco := &ChunkOffsetBox{FullBox: r.fullBox(h)}
+	stco.EntryCount = r.u32()
+	stco.ChunkOffset = make([]uint64, r.entries(stco.EntryCount, size))
+	for i := range stco.ChunkOffset {
+		if size == 8 {
+			stco.ChunkOffset[i] = r.u64()
+		} else {
+			stco.ChunkOffset[i] = uint64(r.u32())
 		}
+	}
+	return stco, r.err
+}
 
-		for minf.Len() != 0 {
-			binary.Read(minf, binary.BigEndian, &fullBox)
+func parseUdta(h Box, payload []byte, offset int64) (*UserDataBox, error) {
+	udta := &UserDataBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case metaType:
+			udta.Meta, err = parseMeta(h, payload, offset+boxHeaderLen(h))
+		case chplType:
+			udta.Chpl, err = parseChpl(h, payload)
+		default:
+			udta.Boxes = append(udta.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return udta, err
+}
 
-			fmt.Printf("\t\t\t{Size:%d Type:%s} ", fullBox.Size, fullBox.Type)
+// Tracks returns the tracks of the movie in file order.
+func (f *File) Tracks() []*TrackBox {
+	if f.Moov == nil {
+		return nil
+	}
+	return f.Moov.Trak
+}
 
-			if fullBox.Type == sttsType {
-				var entryCount uint32
-				binary.Read(minf, binary.BigEndian, &entryCount)
+// Track returns the track with the track ID or nil.
+func (f *File) Track(id uint32) *TrackBox {
+	for _, trak := range f.Tracks() {
+		if trak.ID() == id {
+			return trak
+		}
+	}
+	return nil
+}
 
-				fmt.Printf("EntryCount:%d\n", entryCount)
+// Timescale returns the number of movie time units per second, the timescale of the edit lists.
+func (f *File) Timescale() uint32 {
+	if f.Moov == nil || f.Moov.Mvhd == nil {
+		return 0
+	}
+	return f.Moov.Mvhd.Timescale
+}
 
-				for i := 1; i <= int(entryCount); i++ {
-					var sampleCount, sampleDelta uint32
-					binary.Read(minf, binary.BigEndian, &sampleCount)
-					binary.Read(minf, binary.BigEndian, &sampleDelta)
-					fmt.Printf("\t\t\tsampleCount %d sampleDelta %d\n", sampleCount, sampleDelta)
-				}
-			} else if fullBox.Type == stscType {
-				var entryCount uint32
-				binary.Read(minf, binary.BigEndian, &entryCount)
-
-				fmt.Printf("EntryCount:%d\n", entryCount)
-
-				for i := 1; i <= int(entryCount); i++ {
-					var firstChunk, samplePerChunk, sampleDescriptionIndex uint32
-					binary.Read(minf, binary.BigEndian, &firstChunk)
-					binary.Read(minf, binary.BigEndian, &samplePerChunk)
-					binary.Read(minf, binary.BigEndian, &sampleDescriptionIndex)
-					//fmt.Printf("\t\t\tfirstChunk %d samplePerChunk %d sampleDescriptionIndex %d\n",
-					//	firstChunk, samplePerChunk, sampleDescriptionIndex)
-				}
+// Duration returns the duration of the movie from the MovieHeaderBox, or from the MovieExtendsHeaderBox if the
+// movie is fragmented and its header has no duration.
+func (f *File) Duration() time.Duration {
+	if f.Moov == nil || f.Moov.Mvhd == nil || f.Moov.Mvhd.Timescale == 0 {
+		return 0
+	}
+	duration := f.Moov.Mvhd.Duration
+	if duration == 0 && f.Fragmented() && f.Moov.Mvex.Mehd != nil {
+		duration = f.Moov.Mvex.Mehd.FragmentDuration
+	}
+	return scaleDuration(duration, f.Moov.Mvhd.Timescale)
+}
 
-				// Exactly one variant 'stsz' or 'stz2' must be present
-			} else if fullBox.Type == stszType {
-				sampleSizeBox := SampleSizeBox{}
-				binary.Read(minf, binary.BigEndian, &sampleSizeBox)
+// ID returns the track ID from the TrackHeaderBox.
+func (t *TrackBox) ID() uint32 {
+	if t.Tkhd == nil {
+		return 0
+	}
+	return t.Tkhd.TrackID
+}
 
-				fmt.Printf("SampleSizeBox:%+v\n", sampleSizeBox)
+// HandlerType returns the handler type of the track media, e.g. HandlerVide or HandlerSoun.
+func (t *TrackBox) HandlerType() [4]byte {
+	if t.Mdia == nil || t.Mdia.Hdlr == nil {
+		return [4]byte{}
+	}
+	return t.Mdia.Hdlr.HandlerType
+}
 
-				if sampleSizeBox.SampleSize == 0 {
-					for i := 1; i <= int(sampleSizeBox.SampleCount); i++ {
-						var entrySize uint32
-						binary.Read(minf, binary.BigEndian, &entrySize)
-					}
-				}
+func (t *TrackBox) IsAudio() bool {
+	return t.HandlerType() == HandlerSoun
+}
 
-			} else if fullBox.Type == stz2Type {
-				compactSampleSizeBox := CompactSampleSizeBox{}
-				binary.Read(minf, binary.BigEndian, &compactSampleSizeBox)
+func (t *TrackBox) IsVideo() bool {
+	return t.HandlerType() == HandlerVide
+}
 
-				fmt.Printf("CompactSampleSizeBox:%+v\n", compactSampleSizeBox)
+// Timescale returns the number of media time units per second.
+func (t *TrackBox) Timescale() uint32 {
+	if t.Mdia == nil || t.Mdia.Mdhd == nil {
+		return 0
+	}
+	return t.Mdia.Mdhd.Timescale
+}
 
-				for i := 1; i <= int(compactSampleSizeBox.SampleCount); i++ {
-					var entrySize = 1 << (compactSampleSizeBox.FieldSize & 0xFF)
-					binary.Read(minf, binary.BigEndian, &entrySize)
-				}
+// Duration returns the duration of the track media.
+func (t *TrackBox) Duration() time.Duration {
+	if t.Timescale() == 0 {
+		return 0
+	}
+	return scaleDuration(t.Mdia.Mdhd.Duration, t.Mdia.Mdhd.Timescale)
+}
 
-				// Exactly one variant 'stco' or 'co64' must be present
-			} else if fullBox.Type == stcoType || fullBox.Type == co64Type {
-				var entryCount uint32
-				binary.Read(minf, binary.BigEndian, &entryCount)
-				fmt.Printf("EntryCount:%d\n", entryCount)
-
-				for i := 1; i <= int(entryCount); i++ {
-					if fullBox.Type == stcoType {
-						var chunkOffset uint32
-						binary.Read(minf, binary.BigEndian, &chunkOffset)
-						//fmt.Printf("\t\t\tchunkOffset %d\n", chunkOffset)
-					}
-				}
-			} else {
-				fmt.Println()
-				minf.Next(int(fullBox.Size - uint32(unsafe.Sizeof(FullBox{}))))
-			}
+// SampleTable returns the SampleTableBox of the track or nil.
+func (t *TrackBox) SampleTable() *SampleTableBox {
+	if t.Mdia == nil || t.Mdia.Minf == nil {
+		return nil
+	}
+	return t.Mdia.Minf.Stbl
+}
+
+// SampleDescriptions returns the sample entries of the track.
+func (t *TrackBox) SampleDescriptions() []*SampleDescription {
+	stbl := t.SampleTable()
+	if stbl == nil || stbl.Stsd == nil {
+		return nil
+	}
+	return stbl.Stsd.Entries
+}
+
+// Format returns the coding name of the first sample entry, e.g. 'mp4a' or 'avc1', the original format if the track
+// is protected.
+func (t *TrackBox) Format() [4]byte {
+	entries := t.SampleDescriptions()
+	if len(entries) == 0 {
+		return [4]byte{}
+	}
+	return entries[0].Format()
+}
+
+// Edit returns the part of the media presented by the edit list of the track in the timescale of the media: the
+// delay of the empty edits before it, the media time where it starts and its duration, -1 if the media is presented
+// up to its end. Only the first edit with media is used, dwells and further edits are ignored.
+func (t *TrackBox) Edit(movieTimescale uint32) (delay, mediaTime, duration int64) {
+	duration = -1
+	if t.Edts == nil || t.Edts.Elst == nil || movieTimescale == 0 {
+		return 0, 0, duration
+	}
+	toMedia := func(d uint64) int64 {
+		return int64(d * uint64(t.Timescale()) / uint64(movieTimescale))
+	}
+
+	for _, entry := range t.Edts.Elst.Entries {
+		if entry.MediaTime == -1 {
+			delay += toMedia(entry.SegmentDuration)
+			continue
 		}
-		fmt.Println()
+		if entry.SegmentDuration != 0 {
+			duration = toMedia(entry.SegmentDuration)
+		}
+		return delay, entry.MediaTime, duration
 	}
+	return delay, 0, duration
 }
 
-var DecodeMp4 = mpeg12
+// LanguageCode returns the ISO-639-2/T language code of the media, "und" if it is not specified.
+func (mdhd *MediaHeaderBox) LanguageCode() string {
+	if mdhd.Language == 0 {
+		return "und"
+	}
+	return string([]byte{
+		byte(mdhd.Language>>10&0x1F) + 0x60,
+		byte(mdhd.Language>>5&0x1F) + 0x60,
+		byte(mdhd.Language&0x1F) + 0x60,
+	})
+}
+
+// Creation returns the creation time of the movie.
+func (mvhd *MovieHeaderBox) Creation() time.Time {
+	return UTS.Add(time.Duration(mvhd.CreationTime) * time.Second)
+}
+
+// Rate returns the integer part of the 16.16 fixed point sample rate.
+func (entry *AudioSampleEntry) Rate() int {
+	return int(entry.SampleRate >> 16)
+}
+
+// scaleDuration converts a duration in timescale units to time.Duration.
+func scaleDuration(d uint64, timescale uint32) time.Duration {
+	return time.Duration(d/uint64(timescale))*time.Second +
+		time.Duration(d%uint64(timescale))*time.Second/time.Duration(timescale)
+}
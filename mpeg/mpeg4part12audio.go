@@ -0,0 +1,189 @@
+package mpeg
+
+import (
+	"awCodec/aac"
+	"awCodec/pcm"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	mp4aType = [4]byte{'m', 'p', '4', 'a'}
+	mp3Type  = [4]byte{'.', 'm', 'p', '3'}
+	esdsType = [4]byte{'e', 's', 'd', 's'}
+	waveType = [4]byte{'w', 'a', 'v', 'e'}
+)
+
+var (
+	errNoAudio    = errors.New("mpeg: no supported audio track")
+	errAudioFrame = errors.New("mpeg: invalid audio frame")
+	errLsfAudio   = errors.New("mpeg: unsupported MPEG-2 low sampling frequency audio")
+)
+
+// audioSpecificConfig returns the AudioSpecificConfig of an AAC sample entry.
+func (entry *SampleDescription) audioSpecificConfig() (*aac.AudioSpecificConfig, error) {
+	if entry.Esds == nil || entry.Esds.ES.DecoderConfig == nil || !entry.Esds.ES.DecoderConfig.IsAac() {
+		return nil, errNoAudio
+	}
+	dcd := entry.Esds.ES.DecoderConfig
+	if dcd.AudioSpecificConfig != nil {
+		return dcd.AudioSpecificConfig, nil
+	}
+	return aac.ParseAudioSpecificConfig(dcd.DecoderSpecificInfo)
+}
+
+// sampleDecoder decodes the samples of an audio track into interleaved PCM. The sampling rate and the channels are
+// known after the first sample.
+type sampleDecoder interface {
+	decodeSample(data []byte) ([]float32, error)
+	sampleRate() int
+	channels() int
+}
+
+type aacSampleDecoder struct {
+	*aac.Decoder
+}
+
+func (d aacSampleDecoder) decodeSample(data []byte) ([]float32, error) { return d.DecodeFrame(data) }
+func (d aacSampleDecoder) sampleRate() int                             { return d.SampleRate() }
+func (d aacSampleDecoder) channels() int                               { return d.Channels() }
+
+// mpegAudioSampleDecoder decodes MPEG-1 audio samples, a sample holds one or more complete frames.
+type mpegAudioSampleDecoder struct {
+	audioDecoder
+	header *audioFrameHeader
+}
+
+func (d *mpegAudioSampleDecoder) decodeSample(data []byte) ([]float32, error) {
+	var samples []float32
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 4 {
+			return samples, errAudioFrame
+		}
+		h, ok := parseAudioFrameHeader(binary.BigEndian.Uint32(data[offset:]))
+		if !ok || offset+h.frameLength > len(data) {
+			return samples, errAudioFrame
+		}
+		d.header = h
+		samples = append(samples, d.decodeFrame(h, data[offset:offset+h.frameLength])...)
+		offset += h.frameLength
+	}
+	return samples, nil
+}
+
+func (d *mpegAudioSampleDecoder) sampleRate() int {
+	if d.header == nil {
+		return 0
+	}
+	return d.header.sampleRate
+}
+
+func (d *mpegAudioSampleDecoder) channels() int {
+	if d.header == nil {
+		return 0
+	}
+	return d.header.nch
+}
+
+// newSampleDecoder returns the decoder of the first sample entry of an audio track, errNoAudio if the format is not
+// supported and errLsfAudio for MPEG-2 audio at low sampling frequencies.
+func newSampleDecoder(trak *TrackBox) (sampleDecoder, error) {
+	entries := trak.SampleDescriptions()
+	if !trak.IsAudio() || len(entries) == 0 {
+		return nil, errNoAudio
+	}
+	entry := entries[0]
+
+	switch {
+	case entry.Format() == mp3Type:
+		return &mpegAudioSampleDecoder{}, nil
+	case entry.Format() != mp4aType || entry.Esds == nil || entry.Esds.ES.DecoderConfig == nil:
+		return nil, errNoAudio
+	case entry.Esds.ES.DecoderConfig.ObjectTypeIndication == ObjectTypeMpeg2Audio:
+		return nil, errLsfAudio
+	case entry.Esds.ES.DecoderConfig.IsMpegAudio():
+		return &mpegAudioSampleDecoder{}, nil
+	}
+
+	config, err := entry.audioSpecificConfig()
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := aac.NewDecoder(config)
+	if err != nil {
+		return nil, err
+	}
+	return aacSampleDecoder{decoder}, nil
+}
+
+// DecodeMp4 decodes the first AAC or MPEG-1 audio track of an MP4 file. The part of the track selected by its edit
+// list is output.
+func DecodeMp4(file []byte) (*pcm.F32LE, error) {
+	var out = &pcm.F32LE{}
+
+	demuxer, err := NewDemuxer(bytes.NewReader(file))
+	if err != nil {
+		return out, err
+	}
+
+	for _, trak := range demuxer.File.Tracks() {
+		decoder, err := newSampleDecoder(trak)
+		if err == errNoAudio {
+			continue
+		} else if err != nil {
+			return out, fmt.Errorf("track %d: %w", trak.ID(), err)
+		}
+
+		var samples []float32
+		var firstTime uint64
+		for i := range demuxer.Samples(trak.ID()) {
+			packet, err := demuxer.ReadSample(trak.ID(), i)
+			if err != nil {
+				return out, err
+			}
+			frame, err := decoder.decodeSample(packet.Data)
+			if err != nil {
+				return out, fmt.Errorf("track %d sample %d: %w", trak.ID(), i, err)
+			}
+			samples = append(samples, frame...)
+			if i == 0 {
+				// Program config elements are found in the first frame.
+				out.Context().SampleRate = decoder.sampleRate()
+				out.Context().Channels = decoder.channels()
+				firstTime = packet.DecodeTime
+			}
+		}
+
+		delay, mediaTime, duration := demuxer.File.Edit(trak.ID())
+		out.Append(applyEdit(samples, int64(trak.Timescale()), delay, mediaTime-int64(firstTime), duration, out.Context()))
+		return out, nil
+	}
+
+	return out, errNoAudio
+}
+
+// applyEdit cuts the decoded samples of a track to the part presented by an edit, which starts at skip and lasts
+// duration in the timescale of the media. Empty edits before it, the delay, are output as silence.
+func applyEdit(samples []float32, timescale, delay, skip, duration int64, context *pcm.Context) []float32 {
+	if timescale == 0 || context.Channels == 0 {
+		return samples
+	}
+	toSamples := func(t int64) int {
+		return int(t*int64(context.SampleRate)/timescale) * context.Channels
+	}
+
+	if start := toSamples(skip); start >= len(samples) {
+		samples = nil
+	} else if start > 0 {
+		samples = samples[start:]
+	}
+	if duration >= 0 && toSamples(duration) < len(samples) {
+		samples = samples[:toSamples(duration)]
+	}
+	if delay > 0 {
+		samples = append(make([]float32, toSamples(delay)), samples...)
+	}
+	return samples
+}
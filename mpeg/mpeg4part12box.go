@@ -0,0 +1,261 @@
+package mpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	errShortBox = errors.New("mpeg: box is truncated")
+	errBoxSize  = errors.New("mpeg: invalid box size")
+)
+
+// boxReader reads big-endian fields of a box payload. The first error is kept and every later read returns zero.
+type boxReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func newBoxReader(b []byte) *boxReader {
+	return &boxReader{b: b}
+}
+
+// Len returns the number of unread bytes.
+func (r *boxReader) Len() int {
+	return len(r.b) - r.off
+}
+
+func (r *boxReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > r.Len() {
+		r.err = errShortBox
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *boxReader) u8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *boxReader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *boxReader) u24() uint32 {
+	if b := r.next(3); b != nil {
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	}
+	return 0
+}
+
+func (r *boxReader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *boxReader) u64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// uint reads unsigned int(32) for version 0 boxes and unsigned int(64) for version 1 boxes.
+func (r *boxReader) uint(version uint8) uint64 {
+	if version == 1 {
+		return r.u64()
+	}
+	return uint64(r.u32())
+}
+
+func (r *boxReader) fourCC() (t [4]byte) {
+	copy(t[:], r.next(4))
+	return
+}
+
+// cstring reads a null-terminated string. A missing terminator ends the string at the end of the payload.
+func (r *boxReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	b := r.b[r.off:]
+	if i := bytes.IndexByte(b, 0x00); i != -1 {
+		r.off += i + 1
+		return string(b[:i])
+	}
+	r.off = len(r.b)
+	return string(b)
+}
+
+// entries checks that count entries of size bytes each fit into the rest of the payload.
+func (r *boxReader) entries(count uint32, size int) int {
+	if r.err == nil && uint64(count)*uint64(size) > uint64(r.Len()) {
+		r.err = errShortBox
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(count)
+}
+
+func (r *boxReader) fullBox(h Box) FullBox {
+	return FullBox{Box: h, Version: r.u8(), Flags: [3]byte{r.u8(), r.u8(), r.u8()}}
+}
+
+// Flag returns the 24-bit flags of the box as an integer.
+func (fullBox FullBox) Flag() uint32 {
+	return uint32(fullBox.Flags[0])<<16 | uint32(fullBox.Flags[1])<<8 | uint32(fullBox.Flags[2])
+}
+
+// readFixed decodes a box with a fixed binary layout (a struct starting with Box or FullBox) from the header h and
+// the payload. It returns the number of payload bytes used.
+func readFixed(h Box, payload []byte, v interface{}) (int, error) {
+	n := binary.Size(v) - 8
+	if n < 0 || n > len(payload) {
+		return 0, errShortBox
+	}
+	buf := make([]byte, 8+n)
+	binary.BigEndian.PutUint32(buf, h.Size)
+	copy(buf[4:], h.Type[:])
+	copy(buf[8:], payload)
+	return n, binary.Read(bytes.NewReader(buf), binary.BigEndian, v)
+}
+
+// readBoxHeader reads the header of the box at the start of b. It returns the header, the length of the header and
+// the size of the whole box. A size of 0 means the box extends to the end of b.
+func readBoxHeader(b []byte) (h Box, headerLen int, size uint64, err error) {
+	if len(b) < 8 {
+		return h, 0, 0, errShortBox
+	}
+	h.Size = binary.BigEndian.Uint32(b)
+	copy(h.Type[:], b[4:8])
+
+	headerLen = 8
+	size = uint64(h.Size)
+	if h.Size == 1 {
+		if len(b) < 16 {
+			return h, 0, 0, errShortBox
+		}
+		size = binary.BigEndian.Uint64(b[8:])
+		headerLen = 16
+	} else if h.Size == 0 {
+		size = uint64(len(b))
+	}
+
+	if size < uint64(headerLen) {
+		return h, 0, 0, fmt.Errorf("%w: %s has size %d", errBoxSize, h.Type, size)
+	}
+	if size > uint64(len(b)) {
+		return h, 0, 0, fmt.Errorf("%w: %s needs %d bytes, %d left", errShortBox, h.Type, size, len(b))
+	}
+	return h, headerLen, size, nil
+}
+
+// eachBox calls fn for every box in b in file order. offset is the position of b in the file, it is used to report
+// the position of every box. Trailing bytes too short for a box header (like the 32-bit terminator of QuickTime user
+// data) are ignored.
+func eachBox(b []byte, offset int64, fn func(h Box, payload []byte, offset int64) error) error {
+	for len(b) >= 8 {
+		h, headerLen, size, err := readBoxHeader(b)
+		if err != nil {
+			return err
+		}
+		if err := fn(h, b[headerLen:size], offset); err != nil {
+			return err
+		}
+		b = b[size:]
+		offset += int64(size)
+	}
+	return nil
+}
+
+// boxHeaderLen returns the length of the header h was read from.
+func boxHeaderLen(h Box) int64 {
+	if h.Size == 1 {
+		return 16
+	}
+	return 8
+}
+
+func newRawBox(h Box, payload []byte, offset int64) *RawBox {
+	return &RawBox{Type: h.Type, Offset: offset, Size: uint64(boxHeaderLen(h)) + uint64(len(payload)), Data: payload}
+}
+
+// boxWriter appends big-endian fields of boxes to a buffer.
+type boxWriter struct {
+	b []byte
+}
+
+func (w *boxWriter) u8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *boxWriter) u16(v uint16) {
+	w.b = binary.BigEndian.AppendUint16(w.b, v)
+}
+
+func (w *boxWriter) u24(v uint32) {
+	w.b = append(w.b, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *boxWriter) u32(v uint32) {
+	w.b = binary.BigEndian.AppendUint32(w.b, v)
+}
+
+func (w *boxWriter) u64(v uint64) {
+	w.b = binary.BigEndian.AppendUint64(w.b, v)
+}
+
+// uint writes a 64-bit field of version 1 boxes or a 32-bit field of version 0 boxes.
+func (w *boxWriter) uint(version uint8, v uint64) {
+	if version == 1 {
+		w.u64(v)
+	} else {
+		w.u32(uint32(v))
+	}
+}
+
+func (w *boxWriter) bytes(b []byte) {
+	w.b = append(w.b, b...)
+}
+
+func (w *boxWriter) cstring(s string) {
+	w.b = append(append(w.b, s...), 0)
+}
+
+func (w *boxWriter) fullBox(h FullBox) {
+	w.u8(h.Version)
+	w.bytes(h.Flags[:])
+}
+
+// fixed writes the fields of a struct which starts with the Box header, the header itself is left out.
+func (w *boxWriter) fixed(v interface{}) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, v)
+	w.bytes(buf.Bytes()[8:])
+}
+
+// box writes a box of type t with the payload written by fn. The size is set when fn returns.
+func (w *boxWriter) box(t [4]byte, fn func()) {
+	start := len(w.b)
+	w.u32(0)
+	w.bytes(t[:])
+	fn()
+	binary.BigEndian.PutUint32(w.b[start:], uint32(len(w.b)-start))
+}
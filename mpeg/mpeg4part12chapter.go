@@ -0,0 +1,254 @@
+package mpeg
+
+import (
+	"encoding/binary"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Chapters of audiobooks and movies: the Nero chapter list in the movie user data and QuickTime chapter tracks
+
+var (
+	trefType = [4]byte{'t', 'r', 'e', 'f'}
+	chapType = [4]byte{'c', 'h', 'a', 'p'}
+	chplType = [4]byte{'c', 'h', 'p', 'l'}
+	encdType = [4]byte{'e', 'n', 'c', 'd'}
+	gmhdType = [4]byte{'g', 'm', 'h', 'd'}
+	gminType = [4]byte{'g', 'm', 'i', 'n'}
+	textType = [4]byte{'t', 'e', 'x', 't'}
+
+	HandlerText = [4]byte{'t', 'e', 'x', 't'}
+)
+
+// Chapter is a named part of the presentation.
+type Chapter struct {
+	Start    time.Duration
+	Duration time.Duration
+	Title    string
+}
+
+// TrackReferenceBox links a track to other tracks, e.g. 'chap' to its QuickTime chapter track.
+type TrackReferenceBox struct {
+	Box
+	References []*TrackReferenceTypeBox
+}
+
+// TrackReferenceTypeBox lists the referenced tracks, its type is the type of the reference.
+type TrackReferenceTypeBox struct {
+	Box
+	TrackIDs []uint32 // unsigned int(32)[]
+}
+
+// ChapterListBox is the Nero chapter list 'chpl'.
+type ChapterListBox struct {
+	FullBox
+	Reserved     uint32 // unsigned int(32), version 1 only
+	ChapterCount uint8  // unsigned int(8)
+	Entries      []ChapterListEntry
+}
+
+type ChapterListEntry struct {
+	StartTime uint64 // unsigned int(64) in units of 100 nanoseconds
+	Title     string // unsigned int(8) length and UTF-8 title
+}
+
+func parseTref(h Box, payload []byte) (*TrackReferenceBox, error) {
+	tref := &TrackReferenceBox{Box: h}
+
+	err := eachBox(payload, 0, func(h Box, payload []byte, offset int64) error {
+		r := newBoxReader(payload)
+		ref := &TrackReferenceTypeBox{Box: h}
+		for r.Len() >= 4 {
+			ref.TrackIDs = append(ref.TrackIDs, r.u32())
+		}
+		tref.References = append(tref.References, ref)
+		return nil
+	})
+	return tref, err
+}
+
+func parseChpl(h Box, payload []byte) (*ChapterListBox, error) {
+	r := newBoxReader(payload)
+
+	chpl := &ChapterListBox{FullBox: r.fullBox(h)}
+	if chpl.Version != 0 {
+		chpl.Reserved = r.u32()
+	}
+	chpl.ChapterCount = r.u8()
+	for i := 0; i < int(chpl.ChapterCount) && r.err == nil; i++ {
+		entry := ChapterListEntry{StartTime: r.u64()}
+		entry.Title = string(r.next(int(r.u8())))
+		chpl.Entries = append(chpl.Entries, entry)
+	}
+	return chpl, r.err
+}
+
+func (tref *TrackReferenceBox) write(w *boxWriter) {
+	w.box(trefType, func() {
+		for _, ref := range tref.References {
+			w.box(ref.Type, func() {
+				for _, id := range ref.TrackIDs {
+					w.u32(id)
+				}
+			})
+		}
+	})
+}
+
+func (chpl *ChapterListBox) write(w *boxWriter) {
+	w.box(chplType, func() {
+		w.fullBox(chpl.FullBox)
+		if chpl.Version != 0 {
+			w.u32(chpl.Reserved)
+		}
+		w.u8(uint8(len(chpl.Entries)))
+		for _, entry := range chpl.Entries {
+			w.u64(entry.StartTime)
+			w.u8(uint8(len(entry.Title)))
+			w.bytes([]byte(entry.Title))
+		}
+	})
+}
+
+// References returns the IDs of the tracks referenced by the track with the reference type, e.g. 'chap'.
+func (t *TrackBox) References(referenceType [4]byte) []uint32 {
+	if t.Tref == nil {
+		return nil
+	}
+	for _, ref := range t.Tref.References {
+		if ref.Type == referenceType {
+			return ref.TrackIDs
+		}
+	}
+	return nil
+}
+
+// ChapterTrack returns the QuickTime chapter track referenced by the first track with a 'chap' reference, nil if
+// there is none.
+func (f *File) ChapterTrack() *TrackBox {
+	for _, trak := range f.Tracks() {
+		for _, id := range trak.References(chapType) {
+			if chapters := f.Track(id); chapters != nil {
+				return chapters
+			}
+		}
+	}
+	return nil
+}
+
+// ChapterList returns the chapters of the Nero chapter list, nil if the movie has none. A chapter lasts until the
+// next one, the last one until the end of the movie.
+func (f *File) ChapterList() []Chapter {
+	if f.Moov == nil || f.Moov.Udta == nil || f.Moov.Udta.Chpl == nil {
+		return nil
+	}
+
+	entries := f.Moov.Udta.Chpl.Entries
+	chapters := make([]Chapter, len(entries))
+	for i, entry := range entries {
+		chapters[i] = Chapter{Start: time.Duration(entry.StartTime) * 100, Title: entry.Title}
+	}
+	for i := range chapters {
+		end := f.Duration()
+		if i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		}
+		if end > chapters[i].Start {
+			chapters[i].Duration = end - chapters[i].Start
+		}
+	}
+	return chapters
+}
+
+// Chapters returns the chapters of the movie in presentation order. The samples of the QuickTime chapter track are
+// read if the movie has one, otherwise the Nero chapter list is used. It returns nil if there are no chapters.
+func (d *Demuxer) Chapters() ([]Chapter, error) {
+	trak := d.File.ChapterTrack()
+	if trak == nil || trak.Timescale() == 0 {
+		return d.File.ChapterList(), nil
+	}
+	track := d.track(trak.ID())
+
+	var chapters []Chapter
+	for i := range track.samples {
+		p, err := d.readPacket(track, i)
+		if err != nil {
+			return chapters, err
+		}
+		chapter := Chapter{Duration: scaleDuration(uint64(p.Duration), p.Timescale), Title: chapterTitle(p.Data)}
+		if p.PresentationTime > 0 {
+			chapter.Start = scaleDuration(uint64(p.PresentationTime), p.Timescale)
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters, nil
+}
+
+// chapterTitle decodes the text of a sample of a chapter track, a 16-bit length and UTF-8 or UTF-16 text with a
+// byte order mark. Boxes after the text, like 'encd', are ignored.
+func chapterTitle(sample []byte) string {
+	if len(sample) < 2 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(sample))
+	text := sample[2:]
+	if n < len(text) {
+		text = text[:n]
+	}
+
+	if len(text) >= 2 && (text[0] == 0xFE && text[1] == 0xFF || text[0] == 0xFF && text[1] == 0xFE) {
+		var order binary.ByteOrder = binary.BigEndian
+		if text[0] == 0xFF {
+			order = binary.LittleEndian
+		}
+		units := make([]uint16, (len(text)-2)/2)
+		for i := range units {
+			units[i] = order.Uint16(text[2+2*i:])
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(text)
+}
+
+// chapterSample encodes a title as a sample of a chapter track with an 'encd' box declaring UTF-8.
+func chapterSample(title string) []byte {
+	w := &boxWriter{}
+	w.u16(uint16(len(title)))
+	w.bytes([]byte(title))
+	w.box(encdType, func() { w.u32(0x00000100) })
+	return w.b
+}
+
+// textMediaHeader returns the base media information header of QuickTime text tracks, a 'gmhd' box with the
+// default graphics mode and the identity matrix of the text.
+func textMediaHeader() *RawBox {
+	w := &boxWriter{}
+	w.box(gminType, func() {
+		w.u32(0)
+		w.u16(0x0040) // ditherCopy
+		w.u16(0x8000)
+		w.u16(0x8000)
+		w.u16(0x8000)
+		w.u16(0) // balance
+		w.u16(0)
+	})
+	w.box(textType, func() {
+		for _, v := range [9]uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+			w.u32(v)
+		}
+	})
+	return &RawBox{Type: gmhdType, Data: w.b}
+}
+
+// chapterListTitle shortens a title to the 255 bytes of the Nero chapter list without splitting a character.
+func chapterListTitle(title string) string {
+	if len(title) <= 255 {
+		return title
+	}
+	n := 255
+	for n > 0 && !utf8.RuneStart(title[n]) {
+		n--
+	}
+	return title[:n]
+}
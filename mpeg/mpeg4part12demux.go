@@ -0,0 +1,251 @@
+package mpeg
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	freeType = [4]byte{'f', 'r', 'e', 'e'}
+	skipType = [4]byte{'s', 'k', 'i', 'p'}
+)
+
+// ReadMp4 reads the box tree of an ISO base media file without loading the media data. The Data of 'mdat', 'free'
+// and 'skip' boxes is nil, their position and size are kept.
+func ReadMp4(r io.ReadSeeker) (*File, error) {
+	f := &File{}
+
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return f, err
+	}
+	offset, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return f, err
+	}
+
+	header := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err == io.EOF {
+			break
+		} else if err != nil {
+			return f, err
+		}
+
+		h := Box{Size: binary.BigEndian.Uint32(header), Type: [4]byte{header[4], header[5], header[6], header[7]}}
+		headerLen := int64(8)
+		size := uint64(h.Size)
+		if h.Size == 1 {
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return f, err
+			}
+			size = binary.BigEndian.Uint64(header[8:])
+			headerLen = 16
+		} else if h.Size == 0 {
+			size = uint64(end - offset)
+		}
+		if size < uint64(headerLen) {
+			return f, fmt.Errorf("%w: %s has size %d", errBoxSize, h.Type, size)
+		}
+
+		switch h.Type {
+		case mdatType, freeType, skipType:
+			box := &RawBox{Type: h.Type, Offset: offset, Size: size}
+			if h.Type == mdatType {
+				f.Mdat = append(f.Mdat, box)
+			} else {
+				f.Boxes = append(f.Boxes, box)
+			}
+			if _, err := r.Seek(offset+int64(size), io.SeekStart); err != nil {
+				return f, err
+			}
+
+		default:
+			// The payload is loaded, its size must not exceed the rest of the file.
+			if size > uint64(end-offset) {
+				return f, fmt.Errorf("%w: %s has size %d beyond the end of the file", errBoxSize, h.Type, size)
+			}
+			payload := make([]byte, size-uint64(headerLen))
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return f, fmt.Errorf("%s: %w", h.Type, err)
+			}
+			if err := f.addBox(h, payload, offset); err != nil {
+				return f, err
+			}
+		}
+
+		offset += int64(size)
+	}
+
+	if f.Moov == nil {
+		return f, fmt.Errorf("mpeg: no %s box", moovType)
+	}
+	return f, nil
+}
+
+// Packet is a sample of a track read by the Demuxer. Times are in the timescale of the track media.
+type Packet struct {
+	TrackID         uint32
+	Sample          int // Index of the sample in the track, starting with 0
+	Data            []byte
+	DecodeTime      uint64
+	CompositionTime int64
+	Duration        uint32
+	Timescale       uint32
+	Keyframe        bool
+
+	// PresentationTime is the composition time on the timeline of the movie after the edit list. It is negative
+	// for samples before the edit, like the priming samples of audio tracks, which are decoded but not presented.
+	PresentationTime int64
+}
+
+// Time returns the decoding timestamp of the packet.
+func (p *Packet) Time() time.Duration {
+	if p.Timescale == 0 {
+		return 0
+	}
+	return scaleDuration(p.DecodeTime, p.Timescale)
+}
+
+type demuxTrack struct {
+	trak      *TrackBox
+	samples   []Sample
+	next      int
+	delay     int64 // Empty edits before the media
+	mediaTime int64 // Start of the edit in the media
+
+	encryption []*SampleEncryption // Encryption of each sample, nil if the track is not protected
+}
+
+// Demuxer reads the samples of all tracks of an MP4 file in interleaved decoding order. Only the 'moov' and 'moof'
+// boxes and the sample index are kept in memory, samples are read on demand.
+//
+// Samples protected by Common Encryption are decrypted if Keys is set, it maps the KIDs to their AES-128 keys.
+type Demuxer struct {
+	File *File
+	Keys map[[16]byte][]byte
+
+	r       io.ReadSeeker
+	tracks  []*demuxTrack
+	ciphers map[[16]byte]cipher.Block
+}
+
+// NewDemuxer reads the box tree from r and resolves the sample tables and movie fragments of all tracks.
+func NewDemuxer(r io.ReadSeeker) (*Demuxer, error) {
+	f, err := ReadMp4(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Demuxer{File: f, r: r}
+	for _, trak := range f.Tracks() {
+		samples, err := f.Samples(trak.ID())
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", trak.ID(), err)
+		}
+		track := &demuxTrack{trak: trak, samples: samples}
+		track.delay, track.mediaTime, _ = f.Edit(trak.ID())
+		if track.encryption, err = f.SampleEncryption(trak.ID(), r); err != nil {
+			return nil, fmt.Errorf("track %d: %w", trak.ID(), err)
+		}
+		d.tracks = append(d.tracks, track)
+	}
+	return d, nil
+}
+
+func (d *Demuxer) track(trackID uint32) *demuxTrack {
+	for _, track := range d.tracks {
+		if track.trak.ID() == trackID {
+			return track
+		}
+	}
+	return nil
+}
+
+// Samples returns the sample index of the track.
+func (d *Demuxer) Samples(trackID uint32) []Sample {
+	if track := d.track(trackID); track != nil {
+		return track.samples
+	}
+	return nil
+}
+
+// ReadPacket returns the next sample in decoding order over all tracks. Samples of different tracks are ordered by
+// their decoding time in seconds and then by their position in the file. It returns io.EOF after the last sample.
+func (d *Demuxer) ReadPacket() (*Packet, error) {
+	var next *demuxTrack
+	for _, track := range d.tracks {
+		if track.next >= len(track.samples) || track.trak.Timescale() == 0 {
+			continue
+		}
+		if next == nil || track.before(next) {
+			next = track
+		}
+	}
+	if next == nil {
+		return nil, io.EOF
+	}
+
+	packet, err := d.readPacket(next, next.next)
+	if err != nil {
+		return nil, err
+	}
+	next.next++
+	return packet, nil
+}
+
+// ReadSample reads the sample i of the track.
+func (d *Demuxer) ReadSample(trackID uint32, i int) (*Packet, error) {
+	track := d.track(trackID)
+	if track == nil {
+		return nil, fmt.Errorf("mpeg: no track %d", trackID)
+	}
+	if i < 0 || i >= len(track.samples) {
+		return nil, fmt.Errorf("mpeg: track %d has no sample %d", trackID, i)
+	}
+	return d.readPacket(track, i)
+}
+
+func (d *Demuxer) readPacket(track *demuxTrack, i int) (*Packet, error) {
+	sample := track.samples[i]
+
+	data := make([]byte, sample.Size)
+	if _, err := d.r.Seek(sample.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		return nil, fmt.Errorf("track %d sample %d: %w", track.trak.ID(), i, err)
+	}
+	if err := d.decrypt(track, i, data); err != nil {
+		return nil, fmt.Errorf("track %d sample %d: %w", track.trak.ID(), i, err)
+	}
+
+	return &Packet{
+		TrackID:         track.trak.ID(),
+		Sample:          i,
+		Data:            data,
+		DecodeTime:      sample.DecodeTime,
+		CompositionTime: sample.CompositionTime(),
+		Duration:        sample.Duration,
+		Timescale:       track.trak.Timescale(),
+		Keyframe:        sample.Sync,
+
+		PresentationTime: sample.CompositionTime() - track.mediaTime + track.delay,
+	}, nil
+}
+
+// before reports whether the next sample of track is read before the next sample of other.
+func (track *demuxTrack) before(other *demuxTrack) bool {
+	a, b := track.samples[track.next], other.samples[other.next]
+
+	// a.DecodeTime / timescaleA < b.DecodeTime / timescaleB
+	x := a.DecodeTime * uint64(other.trak.Timescale())
+	y := b.DecodeTime * uint64(track.trak.Timescale())
+	if x != y {
+		return x < y
+	}
+	return a.Offset < b.Offset
+}
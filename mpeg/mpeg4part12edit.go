@@ -0,0 +1,74 @@
+package mpeg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// gaplessInfo is the iTunSMPB comment of iTunes, the number of priming and padding samples and the number of
+// samples of the original audio.
+type gaplessInfo struct {
+	delay   int64
+	padding int64
+	samples int64
+}
+
+// Edit returns the part of the media of the track presented by its edit list, see TrackBox.Edit. Audio tracks
+// without an edit list use the encoder delay and the length of the iTunSMPB comment instead.
+func (f *File) Edit(trackID uint32) (delay, mediaTime, duration int64) {
+	trak := f.Track(trackID)
+	if trak == nil {
+		return 0, 0, -1
+	}
+	if trak.Edts != nil && trak.Edts.Elst != nil || !trak.IsAudio() {
+		return trak.Edit(f.Timescale())
+	}
+	info, ok := f.gaplessInfo()
+	if !ok {
+		return 0, 0, -1
+	}
+
+	// The samples are counted at the sampling rate of the sample entry, which may differ from the timescale.
+	timescale := int64(trak.Timescale())
+	rate := timescale
+	if entries := trak.SampleDescriptions(); len(entries) > 0 && entries[0].Audio != nil && entries[0].Audio.Rate() != 0 {
+		rate = int64(entries[0].Audio.Rate())
+	}
+	if rate == 0 {
+		return 0, 0, -1
+	}
+	duration = -1
+	if info.samples > 0 {
+		duration = info.samples * timescale / rate
+	}
+	return 0, info.delay * timescale / rate, duration
+}
+
+// gaplessInfo returns the iTunSMPB comment of the iTunes metadata.
+func (f *File) gaplessInfo() (info gaplessInfo, ok bool) {
+	tags := f.Tags()
+	if tags == nil {
+		return info, false
+	}
+	value, ok := tags.FreeformValue("com.apple.iTunes", "iTunSMPB")
+	if !ok {
+		return info, false
+	}
+
+	// " 00000000 00000840 000001CA 00000000003F31F6 ..." with the delay, the padding and the length in hex.
+	fields := strings.Fields(value)
+	if len(fields) < 4 {
+		return info, false
+	}
+	var err error
+	if info.delay, err = strconv.ParseInt(fields[1], 16, 64); err != nil {
+		return info, false
+	}
+	if info.padding, err = strconv.ParseInt(fields[2], 16, 64); err != nil {
+		return info, false
+	}
+	if info.samples, err = strconv.ParseInt(fields[3], 16, 64); err != nil {
+		return info, false
+	}
+	return info, true
+}
@@ -0,0 +1,617 @@
+package mpeg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Movie fragments (ISO/IEC 14496-12 8.8) and segment indexes (8.16.3)
+
+var errFragment = errors.New("mpeg: invalid movie fragment")
+
+// Flags of the TrackFragmentHeaderBox
+const (
+	tfhdBaseDataOffsetPresent         = 0x000001
+	tfhdSampleDescriptionIndexPresent = 0x000002
+	tfhdDefaultSampleDurationPresent  = 0x000008
+	tfhdDefaultSampleSizePresent      = 0x000010
+	tfhdDefaultSampleFlagsPresent     = 0x000020
+	tfhdDurationIsEmpty               = 0x010000
+	tfhdDefaultBaseIsMoof             = 0x020000
+)
+
+// Flags of the TrackRunBox
+const (
+	trunDataOffsetPresent                   = 0x000001
+	trunFirstSampleFlagsPresent             = 0x000004
+	trunSampleDurationPresent               = 0x000100
+	trunSampleSizePresent                   = 0x000200
+	trunSampleFlagsPresent                  = 0x000400
+	trunSampleCompositionTimeOffsetsPresent = 0x000800
+)
+
+// sampleIsNonSyncSample is the sample_is_non_sync_sample bit of the sample flags (8.8.3.1).
+const sampleIsNonSyncSample = 0x00010000
+
+type MovieExtendsHeaderBox struct {
+	FullBox
+	FragmentDuration uint64 // unsigned int(32) / unsigned int(64)
+}
+
+type TrackExtendsBox struct {
+	FullBox
+	TrackID                       uint32 // unsigned int(32)
+	DefaultSampleDescriptionIndex uint32 // unsigned int(32)
+	DefaultSampleDuration         uint32 // unsigned int(32)
+	DefaultSampleSize             uint32 // unsigned int(32)
+	DefaultSampleFlags            uint32 // unsigned int(32)
+}
+
+// MovieExtendsBox signals that the movie has fragments and holds the defaults of the track fragments.
+type MovieExtendsBox struct {
+	Box
+	Mehd  *MovieExtendsHeaderBox
+	Trex  []*TrackExtendsBox
+	Boxes []*RawBox
+}
+
+type MovieFragmentHeaderBox struct {
+	FullBox
+	SequenceNumber uint32 // unsigned int(32)
+}
+
+// TrackFragmentHeaderBox holds the defaults of a track fragment. The optional fields are present if their flag is
+// set, otherwise the defaults of the TrackExtendsBox apply.
+type TrackFragmentHeaderBox struct {
+	FullBox
+	TrackID                uint32 // unsigned int(32)
+	BaseDataOffset         uint64 // unsigned int(64)
+	SampleDescriptionIndex uint32 // unsigned int(32)
+	DefaultSampleDuration  uint32 // unsigned int(32)
+	DefaultSampleSize      uint32 // unsigned int(32)
+	DefaultSampleFlags     uint32 // unsigned int(32)
+}
+
+type TrackFragmentBaseMediaDecodeTimeBox struct {
+	FullBox
+	BaseMediaDecodeTime uint64 // unsigned int(32) / unsigned int(64)
+}
+
+// TrackRunBox is a run of contiguous samples of a track fragment. The fields of the entries are present if their
+// flag is set, otherwise the defaults of the track fragment apply. A run without per sample fields has no entries.
+type TrackRunBox struct {
+	FullBox
+	SampleCount      uint32 // unsigned int(32)
+	DataOffset       int32  // signed int(32)
+	FirstSampleFlags uint32 // unsigned int(32)
+	Entries          []TrackRunEntry
+}
+
+type TrackRunEntry struct {
+	SampleDuration              uint32 // unsigned int(32)
+	SampleSize                  uint32 // unsigned int(32)
+	SampleFlags                 uint32 // unsigned int(32)
+	SampleCompositionTimeOffset int32  // unsigned int(32) for version 0, signed int(32) for version 1
+}
+
+type TrackFragmentBox struct {
+	Box
+	Tfhd  *TrackFragmentHeaderBox
+	Tfdt  *TrackFragmentBaseMediaDecodeTimeBox
+	Trun  []*TrackRunBox
+	Senc  *SampleEncryptionBox
+	Saiz  []*SampleAuxiliaryInformationSizesBox
+	Saio  []*SampleAuxiliaryInformationOffsetsBox
+	Sbgp  []*SampleToGroupBox
+	Sgpd  []*SampleGroupDescriptionBox
+	Boxes []*RawBox
+}
+
+type MovieFragmentBox struct {
+	Box
+	Offset int64 // Position of the box header in the file, the default base of the data offsets
+	Mfhd   *MovieFragmentHeaderBox
+	Traf   []*TrackFragmentBox
+	Pssh   []*ProtectionSystemSpecificHeaderBox
+	Boxes  []*RawBox
+}
+
+// SegmentIndexBox indexes the subsegments of a track in a segment.
+type SegmentIndexBox struct {
+	FullBox
+	ReferenceID              uint32 // unsigned int(32)
+	Timescale                uint32 // unsigned int(32)
+	EarliestPresentationTime uint64 // unsigned int(32) / unsigned int(64)
+	FirstOffset              uint64 // unsigned int(32) / unsigned int(64)
+	Reserved                 uint16 // unsigned int(16)
+	ReferenceCount           uint16 // unsigned int(16)
+	References               []SegmentReference
+}
+
+type SegmentReference struct {
+	ReferenceType      bool   // bit(1), set if the reference is to a SegmentIndexBox
+	ReferencedSize     uint32 // unsigned int(31)
+	SubsegmentDuration uint32 // unsigned int(32)
+	StartsWithSAP      bool   // bit(1)
+	SAPType            uint8  // unsigned int(3)
+	SAPDeltaTime       uint32 // unsigned int(28)
+}
+
+// TrackFragmentRandomAccessBox lists the sync samples of a track in the movie fragments.
+type TrackFragmentRandomAccessBox struct {
+	FullBox
+	TrackID               uint32 // unsigned int(32)
+	LengthSizeOfTrafNum   uint8  // const unsigned int(26) reserved and unsigned int(2)
+	LengthSizeOfTrunNum   uint8  // unsigned int(2)
+	LengthSizeOfSampleNum uint8  // unsigned int(2)
+	NumberOfEntry         uint32 // unsigned int(32)
+	Entries               []TrackFragmentRandomAccessEntry
+}
+
+type TrackFragmentRandomAccessEntry struct {
+	Time         uint64 // unsigned int(32) / unsigned int(64)
+	MoofOffset   uint64 // unsigned int(32) / unsigned int(64)
+	TrafNumber   uint32 // unsigned int((LengthSizeOfTrafNum+1)*8)
+	TrunNumber   uint32 // unsigned int((LengthSizeOfTrunNum+1)*8)
+	SampleNumber uint32 // unsigned int((LengthSizeOfSampleNum+1)*8)
+}
+
+type MovieFragmentRandomAccessOffsetBox struct {
+	FullBox
+	Size uint32 // unsigned int(32)
+}
+
+// MovieFragmentRandomAccessBox is found at the end of fragmented files.
+type MovieFragmentRandomAccessBox struct {
+	Box
+	Tfra  []*TrackFragmentRandomAccessBox
+	Mfro  *MovieFragmentRandomAccessOffsetBox
+	Boxes []*RawBox
+}
+
+var (
+	mvexType = [4]byte{'m', 'v', 'e', 'x'}
+	mehdType = [4]byte{'m', 'e', 'h', 'd'}
+	trexType = [4]byte{'t', 'r', 'e', 'x'}
+	moofType = [4]byte{'m', 'o', 'o', 'f'}
+	mfhdType = [4]byte{'m', 'f', 'h', 'd'}
+	trafType = [4]byte{'t', 'r', 'a', 'f'}
+	tfhdType = [4]byte{'t', 'f', 'h', 'd'}
+	tfdtType = [4]byte{'t', 'f', 'd', 't'}
+	trunType = [4]byte{'t', 'r', 'u', 'n'}
+	sidxType = [4]byte{'s', 'i', 'd', 'x'}
+	mfraType = [4]byte{'m', 'f', 'r', 'a'}
+	tfraType = [4]byte{'t', 'f', 'r', 'a'}
+	mfroType = [4]byte{'m', 'f', 'r', 'o'}
+)
+
+func parseMvex(h Box, payload []byte, offset int64) (*MovieExtendsBox, error) {
+	mvex := &MovieExtendsBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case mehdType:
+			r := newBoxReader(payload)
+			mvex.Mehd = &MovieExtendsHeaderBox{FullBox: r.fullBox(h)}
+			mvex.Mehd.FragmentDuration = r.uint(mvex.Mehd.Version)
+			err = r.err
+		case trexType:
+			trex := &TrackExtendsBox{}
+			_, err = readFixed(h, payload, trex)
+			mvex.Trex = append(mvex.Trex, trex)
+		default:
+			mvex.Boxes = append(mvex.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return mvex, err
+}
+
+func parseMoof(h Box, payload []byte, offset int64) (*MovieFragmentBox, error) {
+	moof := &MovieFragmentBox{Box: h, Offset: offset}
+
+	err := eachBox(payload, offset+boxHeaderLen(h), func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case mfhdType:
+			moof.Mfhd = &MovieFragmentHeaderBox{}
+			_, err = readFixed(h, payload, moof.Mfhd)
+		case trafType:
+			var traf *TrackFragmentBox
+			traf, err = parseTraf(h, payload, offset+boxHeaderLen(h))
+			moof.Traf = append(moof.Traf, traf)
+		case psshType:
+			var pssh *ProtectionSystemSpecificHeaderBox
+			pssh, err = parsePssh(h, payload)
+			moof.Pssh = append(moof.Pssh, pssh)
+		default:
+			moof.Boxes = append(moof.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return moof, err
+}
+
+func parseTraf(h Box, payload []byte, offset int64) (*TrackFragmentBox, error) {
+	traf := &TrackFragmentBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case tfhdType:
+			traf.Tfhd, err = parseTfhd(h, payload)
+		case tfdtType:
+			r := newBoxReader(payload)
+			traf.Tfdt = &TrackFragmentBaseMediaDecodeTimeBox{FullBox: r.fullBox(h)}
+			traf.Tfdt.BaseMediaDecodeTime = r.uint(traf.Tfdt.Version)
+			err = r.err
+		case trunType:
+			var trun *TrackRunBox
+			trun, err = parseTrun(h, payload)
+			traf.Trun = append(traf.Trun, trun)
+		case sencType:
+			traf.Senc, err = parseSenc(h, payload)
+		case saizType:
+			var saiz *SampleAuxiliaryInformationSizesBox
+			saiz, err = parseSaiz(h, payload)
+			traf.Saiz = append(traf.Saiz, saiz)
+		case saioType:
+			var saio *SampleAuxiliaryInformationOffsetsBox
+			saio, err = parseSaio(h, payload)
+			traf.Saio = append(traf.Saio, saio)
+		case sbgpType:
+			var sbgp *SampleToGroupBox
+			sbgp, err = parseSbgp(h, payload)
+			traf.Sbgp = append(traf.Sbgp, sbgp)
+		case sgpdType:
+			var sgpd *SampleGroupDescriptionBox
+			sgpd, err = parseSgpd(h, payload)
+			traf.Sgpd = append(traf.Sgpd, sgpd)
+		default:
+			traf.Boxes = append(traf.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return traf, err
+}
+
+func parseTfhd(h Box, payload []byte) (*TrackFragmentHeaderBox, error) {
+	r := newBoxReader(payload)
+
+	tfhd := &TrackFragmentHeaderBox{FullBox: r.fullBox(h)}
+	flags := tfhd.Flag()
+	tfhd.TrackID = r.u32()
+	if flags&tfhdBaseDataOffsetPresent != 0 {
+		tfhd.BaseDataOffset = r.u64()
+	}
+	if flags&tfhdSampleDescriptionIndexPresent != 0 {
+		tfhd.SampleDescriptionIndex = r.u32()
+	}
+	if flags&tfhdDefaultSampleDurationPresent != 0 {
+		tfhd.DefaultSampleDuration = r.u32()
+	}
+	if flags&tfhdDefaultSampleSizePresent != 0 {
+		tfhd.DefaultSampleSize = r.u32()
+	}
+	if flags&tfhdDefaultSampleFlagsPresent != 0 {
+		tfhd.DefaultSampleFlags = r.u32()
+	}
+	return tfhd, r.err
+}
+
+func parseTrun(h Box, payload []byte) (*TrackRunBox, error) {
+	r := newBoxReader(payload)
+
+	trun := &TrackRunBox{FullBox: r.fullBox(h)}
+	flags := trun.Flag()
+	trun.SampleCount = r.u32()
+	if flags&trunDataOffsetPresent != 0 {
+		trun.DataOffset = int32(r.u32())
+	}
+	if flags&trunFirstSampleFlagsPresent != 0 {
+		trun.FirstSampleFlags = r.u32()
+	}
+
+	size := 0
+	for _, flag := range []uint32{trunSampleDurationPresent, trunSampleSizePresent, trunSampleFlagsPresent,
+		trunSampleCompositionTimeOffsetsPresent} {
+		if flags&flag != 0 {
+			size += 4
+		}
+	}
+	// Runs without per sample fields have no entries, their samples take the defaults of the track fragment.
+	if size > 0 {
+		trun.Entries = make([]TrackRunEntry, r.entries(trun.SampleCount, size))
+	}
+	for i := range trun.Entries {
+		entry := &trun.Entries[i]
+		if flags&trunSampleDurationPresent != 0 {
+			entry.SampleDuration = r.u32()
+		}
+		if flags&trunSampleSizePresent != 0 {
+			entry.SampleSize = r.u32()
+		}
+		if flags&trunSampleFlagsPresent != 0 {
+			entry.SampleFlags = r.u32()
+		}
+		if flags&trunSampleCompositionTimeOffsetsPresent != 0 {
+			entry.SampleCompositionTimeOffset = int32(r.u32())
+		}
+	}
+	return trun, r.err
+}
+
+func parseSidx(h Box, payload []byte) (*SegmentIndexBox, error) {
+	r := newBoxReader(payload)
+
+	sidx := &SegmentIndexBox{FullBox: r.fullBox(h)}
+	sidx.ReferenceID = r.u32()
+	sidx.Timescale = r.u32()
+	sidx.EarliestPresentationTime = r.uint(sidx.Version)
+	sidx.FirstOffset = r.uint(sidx.Version)
+	sidx.Reserved = r.u16()
+	sidx.ReferenceCount = r.u16()
+	sidx.References = make([]SegmentReference, r.entries(uint32(sidx.ReferenceCount), 12))
+	for i := range sidx.References {
+		ref := &sidx.References[i]
+		v := r.u32()
+		ref.ReferenceType = v>>31 != 0
+		ref.ReferencedSize = v & 0x7FFFFFFF
+		ref.SubsegmentDuration = r.u32()
+		v = r.u32()
+		ref.StartsWithSAP = v>>31 != 0
+		ref.SAPType = uint8(v >> 28 & 0x07)
+		ref.SAPDeltaTime = v & 0x0FFFFFFF
+	}
+	return sidx, r.err
+}
+
+func parseMfra(h Box, payload []byte, offset int64) (*MovieFragmentRandomAccessBox, error) {
+	mfra := &MovieFragmentRandomAccessBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case tfraType:
+			var tfra *TrackFragmentRandomAccessBox
+			tfra, err = parseTfra(h, payload)
+			mfra.Tfra = append(mfra.Tfra, tfra)
+		case mfroType:
+			mfra.Mfro = &MovieFragmentRandomAccessOffsetBox{}
+			_, err = readFixed(h, payload, mfra.Mfro)
+		default:
+			mfra.Boxes = append(mfra.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return mfra, err
+}
+
+func parseTfra(h Box, payload []byte) (*TrackFragmentRandomAccessBox, error) {
+	r := newBoxReader(payload)
+
+	tfra := &TrackFragmentRandomAccessBox{FullBox: r.fullBox(h)}
+	tfra.TrackID = r.u32()
+	v := r.u32()
+	tfra.LengthSizeOfTrafNum = uint8(v >> 4 & 0x03)
+	tfra.LengthSizeOfTrunNum = uint8(v >> 2 & 0x03)
+	tfra.LengthSizeOfSampleNum = uint8(v & 0x03)
+	tfra.NumberOfEntry = r.u32()
+
+	size := 8 + int(tfra.LengthSizeOfTrafNum+tfra.LengthSizeOfTrunNum+tfra.LengthSizeOfSampleNum) + 3
+	if tfra.Version == 1 {
+		size += 8
+	}
+	number := func(lengthSize uint8) (n uint32) {
+		for _, b := range r.next(int(lengthSize) + 1) {
+			n = n<<8 | uint32(b)
+		}
+		return n
+	}
+	tfra.Entries = make([]TrackFragmentRandomAccessEntry, r.entries(tfra.NumberOfEntry, size))
+	for i := range tfra.Entries {
+		entry := &tfra.Entries[i]
+		entry.Time = r.uint(tfra.Version)
+		entry.MoofOffset = r.uint(tfra.Version)
+		entry.TrafNumber = number(tfra.LengthSizeOfTrafNum)
+		entry.TrunNumber = number(tfra.LengthSizeOfTrunNum)
+		entry.SampleNumber = number(tfra.LengthSizeOfSampleNum)
+	}
+	return tfra, r.err
+}
+
+// Fragmented reports whether the movie may have fragments.
+func (f *File) Fragmented() bool {
+	return f.Moov != nil && f.Moov.Mvex != nil
+}
+
+// trex returns the defaults of the track fragments of the track or nil.
+func (f *File) trex(trackID uint32) *TrackExtendsBox {
+	if !f.Fragmented() {
+		return nil
+	}
+	for _, trex := range f.Moov.Mvex.Trex {
+		if trex.TrackID == trackID {
+			return trex
+		}
+	}
+	return nil
+}
+
+// Samples returns the sample index of the track: the samples of its sample table followed by the samples of the
+// movie fragments in file order. Fragmented and flat files give the same index for the same media.
+func (f *File) Samples(trackID uint32) ([]Sample, error) {
+	trak := f.Track(trackID)
+	if trak == nil {
+		return nil, fmt.Errorf("mpeg: no track %d", trackID)
+	}
+	end := f.end()
+	samples, err := trak.samples(end)
+	if err != nil {
+		return nil, err
+	}
+
+	var decodeTime uint64
+	if n := len(samples); n > 0 {
+		decodeTime = samples[n-1].DecodeTime + uint64(samples[n-1].Duration)
+	}
+	for _, moof := range f.Moof {
+		dataEnd := moof.Offset
+		for i, traf := range moof.Traf {
+			if traf.Tfhd == nil {
+				return nil, fmt.Errorf("%w: %s without %s", errFragment, trafType, tfhdType)
+			}
+
+			var base int64
+			switch flags := traf.Tfhd.Flag(); {
+			case flags&tfhdBaseDataOffsetPresent != 0:
+				base = int64(traf.Tfhd.BaseDataOffset)
+			case flags&tfhdDefaultBaseIsMoof != 0 || i == 0:
+				base = moof.Offset
+			default:
+				// The data follows the data of the previous track fragment.
+				base = dataEnd
+			}
+
+			var fragment []Sample
+			var err error
+			fragment, dataEnd, err = traf.samples(f.trex(traf.Tfhd.TrackID), base, decodeTime, end)
+			if err != nil {
+				return nil, err
+			}
+			if traf.Tfhd.TrackID == trackID {
+				samples = append(samples, fragment...)
+				if n := len(samples); n > 0 {
+					decodeTime = samples[n-1].DecodeTime + uint64(samples[n-1].Duration)
+				}
+			}
+		}
+	}
+	return samples, nil
+}
+
+// samples resolves the track runs of the fragment with the defaults of trex, which may be nil. base is the base
+// data offset and decodeTime the decoding time of the first sample if the fragment has no 'tfdt', end is the end of
+// the file. It also returns the position after the data of the last run.
+func (traf *TrackFragmentBox) samples(trex *TrackExtendsBox, base int64, decodeTime uint64,
+	end int64) ([]Sample, int64, error) {
+	tfhd := traf.Tfhd
+	flags := tfhd.Flag()
+	if flags&tfhdDurationIsEmpty != 0 {
+		return nil, base, nil
+	}
+	if trex == nil {
+		trex = &TrackExtendsBox{DefaultSampleDescriptionIndex: 1}
+	}
+
+	descriptionIndex := trex.DefaultSampleDescriptionIndex
+	if flags&tfhdSampleDescriptionIndexPresent != 0 {
+		descriptionIndex = tfhd.SampleDescriptionIndex
+	}
+	defaultDuration := trex.DefaultSampleDuration
+	if flags&tfhdDefaultSampleDurationPresent != 0 {
+		defaultDuration = tfhd.DefaultSampleDuration
+	}
+	defaultSize := trex.DefaultSampleSize
+	if flags&tfhdDefaultSampleSizePresent != 0 {
+		defaultSize = tfhd.DefaultSampleSize
+	}
+	defaultFlags := trex.DefaultSampleFlags
+	if flags&tfhdDefaultSampleFlagsPresent != 0 {
+		defaultFlags = tfhd.DefaultSampleFlags
+	}
+	if traf.Tfdt != nil {
+		decodeTime = traf.Tfdt.BaseMediaDecodeTime
+	}
+
+	var samples []Sample
+	offset := base
+	for _, trun := range traf.Trun {
+		runFlags := trun.Flag()
+		if runFlags&trunDataOffsetPresent != 0 {
+			offset = base + int64(trun.DataOffset)
+		}
+		n := trun.sampleCount()
+		if len(trun.Entries) == 0 && n > 0 {
+			// The count of a run without entries is only bounded by its data, which must be in the file.
+			size := uint64(defaultSize)
+			if size == 0 {
+				size = 1
+			}
+			if offset < 0 || offset > end || uint64(n)*size > uint64(end-offset) {
+				return nil, offset, fmt.Errorf("%w: run of %d samples of %d bytes beyond the end of the file",
+					errFragment, n, defaultSize)
+			}
+		}
+		for i := 0; i < n; i++ {
+			var entry TrackRunEntry
+			if i < len(trun.Entries) {
+				entry = trun.Entries[i]
+			}
+			sample := Sample{
+				Offset:                 offset,
+				DecodeTime:             decodeTime,
+				Duration:               defaultDuration,
+				Size:                   defaultSize,
+				SampleDescriptionIndex: descriptionIndex,
+			}
+			if runFlags&trunSampleDurationPresent != 0 {
+				sample.Duration = entry.SampleDuration
+			}
+			if runFlags&trunSampleSizePresent != 0 {
+				sample.Size = entry.SampleSize
+			}
+			sampleFlags := defaultFlags
+			if i == 0 && runFlags&trunFirstSampleFlagsPresent != 0 {
+				sampleFlags = trun.FirstSampleFlags
+			} else if runFlags&trunSampleFlagsPresent != 0 {
+				sampleFlags = entry.SampleFlags
+			}
+			sample.Sync = sampleFlags&sampleIsNonSyncSample == 0
+			sample.Dependency = uint8(sampleFlags >> 20)
+			if runFlags&trunSampleCompositionTimeOffsetsPresent != 0 {
+				sample.CompositionOffset = entry.SampleCompositionTimeOffset
+			}
+
+			samples = append(samples, sample)
+			offset += int64(sample.Size)
+			decodeTime += uint64(sample.Duration)
+		}
+	}
+	return samples, offset, nil
+}
+
+// sampleCount returns the number of samples of the run.
+func (trun *TrackRunBox) sampleCount() int {
+	if len(trun.Entries) > 0 {
+		return len(trun.Entries)
+	}
+	return int(trun.SampleCount)
+}
+
+// end returns the position after the last box of the file.
+func (f *File) end() int64 {
+	var end int64
+	for _, boxes := range [][]*RawBox{f.Mdat, f.Boxes} {
+		for _, box := range boxes {
+			if e := box.Offset + int64(box.Size); e > end {
+				end = e
+			}
+		}
+	}
+	for _, moof := range f.Moof {
+		if e := moof.Offset + int64(moof.Size); e > end {
+			end = e
+		}
+	}
+	return end
+}
@@ -0,0 +1,173 @@
+package mpeg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTrackFragmentSamples(t *testing.T) {
+	trex := &TrackExtendsBox{TrackID: 1, DefaultSampleDescriptionIndex: 1, DefaultSampleDuration: 10,
+		DefaultSampleSize: 100, DefaultSampleFlags: sampleFlagsNonSync}
+	flags := func(flags uint32) FullBox {
+		return FullBox{Flags: [3]byte{byte(flags >> 16), byte(flags >> 8), byte(flags)}}
+	}
+	type want struct {
+		offset     int64
+		size       uint32
+		decodeTime uint64
+		duration   uint32
+		sync       bool
+		index      uint32
+	}
+	for _, test := range []struct {
+		name    string
+		tfhd    *TrackFragmentHeaderBox
+		trun    []*TrackRunBox
+		trex    *TrackExtendsBox
+		samples []want
+	}{
+		{
+			name: "trex defaults",
+			tfhd: &TrackFragmentHeaderBox{TrackID: 1},
+			trun: []*TrackRunBox{{SampleCount: 2}},
+			trex: trex,
+			samples: []want{
+				{1000, 100, 500, 10, false, 1},
+				{1100, 100, 510, 10, false, 1},
+			},
+		},
+		{
+			name: "tfhd defaults",
+			tfhd: &TrackFragmentHeaderBox{FullBox: flags(tfhdSampleDescriptionIndexPresent |
+				tfhdDefaultSampleDurationPresent | tfhdDefaultSampleSizePresent | tfhdDefaultSampleFlagsPresent),
+				TrackID: 1, SampleDescriptionIndex: 2, DefaultSampleDuration: 20, DefaultSampleSize: 50,
+				DefaultSampleFlags: sampleFlagsSync},
+			trun: []*TrackRunBox{{SampleCount: 2}},
+			trex: trex,
+			samples: []want{
+				{1000, 50, 500, 20, true, 2},
+				{1050, 50, 520, 20, true, 2},
+			},
+		},
+		{
+			name: "no trex",
+			tfhd: &TrackFragmentHeaderBox{FullBox: flags(tfhdDefaultSampleSizePresent), TrackID: 1,
+				DefaultSampleSize: 5},
+			trun: []*TrackRunBox{{SampleCount: 2}},
+			samples: []want{
+				{1000, 5, 500, 0, true, 1},
+				{1005, 5, 500, 0, true, 1},
+			},
+		},
+		{
+			name: "first sample flags",
+			tfhd: &TrackFragmentHeaderBox{TrackID: 1},
+			trun: []*TrackRunBox{{FullBox: flags(trunFirstSampleFlagsPresent), SampleCount: 3,
+				FirstSampleFlags: sampleFlagsSync}},
+			trex: trex,
+			samples: []want{
+				{1000, 100, 500, 10, true, 1},
+				{1100, 100, 510, 10, false, 1},
+				{1200, 100, 520, 10, false, 1},
+			},
+		},
+		{
+			name: "first sample flags and sample flags",
+			tfhd: &TrackFragmentHeaderBox{TrackID: 1},
+			trun: []*TrackRunBox{{FullBox: flags(trunFirstSampleFlagsPresent | trunSampleFlagsPresent),
+				FirstSampleFlags: sampleFlagsSync, Entries: []TrackRunEntry{
+					{SampleFlags: sampleFlagsNonSync},
+					{SampleFlags: sampleFlagsSync},
+				}}},
+			trex: trex,
+			samples: []want{
+				{1000, 100, 500, 10, true, 1},
+				{1100, 100, 510, 10, true, 1},
+			},
+		},
+		{
+			name: "entries",
+			tfhd: &TrackFragmentHeaderBox{TrackID: 1},
+			trun: []*TrackRunBox{{FullBox: flags(trunDataOffsetPresent | trunSampleDurationPresent |
+				trunSampleSizePresent), DataOffset: 40,
+				Entries: []TrackRunEntry{{SampleDuration: 3, SampleSize: 7}, {SampleDuration: 4, SampleSize: 8}}}},
+			trex: trex,
+			samples: []want{
+				{1040, 7, 500, 3, false, 1},
+				{1047, 8, 503, 4, false, 1},
+			},
+		},
+		{
+			name: "runs",
+			tfhd: &TrackFragmentHeaderBox{TrackID: 1},
+			trun: []*TrackRunBox{
+				{SampleCount: 1},
+				{FullBox: flags(trunDataOffsetPresent), SampleCount: 1, DataOffset: 300},
+				{SampleCount: 1},
+			},
+			trex: trex,
+			samples: []want{
+				{1000, 100, 500, 10, false, 1},
+				{1300, 100, 510, 10, false, 1},
+				{1400, 100, 520, 10, false, 1},
+			},
+		},
+		{
+			name: "duration is empty",
+			tfhd: &TrackFragmentHeaderBox{FullBox: flags(tfhdDurationIsEmpty), TrackID: 1},
+			trun: []*TrackRunBox{{SampleCount: 2}},
+			trex: trex,
+		},
+	} {
+		traf := &TrackFragmentBox{Tfhd: test.tfhd, Trun: test.trun}
+		samples, _, err := traf.samples(test.trex, 1000, 500, 10000)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(samples) != len(test.samples) {
+			t.Errorf("%s: %d samples, want %d", test.name, len(samples), len(test.samples))
+			continue
+		}
+		for i, s := range samples {
+			got := want{s.Offset, s.Size, s.DecodeTime, s.Duration, s.Sync, s.SampleDescriptionIndex}
+			if got != test.samples[i] {
+				t.Errorf("%s: sample %d is %+v, want %+v", test.name, i, got, test.samples[i])
+			}
+		}
+	}
+}
+
+func TestTrackFragmentSamplesTfdt(t *testing.T) {
+	traf := &TrackFragmentBox{
+		Tfhd: &TrackFragmentHeaderBox{TrackID: 1},
+		Tfdt: &TrackFragmentBaseMediaDecodeTimeBox{BaseMediaDecodeTime: 9000},
+		Trun: []*TrackRunBox{{SampleCount: 1}},
+	}
+	samples, end, err := traf.samples(&TrackExtendsBox{DefaultSampleDuration: 1, DefaultSampleSize: 4}, 0, 500, 100)
+	if err != nil || len(samples) != 1 || samples[0].DecodeTime != 9000 || end != 4 {
+		t.Errorf("samples %+v end %d error %v", samples, end, err)
+	}
+}
+
+func TestTrackFragmentSamplesMalformed(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		trun *TrackRunBox
+		size uint32
+	}{
+		{"beyond file", &TrackRunBox{SampleCount: 11}, 100},
+		{"huge count", &TrackRunBox{SampleCount: 1 << 31}, 0},
+		{"negative offset", &TrackRunBox{FullBox: FullBox{Flags: [3]byte{0, 0, trunDataOffsetPresent}},
+			SampleCount: 1, DataOffset: -2000}, 1},
+	} {
+		traf := &TrackFragmentBox{
+			Tfhd: &TrackFragmentHeaderBox{TrackID: 1},
+			Trun: []*TrackRunBox{test.trun},
+		}
+		trex := &TrackExtendsBox{DefaultSampleSize: test.size}
+		if _, _, err := traf.samples(trex, 1000, 0, 2000); !errors.Is(err, errFragment) {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+	}
+}
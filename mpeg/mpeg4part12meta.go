@@ -0,0 +1,530 @@
+package mpeg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// iTunes metadata: the item list of the 'meta' box in the movie user data
+
+var errRewrite = errors.New("mpeg: cannot rewrite file")
+
+// Type indicators of the 'data' atom of an item
+const (
+	DataTypeImplicit = 0
+	DataTypeUtf8     = 1
+	DataTypeJpeg     = 13
+	DataTypePng      = 14
+	DataTypeInteger  = 21 // Big-endian signed integer of 1, 2, 3, 4 or 8 bytes
+)
+
+// Free space left after the 'moov' box when the media data has to be moved to make room for it.
+const metadataPadding = 1024
+
+var (
+	metaType     = [4]byte{'m', 'e', 't', 'a'}
+	ilstType     = [4]byte{'i', 'l', 's', 't'}
+	freeformType = [4]byte{'-', '-', '-', '-'}
+	meanType     = [4]byte{'m', 'e', 'a', 'n'}
+	nameType     = [4]byte{'n', 'a', 'm', 'e'}
+	dataType     = [4]byte{'d', 'a', 't', 'a'}
+
+	HandlerMdir = [4]byte{'m', 'd', 'i', 'r'}
+
+	titleType       = [4]byte{0xA9, 'n', 'a', 'm'}
+	artistType      = [4]byte{0xA9, 'A', 'R', 'T'}
+	albumArtistType = [4]byte{'a', 'A', 'R', 'T'}
+	albumType       = [4]byte{0xA9, 'a', 'l', 'b'}
+	dateType        = [4]byte{0xA9, 'd', 'a', 'y'}
+	genreType       = [4]byte{0xA9, 'g', 'e', 'n'}
+	genreIDType     = [4]byte{'g', 'n', 'r', 'e'}
+	commentType     = [4]byte{0xA9, 'c', 'm', 't'}
+	trackType       = [4]byte{'t', 'r', 'k', 'n'}
+	discType        = [4]byte{'d', 'i', 's', 'k'}
+	coverType       = [4]byte{'c', 'o', 'v', 'r'}
+)
+
+// MetaBox holds metadata of the format given by its handler, 'mdir' for the item list of iTunes.
+type MetaBox struct {
+	FullBox
+	QuickTime bool // The box of QuickTime files has no version and flags
+	Hdlr      *HandlerBox
+	Ilst      *ItemListBox
+	Boxes     []*RawBox
+}
+
+type ItemListBox struct {
+	Box
+	Items []*MetadataItem
+}
+
+// MetadataItem is an entry of the item list, its type names the tag, e.g. '©nam'. Freeform items of type '----'
+// are named by Mean and Name.
+type MetadataItem struct {
+	Type  [4]byte
+	Mean  string
+	Name  string
+	Data  []MetadataData
+	Boxes []*RawBox
+}
+
+// MetadataData is a value of an item.
+type MetadataData struct {
+	DataType uint32 // unsigned int(8) type set and unsigned int(24) type, e.g. DataTypeUtf8
+	Locale   uint32 // unsigned int(32)
+	Value    []byte
+}
+
+// Tags are the common items of the iTunes metadata.
+type Tags struct {
+	Title       string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Date        string
+	Genre       string
+	Comment     string
+	Track       int
+	TrackTotal  int
+	Disc        int
+	DiscTotal   int
+	Covers      []Cover
+	Freeform    []FreeformTag // Like iTunSMPB and the ReplayGain tags
+}
+
+// Cover is an image of the 'covr' item.
+type Cover struct {
+	DataType uint32 // DataTypeJpeg or DataTypePng
+	Data     []byte
+}
+
+// FreeformTag is the text of a '----' item.
+type FreeformTag struct {
+	Mean  string // Reverse DNS domain, e.g. "com.apple.iTunes"
+	Name  string
+	Value string
+}
+
+// id3v1Genres are the genres of ID3v1, the 'gnre' item holds the index plus one.
+var id3v1Genres = [...]string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop", "Jazz", "Metal",
+	"New Age", "Oldies", "Other", "Pop", "R&B", "Rap", "Reggae", "Rock", "Techno", "Industrial",
+	"Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal",
+	"Jazz+Funk", "Fusion", "Trance", "Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel",
+	"Noise", "AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+}
+
+func parseMeta(h Box, payload []byte, offset int64) (*MetaBox, error) {
+	meta := &MetaBox{FullBox: FullBox{Box: h}}
+
+	// The handler follows the version and flags, or the header directly in QuickTime files.
+	if len(payload) >= 8 && string(payload[4:8]) == string(hdlrType[:]) {
+		meta.QuickTime = true
+	} else {
+		r := newBoxReader(payload)
+		meta.FullBox = r.fullBox(h)
+		if r.err != nil {
+			return meta, r.err
+		}
+		payload = payload[4:]
+		offset += 4
+	}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case hdlrType:
+			meta.Hdlr, err = parseHdlr(h, payload)
+		case ilstType:
+			meta.Ilst, err = parseIlst(h, payload, offset+boxHeaderLen(h))
+		default:
+			meta.Boxes = append(meta.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return meta, err
+}
+
+func parseIlst(h Box, payload []byte, offset int64) (*ItemListBox, error) {
+	ilst := &ItemListBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) error {
+		item := &MetadataItem{Type: h.Type}
+		err := eachBox(payload, offset+boxHeaderLen(h), func(h Box, payload []byte, offset int64) error {
+			r := newBoxReader(payload)
+			switch h.Type {
+			case meanType:
+				r.fullBox(h)
+				item.Mean = string(r.next(r.Len()))
+			case nameType:
+				r.fullBox(h)
+				item.Name = string(r.next(r.Len()))
+			case dataType:
+				data := MetadataData{DataType: r.u32(), Locale: r.u32()}
+				data.Value = r.next(r.Len())
+				item.Data = append(item.Data, data)
+			default:
+				item.Boxes = append(item.Boxes, newRawBox(h, payload, offset))
+			}
+			return r.err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		ilst.Items = append(ilst.Items, item)
+		return nil
+	})
+	return ilst, err
+}
+
+func (meta *MetaBox) write(w *boxWriter) {
+	w.box(metaType, func() {
+		if !meta.QuickTime {
+			w.fullBox(meta.FullBox)
+		}
+		if meta.Hdlr != nil {
+			meta.Hdlr.write(w)
+		}
+		if meta.Ilst != nil {
+			w.box(ilstType, func() {
+				for _, item := range meta.Ilst.Items {
+					item.write(w)
+				}
+			})
+		}
+		writeRawBoxes(w, meta.Boxes)
+	})
+}
+
+func (item *MetadataItem) write(w *boxWriter) {
+	w.box(item.Type, func() {
+		if item.Type == freeformType {
+			w.box(meanType, func() {
+				w.u32(0)
+				w.bytes([]byte(item.Mean))
+			})
+			w.box(nameType, func() {
+				w.u32(0)
+				w.bytes([]byte(item.Name))
+			})
+		}
+		for _, data := range item.Data {
+			w.box(dataType, func() {
+				w.u32(data.DataType)
+				w.u32(data.Locale)
+				w.bytes(data.Value)
+			})
+		}
+		writeRawBoxes(w, item.Boxes)
+	})
+}
+
+// Meta returns the 'meta' box of the movie user data or nil.
+func (f *File) Meta() *MetaBox {
+	if f.Moov == nil || f.Moov.Udta == nil {
+		return nil
+	}
+	return f.Moov.Udta.Meta
+}
+
+// Tags returns the iTunes metadata of the file, nil if it has no item list.
+func (f *File) Tags() *Tags {
+	meta := f.Meta()
+	if meta == nil || meta.Ilst == nil {
+		return nil
+	}
+
+	tags := &Tags{}
+	for _, item := range meta.Ilst.Items {
+		if len(item.Data) == 0 {
+			continue
+		}
+		value := item.Data[0].Value
+		text := string(value)
+		switch item.Type {
+		case titleType:
+			tags.Title = text
+		case artistType:
+			tags.Artist = text
+		case albumArtistType:
+			tags.AlbumArtist = text
+		case albumType:
+			tags.Album = text
+		case dateType:
+			tags.Date = text
+		case genreType:
+			tags.Genre = text
+		case genreIDType:
+			if len(value) == 2 && tags.Genre == "" {
+				if id := int(binary.BigEndian.Uint16(value)); id > 0 && id <= len(id3v1Genres) {
+					tags.Genre = id3v1Genres[id-1]
+				}
+			}
+		case commentType:
+			tags.Comment = text
+		case trackType, discType:
+			// reserved, number and total as unsigned int(16), 'trkn' adds 16 reserved bits
+			if len(value) < 6 {
+				continue
+			}
+			number, total := int(binary.BigEndian.Uint16(value[2:])), int(binary.BigEndian.Uint16(value[4:]))
+			if item.Type == trackType {
+				tags.Track, tags.TrackTotal = number, total
+			} else {
+				tags.Disc, tags.DiscTotal = number, total
+			}
+		case coverType:
+			for _, data := range item.Data {
+				tags.Covers = append(tags.Covers, Cover{DataType: data.DataType, Data: data.Value})
+			}
+		case freeformType:
+			tags.Freeform = append(tags.Freeform, FreeformTag{Mean: item.Mean, Name: item.Name, Value: text})
+		}
+	}
+	return tags
+}
+
+// FreeformValue returns the value of the freeform tag with the mean and name.
+func (tags *Tags) FreeformValue(mean, name string) (string, bool) {
+	for _, tag := range tags.Freeform {
+		if tag.Mean == mean && tag.Name == name {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetTags replaces the items of the iTunes metadata of the movie by the tags. Empty fields remove their items,
+// items which are not part of Tags are kept. The 'udta' and 'meta' boxes are added if they do not exist.
+func (f *File) SetTags(tags *Tags) {
+	if f.Moov.Udta == nil {
+		f.Moov.Udta = &UserDataBox{Box: Box{Type: udtaType}}
+	}
+	meta := f.Moov.Udta.Meta
+	if meta == nil {
+		meta = &MetaBox{
+			FullBox: FullBox{Box: Box{Type: metaType}},
+			Hdlr: &HandlerBox{
+				FullBox:     FullBox{Box: Box{Type: hdlrType}},
+				HandlerType: HandlerMdir,
+				Reserved:    [3]uint32{binary.BigEndian.Uint32([]byte("appl"))},
+			},
+		}
+		f.Moov.Udta.Meta = meta
+	}
+
+	var items []*MetadataItem
+	text := func(t [4]byte, value string) {
+		if value != "" {
+			items = append(items, &MetadataItem{Type: t, Data: []MetadataData{{DataType: DataTypeUtf8, Value: []byte(value)}}})
+		}
+	}
+	number := func(t [4]byte, number, total int, size int) {
+		if number == 0 && total == 0 {
+			return
+		}
+		value := make([]byte, size)
+		binary.BigEndian.PutUint16(value[2:], uint16(number))
+		binary.BigEndian.PutUint16(value[4:], uint16(total))
+		items = append(items, &MetadataItem{Type: t, Data: []MetadataData{{DataType: DataTypeImplicit, Value: value}}})
+	}
+	text(titleType, tags.Title)
+	text(artistType, tags.Artist)
+	text(albumArtistType, tags.AlbumArtist)
+	text(albumType, tags.Album)
+	text(dateType, tags.Date)
+	text(genreType, tags.Genre)
+	text(commentType, tags.Comment)
+	number(trackType, tags.Track, tags.TrackTotal, 8)
+	number(discType, tags.Disc, tags.DiscTotal, 6)
+	if len(tags.Covers) > 0 {
+		item := &MetadataItem{Type: coverType}
+		for _, cover := range tags.Covers {
+			item.Data = append(item.Data, MetadataData{DataType: cover.DataType, Value: cover.Data})
+		}
+		items = append(items, item)
+	}
+	for _, tag := range tags.Freeform {
+		items = append(items, &MetadataItem{
+			Type: freeformType,
+			Mean: tag.Mean,
+			Name: tag.Name,
+			Data: []MetadataData{{DataType: DataTypeUtf8, Value: []byte(tag.Value)}},
+		})
+	}
+
+	if meta.Ilst != nil {
+		for _, item := range meta.Ilst.Items {
+			switch item.Type {
+			case titleType, artistType, albumArtistType, albumType, dateType, genreType, genreIDType, commentType,
+				trackType, discType, coverType, freeformType:
+			default:
+				items = append(items, item)
+			}
+		}
+	} else {
+		meta.Ilst = &ItemListBox{Box: Box{Type: ilstType}}
+	}
+	meta.Ilst.Items = items
+}
+
+// WriteMp4Tags replaces the iTunes metadata of the file in rws. The 'moov' box is written in place if it fits into
+// its old size and the 'free' boxes following it. A 'moov' box after the media data is extended into the end of
+// the file. Otherwise the media data is moved behind the new 'moov' box and some free space, and the chunk offsets
+// are updated. Fragmented files whose media data would move are not supported.
+func WriteMp4Tags(rws io.ReadWriteSeeker, tags *Tags) error {
+	f, err := ReadMp4(rws)
+	if err != nil {
+		return err
+	}
+	fileEnd, err := rws.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	moov := f.Moov
+	start := moov.Offset
+	end := start + int64(moov.Size)
+	switch moov.Size {
+	case 0:
+		end = fileEnd
+	case 1:
+		return fmt.Errorf("%w: 64-bit %s size", errRewrite, moovType)
+	}
+	// Free space following the 'moov' box
+	for found := true; found; {
+		found = false
+		for _, box := range f.Boxes {
+			if box.Offset == end && (box.Type == freeType || box.Type == skipType) {
+				end += int64(box.Size)
+				found = true
+			}
+		}
+	}
+	available := end - start
+	atEnd := end == fileEnd
+
+	f.SetTags(tags)
+	w := &boxWriter{}
+	moov.write(w)
+	size := int64(len(w.b))
+
+	var padding int64
+	switch gap := available - size; {
+	case gap == 0 || gap >= 8:
+		padding = gap
+	case atEnd:
+		// Leaves a 'free' box of at least the size of its header behind the new 'moov' box.
+		if gap > 0 {
+			padding = gap + 8
+		}
+	case f.Fragmented():
+		return fmt.Errorf("%w: fragmented file without space for %s", errRewrite, moovType)
+	default:
+		if w.b, err = f.moveMediaData(end, available); err != nil {
+			return err
+		}
+		size = int64(len(w.b))
+		padding = metadataPadding
+		if err := moveData(rws, end, fileEnd, size+padding-available); err != nil {
+			return err
+		}
+	}
+
+	if padding > 0 {
+		w.u32(uint32(padding))
+		w.bytes(freeType[:])
+		w.bytes(make([]byte, padding-8))
+	}
+	if _, err := rws.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = rws.Write(w.b)
+	return err
+}
+
+// moveMediaData updates the chunk offsets and the offsets of auxiliary information behind end for the new size of
+// the 'moov' box, which is followed by metadataPadding bytes of free space instead of the available bytes. It
+// returns the new 'moov' box. The offsets may need 'co64' boxes or version 1 'saio' boxes, which change the size
+// again.
+func (f *File) moveMediaData(end, available int64) ([]byte, error) {
+	type offsetTable struct {
+		offsets  []uint64 // Offsets of the box
+		original []uint64 // Offsets before the move
+		wide     func()   // Changes the box to 64-bit offsets
+	}
+	var tables []offsetTable
+	for _, trak := range f.Tracks() {
+		stbl := trak.SampleTable()
+		if stbl == nil {
+			continue
+		}
+		if stco := stbl.Stco; stco != nil {
+			original := append([]uint64(nil), stco.ChunkOffset...)
+			tables = append(tables, offsetTable{stco.ChunkOffset, original, func() { stco.Type = co64Type }})
+		}
+		for _, saio := range stbl.Saio {
+			saio := saio
+			original := append([]uint64(nil), saio.Offsets...)
+			tables = append(tables, offsetTable{saio.Offsets, original, func() { saio.Version = 1 }})
+		}
+	}
+
+	w := &boxWriter{}
+	for size := int64(0); ; {
+		shift := size + metadataPadding - available
+		for _, table := range tables {
+			for j, offset := range table.original {
+				if int64(offset) >= end {
+					offset += uint64(shift)
+				}
+				if offset > math.MaxUint32 {
+					table.wide()
+				}
+				table.offsets[j] = offset
+			}
+		}
+
+		w.b = w.b[:0]
+		f.Moov.write(w)
+		if int64(len(w.b)) == size {
+			return w.b, nil
+		}
+		size = int64(len(w.b))
+	}
+}
+
+// moveData moves the bytes from start to end of the file by shift bytes towards its end, starting with the last
+// block.
+func moveData(rws io.ReadWriteSeeker, start, end, shift int64) error {
+	buf := make([]byte, 1<<20)
+	for pos := end; pos > start; {
+		n := int64(len(buf))
+		if pos-start < n {
+			n = pos - start
+		}
+		pos -= n
+		if _, err := rws.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(rws, buf[:n]); err != nil {
+			return err
+		}
+		if _, err := rws.Seek(pos+shift, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := rws.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,775 @@
+package mpeg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+var (
+	errMuxerClosed  = errors.New("mpeg: muxer is closed")
+	errMuxerTrack   = errors.New("mpeg: unknown track")
+	errMuxerCodec   = errors.New("mpeg: unsupported codec")
+	errMuxerChapter = errors.New("mpeg: chapters out of order")
+)
+
+var (
+	alacType = [4]byte{'a', 'l', 'a', 'c'}
+	ipcmType = [4]byte{'i', 'p', 'c', 'm'}
+	fpcmType = [4]byte{'f', 'p', 'c', 'm'}
+	pcmCType = [4]byte{'p', 'c', 'm', 'C'}
+
+	brandM4a  = [4]byte{'M', '4', 'A', ' '}
+	brandIsom = [4]byte{'i', 's', 'o', 'm'}
+	brandIso2 = [4]byte{'i', 's', 'o', '2'}
+	brandMp41 = [4]byte{'m', 'p', '4', '1'}
+)
+
+// Codec is the coding of the packets of a track written by the Muxer or of an elementary stream read by a
+// demuxer.
+type Codec int
+
+const (
+	CodecAac       Codec = iota + 1 // Raw data blocks, MuxTrack.Config is the AudioSpecificConfig
+	CodecMp3                        // MPEG-1 or MPEG-2 audio frames
+	CodecAlac                       // MuxTrack.Config is the ALACSpecificConfig
+	CodecLpcm                       // Interleaved integer or float samples
+	CodecAvc                        // Access units of length prefixed NAL units, MuxTrack.Config is the avcC payload
+	CodecHevc                       // Access units of length prefixed NAL units, MuxTrack.Config is the hvcC payload
+	CodecMpegVideo                  // MPEG-1 or MPEG-2 video, not supported by the Muxer
+	CodecAc3                        // AC-3 syncframes, not supported by the Muxer
+
+	codecText Codec = -1 // Titles of the QuickTime chapter track, added by the Muxer itself
+	codecID3  Codec = -2 // ID3 tags of timed metadata, added by TsMuxer.AddMetadataTrack
+)
+
+// Timescale of the movie, the unit of the durations of the movie and track headers and of the edit lists. A movie
+// with a single track uses the timescale of the track, so its edit list is exact to the sample.
+const movieTimescale = 1000
+
+// MuxTrack describes an audio or video track written by the Muxer.
+type MuxTrack struct {
+	Codec        Codec
+	SampleRate   int
+	Channels     int
+	Width        int    // Width of the pictures of video tracks
+	Height       int    // Height of the pictures of video tracks
+	Timescale    uint32 // Timescale of the packet times, SampleRate if 0 and 90000 for video tracks
+	SampleSize   int    // Bits per sample of ALAC and LPCM, 16 if 0
+	Float        bool   // LPCM samples are IEEE 754 floats
+	LittleEndian bool   // LPCM samples are little-endian
+	Config       []byte // Decoder configuration
+	Language     string // ISO-639-2/T language code, "und" if empty
+	EncoderDelay int    // Priming samples at the start of an audio track, in the timescale of the track
+	Padding      int    // Samples added after the end of an audio track, in the timescale of the track
+}
+
+type muxChunk struct {
+	offset      int64 // Position of the chunk in the media data
+	firstSample int
+	samples     int
+}
+
+type muxTrack struct {
+	MuxTrack
+	id                 uint32
+	avcC               *AVCConfigurationBox
+	hvcC               *HEVCConfigurationBox
+	sizes              []uint32
+	decodeTimes        []uint64
+	durations          []uint32 // 0 until it is known from the next sample
+	compositionOffsets []int32
+	sync               []bool
+	chunks             []muxChunk
+}
+
+// Muxer writes audio and video packets into an MP4 file, an M4A file if all tracks are audio. Packets are stored in
+// the order they are written, packets of a track are grouped into chunks of up to one second.
+//
+// If w is an io.WriteSeeker and Faststart is not set, the media data is written while packets arrive and the
+// 'moov' box follows it. Otherwise the media data is kept in memory and written by Close after the 'moov' box, so
+// players can start before the whole file is loaded.
+type Muxer struct {
+	Faststart    bool
+	CreationTime time.Time
+
+	// Chapters are written by Close into a Nero chapter list and a QuickTime chapter track referenced by the
+	// other tracks. They are ordered by their start, the duration of a chapter is given by the next one.
+	Chapters []Chapter
+
+	w         io.Writer
+	tracks    []*muxTrack
+	started   bool
+	closed    bool
+	streaming bool
+	mdat      []byte // Media data kept in memory
+	mdatStart int64  // Position of the media data in the file while streaming
+	mdatSize  int64
+	last      *muxTrack
+}
+
+// NewMuxer returns a muxer writing to w.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w, CreationTime: time.Now()}
+}
+
+// AddTrack adds a track and returns its ID. Tracks are added before the first packet is written.
+func (m *Muxer) AddTrack(track MuxTrack) (uint32, error) {
+	if m.started || m.closed {
+		return 0, errMuxerClosed
+	}
+	t, err := newMuxTrack(track, uint32(len(m.tracks)+1))
+	if err != nil {
+		return 0, err
+	}
+	m.tracks = append(m.tracks, t)
+	return t.id, nil
+}
+
+// newMuxTrack checks the description of a track and sets its defaults.
+func newMuxTrack(track MuxTrack, id uint32) (*muxTrack, error) {
+	t := &muxTrack{MuxTrack: track, id: id}
+	switch track.Codec {
+	case CodecAac, CodecMp3, CodecAlac, CodecLpcm:
+		if t.Timescale == 0 {
+			t.Timescale = uint32(t.SampleRate)
+		}
+		if t.SampleSize == 0 {
+			t.SampleSize = 16
+		}
+		if t.Timescale == 0 || t.Channels == 0 {
+			return nil, fmt.Errorf("%w: sample rate %d channels %d", errMuxerCodec, t.SampleRate, t.Channels)
+		}
+
+	case CodecAvc, CodecHevc:
+		if t.Timescale == 0 {
+			t.Timescale = 90000
+		}
+		if t.Width <= 0 || t.Height <= 0 || t.Width > math.MaxUint16 || t.Height > math.MaxUint16 {
+			return nil, fmt.Errorf("%w: picture size %dx%d", errMuxerCodec, t.Width, t.Height)
+		}
+		var err error
+		if t.Codec == CodecAvc {
+			if t.avcC, err = parseAvcC(Box{Type: avcCType}, t.Config); err != nil {
+				return nil, fmt.Errorf("%s: %w", avcCType, err)
+			}
+		} else if t.hvcC, err = parseHvcC(Box{Type: hvcCType}, t.Config); err != nil {
+			return nil, fmt.Errorf("%s: %w", hvcCType, err)
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: %d", errMuxerCodec, track.Codec)
+	}
+	return t, nil
+}
+
+func (t *muxTrack) isVideo() bool {
+	return t.Codec == CodecAvc || t.Codec == CodecHevc
+}
+
+// add appends a sample to the track and returns its decoding time. A DecodeTime of 0 after the first sample
+// follows the previous sample.
+func (t *muxTrack) add(p *Packet) uint64 {
+	n := len(t.sizes)
+	decodeTime := p.DecodeTime
+	if n > 0 && decodeTime == 0 {
+		decodeTime = t.decodeTimes[n-1] + uint64(t.durations[n-1])
+	}
+	if n > 0 && t.durations[n-1] == 0 && decodeTime > t.decodeTimes[n-1] {
+		t.durations[n-1] = uint32(decodeTime - t.decodeTimes[n-1])
+	}
+
+	t.sizes = append(t.sizes, uint32(len(p.Data)))
+	t.decodeTimes = append(t.decodeTimes, decodeTime)
+	t.durations = append(t.durations, p.Duration)
+	if t.isVideo() {
+		t.compositionOffsets = append(t.compositionOffsets, int32(p.CompositionTime-int64(decodeTime)))
+		t.sync = append(t.sync, p.Keyframe)
+	} else {
+		t.compositionOffsets = append(t.compositionOffsets, 0)
+		t.sync = append(t.sync, true)
+	}
+	return decodeTime
+}
+
+// finish sets the unknown duration of the last sample to the duration of the sample before it.
+func (t *muxTrack) finish() {
+	if n := len(t.durations); n > 1 && t.durations[n-1] == 0 {
+		t.durations[n-1] = t.durations[n-2]
+	}
+}
+
+// WritePacket appends the data of a packet to its track. DecodeTime and Duration are in the timescale of the track,
+// a Duration of 0 is taken from the DecodeTime of the next packet. The CompositionTime and Keyframe fields are used
+// for video tracks only, samples of audio tracks are always sync samples.
+func (m *Muxer) WritePacket(p *Packet) error {
+	if m.closed {
+		return errMuxerClosed
+	}
+	if p.TrackID == 0 || int(p.TrackID) > len(m.tracks) {
+		return fmt.Errorf("%w: %d", errMuxerTrack, p.TrackID)
+	}
+	t := m.tracks[p.TrackID-1]
+
+	if !m.started {
+		if err := m.start(); err != nil {
+			return err
+		}
+	}
+
+	// Chunks --------------------------------------------------
+	n := len(t.sizes)
+	decodeTime := t.add(p)
+	if m.last != t || len(t.chunks) == 0 || decodeTime-t.decodeTimes[t.chunks[len(t.chunks)-1].firstSample] >= uint64(t.Timescale) {
+		t.chunks = append(t.chunks, muxChunk{offset: m.mdatSize, firstSample: n})
+	}
+	t.chunks[len(t.chunks)-1].samples++
+	m.last = t
+
+	// Media data --------------------------------------------------
+	m.mdatSize += int64(len(p.Data))
+	if m.streaming {
+		_, err := m.w.Write(p.Data)
+		return err
+	}
+	m.mdat = append(m.mdat, p.Data...)
+	return nil
+}
+
+// start writes the 'ftyp' box and the header of the 'mdat' box when the media data is written while packets arrive.
+// The 64-bit size of the 'mdat' box is set by Close.
+func (m *Muxer) start() error {
+	m.started = true
+	if _, ok := m.w.(io.WriteSeeker); !ok || m.Faststart {
+		return nil
+	}
+	m.streaming = true
+
+	w := &boxWriter{}
+	m.fileType().write(w)
+	w.u32(1)
+	w.bytes(mdatType[:])
+	w.u64(0)
+	m.mdatStart = int64(len(w.b))
+	_, err := m.w.Write(w.b)
+	return err
+}
+
+// Close writes the 'moov' box and the media data kept in memory.
+func (m *Muxer) Close() error {
+	if m.closed {
+		return errMuxerClosed
+	}
+	if !m.started {
+		if err := m.start(); err != nil {
+			return err
+		}
+	}
+	m.closed = true
+
+	for _, t := range m.tracks {
+		t.finish()
+	}
+
+	tracks := m.tracks
+	if len(m.Chapters) > 0 {
+		t, data, err := m.chapterTrack()
+		if err != nil {
+			return err
+		}
+		tracks = append(tracks[:len(tracks):len(tracks)], t)
+		m.mdatSize += int64(len(data))
+		if m.streaming {
+			if _, err := m.w.Write(data); err != nil {
+				return err
+			}
+		} else {
+			m.mdat = append(m.mdat, data...)
+		}
+	}
+
+	if m.streaming {
+		w := &boxWriter{}
+		m.movie(tracks, m.mdatStart).write(w)
+		if _, err := m.w.Write(w.b); err != nil {
+			return err
+		}
+
+		ws := m.w.(io.WriteSeeker)
+		if _, err := ws.Seek(m.mdatStart-8, io.SeekStart); err != nil {
+			return err
+		}
+		if err := binary.Write(ws, binary.BigEndian, uint64(m.mdatSize+16)); err != nil {
+			return err
+		}
+		_, err := ws.Seek(0, io.SeekEnd)
+		return err
+	}
+
+	// Faststart --------------------------------------------------
+	w := &boxWriter{}
+	m.fileType().write(w)
+	mdatHeaderLen := int64(8)
+	if m.mdatSize+8 > math.MaxUint32 {
+		mdatHeaderLen = 16
+	}
+
+	// The size of 'moov' only depends on the chunk offsets by the choice of 'stco' or 'co64'.
+	moovStart := len(w.b)
+	for moovSize := int64(0); ; {
+		w.b = w.b[:moovStart]
+		m.movie(tracks, int64(moovStart)+moovSize+mdatHeaderLen).write(w)
+		if int64(len(w.b)-moovStart) == moovSize {
+			break
+		}
+		moovSize = int64(len(w.b) - moovStart)
+	}
+
+	if mdatHeaderLen == 16 {
+		w.u32(1)
+		w.bytes(mdatType[:])
+		w.u64(uint64(m.mdatSize + 16))
+	} else {
+		w.u32(uint32(m.mdatSize + 8))
+		w.bytes(mdatType[:])
+	}
+	if _, err := m.w.Write(w.b); err != nil {
+		return err
+	}
+	_, err := m.w.Write(m.mdat)
+	return err
+}
+
+// movie builds the 'moov' box of the tracks and adds the Nero chapter list and the references to the chapter track.
+func (m *Muxer) movie(tracks []*muxTrack, mdatStart int64) *MovieBox {
+	moov := newMovie(tracks, m.CreationTime, mdatStart)
+	if len(m.Chapters) == 0 {
+		return moov
+	}
+
+	chpl := &ChapterListBox{FullBox: FullBox{Box: Box{Type: chplType}, Version: 1}}
+	for _, chapter := range m.Chapters {
+		if len(chpl.Entries) == math.MaxUint8 {
+			break
+		}
+		chpl.Entries = append(chpl.Entries, ChapterListEntry{
+			StartTime: uint64(chapter.Start / 100),
+			Title:     chapterListTitle(chapter.Title),
+		})
+	}
+	moov.Udta = &UserDataBox{Box: Box{Type: udtaType}, Chpl: chpl}
+
+	chapterTrackID := tracks[len(tracks)-1].id
+	for _, trak := range moov.Trak[:len(moov.Trak)-1] {
+		trak.Tref = &TrackReferenceBox{
+			Box:        Box{Type: trefType},
+			References: []*TrackReferenceTypeBox{{Box: Box{Type: chapType}, TrackIDs: []uint32{chapterTrackID}}},
+		}
+	}
+	return moov
+}
+
+// chapterTrack returns the QuickTime chapter track with a sample per chapter and the data of the samples, which
+// follows the media data written so far. The first sample starts at 0 and the last one ends with the longest track.
+func (m *Muxer) chapterTrack() (*muxTrack, []byte, error) {
+	t := &muxTrack{MuxTrack: MuxTrack{Codec: codecText, Timescale: movieTimescale}, id: uint32(len(m.tracks) + 1)}
+
+	var end time.Duration
+	for _, track := range m.tracks {
+		var duration uint64
+		for _, d := range track.durations {
+			duration += uint64(d)
+		}
+		if d := scaleDuration(duration, track.Timescale); d > end {
+			end = d
+		}
+	}
+
+	var data []byte
+	for i, chapter := range m.Chapters {
+		if i > 0 && chapter.Start <= m.Chapters[i-1].Start {
+			return nil, nil, fmt.Errorf("%w: chapter %d starts at %v", errMuxerChapter, i+1, chapter.Start)
+		}
+		start := uint64(chapter.Start / time.Millisecond)
+		if i == 0 {
+			start = 0
+		}
+		next := uint64(end / time.Millisecond)
+		if i+1 < len(m.Chapters) {
+			next = uint64(m.Chapters[i+1].Start / time.Millisecond)
+		}
+		duration := uint32(1)
+		if next > start {
+			duration = uint32(next - start)
+		}
+
+		sample := chapterSample(chapter.Title)
+		t.add(&Packet{Data: sample, DecodeTime: start, Duration: duration})
+		data = append(data, sample...)
+	}
+	t.chunks = []muxChunk{{offset: m.mdatSize, samples: len(t.sizes)}}
+	return t, data, nil
+}
+
+// fileType returns the 'ftyp' box, of the M4A brand for audio only files and of the isom brand otherwise.
+func (m *Muxer) fileType() *FileTypeBox {
+	ftyp := &FileTypeBox{
+		Box:              Box{Type: ftypType},
+		MajorBrand:       brandM4a,
+		MinorVersion:     [4]byte{0, 0, 2, 0},
+		CompatibleBrands: [][4]byte{brandM4a, brandIsom, brandIso2, brandMp41},
+	}
+	for _, t := range m.tracks {
+		if t.isVideo() {
+			ftyp.MajorBrand = brandIsom
+			ftyp.CompatibleBrands = [][4]byte{brandIsom, brandIso2, brandMp41}
+			break
+		}
+	}
+	return ftyp
+}
+
+// newMovie builds the 'moov' box of the tracks, mdatStart is the position of the media data in the file. The offsets
+// larger than 32 bits are written into 'co64' boxes.
+func newMovie(tracks []*muxTrack, creation time.Time, mdatStart int64) *MovieBox {
+	creationTime := uint64(creation.Sub(UTS) / time.Second)
+	timescale := uint32(movieTimescale)
+	if len(tracks) == 1 {
+		timescale = tracks[0].Timescale
+	}
+	unityMatrix := [9]int32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+	moov := &MovieBox{Box: Box{Type: moovType}}
+	moov.Mvhd = &MovieHeaderBox{
+		FullBox:          FullBox{Box: Box{Type: mvhdType}},
+		CreationTime:     creationTime,
+		ModificationTime: creationTime,
+		Timescale:        timescale,
+		Rate:             0x00010000,
+		Volume:           0x0100,
+		Matrix:           unityMatrix,
+		NextTrackID:      uint32(len(tracks) + 1),
+	}
+
+	for _, t := range tracks {
+		var mediaDuration uint64
+		for _, d := range t.durations {
+			mediaDuration += uint64(d)
+		}
+		trak := &TrackBox{Box: Box{Type: trakType}}
+		trak.Edts = t.editBox(mediaDuration, timescale)
+		duration := mediaDuration * uint64(timescale) / uint64(t.Timescale)
+		if trak.Edts != nil {
+			duration = trak.Edts.Elst.Entries[0].SegmentDuration
+		}
+		if duration > moov.Mvhd.Duration {
+			moov.Mvhd.Duration = duration
+		}
+
+		trak.Tkhd = &TrackHeaderBox{
+			FullBox:          FullBox{Box: Box{Type: tkhdType}, Flags: [3]byte{0, 0, 0x07}}, // enabled, in movie and preview
+			CreationTime:     creationTime,
+			ModificationTime: creationTime,
+			TrackID:          t.id,
+			Duration:         duration,
+			AlternateGroup:   1,
+			Volume:           0x0100,
+			Matrix:           unityMatrix,
+		}
+		trak.Mdia = &MediaBox{Box: Box{Type: mdiaType}}
+		trak.Mdia.Mdhd = &MediaHeaderBox{
+			FullBox:          FullBox{Box: Box{Type: mdhdType}},
+			CreationTime:     creationTime,
+			ModificationTime: creationTime,
+			Timescale:        t.Timescale,
+			Duration:         mediaDuration,
+			Language:         languageCode(t.Language),
+		}
+		if mediaDuration > math.MaxUint32 || creationTime > math.MaxUint32 {
+			trak.Mdia.Mdhd.Version = 1
+			trak.Tkhd.Version = 1
+		}
+		trak.Mdia.Hdlr = &HandlerBox{
+			FullBox:     FullBox{Box: Box{Type: hdlrType}},
+			HandlerType: HandlerSoun,
+			Name:        "SoundHandler",
+		}
+		trak.Mdia.Minf = &MediaInformationBox{
+			Box: Box{Type: minfType},
+			Dinf: &DataInformationBox{
+				Box: Box{Type: dinfType},
+				Dref: &DataReferenceBox{
+					FullBox: FullBox{Box: Box{Type: drefType}},
+					Entries: []DataEntryBox{{FullBox: FullBox{Box: Box{Type: urlType}, Flags: [3]byte{0, 0, 1}}}},
+				},
+			},
+			Stbl: t.sampleTable(mdatStart),
+		}
+		if t.isVideo() {
+			trak.Tkhd.AlternateGroup = 0
+			trak.Tkhd.Volume = 0
+			trak.Tkhd.Width = uint32(t.Width) << 16
+			trak.Tkhd.Height = uint32(t.Height) << 16
+			trak.Mdia.Hdlr.HandlerType = HandlerVide
+			trak.Mdia.Hdlr.Name = "VideoHandler"
+			trak.Mdia.Minf.Vmhd = &VideoMediaHeaderBox{FullBox: FullBox{Box: Box{Type: vmhdType}, Flags: [3]byte{0, 0, 1}}}
+		} else if t.Codec == codecText {
+			trak.Tkhd.Flags = [3]byte{0, 0, 0x02} // in movie, but not enabled for playback
+			trak.Tkhd.AlternateGroup = 0
+			trak.Tkhd.Volume = 0
+			trak.Mdia.Hdlr.HandlerType = HandlerText
+			trak.Mdia.Hdlr.Name = "TextHandler"
+			trak.Mdia.Minf.Boxes = append(trak.Mdia.Minf.Boxes, textMediaHeader())
+		} else {
+			trak.Mdia.Minf.Smhd = &SoundMediaHeaderBox{FullBox: FullBox{Box: Box{Type: smhdType}}}
+		}
+		moov.Trak = append(moov.Trak, trak)
+	}
+	if moov.Mvhd.Duration > math.MaxUint32 || creationTime > math.MaxUint32 {
+		moov.Mvhd.Version = 1
+	}
+	return moov
+}
+
+// editBox returns the edit list which skips the encoder delay and the padding of an audio track or starts a video
+// track at the composition time of its first frame, nil if the whole media is presented. The duration of the edit
+// is 0, the rest of the media, if the track has no samples yet.
+func (t *muxTrack) editBox(mediaDuration uint64, movieTimescale uint32) *EditBox {
+	mediaTime := int64(t.EncoderDelay)
+	end := int64(mediaDuration) - int64(t.Padding)
+	if t.isVideo() && len(t.decodeTimes) > 0 {
+		mediaTime = int64(t.decodeTimes[0]) + int64(t.compositionOffsets[0])
+		end = mediaTime
+		for i, decodeTime := range t.decodeTimes {
+			composition := int64(decodeTime) + int64(t.compositionOffsets[i])
+			if composition < mediaTime {
+				mediaTime = composition
+			}
+			if composition+int64(t.durations[i]) > end {
+				end = composition + int64(t.durations[i])
+			}
+		}
+		if mediaTime == 0 && end == int64(mediaDuration) {
+			return nil
+		}
+	} else if t.EncoderDelay == 0 && t.Padding == 0 {
+		return nil
+	}
+
+	elst := &EditListBox{FullBox: FullBox{Box: Box{Type: elstType}}}
+	entry := EditListEntry{MediaTime: mediaTime, MediaRateInteger: 1}
+	if end > mediaTime {
+		entry.SegmentDuration = uint64(end-mediaTime) * uint64(movieTimescale) / uint64(t.Timescale)
+	}
+	if entry.SegmentDuration > math.MaxUint32 || entry.MediaTime > math.MaxInt32 {
+		elst.Version = 1
+	}
+	elst.Entries = []EditListEntry{entry}
+	return &EditBox{Box: Box{Type: edtsType}, Elst: elst}
+}
+
+// languageCode packs an ISO-639-2/T code into the 15 bits of the MediaHeaderBox.
+func languageCode(language string) uint16 {
+	if len(language) != 3 {
+		language = "und"
+	}
+	return uint16(language[0]-0x60)<<10 | uint16(language[1]-0x60)<<5 | uint16(language[2]-0x60)
+}
+
+func (t *muxTrack) sampleTable(mdatStart int64) *SampleTableBox {
+	stbl := &SampleTableBox{Box: Box{Type: stblType}}
+	stbl.Stsd = &SampleDescriptionBox{
+		FullBox: FullBox{Box: Box{Type: stsdType}},
+		Entries: []*SampleDescription{t.sampleEntry()},
+	}
+
+	// Decoding times --------------------------------------------------
+	stbl.Stts = &TimeToSampleBox{FullBox: FullBox{Box: Box{Type: sttsType}}}
+	for _, d := range t.durations {
+		entries := stbl.Stts.Entries
+		if len(entries) > 0 && entries[len(entries)-1].SampleDelta == d {
+			entries[len(entries)-1].SampleCount++
+		} else {
+			stbl.Stts.Entries = append(entries, TimeToSampleEntry{SampleCount: 1, SampleDelta: d})
+		}
+	}
+
+	var reordered bool
+	for _, offset := range t.compositionOffsets {
+		reordered = reordered || offset != 0
+	}
+	if reordered {
+		stbl.Ctts = &CompositionOffsetBox{FullBox: FullBox{Box: Box{Type: cttsType}}}
+		for _, offset := range t.compositionOffsets {
+			entries := stbl.Ctts.Entries
+			if len(entries) > 0 && entries[len(entries)-1].SampleOffset == offset {
+				entries[len(entries)-1].SampleCount++
+			} else {
+				stbl.Ctts.Entries = append(entries, CompositionOffsetEntry{SampleCount: 1, SampleOffset: offset})
+			}
+			if offset < 0 {
+				stbl.Ctts.Version = 1
+			}
+		}
+	}
+
+	// Sync samples --------------------------------------------------
+	if t.isVideo() {
+		stbl.Stss = &SyncSampleBox{FullBox: FullBox{Box: Box{Type: stssType}}}
+		for i, sync := range t.sync {
+			if sync {
+				stbl.Stss.SampleNumber = append(stbl.Stss.SampleNumber, uint32(i+1))
+			}
+		}
+	}
+
+	// Chunks --------------------------------------------------
+	stbl.Stsc = &SampleToChunkBox{FullBox: FullBox{Box: Box{Type: stscType}}}
+	stbl.Stco = &ChunkOffsetBox{FullBox: FullBox{Box: Box{Type: stcoType}}}
+	for i, chunk := range t.chunks {
+		entries := stbl.Stsc.Entries
+		if len(entries) == 0 || entries[len(entries)-1].SamplesPerChunk != uint32(chunk.samples) {
+			stbl.Stsc.Entries = append(entries, SampleToChunkEntry{
+				FirstChunk:             uint32(i + 1),
+				SamplesPerChunk:        uint32(chunk.samples),
+				SampleDescriptionIndex: 1,
+			})
+		}
+
+		offset := uint64(mdatStart + chunk.offset)
+		if offset > math.MaxUint32 {
+			stbl.Stco.Type = co64Type
+		}
+		stbl.Stco.ChunkOffset = append(stbl.Stco.ChunkOffset, offset)
+	}
+
+	// Sample sizes --------------------------------------------------
+	stbl.Stsz = &SampleSizeBox{FullBox: FullBox{Box: Box{Type: stszType}}, SampleCount: uint32(len(t.sizes))}
+	constant := len(t.sizes) > 0
+	for _, size := range t.sizes {
+		constant = constant && size == t.sizes[0]
+	}
+	if constant {
+		stbl.Stsz.SampleSize = t.sizes[0]
+	} else {
+		stbl.Stsz.EntrySize = t.sizes
+	}
+	return stbl
+}
+
+// sampleEntry builds the sample entry of the codec of the track.
+func (t *muxTrack) sampleEntry() *SampleDescription {
+	entry := &SampleDescription{}
+	if t.Codec == codecText {
+		entry.Type = textType
+		entry.DataReferenceIndex = 1
+		// QuickTime text sample description with default display flags, justification, colors, text box and font,
+		// ending with an empty font name.
+		entry.Data = make([]byte, 44)
+		return entry
+	}
+	if t.isVideo() {
+		entry.Visual = &VisualSampleEntry{
+			Width:           uint16(t.Width),
+			Height:          uint16(t.Height),
+			HorizResolution: 0x00480000, // 72 dpi
+			VertResolution:  0x00480000,
+			FrameCount:      1,
+			Depth:           0x0018,
+			PreDefined__:    -1,
+		}
+		entry.Visual.Type = avc1Type
+		if t.Codec == CodecHevc {
+			entry.Visual.Type = hvc1Type
+		}
+		entry.Visual.DataReferenceIndex = 1
+		entry.SampleEntry = entry.Visual.SampleEntry
+		entry.AvcC = t.avcC
+		entry.HvcC = t.hvcC
+		return entry
+	}
+
+	entry.Audio = &AudioSampleEntry{
+		ChannelCount: uint16(t.Channels),
+		SampleSize:   16,
+	}
+	entry.Audio.DataReferenceIndex = 1
+	if t.SampleRate <= math.MaxUint16 {
+		entry.Audio.SampleRate = uint32(t.SampleRate) << 16
+	}
+
+	switch t.Codec {
+	case CodecAac, CodecMp3:
+		entry.Type = mp4aType
+		dcd := &DecoderConfigDescriptor{
+			ObjectTypeIndication: ObjectTypeMpeg4Audio,
+			StreamType:           StreamTypeAudio,
+			DecoderSpecificInfo:  t.Config,
+		}
+		if t.Codec == CodecMp3 {
+			dcd.ObjectTypeIndication = ObjectTypeMpeg1Audio
+			if t.SampleRate < 32000 {
+				dcd.ObjectTypeIndication = ObjectTypeMpeg2Audio
+			}
+			dcd.DecoderSpecificInfo = nil
+		}
+		dcd.BufferSizeDB, dcd.MaxBitrate, dcd.AvgBitrate = t.bitrates()
+		entry.Esds = &ESDescriptorBox{
+			FullBox: FullBox{Box: Box{Type: esdsType}},
+			ES: &ESDescriptor{
+				ESID:          uint16(t.id),
+				DecoderConfig: dcd,
+				SLConfig:      &SLConfigDescriptor{Predefined: 2},
+			},
+		}
+
+	case CodecAlac:
+		entry.Type = alacType
+		entry.Audio.SampleSize = uint16(t.SampleSize)
+		entry.Boxes = append(entry.Boxes, &RawBox{Type: alacType, Data: append(make([]byte, 4), t.Config...)})
+
+	case CodecLpcm:
+		entry.Type = ipcmType
+		if t.Float {
+			entry.Type = fpcmType
+		}
+		entry.Audio.SampleSize = uint16(t.SampleSize)
+		var formatFlags byte
+		if t.LittleEndian {
+			formatFlags = 0x01
+		}
+		entry.Boxes = append(entry.Boxes, &RawBox{Type: pcmCType, Data: []byte{0, 0, 0, 0, formatFlags, byte(t.SampleSize)}})
+	}
+	entry.Audio.Type = entry.Type
+	entry.SampleEntry = entry.Audio.SampleEntry
+	return entry
+}
+
+// bitrates returns the largest sample, the largest number of bits in one second and the average bitrate.
+func (t *muxTrack) bitrates() (bufferSize, maxBitrate, avgBitrate uint32) {
+	var total, window, duration uint64
+	first := 0
+	for i, size := range t.sizes {
+		if size > bufferSize {
+			bufferSize = size
+		}
+		total += uint64(size)
+		duration += uint64(t.durations[i])
+
+		window += uint64(size)
+		for t.decodeTimes[i]-t.decodeTimes[first] >= uint64(t.Timescale) {
+			window -= uint64(t.sizes[first])
+			first++
+		}
+		if uint32(window*8) > maxBitrate {
+			maxBitrate = uint32(window * 8)
+		}
+	}
+	if duration != 0 {
+		avgBitrate = uint32(total * 8 * uint64(t.Timescale) / duration)
+	}
+	return bufferSize, maxBitrate, avgBitrate
+}
@@ -0,0 +1,280 @@
+package mpeg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var errSampleTable = errors.New("mpeg: inconsistent sample table")
+
+type CompositionOffsetBox struct {
+	FullBox
+	EntryCount uint32 // unsigned int(32)
+	Entries    []CompositionOffsetEntry
+}
+
+type CompositionOffsetEntry struct {
+	SampleCount  uint32 // unsigned int(32)
+	SampleOffset int32  // unsigned int(32) for version 0, signed int(32) for version 1
+}
+
+type SyncSampleBox struct {
+	FullBox
+	EntryCount   uint32   // unsigned int(32)
+	SampleNumber []uint32 // unsigned int(32)
+}
+
+// SampleDependencyTypeBox holds a byte of dependency flags per sample, see Sample.Dependency.
+type SampleDependencyTypeBox struct {
+	FullBox
+	Entries []uint8 // unsigned int(2) is_leading, sample_depends_on, sample_is_depended_on, sample_has_redundancy
+}
+
+var (
+	cttsType = [4]byte{'c', 't', 't', 's'}
+	stssType = [4]byte{'s', 't', 's', 's'}
+	stpsType = [4]byte{'s', 't', 'p', 's'}
+	sdtpType = [4]byte{'s', 'd', 't', 'p'}
+)
+
+// Sample is one entry of the resolved sample table of a track. Times are in the timescale of the track media.
+type Sample struct {
+	Offset                 int64  // Position of the sample data in the file
+	Size                   uint32 // Size of the sample data in bytes
+	DecodeTime             uint64 // Decoding timestamp
+	CompositionOffset      int32  // Composition timestamp is DecodeTime + CompositionOffset
+	Duration               uint32 // Difference to the decoding timestamp of the next sample
+	Sync                   bool   // Sync samples (key frames) can be decoded without previous samples
+	PartialSync            bool   // Random access point of an open GOP from 'stps', its leading samples are not decodable
+	Dependency             uint8  // Dependency flags from 'sdtp' or the sample flags of the track fragment
+	SampleDescriptionIndex uint32 // Index of the sample entry in the SampleDescriptionBox, starting with 1
+}
+
+// Values of the two-bit fields of Sample.Dependency, 0 if unknown.
+const (
+	LeadingDependent   = 1 // is_leading: leading sample depending on samples before the random access point
+	NotLeading         = 2
+	LeadingIndependent = 3
+
+	DependsOnOthers = 1 // sample_depends_on
+	DependsOnNone   = 2 // I picture
+
+	DependedOn    = 1 // sample_is_depended_on
+	NotDependedOn = 2 // disposable sample
+)
+
+// CompositionTime returns the composition (presentation) timestamp of the sample.
+func (s *Sample) CompositionTime() int64 {
+	return int64(s.DecodeTime) + int64(s.CompositionOffset)
+}
+
+// RandomAccessPoint reports whether decoding can start at the sample.
+func (s *Sample) RandomAccessPoint() bool {
+	return s.Sync || s.PartialSync
+}
+
+// IsLeading returns the is_leading field of the dependency flags.
+func (s *Sample) IsLeading() uint8 {
+	return s.Dependency >> 6
+}
+
+// DependsOn returns the sample_depends_on field of the dependency flags.
+func (s *Sample) DependsOn() uint8 {
+	return s.Dependency >> 4 & 3
+}
+
+// IsDependedOn returns the sample_is_depended_on field of the dependency flags.
+func (s *Sample) IsDependedOn() uint8 {
+	return s.Dependency >> 2 & 3
+}
+
+func parseCtts(h Box, payload []byte) (*CompositionOffsetBox, error) {
+	r := newBoxReader(payload)
+
+	ctts := &CompositionOffsetBox{FullBox: r.fullBox(h)}
+	ctts.EntryCount = r.u32()
+	ctts.Entries = make([]CompositionOffsetEntry, r.entries(ctts.EntryCount, 8))
+	for i := range ctts.Entries {
+		ctts.Entries[i].SampleCount = r.u32()
+		ctts.Entries[i].SampleOffset = int32(r.u32())
+	}
+	return ctts, r.err
+}
+
+func parseStss(h Box, payload []byte) (*SyncSampleBox, error) {
+	r := newBoxReader(payload)
+
+	stss := &SyncSampleBox{FullBox: r.fullBox(h)}
+	stss.EntryCount = r.u32()
+	stss.SampleNumber = make([]uint32, r.entries(stss.EntryCount, 4))
+	for i := range stss.SampleNumber {
+		stss.SampleNumber[i] = r.u32()
+	}
+	return stss, r.err
+}
+
+func parseSdtp(h Box, payload []byte) (*SampleDependencyTypeBox, error) {
+	r := newBoxReader(payload)
+
+	sdtp := &SampleDependencyTypeBox{FullBox: r.fullBox(h)}
+	sdtp.Entries = r.next(r.Len())
+	return sdtp, r.err
+}
+
+// sampleSizes returns the size of every sample from 'stsz' or 'stz2'. The sample data ends before end.
+func (stbl *SampleTableBox) sampleSizes(end int64) ([]uint32, error) {
+	if stbl.Stsz != nil {
+		if stbl.Stsz.SampleSize == 0 {
+			return stbl.Stsz.EntrySize, nil
+		}
+		// The count of samples of constant size is not backed by a table, it must not exceed the samples of 'stts',
+		// the samples of the chunks or the data of the file.
+		count := uint64(stbl.Stsz.SampleCount)
+		var timed, chunked uint64
+		if stbl.Stts != nil {
+			for _, entry := range stbl.Stts.Entries {
+				timed += uint64(entry.SampleCount)
+			}
+		}
+		for _, n := range stbl.chunks() {
+			chunked += uint64(n)
+		}
+		switch {
+		case count > timed:
+			return nil, fmt.Errorf("%w: %d samples of constant size, %d in %s", errSampleTable, count, timed, sttsType)
+		case count > chunked:
+			return nil, fmt.Errorf("%w: %d samples of constant size, %d in chunks", errSampleTable, count, chunked)
+		case count*uint64(stbl.Stsz.SampleSize) > uint64(end):
+			return nil, fmt.Errorf("%w: %d samples of %d bytes in a file of %d bytes", errSampleTable, count,
+				stbl.Stsz.SampleSize, end)
+		}
+		sizes := make([]uint32, stbl.Stsz.SampleCount)
+		for i := range sizes {
+			sizes[i] = stbl.Stsz.SampleSize
+		}
+		return sizes, nil
+	}
+	if stbl.Stz2 != nil {
+		return stbl.Stz2.EntrySize, nil
+	}
+	return nil, fmt.Errorf("%w: no %s or %s box", errSampleTable, stszType, stz2Type)
+}
+
+// Samples resolves the sample table into the position, size, timing and sync flag of every sample of the track.
+func (t *TrackBox) Samples() ([]Sample, error) {
+	return t.samples(math.MaxInt64)
+}
+
+// samples returns the samples of the sample table of a file of end bytes.
+func (t *TrackBox) samples(end int64) ([]Sample, error) {
+	stbl := t.SampleTable()
+	if stbl == nil {
+		return nil, fmt.Errorf("%w: no %s box", errSampleTable, stblType)
+	}
+
+	sizes, err := stbl.sampleSizes(end)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]Sample, len(sizes))
+	for i, size := range sizes {
+		samples[i].Size = size
+	}
+	if len(samples) == 0 {
+		return samples, nil
+	}
+
+	// Position --------------------------------------------------
+	if stbl.Stsc == nil || stbl.Stco == nil {
+		return nil, fmt.Errorf("%w: no %s or %s box", errSampleTable, stscType, stcoType)
+	}
+	chunks := stbl.Stco.ChunkOffset
+	entries := stbl.Stsc.Entries
+
+	sample := 0
+	for i, entry := range entries {
+		lastChunk := uint32(len(chunks))
+		if i+1 < len(entries) {
+			lastChunk = entries[i+1].FirstChunk - 1
+		}
+		if entry.FirstChunk == 0 || lastChunk > uint32(len(chunks)) {
+			return nil, fmt.Errorf("%w: chunk %d of %d", errSampleTable, lastChunk, len(chunks))
+		}
+
+		for chunk := entry.FirstChunk; chunk <= lastChunk; chunk++ {
+			offset := int64(chunks[chunk-1])
+			for j := uint32(0); j < entry.SamplesPerChunk && sample < len(samples); j++ {
+				samples[sample].Offset = offset
+				samples[sample].SampleDescriptionIndex = entry.SampleDescriptionIndex
+				offset += int64(samples[sample].Size)
+				sample++
+			}
+		}
+	}
+	if sample != len(samples) {
+		return nil, fmt.Errorf("%w: %d samples in chunks, %d sizes", errSampleTable, sample, len(samples))
+	}
+
+	// Timing --------------------------------------------------
+	if stbl.Stts == nil {
+		return nil, fmt.Errorf("%w: no %s box", errSampleTable, sttsType)
+	}
+	sample = 0
+	var decodeTime uint64
+	for _, entry := range stbl.Stts.Entries {
+		for j := uint32(0); j < entry.SampleCount && sample < len(samples); j++ {
+			samples[sample].DecodeTime = decodeTime
+			samples[sample].Duration = entry.SampleDelta
+			decodeTime += uint64(entry.SampleDelta)
+			sample++
+		}
+	}
+	if sample == 0 {
+		return nil, fmt.Errorf("%w: no samples in %s", errSampleTable, sttsType)
+	}
+	// A shorter table repeats the last delta for the rest of the samples.
+	for ; sample < len(samples); sample++ {
+		samples[sample].DecodeTime = decodeTime
+		samples[sample].Duration = samples[sample-1].Duration
+		decodeTime += uint64(samples[sample].Duration)
+	}
+
+	if stbl.Ctts != nil {
+		sample = 0
+		for _, entry := range stbl.Ctts.Entries {
+			for j := uint32(0); j < entry.SampleCount && sample < len(samples); j++ {
+				samples[sample].CompositionOffset = entry.SampleOffset
+				sample++
+			}
+		}
+	}
+
+	// Sync --------------------------------------------------
+	// If the sync sample box is not present, every sample is a sync sample.
+	if stbl.Stss == nil {
+		for i := range samples {
+			samples[i].Sync = true
+		}
+	} else {
+		for _, number := range stbl.Stss.SampleNumber {
+			if number != 0 && int(number) <= len(samples) {
+				samples[number-1].Sync = true
+			}
+		}
+	}
+	if stbl.Stps != nil {
+		for _, number := range stbl.Stps.SampleNumber {
+			if number != 0 && int(number) <= len(samples) {
+				samples[number-1].PartialSync = true
+			}
+		}
+	}
+	if stbl.Sdtp != nil {
+		for i := 0; i < len(samples) && i < len(stbl.Sdtp.Entries); i++ {
+			samples[i].Dependency = stbl.Sdtp.Entries[i]
+		}
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,164 @@
+package mpeg
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testTracks are a video track of B-frames and keyframes every 5 pictures and an LPCM audio track.
+var testTracks = []MuxTrack{
+	{Codec: CodecAvc, Width: 64, Height: 48, Config: []byte{1, 0x42, 0xC0, 0x1E, 0xFF, 0xE1, 0, 4, 0x67, 0x42, 0xC0, 0x1E,
+		1, 0, 2, 0x68, 0xCE}},
+	{Codec: CodecLpcm, SampleRate: 8000, Channels: 1},
+}
+
+// testPackets returns 20 pictures of 25 frames per second and 2 seconds of audio in packets of 1/10 seconds,
+// interleaved by decoding time.
+func testPackets() []*Packet {
+	var packets []*Packet
+	for i := 0; i < 20; i++ {
+		decodeTime := uint64(i) * 3600
+		if i%2 == 0 {
+			audio := make([]byte, 1600)
+			audio[0] = byte(i)
+			packets = append(packets, &Packet{TrackID: 2, Data: audio, DecodeTime: uint64(i/2) * 800, Duration: 800})
+		}
+		video := bytes.Repeat([]byte{byte(i)}, 100+i*7)
+		packets = append(packets, &Packet{TrackID: 1, Data: video, DecodeTime: decodeTime, Duration: 3600,
+			CompositionTime: int64(decodeTime) + int64(i%3)*3600, Keyframe: i%5 == 0})
+	}
+	return packets
+}
+
+func muxTestFile(t *testing.T) []byte {
+	t.Helper()
+	b := &bytes.Buffer{}
+	m := NewMuxer(b)
+	for _, track := range testTracks {
+		if _, err := m.AddTrack(track); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range testPackets() {
+		if err := m.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func segmentTestFile(t *testing.T) []byte {
+	t.Helper()
+	s := NewSegmenter(200 * time.Millisecond)
+	for _, track := range testTracks {
+		if _, err := s.AddTrack(track); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := s.InitSegment()
+	for _, p := range testPackets() {
+		segment, err := s.WritePacket(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if segment != nil {
+			file = append(file, segment.Data...)
+		}
+	}
+	if segment := s.Flush(); segment != nil {
+		file = append(file, segment.Data...)
+	}
+	return file
+}
+
+func TestSamplesFlatAndFragmented(t *testing.T) {
+	packets := testPackets()
+	for _, test := range []struct {
+		name       string
+		file       []byte
+		fragmented bool
+	}{
+		{"flat", muxTestFile(t), false},
+		{"fragmented", segmentTestFile(t), true},
+	} {
+		d, err := NewDemuxer(bytes.NewReader(test.file))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if d.File.Fragmented() != test.fragmented {
+			t.Errorf("%s: fragmented %v", test.name, d.File.Fragmented())
+		}
+		for trackID := uint32(1); trackID <= 2; trackID++ {
+			var want []*Packet
+			for _, p := range packets {
+				if p.TrackID == trackID {
+					want = append(want, p)
+				}
+			}
+			samples, err := d.File.Samples(trackID)
+			if err != nil {
+				t.Fatalf("%s: track %d: %v", test.name, trackID, err)
+			}
+			if len(samples) != len(want) {
+				t.Fatalf("%s: track %d has %d samples, want %d", test.name, trackID, len(samples), len(want))
+			}
+			for i, s := range samples {
+				p := want[i]
+				compositionTime := int64(p.DecodeTime)
+				sync := true
+				if trackID == 1 {
+					compositionTime, sync = p.CompositionTime, p.Keyframe
+				}
+				if s.Size != uint32(len(p.Data)) || s.DecodeTime != p.DecodeTime || s.Duration != p.Duration ||
+					s.CompositionTime() != compositionTime || s.Sync != sync {
+					t.Errorf("%s: track %d sample %d is %+v, want %+v", test.name, trackID, i, s, p)
+				}
+
+				packet, err := d.ReadSample(trackID, i)
+				if err != nil {
+					t.Fatalf("%s: track %d sample %d: %v", test.name, trackID, i, err)
+				}
+				if !bytes.Equal(packet.Data, p.Data) {
+					t.Errorf("%s: track %d sample %d has other data", test.name, trackID, i)
+				}
+			}
+		}
+	}
+}
+
+func TestSampleSizesConstant(t *testing.T) {
+	stbl := func(count, timed, samplesPerChunk uint32) *SampleTableBox {
+		return &SampleTableBox{
+			Stts: &TimeToSampleBox{Entries: []TimeToSampleEntry{{SampleCount: timed, SampleDelta: 1}}},
+			Stsc: &SampleToChunkBox{Entries: []SampleToChunkEntry{{FirstChunk: 1, SamplesPerChunk: samplesPerChunk}}},
+			Stsz: &SampleSizeBox{SampleSize: 10, SampleCount: count},
+			Stco: &ChunkOffsetBox{ChunkOffset: []uint64{0, 100}},
+		}
+	}
+	for _, test := range []struct {
+		name string
+		stbl *SampleTableBox
+		end  int64
+		err  bool
+	}{
+		{"valid", stbl(20, 20, 10), 200, false},
+		{"beyond stts", stbl(21, 20, 20), 1000, true},
+		{"beyond chunks", stbl(20, 20, 5), 1000, true},
+		{"beyond file", stbl(20, 20, 10), 199, true},
+		{"huge", stbl(0xFFFFFFFF, 0xFFFFFFFF, 0xFFFFFFFF), 1000, true},
+	} {
+		sizes, err := test.stbl.sampleSizes(test.end)
+		if test.err {
+			if !errors.Is(err, errSampleTable) {
+				t.Errorf("%s: error %v", test.name, err)
+			}
+		} else if err != nil || len(sizes) != 20 || sizes[19] != 10 {
+			t.Errorf("%s: %d sizes, error %v", test.name, len(sizes), err)
+		}
+	}
+}
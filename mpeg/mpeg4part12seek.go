@@ -0,0 +1,102 @@
+package mpeg
+
+import (
+	"fmt"
+	"time"
+)
+
+// Random access: sync samples and the mapping of presentation times to samples
+
+// RandomAccessPoints returns the indexes of the samples of the track where decoding can start, the sync samples and
+// the partial sync samples of open GOPs.
+func (d *Demuxer) RandomAccessPoints(trackID uint32) []int {
+	track := d.track(trackID)
+	if track == nil {
+		return nil
+	}
+	var points []int
+	for i := range track.samples {
+		if track.samples[i].RandomAccessPoint() {
+			points = append(points, i)
+		}
+	}
+	return points
+}
+
+// presentationTime returns the composition time of sample i on the timeline of the movie, see Packet.
+func (track *demuxTrack) presentationTime(i int) int64 {
+	return track.samples[i].CompositionTime() - track.mediaTime + track.delay
+}
+
+// SampleAtTime returns the index of the sample of the track presented at t on the timeline of the movie: the last
+// sample in presentation order starting at or before t, or the first one if t is earlier. It returns -1 if the
+// track has no samples.
+func (d *Demuxer) SampleAtTime(trackID uint32, t time.Duration) int {
+	track := d.track(trackID)
+	if track == nil || len(track.samples) == 0 || track.trak.Timescale() == 0 {
+		return -1
+	}
+	target := int64(t / time.Second * time.Duration(track.trak.Timescale()))
+	target += int64(t % time.Second * time.Duration(track.trak.Timescale()) / time.Second)
+
+	found, first := -1, 0
+	for i := range track.samples {
+		pt := track.presentationTime(i)
+		if pt <= target && (found < 0 || pt > track.presentationTime(found)) {
+			found = i
+		}
+		if pt < track.presentationTime(first) {
+			first = i
+		}
+	}
+	if found < 0 {
+		return first
+	}
+	return found
+}
+
+// SeekTrack returns the sample of the track to start decoding from to present the sample at t, the last random
+// access point before it in decoding order, or the last sync sample before it if the sample at t is a leading sample
+// of an open GOP. Decoding from there yields discard frames, in presentation order, that are presented before the
+// sample at t; the leading samples of an open GOP are counted among them. The next ReadPacket of the track returns
+// the start sample. It returns an error if there is no such sample.
+func (d *Demuxer) SeekTrack(trackID uint32, t time.Duration) (start, discard int, err error) {
+	track := d.track(trackID)
+	if track == nil {
+		return 0, 0, fmt.Errorf("mpeg: no track %d", trackID)
+	}
+	target := d.SampleAtTime(trackID, t)
+	if target < 0 {
+		return 0, 0, fmt.Errorf("mpeg: track %d has no samples", trackID)
+	}
+
+	start = target
+	for start >= 0 && !track.samples[start].RandomAccessPoint() {
+		start--
+	}
+
+	// A leading sample of an open GOP, presented before its partial sync sample, may refer to the previous GOP, only
+	// those marked as decodable by 'sdtp' can be decoded from the partial sync sample.
+	if start >= 0 && start < target && !track.samples[start].Sync && track.samples[target].IsLeading() != LeadingIndependent &&
+		track.samples[target].CompositionTime() < track.samples[start].CompositionTime() {
+		for start--; start >= 0 && !track.samples[start].Sync; start-- {
+		}
+	}
+	if start < 0 {
+		return 0, 0, fmt.Errorf("mpeg: no random access point of track %d before sample %d", trackID, target)
+	}
+
+	// Samples following the target in decoding order may be presented before it, up to the next random access point.
+	targetTime := track.samples[target].CompositionTime()
+	for i := start; i < len(track.samples); i++ {
+		if i > target && track.samples[i].RandomAccessPoint() {
+			break
+		}
+		if track.samples[i].CompositionTime() < targetTime {
+			discard++
+		}
+	}
+
+	track.next = start
+	return start, discard, nil
+}
@@ -0,0 +1,298 @@
+package mpeg
+
+import (
+	"fmt"
+	"time"
+)
+
+// Fragmented MP4 segments for DASH and HLS, with the CMAF brands (ISO/IEC 23000-19) for segments of a single track
+
+var (
+	stypType = [4]byte{'s', 't', 'y', 'p'}
+
+	brandIso6 = [4]byte{'i', 's', 'o', '6'}
+	brandCmfc = [4]byte{'c', 'm', 'f', 'c'}
+	brandCmfs = [4]byte{'c', 'm', 'f', 's'}
+	brandDash = [4]byte{'d', 'a', 's', 'h'}
+	brandMsdh = [4]byte{'m', 's', 'd', 'h'}
+	brandMsix = [4]byte{'m', 's', 'i', 'x'}
+)
+
+// Sample flags of the track fragments (ISO/IEC 14496-12 8.8.3.1): sample_depends_on and sample_is_non_sync_sample.
+const (
+	sampleFlagsSync    = 0x02000000
+	sampleFlagsNonSync = 0x01000000 | sampleIsNonSyncSample
+)
+
+// Segment is a media segment written by the Segmenter or the HlsSegmenter.
+type Segment struct {
+	Sequence uint32        // Sequence number of the movie fragment or of the segment, starting with 1
+	Time     time.Duration // Earliest presentation time of the samples of the reference track
+	Duration time.Duration // Duration of the samples of the reference track
+	Data     []byte        // 'styp', 'sidx', 'moof' and 'mdat' boxes, or transport stream packets
+}
+
+type segmentTrack struct {
+	*muxTrack
+	data           []byte // Sample data of the current segment
+	nextDecodeTime uint64 // Decoding time following the last segment
+}
+
+// Segmenter writes audio and video packets into fragmented MP4 segments. The init segment holds the 'moov' box
+// without samples, every media segment one movie fragment with all tracks and a 'sidx' box per track.
+//
+// A segment ends when a sync sample of the reference track, the first video track or else the first track, is
+// written after SegmentDuration. Samples of the other tracks are added to the segment in the order they are written.
+type Segmenter struct {
+	SegmentDuration time.Duration
+	CreationTime    time.Time
+
+	tracks    []*segmentTrack
+	reference *segmentTrack
+	started   bool
+	sequence  uint32
+}
+
+// NewSegmenter returns a segmenter cutting segments of at least duration.
+func NewSegmenter(duration time.Duration) *Segmenter {
+	return &Segmenter{SegmentDuration: duration, CreationTime: time.Now()}
+}
+
+// AddTrack adds a track and returns its ID. Tracks are added before the first packet is written.
+func (s *Segmenter) AddTrack(track MuxTrack) (uint32, error) {
+	if s.started {
+		return 0, errMuxerClosed
+	}
+	t, err := newMuxTrack(track, uint32(len(s.tracks)+1))
+	if err != nil {
+		return 0, err
+	}
+	s.tracks = append(s.tracks, &segmentTrack{muxTrack: t})
+	if s.reference == nil || !s.reference.isVideo() && t.isVideo() {
+		s.reference = s.tracks[len(s.tracks)-1]
+	}
+	return t.id, nil
+}
+
+// InitSegment returns the 'ftyp' and 'moov' boxes of the tracks.
+func (s *Segmenter) InitSegment() []byte {
+	tracks := make([]*muxTrack, len(s.tracks))
+	mvex := &MovieExtendsBox{Box: Box{Type: mvexType}}
+	for i, t := range s.tracks {
+		tracks[i] = &muxTrack{MuxTrack: t.MuxTrack, id: t.id, avcC: t.avcC, hvcC: t.hvcC}
+		trex := &TrackExtendsBox{
+			FullBox:                       FullBox{Box: Box{Type: trexType}},
+			TrackID:                       t.id,
+			DefaultSampleDescriptionIndex: 1,
+		}
+		if t.isVideo() {
+			trex.DefaultSampleFlags = sampleFlagsNonSync
+		}
+		mvex.Trex = append(mvex.Trex, trex)
+	}
+	moov := newMovie(tracks, s.CreationTime, 0)
+	moov.Mvex = mvex
+
+	w := &boxWriter{}
+	ftyp := &FileTypeBox{
+		Box:              Box{Type: ftypType},
+		MajorBrand:       brandIso6,
+		CompatibleBrands: [][4]byte{brandIso6, brandDash, brandMp41},
+	}
+	if s.cmaf() {
+		ftyp.CompatibleBrands = append(ftyp.CompatibleBrands, brandCmfc)
+	}
+	ftyp.write(w)
+	moov.write(w)
+	return w.b
+}
+
+// cmaf reports whether the segments are CMAF segments. A CMAF track file has a single track, the segments of
+// several tracks are only DASH segments.
+func (s *Segmenter) cmaf() bool {
+	return len(s.tracks) == 1
+}
+
+// WritePacket adds a packet to the current segment. It returns the previous segment if the packet starts a new one,
+// otherwise nil. The times of the packet are used as by the Muxer.
+func (s *Segmenter) WritePacket(p *Packet) (*Segment, error) {
+	if p.TrackID == 0 || int(p.TrackID) > len(s.tracks) {
+		return nil, fmt.Errorf("%w: %d", errMuxerTrack, p.TrackID)
+	}
+	t := s.tracks[p.TrackID-1]
+	s.started = true
+
+	if len(t.sizes) == 0 && p.DecodeTime == 0 && t.nextDecodeTime != 0 {
+		packet := *p
+		packet.DecodeTime = t.nextDecodeTime
+		p = &packet
+	}
+
+	var segment *Segment
+	if t == s.reference && len(t.sizes) > 0 && (p.Keyframe || !t.isVideo()) {
+		decodeTime := p.DecodeTime
+		if decodeTime == 0 {
+			decodeTime = t.decodeTimes[len(t.sizes)-1] + uint64(t.durations[len(t.sizes)-1])
+		}
+		if scaleDuration(decodeTime-t.decodeTimes[0], t.Timescale) >= s.SegmentDuration {
+			if n := len(t.sizes); t.durations[n-1] == 0 && decodeTime > t.decodeTimes[n-1] {
+				t.durations[n-1] = uint32(decodeTime - t.decodeTimes[n-1])
+			}
+			segment = s.segment()
+		}
+	}
+
+	t.add(p)
+	t.data = append(t.data, p.Data...)
+	return segment, nil
+}
+
+// Flush returns the samples written since the last segment as a segment, nil if there are none.
+func (s *Segmenter) Flush() *Segment {
+	for _, t := range s.tracks {
+		if len(t.sizes) > 0 {
+			return s.segment()
+		}
+	}
+	return nil
+}
+
+// segment builds a segment from the samples of all tracks and removes them from the tracks.
+func (s *Segmenter) segment() *Segment {
+	s.sequence++
+	segment := &Segment{Sequence: s.sequence}
+
+	moof := &MovieFragmentBox{
+		Box:  Box{Type: moofType},
+		Mfhd: &MovieFragmentHeaderBox{FullBox: FullBox{Box: Box{Type: mfhdType}}, SequenceNumber: s.sequence},
+	}
+	var sidx []*SegmentIndexBox
+	var mdat []byte
+	for _, t := range s.tracks {
+		if len(t.sizes) == 0 {
+			continue
+		}
+		t.finish()
+		traf, index := t.fragment()
+		moof.Traf = append(moof.Traf, traf)
+		sidx = append(sidx, index)
+		mdat = append(mdat, t.data...)
+		if t == s.reference {
+			segment.Time = scaleDuration(index.EarliestPresentationTime, t.Timescale)
+			segment.Duration = scaleDuration(uint64(index.References[0].SubsegmentDuration), t.Timescale)
+		}
+	}
+
+	// The data offsets are relative to the 'moof' box, its size does not depend on them.
+	w := &boxWriter{}
+	moof.write(w)
+	offset := int32(len(w.b) + 8)
+	for _, traf := range moof.Traf {
+		traf.Trun[0].DataOffset = offset
+		for _, entry := range traf.Trun[0].Entries {
+			offset += int32(entry.SampleSize)
+		}
+	}
+	referencedSize := uint32(len(w.b) + 8 + len(mdat))
+
+	w = &boxWriter{}
+	styp := &FileTypeBox{
+		Box:              Box{Type: stypType},
+		MajorBrand:       brandMsdh,
+		CompatibleBrands: [][4]byte{brandMsdh, brandMsix},
+	}
+	if s.cmaf() {
+		styp.CompatibleBrands = append(styp.CompatibleBrands, brandCmfs)
+	}
+	styp.write(w)
+	// Every 'sidx' box references the fragment which follows all of them, the boxes have the same size.
+	sidxSize := 0
+	for i, index := range sidx {
+		index.References[0].ReferencedSize = referencedSize
+		start := len(w.b)
+		index.write(w)
+		sidxSize = len(w.b) - start
+		w.b = w.b[:start]
+		index.FirstOffset = uint64((len(sidx) - 1 - i) * sidxSize)
+	}
+	for _, index := range sidx {
+		index.write(w)
+	}
+	moof.write(w)
+	w.box(mdatType, func() { w.bytes(mdat) })
+	segment.Data = w.b
+
+	for _, t := range s.tracks {
+		if n := len(t.sizes); n > 0 {
+			t.nextDecodeTime = t.decodeTimes[n-1] + uint64(t.durations[n-1])
+		}
+		t.sizes, t.decodeTimes, t.durations, t.compositionOffsets, t.sync = nil, nil, nil, nil, nil
+		t.data = nil
+	}
+	return segment
+}
+
+// fragment returns the track fragment of the samples of the track and their segment index.
+func (t *segmentTrack) fragment() (*TrackFragmentBox, *SegmentIndexBox) {
+	traf := &TrackFragmentBox{
+		Box: Box{Type: trafType},
+		Tfhd: &TrackFragmentHeaderBox{
+			FullBox: FullBox{Box: Box{Type: tfhdType}, Flags: [3]byte{0x02, 0, 0}}, // default-base-is-moof
+			TrackID: t.id,
+		},
+		Tfdt: &TrackFragmentBaseMediaDecodeTimeBox{
+			FullBox:             FullBox{Box: Box{Type: tfdtType}, Version: 1},
+			BaseMediaDecodeTime: t.decodeTimes[0],
+		},
+	}
+
+	flags := uint32(trunDataOffsetPresent | trunSampleDurationPresent | trunSampleSizePresent)
+	if t.isVideo() {
+		flags |= trunSampleFlagsPresent | trunSampleCompositionTimeOffsetsPresent
+	}
+	trun := &TrackRunBox{
+		FullBox:     FullBox{Box: Box{Type: trunType}, Flags: [3]byte{byte(flags >> 16), byte(flags >> 8), byte(flags)}},
+		SampleCount: uint32(len(t.sizes)),
+	}
+	var duration uint64
+	earliest := int64(t.decodeTimes[0]) + int64(t.compositionOffsets[0])
+	for i, size := range t.sizes {
+		entry := TrackRunEntry{
+			SampleDuration:              t.durations[i],
+			SampleSize:                  size,
+			SampleFlags:                 sampleFlagsNonSync,
+			SampleCompositionTimeOffset: t.compositionOffsets[i],
+		}
+		if t.sync[i] {
+			entry.SampleFlags = sampleFlagsSync
+		}
+		if entry.SampleCompositionTimeOffset < 0 {
+			trun.Version = 1
+		}
+		trun.Entries = append(trun.Entries, entry)
+
+		duration += uint64(t.durations[i])
+		if composition := int64(t.decodeTimes[i]) + int64(t.compositionOffsets[i]); composition < earliest {
+			earliest = composition
+		}
+	}
+	traf.Trun = []*TrackRunBox{trun}
+	if earliest < 0 {
+		earliest = 0
+	}
+
+	sidx := &SegmentIndexBox{
+		FullBox:                  FullBox{Box: Box{Type: sidxType}, Version: 1},
+		ReferenceID:              t.id,
+		Timescale:                t.Timescale,
+		EarliestPresentationTime: uint64(earliest),
+		References: []SegmentReference{{
+			SubsegmentDuration: uint32(duration),
+			StartsWithSAP:      t.sync[0],
+		}},
+	}
+	if t.sync[0] {
+		sidx.References[0].SAPType = 1
+	}
+	return traf, sidx
+}
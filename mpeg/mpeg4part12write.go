@@ -0,0 +1,646 @@
+package mpeg
+
+// Serialization of the box tree. Box sizes are computed while writing, the Size fields of the structs are ignored.
+// Boxes which were not decoded are written back from their RawBox.
+
+// Bytes returns the file with its boxes in the order ftyp, moov, other boxes, sidx, moof and mdat and finally mfra.
+// Movie fragments and mdat boxes keep the order of their offsets. The chunk and data offsets are written as they
+// are, they have to be updated by the caller if the position of the media data changes.
+func (f *File) Bytes() []byte {
+	w := &boxWriter{}
+	if f.Ftyp != nil {
+		f.Ftyp.write(w)
+	}
+	if f.Moov != nil {
+		f.Moov.write(w)
+	}
+	writeRawBoxes(w, f.Boxes)
+	for _, sidx := range f.Sidx {
+		sidx.write(w)
+	}
+
+	moof, mdat := f.Moof, f.Mdat
+	for len(moof) > 0 || len(mdat) > 0 {
+		if len(moof) > 0 && (len(mdat) == 0 || moof[0].Offset < mdat[0].Offset) {
+			moof[0].write(w)
+			moof = moof[1:]
+		} else {
+			writeRawBoxes(w, mdat[:1])
+			mdat = mdat[1:]
+		}
+	}
+
+	if f.Mfra != nil {
+		f.Mfra.write(w)
+	}
+	return w.b
+}
+
+func writeRawBoxes(w *boxWriter, boxes []*RawBox) {
+	for _, box := range boxes {
+		w.box(box.Type, func() { w.bytes(box.Data) })
+	}
+}
+
+func (ftyp *FileTypeBox) write(w *boxWriter) {
+	w.box(ftyp.Type, func() {
+		w.bytes(ftyp.MajorBrand[:])
+		w.bytes(ftyp.MinorVersion[:])
+		for _, brand := range ftyp.CompatibleBrands {
+			w.bytes(brand[:])
+		}
+	})
+}
+
+func (moov *MovieBox) write(w *boxWriter) {
+	w.box(moovType, func() {
+		if moov.Mvhd != nil {
+			moov.Mvhd.write(w)
+		}
+		for _, trak := range moov.Trak {
+			trak.write(w)
+		}
+		if moov.Mvex != nil {
+			moov.Mvex.write(w)
+		}
+		if moov.Udta != nil {
+			moov.Udta.write(w)
+		}
+		for _, pssh := range moov.Pssh {
+			pssh.write(w)
+		}
+		writeRawBoxes(w, moov.Boxes)
+	})
+}
+
+func (mvhd *MovieHeaderBox) write(w *boxWriter) {
+	w.box(mvhdType, func() {
+		w.fullBox(mvhd.FullBox)
+		w.uint(mvhd.Version, mvhd.CreationTime)
+		w.uint(mvhd.Version, mvhd.ModificationTime)
+		w.u32(mvhd.Timescale)
+		w.uint(mvhd.Version, mvhd.Duration)
+		w.u32(uint32(mvhd.Rate))
+		w.u16(uint16(mvhd.Volume))
+		w.u16(mvhd.Reserved)
+		for _, v := range mvhd.Reserved_ {
+			w.u32(v)
+		}
+		for _, v := range mvhd.Matrix {
+			w.u32(uint32(v))
+		}
+		for _, v := range mvhd.PreDefined {
+			w.u32(uint32(v))
+		}
+		w.u32(mvhd.NextTrackID)
+	})
+}
+
+func (trak *TrackBox) write(w *boxWriter) {
+	w.box(trakType, func() {
+		if trak.Tkhd != nil {
+			trak.Tkhd.write(w)
+		}
+		if trak.Tref != nil {
+			trak.Tref.write(w)
+		}
+		if trak.Edts != nil {
+			trak.Edts.write(w)
+		}
+		if trak.Mdia != nil {
+			trak.Mdia.write(w)
+		}
+		if trak.Udta != nil {
+			trak.Udta.write(w)
+		}
+		writeRawBoxes(w, trak.Boxes)
+	})
+}
+
+func (tkhd *TrackHeaderBox) write(w *boxWriter) {
+	w.box(tkhdType, func() {
+		w.fullBox(tkhd.FullBox)
+		w.uint(tkhd.Version, tkhd.CreationTime)
+		w.uint(tkhd.Version, tkhd.ModificationTime)
+		w.u32(tkhd.TrackID)
+		w.u32(tkhd.Reserved)
+		w.uint(tkhd.Version, tkhd.Duration)
+		for _, v := range tkhd.Reserved_ {
+			w.u32(v)
+		}
+		w.u16(uint16(tkhd.Layer))
+		w.u16(uint16(tkhd.AlternateGroup))
+		w.u16(uint16(tkhd.Volume))
+		w.u16(tkhd.Reserved__)
+		for _, v := range tkhd.Matrix {
+			w.u32(uint32(v))
+		}
+		w.u32(tkhd.Width)
+		w.u32(tkhd.Height)
+	})
+}
+
+func (edts *EditBox) write(w *boxWriter) {
+	w.box(edtsType, func() {
+		if elst := edts.Elst; elst != nil {
+			w.box(elstType, func() {
+				w.fullBox(elst.FullBox)
+				w.u32(uint32(len(elst.Entries)))
+				for _, entry := range elst.Entries {
+					w.uint(elst.Version, entry.SegmentDuration)
+					w.uint(elst.Version, uint64(entry.MediaTime))
+					w.u16(uint16(entry.MediaRateInteger))
+					w.u16(uint16(entry.MediaRateFraction))
+				}
+			})
+		}
+		writeRawBoxes(w, edts.Boxes)
+	})
+}
+
+func (mdia *MediaBox) write(w *boxWriter) {
+	w.box(mdiaType, func() {
+		if mdhd := mdia.Mdhd; mdhd != nil {
+			w.box(mdhdType, func() {
+				w.fullBox(mdhd.FullBox)
+				w.uint(mdhd.Version, mdhd.CreationTime)
+				w.uint(mdhd.Version, mdhd.ModificationTime)
+				w.u32(mdhd.Timescale)
+				w.uint(mdhd.Version, mdhd.Duration)
+				w.u16(mdhd.Language)
+				w.u16(mdhd.PreDefined)
+			})
+		}
+		if mdia.Hdlr != nil {
+			mdia.Hdlr.write(w)
+		}
+		if mdia.Minf != nil {
+			mdia.Minf.write(w)
+		}
+		writeRawBoxes(w, mdia.Boxes)
+	})
+}
+
+func (hdlr *HandlerBox) write(w *boxWriter) {
+	w.box(hdlrType, func() {
+		w.fullBox(hdlr.FullBox)
+		w.u32(hdlr.PreDefined)
+		w.bytes(hdlr.HandlerType[:])
+		for _, v := range hdlr.Reserved {
+			w.u32(v)
+		}
+		w.cstring(hdlr.Name)
+	})
+}
+
+func (minf *MediaInformationBox) write(w *boxWriter) {
+	w.box(minfType, func() {
+		if minf.Vmhd != nil {
+			w.box(vmhdType, func() { w.fixed(minf.Vmhd) })
+		}
+		if minf.Smhd != nil {
+			w.box(smhdType, func() { w.fixed(minf.Smhd) })
+		}
+		if minf.Hmhd != nil {
+			w.box(hmhdType, func() { w.fixed(minf.Hmhd) })
+		}
+		if minf.Nmhd != nil {
+			w.box(nmhdType, func() { w.fixed(minf.Nmhd) })
+		}
+		if dinf := minf.Dinf; dinf != nil {
+			w.box(dinfType, func() {
+				if dref := dinf.Dref; dref != nil {
+					w.box(drefType, func() {
+						w.fullBox(dref.FullBox)
+						w.u32(uint32(len(dref.Entries)))
+						for _, entry := range dref.Entries {
+							w.box(entry.Type, func() {
+								w.fullBox(entry.FullBox)
+								if entry.Type == urnType {
+									w.cstring(entry.Name)
+								}
+								if entry.Location != "" || entry.Type == urnType {
+									w.cstring(entry.Location)
+								}
+							})
+						}
+					})
+				}
+				writeRawBoxes(w, dinf.Boxes)
+			})
+		}
+		if minf.Stbl != nil {
+			minf.Stbl.write(w)
+		}
+		writeRawBoxes(w, minf.Boxes)
+	})
+}
+
+func (stbl *SampleTableBox) write(w *boxWriter) {
+	w.box(stblType, func() {
+		if stsd := stbl.Stsd; stsd != nil {
+			w.box(stsdType, func() {
+				w.fullBox(stsd.FullBox)
+				w.u32(uint32(len(stsd.Entries)))
+				for _, entry := range stsd.Entries {
+					entry.write(w)
+				}
+			})
+		}
+		if stts := stbl.Stts; stts != nil {
+			w.box(sttsType, func() {
+				w.fullBox(stts.FullBox)
+				w.u32(uint32(len(stts.Entries)))
+				for _, entry := range stts.Entries {
+					w.u32(entry.SampleCount)
+					w.u32(entry.SampleDelta)
+				}
+			})
+		}
+		if ctts := stbl.Ctts; ctts != nil {
+			w.box(cttsType, func() {
+				w.fullBox(ctts.FullBox)
+				w.u32(uint32(len(ctts.Entries)))
+				for _, entry := range ctts.Entries {
+					w.u32(entry.SampleCount)
+					w.u32(uint32(entry.SampleOffset))
+				}
+			})
+		}
+		for _, stss := range []*SyncSampleBox{stbl.Stss, stbl.Stps} {
+			if stss == nil {
+				continue
+			}
+			w.box(stss.Type, func() {
+				w.fullBox(stss.FullBox)
+				w.u32(uint32(len(stss.SampleNumber)))
+				for _, v := range stss.SampleNumber {
+					w.u32(v)
+				}
+			})
+		}
+		if sdtp := stbl.Sdtp; sdtp != nil {
+			w.box(sdtpType, func() {
+				w.fullBox(sdtp.FullBox)
+				w.bytes(sdtp.Entries)
+			})
+		}
+		if stsc := stbl.Stsc; stsc != nil {
+			w.box(stscType, func() {
+				w.fullBox(stsc.FullBox)
+				w.u32(uint32(len(stsc.Entries)))
+				for _, entry := range stsc.Entries {
+					w.u32(entry.FirstChunk)
+					w.u32(entry.SamplesPerChunk)
+					w.u32(entry.SampleDescriptionIndex)
+				}
+			})
+		}
+		if stsz := stbl.Stsz; stsz != nil {
+			w.box(stszType, func() {
+				w.fullBox(stsz.FullBox)
+				w.u32(stsz.SampleSize)
+				w.u32(stsz.SampleCount)
+				if stsz.SampleSize == 0 {
+					for _, v := range stsz.EntrySize {
+						w.u32(v)
+					}
+				}
+			})
+		}
+		if stz2 := stbl.Stz2; stz2 != nil {
+			w.box(stz2Type, func() {
+				w.fullBox(stz2.FullBox)
+				w.bytes(stz2.Reserved[:])
+				w.u8(stz2.FieldSize)
+				w.u32(uint32(len(stz2.EntrySize)))
+				for i, v := range stz2.EntrySize {
+					switch stz2.FieldSize {
+					case 4:
+						if i%2 == 0 {
+							w.u8(uint8(v << 4))
+						} else {
+							w.b[len(w.b)-1] |= uint8(v & 0xF)
+						}
+					case 8:
+						w.u8(uint8(v))
+					case 16:
+						w.u16(uint16(v))
+					}
+				}
+			})
+		}
+		if stco := stbl.Stco; stco != nil {
+			w.box(stco.Type, func() {
+				w.fullBox(stco.FullBox)
+				w.u32(uint32(len(stco.ChunkOffset)))
+				for _, v := range stco.ChunkOffset {
+					if stco.Type == co64Type {
+						w.u64(v)
+					} else {
+						w.u32(uint32(v))
+					}
+				}
+			})
+		}
+		for _, sbgp := range stbl.Sbgp {
+			sbgp.write(w)
+		}
+		for _, sgpd := range stbl.Sgpd {
+			sgpd.write(w)
+		}
+		for _, saiz := range stbl.Saiz {
+			saiz.write(w)
+		}
+		for _, saio := range stbl.Saio {
+			saio.write(w)
+		}
+		writeRawBoxes(w, stbl.Boxes)
+	})
+}
+
+func (entry *SampleDescription) write(w *boxWriter) {
+	w.box(entry.Type, func() {
+		switch {
+		case entry.Audio != nil:
+			w.fixed(entry.Audio)
+			w.bytes(entry.Data)
+		case entry.Visual != nil:
+			w.fixed(entry.Visual)
+		default:
+			w.fixed(&entry.SampleEntry)
+			w.bytes(entry.Data)
+			return
+		}
+
+		if entry.AvcC != nil {
+			entry.AvcC.write(w)
+		}
+		if entry.HvcC != nil {
+			entry.HvcC.write(w)
+		}
+		// The 'esds' box of QuickTime sound descriptions is written as part of the 'wave' box.
+		if entry.Esds != nil && !entry.hasBox(waveType) {
+			entry.Esds.write(w)
+		}
+		if entry.Sinf != nil {
+			entry.Sinf.write(w)
+		}
+		writeRawBoxes(w, entry.Boxes)
+	})
+}
+
+func (avcC *AVCConfigurationBox) write(w *boxWriter) {
+	w.box(avcCType, func() {
+		w.u8(avcC.ConfigurationVersion)
+		w.u8(avcC.AVCProfileIndication)
+		w.u8(avcC.ProfileCompatibility)
+		w.u8(avcC.AVCLevelIndication)
+		w.u8(0b11111100 | avcC.LengthSizeMinusOne)
+		w.u8(0b11100000 | uint8(len(avcC.SequenceParameterSets)))
+		for _, sps := range avcC.SequenceParameterSets {
+			w.u16(uint16(len(sps)))
+			w.bytes(sps)
+		}
+		w.u8(uint8(len(avcC.PictureParameterSets)))
+		for _, pps := range avcC.PictureParameterSets {
+			w.u16(uint16(len(pps)))
+			w.bytes(pps)
+		}
+		if ext := avcC.Ext; ext != nil {
+			w.u8(0b11111100 | ext.ChromaFormat)
+			w.u8(0b11111000 | ext.BitDepthLumaMinus8)
+			w.u8(0b11111000 | ext.BitDepthChromaMinus8)
+			w.u8(uint8(len(avcC.SequenceParameterSetExts)))
+			for _, spsExt := range avcC.SequenceParameterSetExts {
+				w.u16(uint16(len(spsExt)))
+				w.bytes(spsExt)
+			}
+		}
+	})
+}
+
+func (hvcC *HEVCConfigurationBox) write(w *boxWriter) {
+	w.box(hvcCType, func() {
+		w.u8(hvcC.ConfigurationVersion)
+		v := hvcC.GeneralProfileSpace<<6 | hvcC.GeneralProfileIdc&0x1F
+		if hvcC.GeneralTierFlag {
+			v |= 0x20
+		}
+		w.u8(v)
+		w.u32(hvcC.GeneralProfileCompatibilityFlags)
+		w.u32(uint32(hvcC.GeneralConstraintIndicatorFlags >> 16))
+		w.u16(uint16(hvcC.GeneralConstraintIndicatorFlags))
+		w.u8(hvcC.GeneralLevelIdc)
+		w.u16(0xF000 | hvcC.MinSpatialSegmentationIdc)
+		w.u8(0b11111100 | hvcC.ParallelismType)
+		w.u8(0b11111100 | hvcC.ChromaFormat)
+		w.u8(0b11111000 | hvcC.BitDepthLumaMinus8)
+		w.u8(0b11111000 | hvcC.BitDepthChromaMinus8)
+		w.u16(hvcC.AvgFrameRate)
+		v = hvcC.ConstantFrameRate<<6 | hvcC.NumTemporalLayers<<3 | hvcC.LengthSizeMinusOne
+		if hvcC.TemporalIDNested {
+			v |= 0b100
+		}
+		w.u8(v)
+		w.u8(uint8(len(hvcC.Arrays)))
+		for _, array := range hvcC.Arrays {
+			v := array.NalUnitType & 0x3F
+			if array.ArrayCompleteness {
+				v |= 0x80
+			}
+			w.u8(v)
+			w.u16(uint16(len(array.NalUnits)))
+			for _, nal := range array.NalUnits {
+				w.u16(uint16(len(nal)))
+				w.bytes(nal)
+			}
+		}
+	})
+}
+
+func (udta *UserDataBox) write(w *boxWriter) {
+	w.box(udtaType, func() {
+		if udta.Meta != nil {
+			udta.Meta.write(w)
+		}
+		if udta.Chpl != nil {
+			udta.Chpl.write(w)
+		}
+		writeRawBoxes(w, udta.Boxes)
+	})
+}
+
+func (mvex *MovieExtendsBox) write(w *boxWriter) {
+	w.box(mvexType, func() {
+		if mehd := mvex.Mehd; mehd != nil {
+			w.box(mehdType, func() {
+				w.fullBox(mehd.FullBox)
+				w.uint(mehd.Version, mehd.FragmentDuration)
+			})
+		}
+		for _, trex := range mvex.Trex {
+			w.box(trexType, func() { w.fixed(trex) })
+		}
+		writeRawBoxes(w, mvex.Boxes)
+	})
+}
+
+func (moof *MovieFragmentBox) write(w *boxWriter) {
+	w.box(moofType, func() {
+		if moof.Mfhd != nil {
+			w.box(mfhdType, func() { w.fixed(moof.Mfhd) })
+		}
+		for _, traf := range moof.Traf {
+			traf.write(w)
+		}
+		for _, pssh := range moof.Pssh {
+			pssh.write(w)
+		}
+		writeRawBoxes(w, moof.Boxes)
+	})
+}
+
+func (traf *TrackFragmentBox) write(w *boxWriter) {
+	w.box(trafType, func() {
+		if tfhd := traf.Tfhd; tfhd != nil {
+			w.box(tfhdType, func() {
+				w.fullBox(tfhd.FullBox)
+				flags := tfhd.Flag()
+				w.u32(tfhd.TrackID)
+				if flags&tfhdBaseDataOffsetPresent != 0 {
+					w.u64(tfhd.BaseDataOffset)
+				}
+				if flags&tfhdSampleDescriptionIndexPresent != 0 {
+					w.u32(tfhd.SampleDescriptionIndex)
+				}
+				if flags&tfhdDefaultSampleDurationPresent != 0 {
+					w.u32(tfhd.DefaultSampleDuration)
+				}
+				if flags&tfhdDefaultSampleSizePresent != 0 {
+					w.u32(tfhd.DefaultSampleSize)
+				}
+				if flags&tfhdDefaultSampleFlagsPresent != 0 {
+					w.u32(tfhd.DefaultSampleFlags)
+				}
+			})
+		}
+		if tfdt := traf.Tfdt; tfdt != nil {
+			w.box(tfdtType, func() {
+				w.fullBox(tfdt.FullBox)
+				w.uint(tfdt.Version, tfdt.BaseMediaDecodeTime)
+			})
+		}
+		for _, trun := range traf.Trun {
+			trun.write(w)
+		}
+		if traf.Senc != nil {
+			traf.Senc.write(w)
+		}
+		for _, sbgp := range traf.Sbgp {
+			sbgp.write(w)
+		}
+		for _, sgpd := range traf.Sgpd {
+			sgpd.write(w)
+		}
+		for _, saiz := range traf.Saiz {
+			saiz.write(w)
+		}
+		for _, saio := range traf.Saio {
+			saio.write(w)
+		}
+		writeRawBoxes(w, traf.Boxes)
+	})
+}
+
+func (trun *TrackRunBox) write(w *boxWriter) {
+	w.box(trunType, func() {
+		w.fullBox(trun.FullBox)
+		flags := trun.Flag()
+		w.u32(uint32(trun.sampleCount()))
+		if flags&trunDataOffsetPresent != 0 {
+			w.u32(uint32(trun.DataOffset))
+		}
+		if flags&trunFirstSampleFlagsPresent != 0 {
+			w.u32(trun.FirstSampleFlags)
+		}
+		for _, entry := range trun.Entries {
+			if flags&trunSampleDurationPresent != 0 {
+				w.u32(entry.SampleDuration)
+			}
+			if flags&trunSampleSizePresent != 0 {
+				w.u32(entry.SampleSize)
+			}
+			if flags&trunSampleFlagsPresent != 0 {
+				w.u32(entry.SampleFlags)
+			}
+			if flags&trunSampleCompositionTimeOffsetsPresent != 0 {
+				w.u32(uint32(entry.SampleCompositionTimeOffset))
+			}
+		}
+	})
+}
+
+func (sidx *SegmentIndexBox) write(w *boxWriter) {
+	w.box(sidxType, func() {
+		w.fullBox(sidx.FullBox)
+		w.u32(sidx.ReferenceID)
+		w.u32(sidx.Timescale)
+		w.uint(sidx.Version, sidx.EarliestPresentationTime)
+		w.uint(sidx.Version, sidx.FirstOffset)
+		w.u16(sidx.Reserved)
+		w.u16(uint16(len(sidx.References)))
+		for _, ref := range sidx.References {
+			v := ref.ReferencedSize & 0x7FFFFFFF
+			if ref.ReferenceType {
+				v |= 1 << 31
+			}
+			w.u32(v)
+			w.u32(ref.SubsegmentDuration)
+			v = uint32(ref.SAPType&0x07)<<28 | ref.SAPDeltaTime&0x0FFFFFFF
+			if ref.StartsWithSAP {
+				v |= 1 << 31
+			}
+			w.u32(v)
+		}
+	})
+}
+
+func (mfra *MovieFragmentRandomAccessBox) write(w *boxWriter) {
+	w.box(mfraType, func() {
+		for _, tfra := range mfra.Tfra {
+			w.box(tfraType, func() {
+				w.fullBox(tfra.FullBox)
+				w.u32(tfra.TrackID)
+				w.u32(uint32(tfra.LengthSizeOfTrafNum&0x03)<<4 | uint32(tfra.LengthSizeOfTrunNum&0x03)<<2 |
+					uint32(tfra.LengthSizeOfSampleNum&0x03))
+				w.u32(uint32(len(tfra.Entries)))
+				number := func(lengthSize uint8, n uint32) {
+					for i := int(lengthSize & 0x03); i >= 0; i-- {
+						w.u8(uint8(n >> (8 * i)))
+					}
+				}
+				for _, entry := range tfra.Entries {
+					w.uint(tfra.Version, entry.Time)
+					w.uint(tfra.Version, entry.MoofOffset)
+					number(tfra.LengthSizeOfTrafNum, entry.TrafNumber)
+					number(tfra.LengthSizeOfTrunNum, entry.TrunNumber)
+					number(tfra.LengthSizeOfSampleNum, entry.SampleNumber)
+				}
+			})
+		}
+		writeRawBoxes(w, mfra.Boxes)
+		if mfra.Mfro != nil {
+			w.box(mfroType, func() { w.fixed(mfra.Mfro) })
+		}
+	})
+}
+
+func (entry *SampleDescription) hasBox(t [4]byte) bool {
+	for _, box := range entry.Boxes {
+		if box.Type == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,327 @@
+package mpeg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ISO/IEC 14496-15: length prefixed NAL units of 'avc1', 'avc3', 'hvc1' and 'hev1' samples and Annex B byte streams
+
+var (
+	errNalUnitLength = errors.New("mpeg: invalid NAL unit length")
+	errNoAvcC        = errors.New("mpeg: no avcC or hvcC box")
+)
+
+// NewAVCConfigurationBox returns the decoder configuration of the sequence and picture parameter sets found in the
+// NAL units, with 4 byte NAL unit lengths. The chroma format and bit depths of the high profiles are taken from the
+// first sequence parameter set.
+func NewAVCConfigurationBox(nals [][]byte) (*AVCConfigurationBox, error) {
+	avcC := &AVCConfigurationBox{Box: Box{Type: avcCType}}
+	avcC.ConfigurationVersion = 1
+	avcC.LengthSizeMinusOne = 3
+	for _, nal := range nals {
+		switch NalUnitType(nal) {
+		case NalSps:
+			if !containsNal(avcC.SequenceParameterSets, nal) {
+				avcC.SequenceParameterSets = append(avcC.SequenceParameterSets, nal)
+			}
+		case NalPps:
+			if !containsNal(avcC.PictureParameterSets, nal) {
+				avcC.PictureParameterSets = append(avcC.PictureParameterSets, nal)
+			}
+		case NalSpsExt:
+			if !containsNal(avcC.SequenceParameterSetExts, nal) {
+				avcC.SequenceParameterSetExts = append(avcC.SequenceParameterSetExts, nal)
+			}
+		}
+	}
+	if len(avcC.SequenceParameterSets) == 0 || len(avcC.SequenceParameterSets[0]) < 4 {
+		return nil, fmt.Errorf("%w: no sequence parameter set", errNoAvcC)
+	}
+	sps := avcC.SequenceParameterSets[0]
+	avcC.AVCProfileIndication = sps[1]
+	avcC.ProfileCompatibility = sps[2]
+	avcC.AVCLevelIndication = sps[3]
+	avcC.NumOfSequenceParameterSets = uint8(len(avcC.SequenceParameterSets))
+
+	if hasAvcCExt(avcC.AVCProfileIndication) {
+		parsed, err := ParseSps(sps)
+		if err != nil {
+			return nil, err
+		}
+		avcC.Ext = &AVCConfigurationBoxExt{
+			ChromaFormat:                 uint8(parsed.ChromaFormatIdc),
+			BitDepthLumaMinus8:           uint8(parsed.BitDepthLumaMinus8),
+			BitDepthChromaMinus8:         uint8(parsed.BitDepthChromaMinus8),
+			NumOfSequenceParameterSetExt: uint8(len(avcC.SequenceParameterSetExts)),
+		}
+	} else {
+		avcC.SequenceParameterSetExts = nil
+	}
+	return avcC, nil
+}
+
+func containsNal(nals [][]byte, nal []byte) bool {
+	for _, n := range nals {
+		if string(n) == string(nal) {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload returns the box without its header, the MuxTrack.Config of CodecAvc.
+func (avcC *AVCConfigurationBox) Payload() []byte {
+	w := &boxWriter{}
+	avcC.write(w)
+	return w.b[8:]
+}
+
+// NalUnits splits a sample into its NAL units, which are prefixed by their length of LengthSizeMinusOne+1 bytes.
+func (avcC *AVCConfigurationBox) NalUnits(sample []byte) ([][]byte, error) {
+	return splitNalUnits(sample, int(avcC.LengthSizeMinusOne)+1)
+}
+
+// splitNalUnits splits a sample of NAL units prefixed by their length of lengthSize bytes.
+func splitNalUnits(sample []byte, lengthSize int) ([][]byte, error) {
+	if lengthSize == 3 {
+		return nil, fmt.Errorf("%w: size of length %d", errNalUnitLength, lengthSize)
+	}
+
+	var nals [][]byte
+	for len(sample) > 0 {
+		if len(sample) < lengthSize {
+			return nals, errNalUnitLength
+		}
+		var n uint32
+		switch lengthSize {
+		case 1:
+			n = uint32(sample[0])
+		case 2:
+			n = uint32(binary.BigEndian.Uint16(sample))
+		case 4:
+			n = binary.BigEndian.Uint32(sample)
+		}
+		sample = sample[lengthSize:]
+		if uint64(n) > uint64(len(sample)) {
+			return nals, fmt.Errorf("%w: %d of %d bytes", errNalUnitLength, n, len(sample))
+		}
+		nals = append(nals, sample[:n])
+		sample = sample[n:]
+	}
+	return nals, nil
+}
+
+// AnnexB converts a sample into an access unit of an Annex B byte stream. It starts with an access unit delimiter,
+// and the parameter sets of the configuration are inserted before IDR pictures which do not carry their own, as in
+// 'avc3' tracks.
+func (avcC *AVCConfigurationBox) AnnexB(sample []byte) ([]byte, error) {
+	nals, err := avcC.NalUnits(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := make([][]byte, 0, len(nals)+1+len(avcC.SequenceParameterSets)+len(avcC.PictureParameterSets))
+	unit = append(unit, accessUnitDelimiter)
+	if len(nals) > 0 && NalUnitType(nals[0]) == NalAud {
+		unit[0] = nals[0]
+		nals = nals[1:]
+	}
+	if HasIdr(nals) && !hasNalUnit(nals, NalSps) {
+		unit = append(unit, avcC.SequenceParameterSets...)
+		unit = append(unit, avcC.SequenceParameterSetExts...)
+		unit = append(unit, avcC.PictureParameterSets...)
+	}
+	unit = append(unit, nals...)
+	return AnnexB(unit), nil
+}
+
+// Sample converts the NAL units of an access unit into a sample prefixed by lengths of LengthSizeMinusOne+1 bytes.
+// Access unit delimiters are dropped, and so are the parameter sets unless parameterSets is set for 'avc3' tracks.
+// It returns errNalUnitLength if a NAL unit is too long for the size of the lengths.
+func (avcC *AVCConfigurationBox) Sample(nals [][]byte, parameterSets bool) ([]byte, error) {
+	var sample []byte
+	for _, nal := range nals {
+		switch NalUnitType(nal) {
+		case NalAud:
+			continue
+		case NalSps, NalPps, NalSpsExt:
+			if !parameterSets {
+				continue
+			}
+		}
+		var err error
+		if sample, err = appendNalUnit(sample, nal, int(avcC.LengthSizeMinusOne)+1); err != nil {
+			return nil, err
+		}
+	}
+	return sample, nil
+}
+
+// appendNalUnit appends a NAL unit prefixed by its length of lengthSize bytes.
+func appendNalUnit(sample []byte, nal []byte, lengthSize int) ([]byte, error) {
+	if uint64(len(nal)) >= 1<<(8*lengthSize) {
+		return nil, fmt.Errorf("%w: %d bytes with lengths of %d bytes", errNalUnitLength, len(nal), lengthSize)
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(nal)))
+	sample = append(sample, length[4-lengthSize:]...)
+	return append(sample, nal...), nil
+}
+
+// NewHEVCConfigurationBox returns the decoder configuration of the video, sequence and picture parameter sets and
+// the SEI found in the NAL units, with 4 byte NAL unit lengths. The profile, chroma format and bit depths are taken
+// from the first sequence parameter set.
+func NewHEVCConfigurationBox(nals [][]byte) (*HEVCConfigurationBox, error) {
+	hvcC := &HEVCConfigurationBox{Box: Box{Type: hvcCType}}
+	hvcC.ConfigurationVersion = 1
+	hvcC.LengthSizeMinusOne = 3
+	for _, t := range []uint8{HevcNalVps, HevcNalSps, HevcNalPps, HevcNalPrefixSei} {
+		array := HEVCNalUnitArray{NalUnitType: t}
+		for _, nal := range nals {
+			if HevcNalUnitType(nal) == t && !containsNal(array.NalUnits, nal) {
+				array.NalUnits = append(array.NalUnits, nal)
+			}
+		}
+		if len(array.NalUnits) > 0 {
+			// The parameter sets of 'hvc1' tracks are all in the configuration, unlike the SEI messages.
+			array.ArrayCompleteness = t != HevcNalPrefixSei
+			hvcC.Arrays = append(hvcC.Arrays, array)
+		}
+	}
+	spss := hvcC.NalUnitsOfType(HevcNalSps)
+	if len(spss) == 0 {
+		return nil, fmt.Errorf("%w: no sequence parameter set", errNoAvcC)
+	}
+	sps, err := ParseHevcSps(spss[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ptl := sps.ProfileTierLevel
+	hvcC.GeneralProfileSpace = uint8(ptl.GeneralProfileSpace)
+	hvcC.GeneralTierFlag = ptl.GeneralTierFlag
+	hvcC.GeneralProfileIdc = uint8(ptl.GeneralProfileIdc)
+	hvcC.GeneralProfileCompatibilityFlags = ptl.GeneralProfileCompatibilityFlags
+	hvcC.GeneralConstraintIndicatorFlags = ptl.GeneralConstraintIndicatorFlags
+	hvcC.GeneralLevelIdc = uint8(ptl.GeneralLevelIdc)
+	if sps.Vui != nil && sps.Vui.BitstreamRestrictionFlag {
+		hvcC.MinSpatialSegmentationIdc = uint16(sps.Vui.MinSpatialSegmentationIdc)
+	}
+	hvcC.ChromaFormat = uint8(sps.ChromaFormatIdc)
+	hvcC.BitDepthLumaMinus8 = uint8(sps.BitDepthLumaMinus8)
+	hvcC.BitDepthChromaMinus8 = uint8(sps.BitDepthChromaMinus8)
+	// The average frame rate in frames per 256 seconds is limited to 16 bits, just above 255 frames per second.
+	hvcC.AvgFrameRate = math.MaxUint16
+	if frameRate := sps.FrameRate()*256 + 0.5; frameRate < math.MaxUint16 {
+		hvcC.AvgFrameRate = uint16(frameRate)
+	}
+	hvcC.NumTemporalLayers = uint8(sps.SpsMaxSubLayersMinus1 + 1)
+	hvcC.TemporalIDNested = sps.SpsTemporalIDNestingFlag
+	return hvcC, nil
+}
+
+// NalUnitsOfType returns the NAL units of the array of a type, such as HevcNalSps.
+func (hvcC *HEVCConfigurationBox) NalUnitsOfType(nalUnitType uint8) [][]byte {
+	for _, array := range hvcC.Arrays {
+		if array.NalUnitType == nalUnitType {
+			return array.NalUnits
+		}
+	}
+	return nil
+}
+
+// Payload returns the box without its header, the MuxTrack.Config of CodecHevc.
+func (hvcC *HEVCConfigurationBox) Payload() []byte {
+	w := &boxWriter{}
+	hvcC.write(w)
+	return w.b[8:]
+}
+
+// NalUnits splits a sample into its NAL units, which are prefixed by their length of LengthSizeMinusOne+1 bytes.
+func (hvcC *HEVCConfigurationBox) NalUnits(sample []byte) ([][]byte, error) {
+	return splitNalUnits(sample, int(hvcC.LengthSizeMinusOne)+1)
+}
+
+// AnnexB converts a sample into an access unit of an Annex B byte stream. It starts with an access unit delimiter,
+// and the parameter sets of the configuration are inserted before IRAP pictures which do not carry their own, as in
+// 'hev1' tracks.
+func (hvcC *HEVCConfigurationBox) AnnexB(sample []byte) ([]byte, error) {
+	nals, err := hvcC.NalUnits(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := make([][]byte, 0, len(nals)+4)
+	unit = append(unit, hevcAccessUnitDelimiter)
+	if len(nals) > 0 && HevcNalUnitType(nals[0]) == HevcNalAud {
+		unit[0] = nals[0]
+		nals = nals[1:]
+	}
+	if HasIrap(nals) && !hasHevcNalUnit(nals, HevcNalSps) {
+		unit = append(unit, hvcC.NalUnitsOfType(HevcNalVps)...)
+		unit = append(unit, hvcC.NalUnitsOfType(HevcNalSps)...)
+		unit = append(unit, hvcC.NalUnitsOfType(HevcNalPps)...)
+	}
+	unit = append(unit, nals...)
+	return AnnexB(unit), nil
+}
+
+// Sample converts the NAL units of an access unit into a sample prefixed by lengths of LengthSizeMinusOne+1 bytes.
+// Access unit delimiters are dropped, and so are the parameter sets unless parameterSets is set for 'hev1' tracks.
+// It returns errNalUnitLength if a NAL unit is too long for the size of the lengths.
+func (hvcC *HEVCConfigurationBox) Sample(nals [][]byte, parameterSets bool) ([]byte, error) {
+	var sample []byte
+	for _, nal := range nals {
+		switch HevcNalUnitType(nal) {
+		case HevcNalAud:
+			continue
+		case HevcNalVps, HevcNalSps, HevcNalPps:
+			if !parameterSets {
+				continue
+			}
+		}
+		var err error
+		if sample, err = appendNalUnit(sample, nal, int(hvcC.LengthSizeMinusOne)+1); err != nil {
+			return nil, err
+		}
+	}
+	return sample, nil
+}
+
+// WriteAnnexB writes the samples of an 'avc1', 'avc3', 'hvc1' or 'hev1' track as an Annex B byte stream, a raw
+// .h264 or .h265 file.
+func (d *Demuxer) WriteAnnexB(w io.Writer, trackID uint32) error {
+	track := d.track(trackID)
+	if track == nil {
+		return fmt.Errorf("mpeg: no track %d", trackID)
+	}
+	entries := track.trak.SampleDescriptions()
+
+	for i, sample := range track.samples {
+		index := int(sample.SampleDescriptionIndex)
+		if index == 0 || index > len(entries) || entries[index-1].AvcC == nil && entries[index-1].HvcC == nil {
+			return fmt.Errorf("track %d sample %d: %w", trackID, i, errNoAvcC)
+		}
+		p, err := d.readPacket(track, i)
+		if err != nil {
+			return err
+		}
+		var b []byte
+		if entry := entries[index-1]; entry.AvcC != nil {
+			b, err = entry.AvcC.AnnexB(p.Data)
+		} else {
+			b, err = entry.HvcC.AnnexB(p.Data)
+		}
+		if err != nil {
+			return fmt.Errorf("track %d sample %d: %w", trackID, i, err)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
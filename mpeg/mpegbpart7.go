@@ -0,0 +1,890 @@
+package mpeg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Common Encryption of ISO base media files (ISO/IEC 23001-7): protection scheme boxes, sample auxiliary
+// information, sample groups and the decryption of protected samples with clear keys
+
+var (
+	sinfType = [4]byte{'s', 'i', 'n', 'f'}
+	frmaType = [4]byte{'f', 'r', 'm', 'a'}
+	schmType = [4]byte{'s', 'c', 'h', 'm'}
+	schiType = [4]byte{'s', 'c', 'h', 'i'}
+	tencType = [4]byte{'t', 'e', 'n', 'c'}
+	psshType = [4]byte{'p', 's', 's', 'h'}
+	sencType = [4]byte{'s', 'e', 'n', 'c'}
+	saizType = [4]byte{'s', 'a', 'i', 'z'}
+	saioType = [4]byte{'s', 'a', 'i', 'o'}
+	sbgpType = [4]byte{'s', 'b', 'g', 'p'}
+	sgpdType = [4]byte{'s', 'g', 'p', 'd'}
+	seigType = [4]byte{'s', 'e', 'i', 'g'}
+
+	// Protection schemes of ISO/IEC 23001-7 4.2
+	SchemeCenc = [4]byte{'c', 'e', 'n', 'c'} // AES-CTR of whole blocks and partial blocks
+	SchemeCbc1 = [4]byte{'c', 'b', 'c', '1'} // AES-CBC of whole blocks
+	SchemeCens = [4]byte{'c', 'e', 'n', 's'} // AES-CTR with a pattern of encrypted blocks
+	SchemeCbcs = [4]byte{'c', 'b', 'c', 's'} // AES-CBC with a pattern of encrypted blocks and a constant IV
+)
+
+var (
+	errEncryption = errors.New("mpeg: invalid sample encryption")
+	errScheme     = errors.New("mpeg: unsupported protection scheme")
+	errNoKey      = errors.New("mpeg: no key")
+)
+
+// Flag of the SampleEncryptionBox
+const sencUseSubSampleEncryption = 0x000002
+
+// ProtectionSchemeInfoBox is found in the sample entries of protected tracks, 'encv' or 'enca', and holds the
+// original format of the samples and the protection scheme.
+type ProtectionSchemeInfoBox struct {
+	Box
+	Frma  *OriginalFormatBox
+	Schm  *SchemeTypeBox
+	Schi  *SchemeInformationBox
+	Boxes []*RawBox
+}
+
+type OriginalFormatBox struct {
+	Box
+	DataFormat [4]byte // unsigned int(32), the coding name of the sample entry before it was protected
+}
+
+type SchemeTypeBox struct {
+	FullBox
+	SchemeType    [4]byte // unsigned int(32)
+	SchemeVersion uint32  // unsigned int(32)
+	SchemeURI     string  // utf8string, if the flag 0x000001 is set
+}
+
+type SchemeInformationBox struct {
+	Box
+	Tenc  *TrackEncryptionBox
+	Boxes []*RawBox
+}
+
+// CencSampleEncryptionInformation are the fields shared by the TrackEncryptionBox, the defaults of a track, and
+// the entries of the sample group 'seig', which override them for groups of samples.
+type CencSampleEncryptionInformation struct {
+	Reserved        uint8    // unsigned int(8)
+	CryptByteBlock  uint8    // unsigned int(4), reserved in version 0 of 'tenc'
+	SkipByteBlock   uint8    // unsigned int(4), reserved in version 0 of 'tenc'
+	IsProtected     uint8    // unsigned int(8)
+	PerSampleIVSize uint8    // unsigned int(8), 0, 8 or 16
+	KID             [16]byte // unsigned int(8)[16]
+	ConstantIVSize  uint8    // unsigned int(8), if IsProtected and PerSampleIVSize is 0
+	ConstantIV      []byte   // unsigned int(8)[ConstantIVSize]
+}
+
+type TrackEncryptionBox struct {
+	FullBox
+	CencSampleEncryptionInformation // default_*
+}
+
+// ProtectionSystemSpecificHeaderBox holds the data a DRM system needs to obtain the keys.
+type ProtectionSystemSpecificHeaderBox struct {
+	FullBox
+	SystemID [16]byte   // unsigned int(8)[16]
+	KIDs     [][16]byte // unsigned int(32) KID_count and unsigned int(8)[16] KIDs, version 1 only
+	Data     []byte     // unsigned int(32) DataSize and unsigned int(8)[DataSize]
+}
+
+// SampleEncryptionBox holds the IVs and subsample maps of the samples of a track fragment. The size of the IVs
+// depends on the encryption parameters of each sample, so the entries are kept in Data and decoded while the
+// samples are resolved by File.SampleEncryption.
+type SampleEncryptionBox struct {
+	FullBox
+	SampleCount uint32 // unsigned int(32)
+	Data        []byte
+}
+
+// SampleAuxiliaryInformationSizesBox holds the sizes of the auxiliary information of the samples, for Common
+// Encryption the IV and subsample map of each sample.
+type SampleAuxiliaryInformationSizesBox struct {
+	FullBox
+	AuxInfoType           [4]byte // unsigned int(32), if the flag 0x000001 is set
+	AuxInfoTypeParameter  uint32  // unsigned int(32), if the flag 0x000001 is set
+	DefaultSampleInfoSize uint8   // unsigned int(8)
+	SampleCount           uint32  // unsigned int(32)
+	SampleInfoSizes       []uint8 // unsigned int(8)[SampleCount], if DefaultSampleInfoSize is 0
+}
+
+// SampleAuxiliaryInformationOffsetsBox holds the positions of the auxiliary information: in the file for the sample
+// table, relative to the base data offset for track fragments. There is one offset for all samples, or one offset
+// per chunk or track run.
+type SampleAuxiliaryInformationOffsetsBox struct {
+	FullBox
+	AuxInfoType          [4]byte  // unsigned int(32), if the flag 0x000001 is set
+	AuxInfoTypeParameter uint32   // unsigned int(32), if the flag 0x000001 is set
+	Offsets              []uint64 // unsigned int(32) entry_count and unsigned int(32) / unsigned int(64)[]
+}
+
+// SampleToGroupBox assigns runs of samples to the entries of the SampleGroupDescriptionBox of the same grouping
+// type.
+type SampleToGroupBox struct {
+	FullBox
+	GroupingType          [4]byte // unsigned int(32)
+	GroupingTypeParameter uint32  // unsigned int(32), version 1 only
+	Entries               []SampleToGroupEntry
+}
+
+type SampleToGroupEntry struct {
+	SampleCount uint32 // unsigned int(32)
+
+	// GroupDescriptionIndex starts with 1, 0 if the samples are not in a group. In track fragments, indexes above
+	// 0x10000 refer to the SampleGroupDescriptionBox of the fragment.
+	GroupDescriptionIndex uint32 // unsigned int(32)
+}
+
+// SampleGroupDescriptionBox describes the sample groups of a grouping type. The entries are kept undecoded,
+// 'seig' entries are decoded by SampleEncryptionGroups.
+type SampleGroupDescriptionBox struct {
+	FullBox
+	GroupingType                  [4]byte  // unsigned int(32)
+	DefaultLength                 uint32   // unsigned int(32), version 1 only, entries have a length field if 0
+	DefaultSampleDescriptionIndex uint32   // unsigned int(32), version 2 and later
+	Entries                       [][]byte // unsigned int(32) entry_count and the entries
+}
+
+// SampleEncryption describes how a protected sample is encrypted. It is resolved from the defaults of the track,
+// the sample group 'seig' and the auxiliary information of the sample.
+type SampleEncryption struct {
+	Scheme         [4]byte // 'cenc', 'cbc1', 'cens' or 'cbcs'
+	KID            [16]byte
+	IV             []byte // Per-sample IV or the constant IV, 8 or 16 bytes
+	CryptByteBlock uint8  // Number of encrypted blocks of the pattern, no pattern if both are 0
+	SkipByteBlock  uint8  // Number of clear blocks of the pattern
+	Subsamples     []Subsample
+}
+
+// Subsample is a clear part of a sample followed by a protected part. The whole sample is protected if it has no
+// subsamples.
+type Subsample struct {
+	BytesOfClearData     uint16 // unsigned int(16)
+	BytesOfProtectedData uint32 // unsigned int(32)
+}
+
+func parseSinf(h Box, payload []byte, offset int64) (*ProtectionSchemeInfoBox, error) {
+	sinf := &ProtectionSchemeInfoBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case frmaType:
+			r := newBoxReader(payload)
+			sinf.Frma = &OriginalFormatBox{Box: h, DataFormat: r.fourCC()}
+			err = r.err
+		case schmType:
+			r := newBoxReader(payload)
+			sinf.Schm = &SchemeTypeBox{FullBox: r.fullBox(h)}
+			sinf.Schm.SchemeType = r.fourCC()
+			sinf.Schm.SchemeVersion = r.u32()
+			if sinf.Schm.Flag()&1 != 0 {
+				sinf.Schm.SchemeURI = r.cstring()
+			}
+			err = r.err
+		case schiType:
+			sinf.Schi, err = parseSchi(h, payload, offset+boxHeaderLen(h))
+		default:
+			sinf.Boxes = append(sinf.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return sinf, err
+}
+
+func parseSchi(h Box, payload []byte, offset int64) (*SchemeInformationBox, error) {
+	schi := &SchemeInformationBox{Box: h}
+
+	err := eachBox(payload, offset, func(h Box, payload []byte, offset int64) (err error) {
+		switch h.Type {
+		case tencType:
+			r := newBoxReader(payload)
+			schi.Tenc = &TrackEncryptionBox{FullBox: r.fullBox(h)}
+			schi.Tenc.CencSampleEncryptionInformation = readCencSampleEncryptionInformation(r)
+			err = r.err
+		default:
+			schi.Boxes = append(schi.Boxes, newRawBox(h, payload, offset))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Type, err)
+		}
+		return nil
+	})
+	return schi, err
+}
+
+func readCencSampleEncryptionInformation(r *boxReader) CencSampleEncryptionInformation {
+	info := CencSampleEncryptionInformation{Reserved: r.u8()}
+	pattern := r.u8()
+	info.CryptByteBlock, info.SkipByteBlock = pattern>>4, pattern&0xF
+	info.IsProtected = r.u8()
+	info.PerSampleIVSize = r.u8()
+	copy(info.KID[:], r.next(16))
+	if info.IsProtected != 0 && info.PerSampleIVSize == 0 {
+		info.ConstantIVSize = r.u8()
+		info.ConstantIV = r.next(int(info.ConstantIVSize))
+	}
+	return info
+}
+
+func parsePssh(h Box, payload []byte) (*ProtectionSystemSpecificHeaderBox, error) {
+	r := newBoxReader(payload)
+
+	pssh := &ProtectionSystemSpecificHeaderBox{FullBox: r.fullBox(h)}
+	copy(pssh.SystemID[:], r.next(16))
+	if pssh.Version > 0 {
+		n := r.u32()
+		for i := r.entries(n, 16); i > 0; i-- {
+			var kid [16]byte
+			copy(kid[:], r.next(16))
+			pssh.KIDs = append(pssh.KIDs, kid)
+		}
+	}
+	pssh.Data = r.next(int(r.u32()))
+	return pssh, r.err
+}
+
+func parseSenc(h Box, payload []byte) (*SampleEncryptionBox, error) {
+	r := newBoxReader(payload)
+
+	senc := &SampleEncryptionBox{FullBox: r.fullBox(h)}
+	senc.SampleCount = r.u32()
+	senc.Data = r.next(r.Len())
+	return senc, r.err
+}
+
+func parseSaiz(h Box, payload []byte) (*SampleAuxiliaryInformationSizesBox, error) {
+	r := newBoxReader(payload)
+
+	saiz := &SampleAuxiliaryInformationSizesBox{FullBox: r.fullBox(h)}
+	if saiz.Flag()&1 != 0 {
+		saiz.AuxInfoType = r.fourCC()
+		saiz.AuxInfoTypeParameter = r.u32()
+	}
+	saiz.DefaultSampleInfoSize = r.u8()
+	saiz.SampleCount = r.u32()
+	if saiz.DefaultSampleInfoSize == 0 {
+		saiz.SampleInfoSizes = r.next(r.entries(saiz.SampleCount, 1))
+	}
+	return saiz, r.err
+}
+
+func parseSaio(h Box, payload []byte) (*SampleAuxiliaryInformationOffsetsBox, error) {
+	r := newBoxReader(payload)
+
+	saio := &SampleAuxiliaryInformationOffsetsBox{FullBox: r.fullBox(h)}
+	if saio.Flag()&1 != 0 {
+		saio.AuxInfoType = r.fourCC()
+		saio.AuxInfoTypeParameter = r.u32()
+	}
+	size := 4
+	if saio.Version == 1 {
+		size = 8
+	}
+	n := r.entries(r.u32(), size)
+	saio.Offsets = make([]uint64, n)
+	for i := range saio.Offsets {
+		saio.Offsets[i] = r.uint(saio.Version)
+	}
+	return saio, r.err
+}
+
+func parseSbgp(h Box, payload []byte) (*SampleToGroupBox, error) {
+	r := newBoxReader(payload)
+
+	sbgp := &SampleToGroupBox{FullBox: r.fullBox(h)}
+	sbgp.GroupingType = r.fourCC()
+	if sbgp.Version == 1 {
+		sbgp.GroupingTypeParameter = r.u32()
+	}
+	n := r.entries(r.u32(), 8)
+	sbgp.Entries = make([]SampleToGroupEntry, n)
+	for i := range sbgp.Entries {
+		sbgp.Entries[i] = SampleToGroupEntry{SampleCount: r.u32(), GroupDescriptionIndex: r.u32()}
+	}
+	return sbgp, r.err
+}
+
+func parseSgpd(h Box, payload []byte) (*SampleGroupDescriptionBox, error) {
+	r := newBoxReader(payload)
+
+	sgpd := &SampleGroupDescriptionBox{FullBox: r.fullBox(h)}
+	sgpd.GroupingType = r.fourCC()
+	if sgpd.Version == 1 {
+		sgpd.DefaultLength = r.u32()
+	}
+	if sgpd.Version >= 2 {
+		sgpd.DefaultSampleDescriptionIndex = r.u32()
+	}
+	n := r.entries(r.u32(), 0)
+	length := int(sgpd.DefaultLength)
+	if sgpd.Version != 1 && n > 0 {
+		// Version 0 has no lengths, the entries are assumed to have the same size.
+		if r.Len()%n != 0 {
+			return sgpd, fmt.Errorf("%w: %d entries in %d bytes", errShortBox, n, r.Len())
+		}
+		length = r.Len() / n
+	}
+	for i := 0; i < n && r.err == nil; i++ {
+		if sgpd.Version == 1 && sgpd.DefaultLength == 0 {
+			length = int(r.u32())
+		}
+		sgpd.Entries = append(sgpd.Entries, r.next(length))
+	}
+	return sgpd, r.err
+}
+
+// SampleEncryptionGroups decodes the entries of a SampleGroupDescriptionBox of the grouping type 'seig'.
+func (sgpd *SampleGroupDescriptionBox) SampleEncryptionGroups() ([]CencSampleEncryptionInformation, error) {
+	if sgpd.GroupingType != seigType {
+		return nil, fmt.Errorf("mpeg: %s is not %s", sgpd.GroupingType, seigType)
+	}
+	groups := make([]CencSampleEncryptionInformation, len(sgpd.Entries))
+	for i, entry := range sgpd.Entries {
+		r := newBoxReader(entry)
+		if groups[i] = readCencSampleEncryptionInformation(r); r.err != nil {
+			return nil, fmt.Errorf("%s entry %d: %w", seigType, i+1, r.err)
+		}
+	}
+	return groups, nil
+}
+
+func (sinf *ProtectionSchemeInfoBox) write(w *boxWriter) {
+	w.box(sinfType, func() {
+		if sinf.Frma != nil {
+			w.box(frmaType, func() { w.bytes(sinf.Frma.DataFormat[:]) })
+		}
+		if schm := sinf.Schm; schm != nil {
+			w.box(schmType, func() {
+				w.fullBox(schm.FullBox)
+				w.bytes(schm.SchemeType[:])
+				w.u32(schm.SchemeVersion)
+				if schm.Flag()&1 != 0 {
+					w.cstring(schm.SchemeURI)
+				}
+			})
+		}
+		if schi := sinf.Schi; schi != nil {
+			w.box(schiType, func() {
+				if tenc := schi.Tenc; tenc != nil {
+					w.box(tencType, func() {
+						w.fullBox(tenc.FullBox)
+						tenc.CencSampleEncryptionInformation.write(w)
+					})
+				}
+				writeRawBoxes(w, schi.Boxes)
+			})
+		}
+		writeRawBoxes(w, sinf.Boxes)
+	})
+}
+
+func (info *CencSampleEncryptionInformation) write(w *boxWriter) {
+	w.u8(info.Reserved)
+	w.u8(info.CryptByteBlock<<4 | info.SkipByteBlock&0xF)
+	w.u8(info.IsProtected)
+	w.u8(info.PerSampleIVSize)
+	w.bytes(info.KID[:])
+	if info.IsProtected != 0 && info.PerSampleIVSize == 0 {
+		w.u8(uint8(len(info.ConstantIV)))
+		w.bytes(info.ConstantIV)
+	}
+}
+
+func (pssh *ProtectionSystemSpecificHeaderBox) write(w *boxWriter) {
+	w.box(psshType, func() {
+		w.fullBox(pssh.FullBox)
+		w.bytes(pssh.SystemID[:])
+		if pssh.Version > 0 {
+			w.u32(uint32(len(pssh.KIDs)))
+			for _, kid := range pssh.KIDs {
+				w.bytes(kid[:])
+			}
+		}
+		w.u32(uint32(len(pssh.Data)))
+		w.bytes(pssh.Data)
+	})
+}
+
+func (senc *SampleEncryptionBox) write(w *boxWriter) {
+	w.box(sencType, func() {
+		w.fullBox(senc.FullBox)
+		w.u32(senc.SampleCount)
+		w.bytes(senc.Data)
+	})
+}
+
+func (saiz *SampleAuxiliaryInformationSizesBox) write(w *boxWriter) {
+	w.box(saizType, func() {
+		w.fullBox(saiz.FullBox)
+		if saiz.Flag()&1 != 0 {
+			w.bytes(saiz.AuxInfoType[:])
+			w.u32(saiz.AuxInfoTypeParameter)
+		}
+		w.u8(saiz.DefaultSampleInfoSize)
+		w.u32(saiz.SampleCount)
+		if saiz.DefaultSampleInfoSize == 0 {
+			w.bytes(saiz.SampleInfoSizes)
+		}
+	})
+}
+
+func (saio *SampleAuxiliaryInformationOffsetsBox) write(w *boxWriter) {
+	w.box(saioType, func() {
+		w.fullBox(saio.FullBox)
+		if saio.Flag()&1 != 0 {
+			w.bytes(saio.AuxInfoType[:])
+			w.u32(saio.AuxInfoTypeParameter)
+		}
+		w.u32(uint32(len(saio.Offsets)))
+		for _, offset := range saio.Offsets {
+			w.uint(saio.Version, offset)
+		}
+	})
+}
+
+func (sbgp *SampleToGroupBox) write(w *boxWriter) {
+	w.box(sbgpType, func() {
+		w.fullBox(sbgp.FullBox)
+		w.bytes(sbgp.GroupingType[:])
+		if sbgp.Version == 1 {
+			w.u32(sbgp.GroupingTypeParameter)
+		}
+		w.u32(uint32(len(sbgp.Entries)))
+		for _, entry := range sbgp.Entries {
+			w.u32(entry.SampleCount)
+			w.u32(entry.GroupDescriptionIndex)
+		}
+	})
+}
+
+func (sgpd *SampleGroupDescriptionBox) write(w *boxWriter) {
+	w.box(sgpdType, func() {
+		w.fullBox(sgpd.FullBox)
+		w.bytes(sgpd.GroupingType[:])
+		if sgpd.Version == 1 {
+			w.u32(sgpd.DefaultLength)
+		}
+		if sgpd.Version >= 2 {
+			w.u32(sgpd.DefaultSampleDescriptionIndex)
+		}
+		w.u32(uint32(len(sgpd.Entries)))
+		for _, entry := range sgpd.Entries {
+			if sgpd.Version == 1 && sgpd.DefaultLength == 0 {
+				w.u32(uint32(len(entry)))
+			}
+			w.bytes(entry)
+		}
+	})
+}
+
+// Format returns the coding name of the sample entry, the original format for protected entries.
+func (entry *SampleDescription) Format() [4]byte {
+	if entry.Sinf != nil && entry.Sinf.Frma != nil {
+		return entry.Sinf.Frma.DataFormat
+	}
+	return entry.Type
+}
+
+// sampleGroups returns the group description indexes of count samples for the grouping type, 0 for the samples
+// which are not in a group.
+func sampleGroups(boxes []*SampleToGroupBox, groupingType [4]byte, count int) []uint32 {
+	groups := make([]uint32, count)
+	for _, sbgp := range boxes {
+		if sbgp.GroupingType != groupingType {
+			continue
+		}
+		i := 0
+		for _, entry := range sbgp.Entries {
+			for n := entry.SampleCount; n > 0 && i < count; n-- {
+				groups[i] = entry.GroupDescriptionIndex
+				i++
+			}
+		}
+		break
+	}
+	return groups
+}
+
+func encryptionGroups(boxes []*SampleGroupDescriptionBox) ([]CencSampleEncryptionInformation, error) {
+	for _, sgpd := range boxes {
+		if sgpd.GroupingType == seigType {
+			return sgpd.SampleEncryptionGroups()
+		}
+	}
+	return nil, nil
+}
+
+// auxiliaryInfo reads the sample auxiliary information of count samples from r. base is added to the offsets,
+// runs are the numbers of samples of the chunks or track runs if there is one offset for each of them.
+func auxiliaryInfo(r io.ReadSeeker, saiz *SampleAuxiliaryInformationSizesBox, saio *SampleAuxiliaryInformationOffsetsBox,
+	base int64, count int, runs []int) ([][]byte, error) {
+	if int(saiz.SampleCount) < count {
+		return nil, fmt.Errorf("%w: %s has %d samples, not %d", errEncryption, saizType, saiz.SampleCount, count)
+	}
+	if len(saio.Offsets) != 1 && len(saio.Offsets) != len(runs) {
+		return nil, fmt.Errorf("%w: %d offsets in %s", errEncryption, len(saio.Offsets), saioType)
+	}
+	if r == nil {
+		return nil, fmt.Errorf("%w: no reader for the auxiliary information", errEncryption)
+	}
+	size := func(i int) int {
+		if saiz.DefaultSampleInfoSize != 0 {
+			return int(saiz.DefaultSampleInfoSize)
+		}
+		return int(saiz.SampleInfoSizes[i])
+	}
+
+	info := make([][]byte, 0, count)
+	read := func(offset int64, n int) error {
+		total := 0
+		for i := len(info); i < len(info)+n; i++ {
+			total += size(i)
+		}
+		b := make([]byte, total)
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return fmt.Errorf("%s: %w", saioType, err)
+		}
+		for i := 0; i < n; i++ {
+			info = append(info, b[:size(len(info))])
+			b = b[size(len(info)-1):]
+		}
+		return nil
+	}
+
+	if len(saio.Offsets) == 1 {
+		return info, read(base+int64(saio.Offsets[0]), count)
+	}
+	for i, n := range runs {
+		if err := read(base+int64(saio.Offsets[i]), n); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// SampleEncryption resolves the encryption of the samples of the track, in the order of File.Samples. It returns
+// nil if the track is not protected by Common Encryption and a nil entry for each clear sample. The auxiliary
+// information outside of 'senc' boxes is read from r, which may be nil for movie fragments with 'senc' boxes.
+func (f *File) SampleEncryption(trackID uint32, r io.ReadSeeker) ([]*SampleEncryption, error) {
+	trak := f.Track(trackID)
+	if trak == nil {
+		return nil, fmt.Errorf("mpeg: no track %d", trackID)
+	}
+	protected := false
+	for _, entry := range trak.SampleDescriptions() {
+		protected = protected || entry.Sinf != nil && entry.Sinf.Schi != nil && entry.Sinf.Schi.Tenc != nil
+	}
+	if !protected {
+		return nil, nil
+	}
+	samples, err := f.Samples(trackID)
+	if err != nil {
+		return nil, err
+	}
+	stbl := trak.SampleTable()
+	tableSamples, err := trak.samples(f.end())
+	if err != nil {
+		return nil, err
+	}
+	entries := trak.SampleDescriptions()
+	stblGroups, err := encryptionGroups(stbl.Sgpd)
+	if err != nil {
+		return nil, err
+	}
+
+	var encryption []*SampleEncryption
+
+	// resolve decodes the auxiliary information of the next samples from either senc or info. groups are their
+	// 'seig' group description indexes.
+	resolve := func(senc *SampleEncryptionBox, info [][]byte, groups []uint32, fragmentGroups []CencSampleEncryptionInformation) error {
+		var sencReader *boxReader
+		if senc != nil {
+			sencReader = newBoxReader(senc.Data)
+			if int(senc.SampleCount) < len(groups) {
+				return fmt.Errorf("%w: %s has %d samples, not %d", errEncryption, sencType, senc.SampleCount, len(groups))
+			}
+		}
+		for i, group := range groups {
+			index := int(samples[len(encryption)].SampleDescriptionIndex)
+			if index == 0 || index > len(entries) {
+				return fmt.Errorf("%w: sample %d has no sample entry", errEncryption, len(encryption))
+			}
+			var e *SampleEncryption
+			ivSize := 0
+			if sinf := entries[index-1].Sinf; sinf != nil && sinf.Schi != nil && sinf.Schi.Tenc != nil && sinf.Schm != nil {
+				params := sinf.Schi.Tenc.CencSampleEncryptionInformation
+				switch {
+				case group > 0x10000 && int(group-0x10000) <= len(fragmentGroups):
+					params = fragmentGroups[group-0x10001]
+				case group > 0 && group <= 0x10000 && int(group) <= len(stblGroups):
+					params = stblGroups[group-1]
+				case group > 0:
+					return fmt.Errorf("%w: sample %d has no %s group %d", errEncryption, len(encryption), seigType, group)
+				}
+				if params.IsProtected != 0 {
+					e = &SampleEncryption{
+						Scheme:         sinf.Schm.SchemeType,
+						KID:            params.KID,
+						IV:             params.ConstantIV,
+						CryptByteBlock: params.CryptByteBlock,
+						SkipByteBlock:  params.SkipByteBlock,
+					}
+					ivSize = int(params.PerSampleIVSize)
+				}
+			}
+
+			// Clear samples still have an entry in 'senc', without IV.
+			var aux *boxReader
+			subsamples := false
+			switch {
+			case sencReader != nil:
+				aux, subsamples = sencReader, senc.Flag()&sencUseSubSampleEncryption != 0
+			case e == nil:
+				encryption = append(encryption, nil)
+				continue
+			case info != nil:
+				aux = newBoxReader(info[i])
+				subsamples = len(info[i]) > ivSize
+			default:
+				return fmt.Errorf("%w: no auxiliary information for sample %d", errEncryption, len(encryption))
+			}
+			var iv []byte
+			var subs []Subsample
+			if ivSize > 0 {
+				iv = aux.next(ivSize)
+			}
+			if subsamples {
+				subs = make([]Subsample, aux.entries(uint32(aux.u16()), 6))
+				for j := range subs {
+					subs[j] = Subsample{BytesOfClearData: aux.u16(), BytesOfProtectedData: aux.u32()}
+				}
+			}
+			if aux.err != nil {
+				return fmt.Errorf("%w: sample %d: %v", errEncryption, len(encryption), aux.err)
+			}
+			if e != nil {
+				if ivSize > 0 {
+					e.IV = iv
+				}
+				e.Subsamples = subs
+			}
+			encryption = append(encryption, e)
+		}
+		return nil
+	}
+
+	// Samples of the sample table, the auxiliary information of each chunk may be at its own offset.
+	if n := len(tableSamples); n > 0 {
+		var info [][]byte
+		if saiz, saio := cencAuxiliaryInfo(stbl.Saiz, stbl.Saio); saiz != nil && saio != nil {
+			if info, err = auxiliaryInfo(r, saiz, saio, 0, n, stbl.chunks()); err != nil {
+				return nil, err
+			}
+		}
+		if err := resolve(nil, info, sampleGroups(stbl.Sbgp, seigType, n), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, moof := range f.Moof {
+		for _, traf := range moof.Traf {
+			if traf.Tfhd == nil || traf.Tfhd.TrackID != trackID || traf.Tfhd.Flag()&tfhdDurationIsEmpty != 0 {
+				continue
+			}
+			var runs []int
+			count := 0
+			for _, trun := range traf.Trun {
+				runs = append(runs, trun.sampleCount())
+				count += trun.sampleCount()
+			}
+			if count == 0 {
+				continue
+			}
+
+			var info [][]byte
+			if saiz, saio := cencAuxiliaryInfo(traf.Saiz, traf.Saio); traf.Senc == nil && saiz != nil && saio != nil {
+				base := moof.Offset
+				if traf.Tfhd.Flag()&tfhdBaseDataOffsetPresent != 0 {
+					base = int64(traf.Tfhd.BaseDataOffset)
+				}
+				if info, err = auxiliaryInfo(r, saiz, saio, base, count, runs); err != nil {
+					return nil, err
+				}
+			}
+			groups, err := encryptionGroups(traf.Sgpd)
+			if err != nil {
+				return nil, err
+			}
+			if err := resolve(traf.Senc, info, sampleGroups(traf.Sbgp, seigType, count), groups); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return encryption, nil
+}
+
+// chunks returns the number of samples of each chunk.
+func (stbl *SampleTableBox) chunks() []int {
+	if stbl.Stsc == nil || stbl.Stco == nil {
+		return nil
+	}
+	chunks := make([]int, len(stbl.Stco.ChunkOffset))
+	for i, entry := range stbl.Stsc.Entries {
+		last := uint32(len(chunks))
+		if i+1 < len(stbl.Stsc.Entries) {
+			last = stbl.Stsc.Entries[i+1].FirstChunk - 1
+		}
+		for chunk := entry.FirstChunk; chunk >= 1 && chunk <= last && chunk <= uint32(len(chunks)); chunk++ {
+			chunks[chunk-1] = int(entry.SamplesPerChunk)
+		}
+	}
+	return chunks
+}
+
+// cencAuxiliaryInfo returns the sizes and offsets of the Common Encryption auxiliary information, those without an
+// auxiliary information type or with the type of a scheme.
+func cencAuxiliaryInfo(sizes []*SampleAuxiliaryInformationSizesBox, offsets []*SampleAuxiliaryInformationOffsetsBox) (
+	*SampleAuxiliaryInformationSizesBox, *SampleAuxiliaryInformationOffsetsBox) {
+	isCenc := func(flags uint32, auxInfoType [4]byte) bool {
+		switch {
+		case flags&1 == 0:
+			return true
+		default:
+			return auxInfoType == SchemeCenc || auxInfoType == SchemeCbc1 || auxInfoType == SchemeCens ||
+				auxInfoType == SchemeCbcs
+		}
+	}
+	var saiz *SampleAuxiliaryInformationSizesBox
+	var saio *SampleAuxiliaryInformationOffsetsBox
+	for _, box := range sizes {
+		if isCenc(box.Flag(), box.AuxInfoType) {
+			saiz = box
+			break
+		}
+	}
+	for _, box := range offsets {
+		if isCenc(box.Flag(), box.AuxInfoType) {
+			saio = box
+			break
+		}
+	}
+	return saiz, saio
+}
+
+// Decrypt decrypts a sample in place with the AES-128 key of its KID. The protected ranges of 'cenc' and 'cens'
+// samples are a single AES-CTR key stream. 'cbc1' samples are a single AES-CBC chain and the chain of 'cbcs'
+// samples restarts with the IV in every subsample. Partial blocks at the end of a protected range are clear,
+// except for 'cenc'. With a pattern, only the first CryptByteBlock of every CryptByteBlock+SkipByteBlock blocks
+// are encrypted.
+func (e *SampleEncryption) Decrypt(data []byte, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	return e.decrypt(block, data)
+}
+
+func (e *SampleEncryption) decrypt(block cipher.Block, data []byte) error {
+	var iv [aes.BlockSize]byte
+	cbc := e.Scheme == SchemeCbc1 || e.Scheme == SchemeCbcs
+	if len(e.IV) != 8 && len(e.IV) != 16 || cbc && len(e.IV) != 16 {
+		return fmt.Errorf("%w: IV of %d bytes for %s", errEncryption, len(e.IV), e.Scheme)
+	}
+	// 8-byte IVs of 'cenc' and 'cens' are the upper half of the counter block, AES-CBC needs a full block.
+	copy(iv[:], e.IV)
+
+	ranges := [][]byte{data}
+	if len(e.Subsamples) > 0 {
+		ranges = ranges[:0]
+		offset := 0
+		for _, subsample := range e.Subsamples {
+			offset += int(subsample.BytesOfClearData)
+			end := offset + int(subsample.BytesOfProtectedData)
+			if end > len(data) {
+				return fmt.Errorf("%w: subsamples of %d bytes in a sample of %d bytes", errEncryption, end, len(data))
+			}
+			ranges = append(ranges, data[offset:end])
+			offset = end
+		}
+	}
+
+	var stream cipher.Stream
+	var chain cipher.BlockMode
+	switch e.Scheme {
+	case SchemeCenc, SchemeCens:
+		stream = cipher.NewCTR(block, iv[:])
+	case SchemeCbc1, SchemeCbcs:
+		chain = cipher.NewCBCDecrypter(block, iv[:])
+	default:
+		return fmt.Errorf("%w: %s", errScheme, e.Scheme)
+	}
+
+	apply := func(b []byte) {
+		if stream != nil {
+			stream.XORKeyStream(b, b)
+		} else {
+			chain.CryptBlocks(b, b)
+		}
+	}
+	for _, b := range ranges {
+		if e.Scheme == SchemeCbcs {
+			chain = cipher.NewCBCDecrypter(block, iv[:])
+		}
+		if e.CryptByteBlock == 0 && e.SkipByteBlock == 0 {
+			if e.Scheme == SchemeCenc {
+				apply(b)
+			} else {
+				apply(b[:len(b)&^(aes.BlockSize-1)])
+			}
+			continue
+		}
+
+		crypt := int(e.CryptByteBlock) * aes.BlockSize
+		stride := crypt + int(e.SkipByteBlock)*aes.BlockSize
+		for offset := 0; offset+aes.BlockSize <= len(b); offset += stride {
+			n := crypt
+			if rest := (len(b) - offset) &^ (aes.BlockSize - 1); n > rest {
+				n = rest
+			}
+			apply(b[offset : offset+n])
+		}
+	}
+	return nil
+}
+
+// decrypt decrypts the sample i of the track if it is protected and the demuxer has keys.
+func (d *Demuxer) decrypt(track *demuxTrack, i int, data []byte) error {
+	if d.Keys == nil || track.encryption == nil || track.encryption[i] == nil {
+		return nil
+	}
+	e := track.encryption[i]
+	block, ok := d.ciphers[e.KID]
+	if !ok {
+		key, ok := d.Keys[e.KID]
+		if !ok {
+			return fmt.Errorf("%w for KID %x", errNoKey, e.KID)
+		}
+		var err error
+		if block, err = aes.NewCipher(key); err != nil {
+			return err
+		}
+		if d.ciphers == nil {
+			d.ciphers = map[[16]byte]cipher.Block{}
+		}
+		d.ciphers[e.KID] = block
+	}
+	return e.decrypt(block, data)
+}
@@ -0,0 +1,668 @@
+package mpeg
+
+import (
+	"awCodec/aac"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media presentation descriptions of MPEG-DASH (ISO/IEC 23009-1), the .mpd manifests of fragmented MP4 segments
+
+var (
+	errMpd          = errors.New("mpeg: invalid MPD")
+	errMpdDuration  = errors.New("mpeg: invalid xs:duration")
+	errMpdTemplate  = errors.New("mpeg: invalid segment template")
+	errMpdSegmenter = errors.New("mpeg: unknown representation")
+)
+
+const (
+	mpdNamespace  = "urn:mpeg:dash:schema:mpd:2011"
+	cencNamespace = "urn:mpeg:cenc:2013"
+
+	ProfileIsoffLive = "urn:mpeg:dash:profile:isoff-live:2011"
+
+	// SchemeMp4Protection is the schemeIdUri of the ContentProtection element signalling the Common Encryption
+	// scheme, like "cenc" or "cbcs", and the default KID. DRM systems use "urn:uuid:" and their system ID.
+	SchemeMp4Protection = "urn:mpeg:dash:mp4protection:2011"
+
+	schemeAudioChannelConfiguration = "urn:mpeg:dash:23003:3:audio_channel_configuration:2011"
+)
+
+// Mpd is a media presentation description (ISO/IEC 23009-1 5.3.1). Durations are xs:duration attributes.
+type Mpd struct {
+	XMLName                   xml.Name
+	ID                        string     `xml:"id,attr,omitempty"`
+	Profiles                  string     `xml:"profiles,attr"`
+	Type                      string     `xml:"type,attr,omitempty"` // "static" if empty, or "dynamic"
+	AvailabilityStartTime     *time.Time `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime               *time.Time `xml:"publishTime,attr,omitempty"`
+	MediaPresentationDuration XsDuration `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinimumUpdatePeriod       XsDuration `xml:"minimumUpdatePeriod,attr,omitempty"`
+	MinBufferTime             XsDuration `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth      XsDuration `xml:"timeShiftBufferDepth,attr,omitempty"`
+	BaseURL                   string     `xml:"BaseURL,omitempty"`
+	Periods                   []*Period  `xml:"Period"`
+}
+
+// Period is a period of a media presentation (ISO/IEC 23009-1 5.3.2).
+type Period struct {
+	ID              string           `xml:"id,attr,omitempty"`
+	Start           XsDuration       `xml:"start,attr"`
+	Duration        XsDuration       `xml:"duration,attr,omitempty"`
+	BaseURL         string           `xml:"BaseURL,omitempty"`
+	SegmentTemplate *SegmentTemplate `xml:"SegmentTemplate"`
+	AdaptationSets  []*AdaptationSet `xml:"AdaptationSet"`
+}
+
+// AdaptationSet is a set of interchangeable representations of a media content (ISO/IEC 23009-1 5.3.3).
+type AdaptationSet struct {
+	ID                        string              `xml:"id,attr,omitempty"`
+	ContentType               string              `xml:"contentType,attr,omitempty"` // "video", "audio" or "text"
+	MimeType                  string              `xml:"mimeType,attr,omitempty"`
+	Codecs                    string              `xml:"codecs,attr,omitempty"`
+	Lang                      string              `xml:"lang,attr,omitempty"`
+	SegmentAlignment          bool                `xml:"segmentAlignment,attr,omitempty"`
+	StartWithSAP              int                 `xml:"startWithSAP,attr,omitempty"`
+	AudioChannelConfiguration []MpdDescriptor     `xml:"AudioChannelConfiguration"`
+	ContentProtection         []ContentProtection `xml:"ContentProtection"`
+	Roles                     []MpdDescriptor     `xml:"Role"`
+	SegmentTemplate           *SegmentTemplate    `xml:"SegmentTemplate"`
+	Representations           []*Representation   `xml:"Representation"`
+}
+
+// Representation is an encoding of the media content of an adaptation set (ISO/IEC 23009-1 5.3.5).
+type Representation struct {
+	ID                        string              `xml:"id,attr"`
+	Bandwidth                 int                 `xml:"bandwidth,attr"` // Bits per second
+	MimeType                  string              `xml:"mimeType,attr,omitempty"`
+	Codecs                    string              `xml:"codecs,attr,omitempty"` // RFC 6381 codecs parameter
+	Width                     int                 `xml:"width,attr,omitempty"`
+	Height                    int                 `xml:"height,attr,omitempty"`
+	FrameRate                 string              `xml:"frameRate,attr,omitempty"`
+	AudioSamplingRate         string              `xml:"audioSamplingRate,attr,omitempty"`
+	AudioChannelConfiguration []MpdDescriptor     `xml:"AudioChannelConfiguration"`
+	ContentProtection         []ContentProtection `xml:"ContentProtection"`
+	BaseURL                   string              `xml:"BaseURL,omitempty"`
+	SegmentTemplate           *SegmentTemplate    `xml:"SegmentTemplate"`
+}
+
+// MpdDescriptor is a descriptor element of a scheme, like AudioChannelConfiguration (ISO/IEC 23009-1 5.8.2).
+type MpdDescriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr,omitempty"`
+}
+
+// ContentProtection describes a protection scheme of the segments (ISO/IEC 23009-1 5.8.4.1), with the cenc:default_KID
+// attribute and the cenc:pssh element of ISO/IEC 23001-7 11.
+type ContentProtection struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr,omitempty"`
+	DefaultKID  string `xml:"default_KID,attr,omitempty"` // UUID form of the key ID
+	Pssh        string `xml:"pssh,omitempty"`             // Base64 of a 'pssh' box
+}
+
+// SegmentTemplate gives the URLs of the segments of representations (ISO/IEC 23009-1 5.3.9.4). The segments are
+// numbered from StartNumber, they are listed by the SegmentTimeline or else have the same Duration.
+type SegmentTemplate struct {
+	Timescale              uint32           `xml:"timescale,attr,omitempty"` // 1 if 0
+	PresentationTimeOffset uint64           `xml:"presentationTimeOffset,attr,omitempty"`
+	Duration               uint64           `xml:"duration,attr,omitempty"`
+	StartNumber            *uint64          `xml:"startNumber,attr,omitempty"` // 1 if nil
+	Initialization         string           `xml:"initialization,attr,omitempty"`
+	Media                  string           `xml:"media,attr,omitempty"`
+	SegmentTimeline        *SegmentTimeline `xml:"SegmentTimeline"`
+}
+
+// SegmentTimeline lists the times of the segments (ISO/IEC 23009-1 5.3.9.6).
+type SegmentTimeline struct {
+	S []SegmentTimelineEntry `xml:"S"`
+}
+
+// SegmentTimelineEntry is a run of segments of the same duration.
+type SegmentTimelineEntry struct {
+	T *uint64 `xml:"t,attr"` // Time of the first segment, following the previous entry if nil
+	D uint64  `xml:"d,attr"`
+	R int     `xml:"r,attr,omitempty"` // Number of repetitions after the first segment, -1 until the next entry
+}
+
+// MpdSegment is a media segment of a representation listed by a segment template.
+type MpdSegment struct {
+	Number   uint64
+	Time     uint64 // Time in the timescale of the template
+	Duration uint64
+	URL      string
+}
+
+// XsDuration is a duration written as an xs:duration, like PT1M2.5S.
+type XsDuration time.Duration
+
+// MarshalXMLAttr writes the duration in seconds.
+func (d XsDuration) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	s := "PT" + strconv.FormatFloat(time.Duration(d).Seconds(), 'f', -1, 64) + "S"
+	if d < 0 {
+		s = "-PT" + strconv.FormatFloat(-time.Duration(d).Seconds(), 'f', -1, 64) + "S"
+	}
+	return xml.Attr{Name: name, Value: s}, nil
+}
+
+// UnmarshalXMLAttr parses an xs:duration. Years and months are taken to be 365 and 30 days.
+func (d *XsDuration) UnmarshalXMLAttr(attr xml.Attr) error {
+	v, err := parseXsDuration(attr.Value)
+	*d = XsDuration(v)
+	return err
+}
+
+// parseXsDuration parses a duration of the form [-]PnYnMnDTnHnMnS.
+func parseXsDuration(s string) (time.Duration, error) {
+	orig := s
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	if !strings.HasPrefix(s, "P") || len(s) < 2 {
+		return 0, fmt.Errorf("%w: %q", errMpdDuration, orig)
+	}
+	s = s[1:]
+	var seconds float64
+	inTime := false
+	for len(s) > 0 {
+		if s[0] == 'T' {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		i := strings.IndexAny(s, "YMDHS")
+		if i <= 0 {
+			return 0, fmt.Errorf("%w: %q", errMpdDuration, orig)
+		}
+		v, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", errMpdDuration, orig)
+		}
+		var unit float64
+		switch designator := s[i]; {
+		case !inTime && designator == 'Y':
+			unit = 365 * 86400
+		case !inTime && designator == 'M':
+			unit = 30 * 86400
+		case !inTime && designator == 'D':
+			unit = 86400
+		case inTime && designator == 'H':
+			unit = 3600
+		case inTime && designator == 'M':
+			unit = 60
+		case inTime && designator == 'S':
+			unit = 1
+		default:
+			return 0, fmt.Errorf("%w: %q", errMpdDuration, orig)
+		}
+		seconds += v * unit
+		s = s[i+1:]
+	}
+	if negative {
+		seconds = -seconds
+	}
+	return time.Duration(math.Round(seconds * float64(time.Second))), nil
+}
+
+// MarshalXML writes the cenc attribute and element with their namespace prefix.
+func (cp ContentProtection) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Attr = []xml.Attr{{Name: xml.Name{Local: "schemeIdUri"}, Value: cp.SchemeIDURI}}
+	if cp.Value != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "value"}, Value: cp.Value})
+	}
+	if cp.DefaultKID != "" || cp.Pssh != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xmlns:cenc"}, Value: cencNamespace})
+	}
+	if cp.DefaultKID != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "cenc:default_KID"}, Value: cp.DefaultKID})
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if cp.Pssh != "" {
+		if err := e.EncodeElement(cp.Pssh, xml.StartElement{Name: xml.Name{Local: "cenc:pssh"}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// ParseMpd parses a media presentation description.
+func ParseMpd(b []byte) (*Mpd, error) {
+	mpd := &Mpd{}
+	if err := xml.Unmarshal(b, mpd); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMpd, err)
+	}
+	if mpd.XMLName.Local != "MPD" {
+		return nil, fmt.Errorf("%w: root element %s", errMpd, mpd.XMLName.Local)
+	}
+	if mpd.Type != "" && mpd.Type != "static" && mpd.Type != "dynamic" {
+		return mpd, fmt.Errorf("%w: type %q", errMpd, mpd.Type)
+	}
+	return mpd, nil
+}
+
+// Marshal returns the XML document of the media presentation description.
+func (mpd *Mpd) Marshal() ([]byte, error) {
+	b, err := xml.MarshalIndent(mpd, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// maxMpdSegments is the most segments listed of a representation, 2 years of segments of a minute.
+const maxMpdSegments = 1 << 20
+
+// Segments lists the media segments of a representation by a segment template, the one of the representation or
+// else of its adaptation set. Segments of a template without SegmentTimeline and of entries repeated until the end
+// are listed up to duration, the duration of the period. A template of more than maxMpdSegments segments is refused.
+func (t *SegmentTemplate) Segments(r *Representation, duration time.Duration) ([]MpdSegment, error) {
+	timescale := uint64(t.Timescale)
+	if timescale == 0 {
+		timescale = 1
+	}
+	number := uint64(1)
+	if t.StartNumber != nil {
+		number = *t.StartNumber
+	}
+	end := durationTicks(duration, uint32(timescale)) + t.PresentationTimeOffset
+
+	var segments []MpdSegment
+	add := func(time, d uint64) error {
+		url, err := expandTemplate(t.Media, r, number, time)
+		segments = append(segments, MpdSegment{Number: number, Time: time, Duration: d, URL: url})
+		number++
+		return err
+	}
+	if t.SegmentTimeline == nil {
+		if t.Duration == 0 {
+			return nil, fmt.Errorf("%w: no duration", errMpdTemplate)
+		}
+		if end > t.PresentationTimeOffset && (end-t.PresentationTimeOffset-1)/t.Duration >= maxMpdSegments {
+			return nil, fmt.Errorf("%w: more than %d segments of duration %d", errMpdTemplate, maxMpdSegments, t.Duration)
+		}
+		for time := t.PresentationTimeOffset; time < end; time += t.Duration {
+			if err := add(time, t.Duration); err != nil {
+				return segments, err
+			}
+		}
+		return segments, nil
+	}
+
+	var time uint64
+	entries := t.SegmentTimeline.S
+	for i, s := range entries {
+		if s.T != nil {
+			time = *s.T
+		}
+		if s.D == 0 {
+			return segments, fmt.Errorf("%w: S of duration 0", errMpdTemplate)
+		}
+		count := uint64(s.R) + 1
+		if s.R < 0 {
+			until := end
+			if i+1 < len(entries) && entries[i+1].T != nil {
+				until = *entries[i+1].T
+			}
+			count = 0
+			if until > time {
+				count = (until-time-1)/s.D + 1
+			}
+		}
+		if count > maxMpdSegments-uint64(len(segments)) {
+			return segments, fmt.Errorf("%w: more than %d segments", errMpdTemplate, maxMpdSegments)
+		}
+		for j := uint64(0); j < count; j++ {
+			if err := add(time, s.D); err != nil {
+				return segments, err
+			}
+			time += s.D
+		}
+	}
+	return segments, nil
+}
+
+// InitializationURL returns the URL of the initialization segment of a representation.
+func (t *SegmentTemplate) InitializationURL(r *Representation) (string, error) {
+	return expandTemplate(t.Initialization, r, 0, 0)
+}
+
+// expandTemplate replaces the identifiers of a template (ISO/IEC 23009-1 5.3.9.4.4), which may have a width like
+// $Number%05d$.
+func expandTemplate(template string, r *Representation, number, time uint64) (string, error) {
+	b := &strings.Builder{}
+	for {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			b.WriteString(template)
+			return b.String(), nil
+		}
+		j := strings.IndexByte(template[i+1:], '$')
+		if j < 0 {
+			return b.String(), fmt.Errorf("%w: %q", errMpdTemplate, template)
+		}
+		b.WriteString(template[:i])
+		id := template[i+1 : i+1+j]
+		template = template[i+2+j:]
+
+		format := "%d"
+		if k := strings.IndexByte(id, '%'); k >= 0 {
+			id, format = id[:k], id[k:]
+			if !strings.HasSuffix(format, "d") {
+				return b.String(), fmt.Errorf("%w: format %q", errMpdTemplate, format)
+			}
+		}
+		switch id {
+		case "":
+			b.WriteByte('$')
+		case "RepresentationID":
+			b.WriteString(r.ID)
+		case "Number":
+			fmt.Fprintf(b, format, number)
+		case "Time":
+			fmt.Fprintf(b, format, time)
+		case "Bandwidth":
+			fmt.Fprintf(b, format, r.Bandwidth)
+		default:
+			return b.String(), fmt.Errorf("%w: identifier %q", errMpdTemplate, id)
+		}
+	}
+}
+
+// durationTicks converts a duration into a timescale, the inverse of scaleDuration.
+func durationTicks(d time.Duration, timescale uint32) uint64 {
+	if d < 0 {
+		return 0
+	}
+	return uint64(d/time.Second)*uint64(timescale) +
+		(uint64(d%time.Second)*uint64(timescale)+uint64(time.Second/2))/uint64(time.Second)
+}
+
+// Codecs returns the codecs parameter of the sample entry (RFC 6381 3.3), like avc1.64001F or mp4a.40.2.
+func (sd *SampleDescription) Codecs() string {
+	format := sd.Format()
+	typ := string(format[:])
+	switch {
+	case sd.AvcC != nil:
+		return fmt.Sprintf("%s.%02X%02X%02X", typ, sd.AvcC.AVCProfileIndication, sd.AvcC.ProfileCompatibility, sd.AvcC.AVCLevelIndication)
+
+	case sd.HvcC != nil:
+		// ISO/IEC 14496-15 E.3
+		h := sd.HvcC
+		b := &strings.Builder{}
+		b.WriteString(typ + ".")
+		if h.GeneralProfileSpace > 0 {
+			b.WriteByte('A' + h.GeneralProfileSpace - 1)
+		}
+		tier := 'L'
+		if h.GeneralTierFlag {
+			tier = 'H'
+		}
+		var compatibility uint32 // general_profile_compatibility_flag in reverse order
+		for i := 0; i < 32; i++ {
+			compatibility |= h.GeneralProfileCompatibilityFlags >> i & 1 << (31 - i)
+		}
+		fmt.Fprintf(b, "%d.%X.%c%d", h.GeneralProfileIdc, compatibility, tier, h.GeneralLevelIdc)
+		constraints := h.GeneralConstraintIndicatorFlags
+		for n := 6; n > 0 && constraints != 0; n-- {
+			fmt.Fprintf(b, ".%X", byte(constraints>>40))
+			constraints = constraints << 8 & (1<<48 - 1)
+		}
+		return b.String()
+
+	case sd.Esds != nil && sd.Esds.ES != nil && sd.Esds.ES.DecoderConfig != nil:
+		dcd := sd.Esds.ES.DecoderConfig
+		config := dcd.AudioSpecificConfig
+		if config == nil && dcd.ObjectTypeIndication == ObjectTypeMpeg4Audio {
+			config, _ = aac.ParseAudioSpecificConfig(dcd.DecoderSpecificInfo)
+		}
+		if dcd.ObjectTypeIndication == ObjectTypeMpeg4Audio && config != nil {
+			return fmt.Sprintf("%s.40.%d", typ, config.ObjectType)
+		}
+		return fmt.Sprintf("%s.%02X", typ, dcd.ObjectTypeIndication)
+	}
+	return typ
+}
+
+// mpdRepresentation is a representation of the segments of a Segmenter.
+type mpdRepresentation struct {
+	id        string
+	segmenter *Segmenter
+	segments  []mpdSegment
+}
+
+type mpdSegment struct {
+	number   uint32
+	time     uint64 // Earliest presentation time in the timescale of the track
+	duration uint64
+	size     int
+}
+
+// MpdWriter writes the media presentation description of the segments of Segmenters, every Segmenter of a single
+// track being one representation. Representations with the same content type, codec and language share an adaptation set.
+//
+// The representations use a segment template with a segment timeline from the times of the segments, their
+// bandwidth is the largest bitrate of a segment.
+type MpdWriter struct {
+	Dynamic bool
+
+	// AvailabilityStartTime, MinimumUpdatePeriod and TimeShiftBufferDepth are used for dynamic presentations. The
+	// timeline of a dynamic presentation lists the segments of the last TimeShiftBufferDepth, or all if it is 0.
+	AvailabilityStartTime time.Time
+	MinimumUpdatePeriod   time.Duration
+	TimeShiftBufferDepth  time.Duration
+
+	MinBufferTime time.Duration // Twice the longest segment if 0
+
+	// Initialization and Media are the URL templates of the segments, "$RepresentationID$/init.mp4" and
+	// "$RepresentationID$/$Number$.m4s" if empty.
+	Initialization string
+	Media          string
+
+	// ContentProtection elements added to every adaptation set, like placeholders with SchemeMp4Protection and the
+	// default KID, and the system IDs of the DRM systems with their 'pssh' boxes once they are known.
+	ContentProtection []ContentProtection
+
+	representations []*mpdRepresentation
+}
+
+// NewMpdWriter returns a writer of a static or dynamic presentation.
+func NewMpdWriter(dynamic bool) *MpdWriter {
+	w := &MpdWriter{Dynamic: dynamic}
+	if dynamic {
+		w.AvailabilityStartTime = time.Now()
+	}
+	return w
+}
+
+// AddSegmenter adds a Segmenter as a representation. Its track is added before, segments multiplexing several
+// tracks are not supported.
+func (w *MpdWriter) AddSegmenter(id string, s *Segmenter) error {
+	if len(s.tracks) != 1 {
+		return fmt.Errorf("%w: %q has %d tracks instead of one", errMpdSegmenter, id, len(s.tracks))
+	}
+	if w.representation(id) != nil {
+		return fmt.Errorf("%w: %q added twice", errMpdSegmenter, id)
+	}
+	w.representations = append(w.representations, &mpdRepresentation{id: id, segmenter: s})
+	return nil
+}
+
+func (w *MpdWriter) representation(id string) *mpdRepresentation {
+	for _, r := range w.representations {
+		if r.id == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// AddSegment adds a segment returned by the Segmenter of a representation to its timeline.
+func (w *MpdWriter) AddSegment(id string, segment *Segment) error {
+	r := w.representation(id)
+	if r == nil {
+		return fmt.Errorf("%w: %q", errMpdSegmenter, id)
+	}
+	timescale := r.segmenter.reference.Timescale
+	r.segments = append(r.segments, mpdSegment{
+		number:   segment.Sequence,
+		time:     durationTicks(segment.Time, timescale),
+		duration: durationTicks(segment.Duration, timescale),
+		size:     len(segment.Data),
+	})
+	return nil
+}
+
+// Mpd returns the media presentation description of the segments added so far. A dynamic presentation which ended
+// is written as a static one.
+func (w *MpdWriter) Mpd(ended bool) *Mpd {
+	mpd := &Mpd{
+		XMLName:  xml.Name{Space: mpdNamespace, Local: "MPD"},
+		Profiles: ProfileIsoffLive,
+		Type:     "static",
+	}
+	period := &Period{ID: "0"}
+	mpd.Periods = []*Period{period}
+
+	var end, longest time.Duration
+	sets := map[string]*AdaptationSet{}
+	for _, r := range w.representations {
+		representation, key := w.representationElement(r)
+		set := sets[key]
+		if set == nil {
+			set = w.adaptationSet(r, len(period.AdaptationSets)+1)
+			sets[key] = set
+			period.AdaptationSets = append(period.AdaptationSets, set)
+		}
+		set.Representations = append(set.Representations, representation)
+
+		timescale := r.segmenter.reference.Timescale
+		for _, s := range r.segments {
+			if e := scaleDuration(s.time+s.duration, timescale); e > end {
+				end = e
+			}
+			if d := scaleDuration(s.duration, timescale); d > longest {
+				longest = d
+			}
+		}
+	}
+
+	mpd.MinBufferTime = XsDuration(w.MinBufferTime)
+	if w.MinBufferTime == 0 {
+		mpd.MinBufferTime = XsDuration(2 * longest)
+	}
+	if w.Dynamic && !ended {
+		now := time.Now().UTC().Truncate(time.Second)
+		start := w.AvailabilityStartTime.UTC()
+		mpd.Type = "dynamic"
+		mpd.AvailabilityStartTime = &start
+		mpd.PublishTime = &now
+		mpd.MinimumUpdatePeriod = XsDuration(w.MinimumUpdatePeriod)
+		mpd.TimeShiftBufferDepth = XsDuration(w.TimeShiftBufferDepth)
+	} else {
+		mpd.MediaPresentationDuration = XsDuration(end)
+	}
+	return mpd
+}
+
+// representationElement returns the representation of the track of a Segmenter and the key of its adaptation set.
+func (w *MpdWriter) representationElement(r *mpdRepresentation) (*Representation, string) {
+	track := r.segmenter.tracks[0]
+	representation := &Representation{ID: r.id, Codecs: track.sampleEntry().Codecs()}
+	if track.isVideo() {
+		representation.Width, representation.Height = track.Width, track.Height
+	} else {
+		representation.AudioSamplingRate = strconv.Itoa(track.SampleRate)
+		representation.AudioChannelConfiguration = []MpdDescriptor{{
+			SchemeIDURI: schemeAudioChannelConfiguration,
+			Value:       strconv.Itoa(track.Channels),
+		}}
+	}
+
+	// The timeline lists the segments in the time shift buffer, the bandwidth is taken from all segments.
+	segments := r.segments
+	timescale := r.segmenter.reference.Timescale
+	if n := len(segments); w.Dynamic && w.TimeShiftBufferDepth > 0 && n > 0 {
+		last := segments[n-1].time + segments[n-1].duration
+		depth := durationTicks(w.TimeShiftBufferDepth, timescale)
+		first := sort.Search(n, func(i int) bool { return segments[i].time+segments[i].duration+depth > last })
+		segments = segments[first:]
+	}
+	for _, s := range r.segments {
+		if s.duration == 0 {
+			continue
+		}
+		bandwidth := int(math.Ceil(float64(8*s.size) * float64(timescale) / float64(s.duration)))
+		if bandwidth > representation.Bandwidth {
+			representation.Bandwidth = bandwidth
+		}
+	}
+
+	template := &SegmentTemplate{
+		Timescale:      timescale,
+		Initialization: w.Initialization,
+		Media:          w.Media,
+	}
+	if template.Initialization == "" {
+		template.Initialization = "$RepresentationID$/init.mp4"
+	}
+	if template.Media == "" {
+		template.Media = "$RepresentationID$/$Number$.m4s"
+	}
+	if len(segments) > 0 {
+		number := uint64(segments[0].number)
+		template.StartNumber = &number
+		template.SegmentTimeline = &SegmentTimeline{}
+	}
+	timeline := template.SegmentTimeline
+	var next uint64
+	for i, s := range segments {
+		n := len(timeline.S)
+		if i > 0 && s.time == next && timeline.S[n-1].D == s.duration {
+			timeline.S[n-1].R++
+		} else {
+			entry := SegmentTimelineEntry{D: s.duration}
+			if i == 0 || s.time != next {
+				t := s.time
+				entry.T = &t
+			}
+			timeline.S = append(timeline.S, entry)
+		}
+		next = s.time + s.duration
+	}
+	representation.SegmentTemplate = template
+
+	key := "audio"
+	if track.isVideo() {
+		key = "video"
+	}
+	key += "/" + representation.Codecs[:4]
+	if !track.isVideo() && track.Language != "" {
+		key += "/" + track.Language
+	}
+	return representation, key
+}
+
+// adaptationSet returns an adaptation set for the representation of a Segmenter.
+func (w *MpdWriter) adaptationSet(r *mpdRepresentation, id int) *AdaptationSet {
+	set := &AdaptationSet{
+		ID:                strconv.Itoa(id),
+		ContentType:       "audio",
+		MimeType:          "audio/mp4",
+		SegmentAlignment:  true,
+		StartWithSAP:      1,
+		ContentProtection: append([]ContentProtection{}, w.ContentProtection...),
+	}
+	if track := r.segmenter.tracks[0]; track.isVideo() {
+		set.ContentType, set.MimeType = "video", "video/mp4"
+	} else if lang := track.Language; lang != "" && lang != "und" {
+		set.Lang = lang
+	}
+	return set
+}
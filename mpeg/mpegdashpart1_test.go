@@ -0,0 +1,189 @@
+package mpeg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMpdSegmentsRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		media string
+		url   func(number, time uint64, bandwidth int) string
+	}{
+		{"", func(number, _ uint64, _ int) string {
+			return fmt.Sprintf("v/%d.m4s", number)
+		}},
+		{"$RepresentationID$_$Number%05d$_$Time$.m4s", func(number, time uint64, _ int) string {
+			return fmt.Sprintf("v_%05d_%d.m4s", number, time)
+		}},
+		{"$Bandwidth$/$$$Time%08d$.mp4", func(_, time uint64, bandwidth int) string {
+			return fmt.Sprintf("%d/$%08d.mp4", bandwidth, time)
+		}},
+	} {
+		s := NewSegmenter(200 * time.Millisecond)
+		if _, err := s.AddTrack(testTracks[0]); err != nil {
+			t.Fatal(err)
+		}
+		w := NewMpdWriter(false)
+		w.Media = test.media
+		if err := w.AddSegmenter("v", s); err != nil {
+			t.Fatal(err)
+		}
+		var segments []*Segment
+		for _, p := range testPackets() {
+			if p.TrackID != 1 {
+				continue
+			}
+			segment, err := s.WritePacket(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if segment != nil {
+				segments = append(segments, segment)
+			}
+		}
+		segments = append(segments, s.Flush())
+		for _, segment := range segments {
+			if err := w.AddSegment("v", segment); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		b, err := w.Mpd(true).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mpd, err := ParseMpd(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := mpd.Periods[0].AdaptationSets[0].Representations[0]
+		got, err := r.SegmentTemplate.Segments(r, time.Duration(mpd.MediaPresentationDuration))
+		if err != nil {
+			t.Fatalf("%q: %v", test.media, err)
+		}
+		if len(got) != len(segments) {
+			t.Fatalf("%q: %d segments, want %d", test.media, len(got), len(segments))
+		}
+		for i, segment := range segments {
+			want := MpdSegment{
+				Number:   uint64(segment.Sequence),
+				Time:     durationTicks(segment.Time, 90000),
+				Duration: durationTicks(segment.Duration, 90000),
+			}
+			want.URL = test.url(want.Number, want.Time, r.Bandwidth)
+			if got[i] != want {
+				t.Errorf("%q: segment %d is %+v, want %+v", test.media, i, got[i], want)
+			}
+		}
+		if url, err := r.SegmentTemplate.InitializationURL(r); err != nil || url != "v/init.mp4" {
+			t.Errorf("%q: initialization %q, error %v", test.media, url, err)
+		}
+	}
+}
+
+func TestSegmentTemplateSegments(t *testing.T) {
+	r := &Representation{ID: "a", Bandwidth: 128000}
+	number := func(n uint64) *uint64 { return &n }
+	for _, test := range []struct {
+		name     string
+		template *SegmentTemplate
+		duration time.Duration
+		want     []MpdSegment
+	}{
+		{
+			name:     "duration",
+			template: &SegmentTemplate{Timescale: 10, Duration: 40, StartNumber: number(0), Media: "$Number$"},
+			duration: 10 * time.Second,
+			want: []MpdSegment{
+				{Number: 0, Time: 0, Duration: 40, URL: "0"},
+				{Number: 1, Time: 40, Duration: 40, URL: "1"},
+				{Number: 2, Time: 80, Duration: 40, URL: "2"},
+			},
+		},
+		{
+			name: "presentation time offset",
+			template: &SegmentTemplate{Timescale: 10, Duration: 40, PresentationTimeOffset: 100,
+				Media: "$Number$-$Time$"},
+			duration: 5 * time.Second,
+			want: []MpdSegment{
+				{Number: 1, Time: 100, Duration: 40, URL: "1-100"},
+				{Number: 2, Time: 140, Duration: 40, URL: "2-140"},
+			},
+		},
+		{
+			name: "timeline",
+			template: &SegmentTemplate{Timescale: 10, Media: "$Time$", SegmentTimeline: &SegmentTimeline{
+				S: []SegmentTimelineEntry{{T: number(5), D: 20, R: 1}, {T: number(100), D: 10, R: -1}},
+			}},
+			duration: 12 * time.Second,
+			want: []MpdSegment{
+				{Number: 1, Time: 5, Duration: 20, URL: "5"},
+				{Number: 2, Time: 25, Duration: 20, URL: "25"},
+				{Number: 3, Time: 100, Duration: 10, URL: "100"},
+				{Number: 4, Time: 110, Duration: 10, URL: "110"},
+			},
+		},
+		{
+			name: "repeated until the next entry",
+			template: &SegmentTemplate{Media: "$Number$", SegmentTimeline: &SegmentTimeline{
+				S: []SegmentTimelineEntry{{D: 3, R: -1}, {T: number(7), D: 1}},
+			}},
+			want: []MpdSegment{
+				{Number: 1, Time: 0, Duration: 3, URL: "1"},
+				{Number: 2, Time: 3, Duration: 3, URL: "2"},
+				{Number: 3, Time: 6, Duration: 3, URL: "3"},
+				{Number: 4, Time: 7, Duration: 1, URL: "4"},
+			},
+		},
+	} {
+		got, err := test.template.Segments(r, test.duration)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: %d segments, want %d", test.name, len(got), len(test.want))
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: segment %d is %+v, want %+v", test.name, i, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestSegmentTemplateSegmentsMalformed(t *testing.T) {
+	r := &Representation{ID: "a"}
+	timeline := func(media string, entries ...SegmentTimelineEntry) *SegmentTemplate {
+		return &SegmentTemplate{Timescale: 1000, Media: media, SegmentTimeline: &SegmentTimeline{S: entries}}
+	}
+	for _, test := range []struct {
+		name     string
+		template *SegmentTemplate
+	}{
+		{"no duration", &SegmentTemplate{Media: "$Number$"}},
+		{"tiny duration", &SegmentTemplate{Timescale: 1000, Media: "$Number$", Duration: 1}},
+		{"duration 0", timeline("$Number$", SegmentTimelineEntry{D: 0})},
+		{"huge repeat", timeline("$Number$", SegmentTimelineEntry{D: 1, R: math.MaxInt})},
+		{"repeated until a distant end", timeline("$Number$", SegmentTimelineEntry{D: 1, R: -1})},
+		{"repeats adding up", timeline("$Number$", SegmentTimelineEntry{D: 1, R: maxMpdSegments / 2},
+			SegmentTimelineEntry{D: 1, R: maxMpdSegments / 2})},
+		{"unknown identifier", timeline("$Frame$", SegmentTimelineEntry{D: 1})},
+		{"unterminated identifier", timeline("$Number", SegmentTimelineEntry{D: 1})},
+		{"format", timeline("$Number%s$", SegmentTimelineEntry{D: 1})},
+	} {
+		if _, err := test.template.Segments(r, 24*time.Hour); !errors.Is(err, errMpdTemplate) {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+	}
+
+	segments, err := timeline("$Number$", SegmentTimelineEntry{D: 1, R: maxMpdSegments - 1}).Segments(r, 0)
+	if err != nil || len(segments) != maxMpdSegments {
+		t.Errorf("%d segments, error %v", len(segments), err)
+	}
+}
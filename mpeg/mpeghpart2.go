@@ -0,0 +1,93 @@
+package mpeg
+
+// ISO/IEC 23008-2 (ITU-T H.265, HEVC)
+
+// nal_unit_type (ISO/IEC 23008-2 Table 7-1)
+const (
+	HevcNalTrailN     = 0
+	HevcNalTrailR     = 1
+	HevcNalRaslR      = 9
+	HevcNalBlaWLp     = 16
+	HevcNalBlaWRadl   = 17
+	HevcNalBlaNLp     = 18
+	HevcNalIdrWRadl   = 19
+	HevcNalIdrNLp     = 20
+	HevcNalCra        = 21
+	HevcNalVps        = 32
+	HevcNalSps        = 33
+	HevcNalPps        = 34
+	HevcNalAud        = 35
+	HevcNalEos        = 36
+	HevcNalEob        = 37
+	HevcNalFd         = 38
+	HevcNalPrefixSei  = 39
+	HevcNalSuffixSei  = 40
+	hevcNalIrapLast   = 23 // Last reserved IRAP type, RSV_IRAP_VCL23
+	hevcNalVclLast    = 31
+	hevcNalHeaderSize = 2
+)
+
+// hevcAccessUnitDelimiter is an access unit delimiter NAL unit of layer 0 and TemporalId 0 with pic_type 2, any
+// slice type, and the rbsp_trailing_bits.
+var hevcAccessUnitDelimiter = []byte{HevcNalAud << 1, 0x01, 0x50}
+
+// HevcNalUnitType returns the nal_unit_type of the two byte header of an HEVC NAL unit.
+func HevcNalUnitType(nal []byte) uint8 {
+	if len(nal) == 0 {
+		return 0xFF
+	}
+	return nal[0] >> 1 & 0x3F
+}
+
+// HasIrap reports whether the HEVC NAL units of an access unit hold an intra random access point picture, an IDR,
+// CRA or BLA picture.
+func HasIrap(nals [][]byte) bool {
+	for _, nal := range nals {
+		if t := HevcNalUnitType(nal); t >= HevcNalBlaWLp && t <= hevcNalIrapLast {
+			return true
+		}
+	}
+	return false
+}
+
+func hasHevcNalUnit(nals [][]byte, nalUnitType uint8) bool {
+	for _, nal := range nals {
+		if HevcNalUnitType(nal) == nalUnitType {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitHevcAccessUnits groups HEVC NAL units of a byte stream into access units (ISO/IEC 23008-2 7.4.2.4.4). An
+// access unit starts with an access unit delimiter, with a parameter set or prefix SEI following the slice segments
+// of the previous picture, or with the first slice segment of a picture.
+func SplitHevcAccessUnits(nals [][]byte) [][][]byte {
+	var units [][][]byte
+	var unit [][]byte
+	hasVcl := false
+	for _, nal := range nals {
+		newUnit := false
+		switch t := HevcNalUnitType(nal); {
+		case t == HevcNalAud:
+			newUnit = true
+		case t >= HevcNalVps && t <= HevcNalPps || t == HevcNalPrefixSei || t >= 41 && t <= 44 || t >= 48 && t <= 55:
+			newUnit = hasVcl
+		case t <= hevcNalVclLast:
+			// first_slice_segment_in_pic_flag follows the NAL unit header.
+			newUnit = hasVcl && len(nal) > hevcNalHeaderSize && nal[hevcNalHeaderSize]&0x80 != 0
+		}
+		if newUnit && len(unit) > 0 {
+			units = append(units, unit)
+			unit, hasVcl = nil, false
+		}
+		unit = append(unit, nal)
+		if HevcNalUnitType(nal) <= hevcNalVclLast {
+			hasVcl = true
+		}
+	}
+	if len(unit) > 0 {
+		units = append(units, unit)
+	}
+	return units
+}
@@ -0,0 +1,436 @@
+package mpeg
+
+import (
+	"awCodec/utils"
+	"fmt"
+)
+
+// Sequence parameter set of ISO/IEC 23008-2 (ITU-T H.265 7.3.2.2)
+
+// ProfileTierLevel is the general part of the profile_tier_level (ISO/IEC 23008-2 7.3.3).
+type ProfileTierLevel struct {
+	GeneralProfileSpace              int    // u(2)
+	GeneralTierFlag                  bool   // u(1)
+	GeneralProfileIdc                int    // u(5)
+	GeneralProfileCompatibilityFlags uint32 // u(32)
+	GeneralConstraintIndicatorFlags  uint64 // u(48), general_progressive_source_flag in the highest bit
+	GeneralLevelIdc                  int    // u(8), 30 times the level
+}
+
+// HevcSequenceParameterSet is a decoded seq_parameter_set_rbsp of HEVC.
+type HevcSequenceParameterSet struct {
+	SpsVideoParameterSetID        int  // u(4)
+	SpsMaxSubLayersMinus1         int  // u(3)
+	SpsTemporalIDNestingFlag      bool // u(1)
+	ProfileTierLevel              ProfileTierLevel
+	SpsSeqParameterSetID          int  // ue(v)
+	ChromaFormatIdc               int  // ue(v)
+	SeparateColourPlaneFlag       bool // u(1)
+	PicWidthInLumaSamples         int  // ue(v)
+	PicHeightInLumaSamples        int  // ue(v)
+	ConformanceWindowFlag         bool // u(1)
+	ConfWinLeftOffset             int  // ue(v)
+	ConfWinRightOffset            int  // ue(v)
+	ConfWinTopOffset              int  // ue(v)
+	ConfWinBottomOffset           int  // ue(v)
+	BitDepthLumaMinus8            int  // ue(v)
+	BitDepthChromaMinus8          int  // ue(v)
+	Log2MaxPicOrderCntLsbMinus4   int  // ue(v)
+	SpsMaxDecPicBufferingMinus1   int  // ue(v) of the highest sub-layer
+	SpsMaxNumReorderPics          int  // ue(v) of the highest sub-layer
+	SpsMaxLatencyIncreasePlus1    int  // ue(v) of the highest sub-layer
+	Log2MinLumaCodingBlockSizeM3  int  // ue(v) log2_min_luma_coding_block_size_minus3
+	Log2DiffMaxMinLumaCodingBlock int  // ue(v) log2_diff_max_min_luma_coding_block_size
+	ScalingListEnabledFlag        bool // u(1)
+	AmpEnabledFlag                bool // u(1)
+	SampleAdaptiveOffsetEnabled   bool // u(1) sample_adaptive_offset_enabled_flag
+	PcmEnabledFlag                bool // u(1)
+	NumShortTermRefPicSets        int  // ue(v)
+	LongTermRefPicsPresentFlag    bool // u(1)
+	SpsTemporalMvpEnabledFlag     bool // u(1)
+	StrongIntraSmoothingEnabled   bool // u(1) strong_intra_smoothing_enabled_flag
+	Vui                           *HevcVuiParameters
+	numDeltaPocs                  []int
+}
+
+// HevcVuiParameters are the video usability information of an HEVC sequence (ISO/IEC 23008-2 E.2.1).
+type HevcVuiParameters struct {
+	AspectRatioIdc            int  // u(8)
+	SarWidth                  int  // u(16)
+	SarHeight                 int  // u(16)
+	VideoFormat               int  // u(3), 5 (unspecified) if not present
+	VideoFullRangeFlag        bool // u(1)
+	ColourPrimaries           int  // u(8), 2 (unspecified) if not present
+	TransferCharacteristics   int  // u(8), 2 (unspecified) if not present
+	MatrixCoefficients        int  // u(8), 2 (unspecified) if not present
+	FieldSeqFlag              bool // u(1)
+	VuiTimingInfoPresentFlag  bool // u(1)
+	VuiNumUnitsInTick         uint32
+	VuiTimeScale              uint32
+	BitstreamRestrictionFlag  bool // u(1)
+	MinSpatialSegmentationIdc int  // ue(v)
+	Log2MaxMvLengthHorizontal int  // ue(v)
+	Log2MaxMvLengthVertical   int  // ue(v)
+}
+
+// readProfileTierLevel reads the profile_tier_level with profilePresentFlag 1 and returns its general part.
+func readProfileTierLevel(br *utils.BitReader, maxSubLayersMinus1 int) ProfileTierLevel {
+	var ptl ProfileTierLevel
+	ptl.GeneralProfileSpace = br.ReadBits(2)
+	ptl.GeneralTierFlag = br.ReadBool()
+	ptl.GeneralProfileIdc = br.ReadBits(5)
+	ptl.GeneralProfileCompatibilityFlags = uint32(br.ReadBits(16))<<16 | uint32(br.ReadBits(16))
+	ptl.GeneralConstraintIndicatorFlags = uint64(br.ReadBits(24))<<24 | uint64(br.ReadBits(24))
+	ptl.GeneralLevelIdc = br.ReadBits(8)
+
+	profilePresent := make([]bool, maxSubLayersMinus1)
+	levelPresent := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		profilePresent[i] = br.ReadBool()
+		levelPresent[i] = br.ReadBool()
+	}
+	if maxSubLayersMinus1 > 0 {
+		br.Seek(2 * (8 - maxSubLayersMinus1)) // reserved_zero_2bits
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] {
+			br.Seek(88)
+		}
+		if levelPresent[i] {
+			br.Seek(8)
+		}
+	}
+	return ptl
+}
+
+// ParseHevcSps decodes an HEVC sequence parameter set NAL unit.
+func ParseHevcSps(nal []byte) (*HevcSequenceParameterSet, error) {
+	if HevcNalUnitType(nal) != HevcNalSps {
+		return nil, fmt.Errorf("%w: NAL unit type %d", errSps, HevcNalUnitType(nal))
+	}
+	b := rbsp(nal, hevcNalHeaderSize)
+	br := utils.NewBitReader(b)
+
+	sps := &HevcSequenceParameterSet{}
+	sps.SpsVideoParameterSetID = br.ReadBits(4)
+	sps.SpsMaxSubLayersMinus1 = br.ReadBits(3)
+	sps.SpsTemporalIDNestingFlag = br.ReadBool()
+	if sps.SpsMaxSubLayersMinus1 > 6 {
+		return nil, fmt.Errorf("%w: sps_max_sub_layers_minus1 %d", errSps, sps.SpsMaxSubLayersMinus1)
+	}
+	sps.ProfileTierLevel = readProfileTierLevel(br, sps.SpsMaxSubLayersMinus1)
+	sps.SpsSeqParameterSetID = br.ReadUe()
+	if sps.SpsSeqParameterSetID > 15 {
+		return nil, fmt.Errorf("%w: sps_seq_parameter_set_id %d", errSps, sps.SpsSeqParameterSetID)
+	}
+	sps.ChromaFormatIdc = br.ReadUe()
+	if sps.ChromaFormatIdc > 3 {
+		return nil, fmt.Errorf("%w: chroma_format_idc %d", errSps, sps.ChromaFormatIdc)
+	}
+	if sps.ChromaFormatIdc == 3 {
+		sps.SeparateColourPlaneFlag = br.ReadBool()
+	}
+	sps.PicWidthInLumaSamples = br.ReadUe()
+	sps.PicHeightInLumaSamples = br.ReadUe()
+	sps.ConformanceWindowFlag = br.ReadBool()
+	if sps.ConformanceWindowFlag {
+		sps.ConfWinLeftOffset = br.ReadUe()
+		sps.ConfWinRightOffset = br.ReadUe()
+		sps.ConfWinTopOffset = br.ReadUe()
+		sps.ConfWinBottomOffset = br.ReadUe()
+	}
+	sps.BitDepthLumaMinus8 = br.ReadUe()
+	sps.BitDepthChromaMinus8 = br.ReadUe()
+	sps.Log2MaxPicOrderCntLsbMinus4 = br.ReadUe()
+	if sps.BitDepthLumaMinus8 > 8 || sps.BitDepthChromaMinus8 > 8 || sps.Log2MaxPicOrderCntLsbMinus4 > 12 {
+		return nil, fmt.Errorf("%w: bit depth %d", errSps, sps.BitDepthLumaMinus8+8)
+	}
+
+	subLayerOrderingInfoPresent := br.ReadBool()
+	first := sps.SpsMaxSubLayersMinus1
+	if subLayerOrderingInfoPresent {
+		first = 0
+	}
+	for i := first; i <= sps.SpsMaxSubLayersMinus1; i++ {
+		sps.SpsMaxDecPicBufferingMinus1 = br.ReadUe()
+		sps.SpsMaxNumReorderPics = br.ReadUe()
+		sps.SpsMaxLatencyIncreasePlus1 = br.ReadUe()
+	}
+
+	sps.Log2MinLumaCodingBlockSizeM3 = br.ReadUe()
+	sps.Log2DiffMaxMinLumaCodingBlock = br.ReadUe()
+	br.ReadUe() // log2_min_luma_transform_block_size_minus2
+	br.ReadUe() // log2_diff_max_min_luma_transform_block_size
+	br.ReadUe() // max_transform_hierarchy_depth_inter
+	br.ReadUe() // max_transform_hierarchy_depth_intra
+	sps.ScalingListEnabledFlag = br.ReadBool()
+	if sps.ScalingListEnabledFlag && br.ReadBool() { // sps_scaling_list_data_present_flag
+		skipHevcScalingListData(br)
+	}
+	sps.AmpEnabledFlag = br.ReadBool()
+	sps.SampleAdaptiveOffsetEnabled = br.ReadBool()
+	sps.PcmEnabledFlag = br.ReadBool()
+	if sps.PcmEnabledFlag {
+		br.Seek(8)  // pcm_sample_bit_depth_luma_minus1 and pcm_sample_bit_depth_chroma_minus1
+		br.ReadUe() // log2_min_pcm_luma_coding_block_size_minus3
+		br.ReadUe() // log2_diff_max_min_pcm_luma_coding_block_size
+		br.Seek(1)  // pcm_loop_filter_disabled_flag
+	}
+
+	sps.NumShortTermRefPicSets = br.ReadUe()
+	if sps.NumShortTermRefPicSets > 64 {
+		return nil, fmt.Errorf("%w: num_short_term_ref_pic_sets %d", errSps, sps.NumShortTermRefPicSets)
+	}
+	sps.numDeltaPocs = make([]int, sps.NumShortTermRefPicSets)
+	for i := range sps.numDeltaPocs {
+		if err := sps.readShortTermRefPicSet(br, i); err != nil {
+			return nil, err
+		}
+	}
+	sps.LongTermRefPicsPresentFlag = br.ReadBool()
+	if sps.LongTermRefPicsPresentFlag {
+		n := br.ReadUe() // num_long_term_ref_pics_sps
+		if n > 32 {
+			return nil, fmt.Errorf("%w: num_long_term_ref_pics_sps %d", errSps, n)
+		}
+		br.Seek(n * (sps.Log2MaxPicOrderCntLsbMinus4 + 4 + 1)) // lt_ref_pic_poc_lsb_sps and used_by_curr_pic_lt_sps_flag
+	}
+	sps.SpsTemporalMvpEnabledFlag = br.ReadBool()
+	sps.StrongIntraSmoothingEnabled = br.ReadBool()
+	if br.ReadBool() {
+		sps.Vui = readHevcVuiParameters(br, sps.SpsMaxSubLayersMinus1)
+	}
+
+	if br.Len() < 0 {
+		return sps, fmt.Errorf("%w: %d bytes", errSps, len(b))
+	}
+	if sps.Width() <= 0 || sps.Height() <= 0 {
+		return sps, fmt.Errorf("%w: picture size %dx%d", errSps, sps.Width(), sps.Height())
+	}
+	return sps, nil
+}
+
+// skipHevcScalingListData reads over the scaling_list_data (ISO/IEC 23008-2 7.3.4).
+func skipHevcScalingListData(br *utils.BitReader) {
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			if !br.ReadBool() { // scaling_list_pred_mode_flag
+				br.ReadUe() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+			coefNum := 1 << (4 + sizeID<<1)
+			if coefNum > 64 {
+				coefNum = 64
+			}
+			if sizeID > 1 {
+				br.ReadSe() // scaling_list_dc_coef_minus8
+			}
+			for i := 0; i < coefNum; i++ {
+				br.ReadSe() // scaling_list_delta_coef
+			}
+		}
+	}
+}
+
+// readShortTermRefPicSet reads the st_ref_pic_set(stRpsIdx) of the sequence parameter set and keeps its
+// NumDeltaPocs, which the sets predicted from it depend on (ISO/IEC 23008-2 7.3.7).
+func (sps *HevcSequenceParameterSet) readShortTermRefPicSet(br *utils.BitReader, stRpsIdx int) error {
+	if stRpsIdx != 0 && br.ReadBool() { // inter_ref_pic_set_prediction_flag
+		br.Seek(1)  // delta_rps_sign
+		br.ReadUe() // abs_delta_rps_minus1
+		refRpsIdx := stRpsIdx - 1
+		numDeltaPocs := 0
+		for j := 0; j <= sps.numDeltaPocs[refRpsIdx]; j++ {
+			useDelta := true
+			if !br.ReadBool() { // used_by_curr_pic_flag
+				useDelta = br.ReadBool()
+			}
+			if useDelta {
+				numDeltaPocs++
+			}
+		}
+		sps.numDeltaPocs[stRpsIdx] = numDeltaPocs
+		return nil
+	}
+
+	numNegativePics := br.ReadUe()
+	numPositivePics := br.ReadUe()
+	if numNegativePics > 16 || numPositivePics > 16 {
+		return fmt.Errorf("%w: short-term reference picture set %d", errSps, stRpsIdx)
+	}
+	for i := 0; i < numNegativePics+numPositivePics; i++ {
+		br.ReadUe() // delta_poc_s0_minus1 or delta_poc_s1_minus1
+		br.Seek(1)  // used_by_curr_pic_s0_flag or used_by_curr_pic_s1_flag
+	}
+	sps.numDeltaPocs[stRpsIdx] = numNegativePics + numPositivePics
+	return nil
+}
+
+func readHevcVuiParameters(br *utils.BitReader, maxSubLayersMinus1 int) *HevcVuiParameters {
+	vui := &HevcVuiParameters{VideoFormat: 5, ColourPrimaries: 2, TransferCharacteristics: 2, MatrixCoefficients: 2}
+	if br.ReadBool() {
+		vui.AspectRatioIdc = br.ReadBits(8)
+		if vui.AspectRatioIdc == extendedSar {
+			vui.SarWidth = br.ReadBits(16)
+			vui.SarHeight = br.ReadBits(16)
+		}
+	}
+	if br.ReadBool() { // overscan_info_present_flag
+		br.Seek(1) // overscan_appropriate_flag
+	}
+	if br.ReadBool() { // video_signal_type_present_flag
+		vui.VideoFormat = br.ReadBits(3)
+		vui.VideoFullRangeFlag = br.ReadBool()
+		if br.ReadBool() { // colour_description_present_flag
+			vui.ColourPrimaries = br.ReadBits(8)
+			vui.TransferCharacteristics = br.ReadBits(8)
+			vui.MatrixCoefficients = br.ReadBits(8)
+		}
+	}
+	if br.ReadBool() { // chroma_loc_info_present_flag
+		br.ReadUe()
+		br.ReadUe()
+	}
+	br.Seek(1) // neutral_chroma_indication_flag
+	vui.FieldSeqFlag = br.ReadBool()
+	br.Seek(1)         // frame_field_info_present_flag
+	if br.ReadBool() { // default_display_window_flag
+		for i := 0; i < 4; i++ {
+			br.ReadUe()
+		}
+	}
+	vui.VuiTimingInfoPresentFlag = br.ReadBool()
+	if vui.VuiTimingInfoPresentFlag {
+		vui.VuiNumUnitsInTick = uint32(br.ReadBits(16))<<16 | uint32(br.ReadBits(16))
+		vui.VuiTimeScale = uint32(br.ReadBits(16))<<16 | uint32(br.ReadBits(16))
+		if br.ReadBool() { // vui_poc_proportional_to_timing_flag
+			br.ReadUe() // vui_num_ticks_poc_diff_one_minus1
+		}
+		if br.ReadBool() { // vui_hrd_parameters_present_flag
+			skipHevcHrdParameters(br, maxSubLayersMinus1)
+		}
+	}
+	vui.BitstreamRestrictionFlag = br.ReadBool()
+	if vui.BitstreamRestrictionFlag {
+		br.Seek(3) // tiles_fixed_structure_flag, motion_vectors_over_pic_boundaries_flag, restricted_ref_pic_lists_flag
+		vui.MinSpatialSegmentationIdc = br.ReadUe()
+		br.ReadUe() // max_bytes_per_pic_denom
+		br.ReadUe() // max_bits_per_min_cu_denom
+		vui.Log2MaxMvLengthHorizontal = br.ReadUe()
+		vui.Log2MaxMvLengthVertical = br.ReadUe()
+	}
+	return vui
+}
+
+// skipHevcHrdParameters reads over the hrd_parameters with commonInfPresentFlag 1 (ISO/IEC 23008-2 E.2.2).
+func skipHevcHrdParameters(br *utils.BitReader, maxSubLayersMinus1 int) {
+	nalHrd := br.ReadBool()
+	vclHrd := br.ReadBool()
+	subPicHrdParams := false
+	if nalHrd || vclHrd {
+		subPicHrdParams = br.ReadBool()
+		if subPicHrdParams {
+			br.Seek(8 + 5 + 1 + 5) // tick_divisor_minus2 to dpb_output_delay_du_length_minus1
+		}
+		br.Seek(8) // bit_rate_scale and cpb_size_scale
+		if subPicHrdParams {
+			br.Seek(4) // cpb_size_du_scale
+		}
+		br.Seek(15) // initial_cpb_removal_delay_length_minus1 to dpb_output_delay_length_minus1
+	}
+
+	for i := 0; i <= maxSubLayersMinus1 && br.Len() > 0; i++ {
+		fixedPicRateWithinCvs := br.ReadBool() // fixed_pic_rate_general_flag
+		if !fixedPicRateWithinCvs {
+			fixedPicRateWithinCvs = br.ReadBool()
+		}
+		lowDelay := false
+		if fixedPicRateWithinCvs {
+			br.ReadUe() // elemental_duration_in_tc_minus1
+		} else {
+			lowDelay = br.ReadBool()
+		}
+		cpbCnt := 1
+		if !lowDelay {
+			cpbCnt = br.ReadUe() + 1
+		}
+		for _, present := range []bool{nalHrd, vclHrd} {
+			if !present {
+				continue
+			}
+			for k := 0; k < cpbCnt && k < 32; k++ {
+				br.ReadUe() // bit_rate_value_minus1
+				br.ReadUe() // cpb_size_value_minus1
+				if subPicHrdParams {
+					br.ReadUe() // cpb_size_du_value_minus1
+					br.ReadUe() // bit_rate_du_value_minus1
+				}
+				br.Seek(1) // cbr_flag
+			}
+		}
+	}
+}
+
+// subsampling returns SubWidthC and SubHeightC of the chroma format (ISO/IEC 23008-2 Table 6-1).
+func (sps *HevcSequenceParameterSet) subsampling() (subWidthC, subHeightC int) {
+	if sps.SeparateColourPlaneFlag {
+		return 1, 1
+	}
+	switch sps.ChromaFormatIdc {
+	case 1:
+		return 2, 2
+	case 2:
+		return 2, 1
+	}
+	return 1, 1
+}
+
+// Width returns the width of the pictures in the conformance window.
+func (sps *HevcSequenceParameterSet) Width() int {
+	subWidthC, _ := sps.subsampling()
+	return sps.PicWidthInLumaSamples - subWidthC*(sps.ConfWinLeftOffset+sps.ConfWinRightOffset)
+}
+
+// Height returns the height of the pictures in the conformance window.
+func (sps *HevcSequenceParameterSet) Height() int {
+	_, subHeightC := sps.subsampling()
+	return sps.PicHeightInLumaSamples - subHeightC*(sps.ConfWinTopOffset+sps.ConfWinBottomOffset)
+}
+
+// BitDepth returns the bit depth of the luma and chroma samples.
+func (sps *HevcSequenceParameterSet) BitDepth() (luma, chroma int) {
+	return sps.BitDepthLumaMinus8 + 8, sps.BitDepthChromaMinus8 + 8
+}
+
+// FrameRate returns the picture rate of the VUI timing information, 0 if it is not present. Unlike H.264 a
+// picture lasts one tick.
+func (sps *HevcSequenceParameterSet) FrameRate() float64 {
+	if sps.Vui == nil || !sps.Vui.VuiTimingInfoPresentFlag || sps.Vui.VuiNumUnitsInTick == 0 {
+		return 0
+	}
+	return float64(sps.Vui.VuiTimeScale) / float64(sps.Vui.VuiNumUnitsInTick)
+}
+
+// SampleAspectRatio returns the shape of the samples, 1:1 if it is not given.
+func (sps *HevcSequenceParameterSet) SampleAspectRatio() (width, height int) {
+	if sps.Vui == nil {
+		return 1, 1
+	}
+	switch idc := sps.Vui.AspectRatioIdc; {
+	case idc == extendedSar && sps.Vui.SarWidth != 0 && sps.Vui.SarHeight != 0:
+		return sps.Vui.SarWidth, sps.Vui.SarHeight
+	case idc >= 1 && idc <= len(sampleAspectRatios):
+		return sampleAspectRatios[idc-1][0], sampleAspectRatios[idc-1][1]
+	}
+	return 1, 1
+}
+
+// ReorderDepth returns the number of pictures which may precede a picture in decoding order and follow it in output
+// order, sps_max_num_reorder_pics of the highest sub-layer.
+func (sps *HevcSequenceParameterSet) ReorderDepth() int {
+	return sps.SpsMaxNumReorderPics
+}
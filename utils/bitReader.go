@@ -10,11 +10,14 @@ func NewBitReader(b []byte) *BitReader {
 	return &BitReader{bytes: b}
 }
 
+// ReadBits reads n bits (up to 25) as an unsigned integer. Bits after the end of the buffer are read as zero.
 func (bitReader *BitReader) ReadBits(n int) int {
 	buf := make([]byte, 4)
 
 	offset := bitReader.offset / 8
-	copy(buf, bitReader.bytes[offset:])
+	if offset >= 0 && offset < len(bitReader.bytes) {
+		copy(buf, bitReader.bytes[offset:])
+	}
 
 	r := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
 	r = r >> (32 - (n + bitReader.offset%8)) & (0xFFFFFFFF >> (32 - n))
@@ -24,7 +27,58 @@ func (bitReader *BitReader) ReadBits(n int) int {
 	return int(r)
 }
 
+// ReadBool reads one bit as a flag.
+func (bitReader *BitReader) ReadBool() bool {
+	return bitReader.ReadBits(1) == 1
+}
+
 func (bitReader *BitReader) Seek(n int) {
 	bitReader.offset += n
 	bitReader.Counter += n
 }
+
+// ByteAlign skips the bits up to the next byte boundary.
+func (bitReader *BitReader) ByteAlign() {
+	if n := bitReader.offset % 8; n != 0 {
+		bitReader.Seek(8 - n)
+	}
+}
+
+// Offset returns the position of the reader in bits.
+func (bitReader *BitReader) Offset() int {
+	return bitReader.offset
+}
+
+// Len returns the number of unread bits, it is negative after reading past the end of the buffer.
+func (bitReader *BitReader) Len() int {
+	return len(bitReader.bytes)*8 - bitReader.offset
+}
+
+// ReadUe reads an unsigned Exp-Golomb code ue(v) of H.264 and H.265, of up to 31 leading zero bits. Longer codes
+// are invalid: it stops after 32 zero bits, reads the next 32 bits as the suffix and returns at least 2^32 - 1,
+// which is out of the range of all syntax elements. Past the end of the buffer, it returns 2^32 - 1.
+func (bitReader *BitReader) ReadUe() int {
+	leadingZeros := 0
+	for leadingZeros < 32 && !bitReader.ReadBool() {
+		leadingZeros++
+	}
+	value := 0
+	for n := leadingZeros; n > 0; {
+		k := n
+		if k > 16 {
+			k = 16
+		}
+		value = value<<k | bitReader.ReadBits(k)
+		n -= k
+	}
+	return 1<<leadingZeros - 1 + value
+}
+
+// ReadSe reads a signed Exp-Golomb code se(v), the codes 1, 2, 3, 4 map to 1, -1, 2, -2.
+func (bitReader *BitReader) ReadSe() int {
+	k := bitReader.ReadUe()
+	if k%2 == 1 {
+		return (k + 1) / 2
+	}
+	return -(k / 2)
+}